@@ -0,0 +1,499 @@
+// Package client talks to the /v1 api of cmd/api. Client implements
+// repo.Repository, so code written against the interface can use a remote
+// server like any local backend:
+//
+//	var r repo.Repository = client.New("http://localhost:8000")
+//	todos, err := r.GetAll(ctx)
+//
+// Problems sent by the server are mapped back to the errors of package repo,
+// so errors.Is(err, repo.ErrNotFound) works as with the other backends.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+)
+
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+var (
+	// ErrUnreachable is returned, wrapped with repo.ErrStorage, when no
+	// response came back from the server
+	ErrUnreachable = errors.New("server unreachable")
+	// ErrUnauthorized is returned when the server refused the token
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+var _ repo.Repository = (*Client)(nil)
+
+type Option func(*Client)
+
+// WithHTTPClient sends requests with c instead of http.DefaultClient
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		cl.http = c
+	}
+}
+
+// WithToken sends token as a bearer token with every request
+func WithToken(token string) Option {
+	return func(cl *Client) {
+		cl.token = token
+	}
+}
+
+// New returns a client of the server at baseURL, e.g. "http://localhost:8000"
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    http.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Problem is an RFC 7807 error response of the server. Index is set when
+// a todo of a batch failed, and the error is then wrapped in a
+// repo.BatchError.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance"`
+	Code     string `json:"code"`
+	Index    *int   `json:"index"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+
+	return fmt.Sprintf("%d %s", p.Status, p.Title)
+}
+
+// codeErrors maps problem codes to the errors of package repo, and of this one
+var codeErrors = map[string]error{
+	"not_found":          repo.ErrNotFound,
+	"invalid_status":     repo.ErrInvalidStatus,
+	"invalid_todo":       repo.ErrInvalidTodo,
+	"invalid_query":      repo.ErrInvalidQuery,
+	"invalid_transition": repo.ErrInvalidTransition,
+	"conflict":           repo.ErrConflict,
+	"version_mismatch":   repo.ErrVersionMismatch,
+	"timeout":            context.DeadlineExceeded,
+	"canceled":           context.Canceled,
+	"unauthorized":       ErrUnauthorized,
+}
+
+// Unwrap lets errors.Is match the repo error of the problem code
+func (p *Problem) Unwrap() error {
+	err, ok := codeErrors[p.Code]
+	if !ok {
+		return repo.ErrStorage
+	}
+
+	return err
+}
+
+type envelope[T any] struct {
+	Data       T      `json:"data"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// do sends a request to path and decodes the data of the response into out
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, out interface{}) (string, error) {
+	return c.doVersion(ctx, method, path, repo.AnyVersion, body, out)
+}
+
+// doVersion is do with If-Match set to version, unless it is repo.AnyVersion
+func (c *Client) doVersion(ctx context.Context, method string, path string, version int64, body interface{}, out interface{}) (string, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return "", fmt.Errorf("%w: failed to marshal request: %w", repo.ErrStorage, err)
+		}
+
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad request: %w", repo.ErrStorage, err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	if version != repo.AnyVersion {
+		req.Header.Set("If-Match", `"`+strconv.FormatInt(version, 10)+`"`)
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		return "", fmt.Errorf("%w: %w: %s: %w", repo.ErrStorage, ErrUnreachable, c.baseURL, errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to read response: %w", repo.ErrStorage, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		problem := &Problem{Status: resp.StatusCode, Title: http.StatusText(resp.StatusCode)}
+		json.Unmarshal(b, problem)
+		if problem.Index != nil {
+			return "", &repo.BatchError{Index: *problem.Index, Err: problem}
+		}
+
+		return "", problem
+	}
+
+	env := envelope[json.RawMessage]{}
+	err = json.Unmarshal(b, &env)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad response: %w", repo.ErrStorage, err)
+	}
+
+	err = json.Unmarshal(env.Data, out)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad response data: %w", repo.ErrStorage, err)
+	}
+
+	return env.NextCursor, nil
+}
+
+func todoPath(id string) string {
+	return "/v1/todos/" + url.PathEscape(id)
+}
+
+// filterQuery writes filter as the query string of GET /v1/todos
+func filterQuery(filter repo.Filter) string {
+	query := url.Values{}
+
+	statuses := make([]string, len(filter.Statuses))
+	for i := range filter.Statuses {
+		statuses[i] = string(filter.Statuses[i])
+	}
+
+	if len(statuses) != 0 {
+		query.Set("status", strings.Join(statuses, ","))
+	}
+
+	if filter.Text != "" {
+		query.Set("q", filter.Text)
+	}
+
+	for _, tag := range filter.Tags {
+		query.Add("tag", tag)
+	}
+
+	if filter.DueFrom != nil {
+		query.Set("due_from", filter.DueFrom.Format(time.RFC3339Nano))
+	}
+
+	if filter.DueTo != nil {
+		query.Set("due_to", filter.DueTo.Format(time.RFC3339Nano))
+	}
+
+	if filter.Sort != "" || filter.Desc {
+		sort := filter.Sort
+		if sort == "" {
+			sort = repo.SortCreatedAt
+		}
+
+		if filter.Desc {
+			sort = "-" + sort
+		}
+
+		query.Set("sort", string(sort))
+	}
+
+	if filter.Limit != 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	if filter.Cursor != "" {
+		query.Set("cursor", filter.Cursor)
+	}
+
+	if len(query) == 0 {
+		return ""
+	}
+
+	return "?" + query.Encode()
+}
+
+func (c *Client) Add(ctx context.Context, todo model.Todo) error {
+	var created model.Todo
+	_, err := c.do(ctx, http.MethodPost, "/v1/todos", todo, &created)
+	return err
+}
+
+func (c *Client) GetAll(ctx context.Context) ([]model.Todo, error) {
+	page, err := c.Query(ctx, repo.Filter{})
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return page.Todos, nil
+}
+
+func (c *Client) Get(ctx context.Context, id string) (model.Todo, error) {
+	var todo model.Todo
+	_, err := c.do(ctx, http.MethodGet, todoPath(id), nil, &todo)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return todo, nil
+}
+
+func (c *Client) GetByStatus(ctx context.Context, status model.Status) ([]model.Todo, error) {
+	if status == "" {
+		return []model.Todo{}, fmt.Errorf("%w: empty status", repo.ErrInvalidStatus)
+	}
+
+	page, err := c.Query(ctx, repo.Filter{Statuses: []model.Status{status}})
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return page.Todos, nil
+}
+
+func (c *Client) Query(ctx context.Context, filter repo.Filter) (repo.Page, error) {
+	todos := []model.Todo{}
+	next, err := c.do(ctx, http.MethodGet, "/v1/todos"+filterQuery(filter), nil, &todos)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	return repo.Page{Todos: todos, Next: next}, nil
+}
+
+// maxReplaceAttempts bounds the writes of replace losing to concurrent ones
+const maxReplaceAttempts = 3
+
+// replace reads the todo with id, then runs write with If-Match at its
+// version, so that the todo returned is the one the write replaced. With
+// version repo.AnyVersion, a write losing to a change made in between is
+// tried again on the new todo, otherwise it fails with
+// repo.ErrVersionMismatch.
+func (c *Client) replace(ctx context.Context, id string, version int64, write func(version int64) error) (model.Todo, error) {
+	for attempt := 1; ; attempt++ {
+		old, err := c.Get(ctx, id)
+		if err != nil {
+			return model.Todo{}, err
+		}
+
+		expected := version
+		if version == repo.AnyVersion {
+			expected = old.Version
+		}
+
+		err = write(expected)
+		if errors.Is(err, repo.ErrVersionMismatch) && version == repo.AnyVersion && attempt < maxReplaceAttempts {
+			continue
+		}
+
+		if err != nil {
+			return model.Todo{}, err
+		}
+
+		return old, nil
+	}
+}
+
+// patch changes the fields in body and returns the todo as it was before
+func (c *Client) patch(ctx context.Context, id string, version int64, body map[string]interface{}) (model.Todo, error) {
+	return c.replace(ctx, id, version, func(version int64) error {
+		var updated model.Todo
+		_, err := c.doVersion(ctx, http.MethodPatch, todoPath(id), version, body, &updated)
+		return err
+	})
+}
+
+func (c *Client) UpdateData(ctx context.Context, id string, newData string, version int64) (model.Todo, error) {
+	return c.patch(ctx, id, version, map[string]interface{}{"data": newData})
+}
+
+func (c *Client) UpdateStatus(ctx context.Context, id string, status model.Status, version int64) (model.Todo, error) {
+	if status == "" {
+		return model.Todo{}, fmt.Errorf("%w: empty status", repo.ErrInvalidStatus)
+	}
+
+	return c.patch(ctx, id, version, map[string]interface{}{"status": status})
+}
+
+func (c *Client) Update(ctx context.Context, todo model.Todo) (model.Todo, error) {
+	return c.replace(ctx, todo.Id, todo.Version, func(version int64) error {
+		todo.Version = version
+
+		var updated model.Todo
+		_, err := c.doVersion(ctx, http.MethodPut, todoPath(todo.Id), version, todo, &updated)
+		return err
+	})
+}
+
+func (c *Client) Remove(ctx context.Context, id string, version int64) (model.Todo, error) {
+	var removed model.Todo
+	_, err := c.doVersion(ctx, http.MethodDelete, todoPath(id), version, nil, &removed)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return removed, nil
+}
+
+// batchResult is the data of a POST /v1/todos:batch response
+type batchResult struct {
+	Todos    []model.Todo `json:"todos"`
+	Previous []model.Todo `json:"previous"`
+}
+
+// batch sends a batch of action, of todos or ids, and returns the todos
+// as stored and as they were before
+func (c *Client) batch(ctx context.Context, action string, todos []model.Todo, ids []string) (batchResult, error) {
+	body := map[string]interface{}{"action": action}
+	if todos != nil {
+		body["todos"] = todos
+	}
+
+	if ids != nil {
+		body["ids"] = ids
+	}
+
+	var result batchResult
+	_, err := c.do(ctx, http.MethodPost, "/v1/todos:batch", body, &result)
+	if err != nil {
+		return batchResult{}, err
+	}
+
+	if result.Todos == nil {
+		result.Todos = []model.Todo{}
+	}
+
+	if result.Previous == nil {
+		result.Previous = []model.Todo{}
+	}
+
+	return result, nil
+}
+
+func (c *Client) AddMany(ctx context.Context, todos []model.Todo) ([]model.Todo, error) {
+	result, err := c.batch(ctx, "add", todos, nil)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return result.Todos, nil
+}
+
+func (c *Client) UpdateMany(ctx context.Context, todos []model.Todo) ([]model.Todo, []model.Todo, error) {
+	result, err := c.batch(ctx, "update", todos, nil)
+	if err != nil {
+		return []model.Todo{}, []model.Todo{}, err
+	}
+
+	return result.Todos, result.Previous, nil
+}
+
+func (c *Client) RemoveMany(ctx context.Context, ids []string) ([]model.Todo, error) {
+	result, err := c.batch(ctx, "remove", nil, ids)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return result.Previous, nil
+}
+
+// Workflow returns the workflow of the server, which its todos follow
+func (c *Client) Workflow(ctx context.Context) (model.Workflow, error) {
+	var wf model.Workflow
+	_, err := c.do(ctx, http.MethodGet, "/v1/workflow", nil, &wf)
+	if err != nil {
+		return model.Workflow{}, err
+	}
+
+	return wf, nil
+}
+
+func trashPath(id string) string {
+	return "/v1/trash/" + url.PathEscape(id)
+}
+
+func (c *Client) Trash(ctx context.Context) ([]model.Todo, error) {
+	todos := []model.Todo{}
+	_, err := c.do(ctx, http.MethodGet, "/v1/trash", nil, &todos)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return todos, nil
+}
+
+func (c *Client) Restore(ctx context.Context, id string) (model.Todo, error) {
+	var restored model.Todo
+	_, err := c.do(ctx, http.MethodPost, trashPath(id)+":restore", nil, &restored)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return restored, nil
+}
+
+func (c *Client) Purge(ctx context.Context, id string) (model.Todo, error) {
+	var purged model.Todo
+	_, err := c.do(ctx, http.MethodDelete, trashPath(id), nil, &purged)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return purged, nil
+}
+
+func (c *Client) PurgeBefore(ctx context.Context, t time.Time) ([]model.Todo, error) {
+	query := url.Values{"before": {t.Format(time.RFC3339Nano)}}
+
+	purged := []model.Todo{}
+	_, err := c.do(ctx, http.MethodDelete, "/v1/trash?"+query.Encode(), nil, &purged)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return purged, nil
+}
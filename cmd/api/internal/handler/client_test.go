@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/eymyong/todo/client"
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/jsonfilemap"
+	"github.com/eymyong/todo/repo/repotest"
+)
+
+// the client talking to this handler must behave like any other backend
+func TestClientConformance(t *testing.T) {
+	repotest.Run(t, func(opts ...repo.Option) repo.Repository {
+		r := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.map.json"), opts...)
+		srv := httptest.NewServer(newRouter(r))
+		t.Cleanup(srv.Close)
+
+		return client.New(srv.URL, client.WithHTTPClient(srv.Client()))
+	})
+}
+
+func TestClientUnreachable(t *testing.T) {
+	srv := httptest.NewServer(newRouter(errRepo{}))
+	url := srv.URL
+	srv.Close()
+
+	_, err := client.New(url).GetAll(context.Background())
+	if !errors.Is(err, repo.ErrStorage) || !errors.Is(err, client.ErrUnreachable) {
+		t.Fatalf("expected ErrStorage and ErrUnreachable, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), url) {
+		t.Errorf("expected the server url in '%s'", err)
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Auth("secret"))
+	New(errRepo{repo.ErrNotFound}).Register(router, false)
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	_, err := client.New(srv.URL).Get(context.Background(), "1")
+	if !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+
+	_, err = client.New(srv.URL, client.WithToken("secret")).Get(context.Background(), "1")
+	if !errors.Is(err, repo.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// racingRepo is r where another client changes todo "1" right after the
+// first Get of it
+type racingRepo struct {
+	repo.Repository
+	raced bool
+}
+
+func (r *racingRepo) Get(ctx context.Context, id string) (model.Todo, error) {
+	todo, err := r.Repository.Get(ctx, id)
+	if err == nil && !r.raced {
+		r.raced = true
+		_, err = r.Repository.UpdateData(ctx, id, "changed elsewhere", repo.AnyVersion)
+	}
+
+	return todo, err
+}
+
+func TestClientConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	tests := map[string]func(c *client.Client, version int64) (model.Todo, error){
+		"UpdateData": func(c *client.Client, version int64) (model.Todo, error) {
+			return c.UpdateData(ctx, "1", "mine", version)
+		},
+		"UpdateStatus": func(c *client.Client, version int64) (model.Todo, error) {
+			return c.UpdateStatus(ctx, "1", model.StatusDone, version)
+		},
+		"Update": func(c *client.Client, version int64) (model.Todo, error) {
+			return c.Update(ctx, model.Todo{Id: "1", Data: "mine", Status: model.StatusTodo, Version: version})
+		},
+	}
+
+	for name, write := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, version := range []int64{repo.AnyVersion, 1} {
+				r := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.map.json"))
+				err := r.Add(ctx, model.Todo{Id: "1", Data: "one", Status: model.StatusTodo})
+				if err != nil {
+					t.Fatalf("unexpected err: %s", err)
+				}
+
+				srv := httptest.NewServer(newRouter(&racingRepo{Repository: r}))
+				defer srv.Close()
+
+				old, err := write(client.New(srv.URL, client.WithHTTPClient(srv.Client())), version)
+				if version != repo.AnyVersion {
+					// the todo is no longer at the version asked for
+					if !errors.Is(err, repo.ErrVersionMismatch) {
+						t.Errorf("expected version mismatch but got '%v'", err)
+					}
+
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("unexpected err: %s", err)
+				}
+
+				// the write is retried on the changed todo, which it replaced
+				if old.Data != "changed elsewhere" || old.Version != 2 {
+					t.Errorf("expected the todo replaced but got %+v", old)
+				}
+			}
+		})
+	}
+}
+
+// remote clients follow the workflow of the server, the default one when
+// it has none
+func TestClientWorkflow(t *testing.T) {
+	for _, expected := range []model.Workflow{testWorkflow, model.DefaultWorkflow} {
+		r := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.map.json"))
+		srv := httptest.NewServer(newRouter(r, repo.WithWorkflow(expected)))
+		defer srv.Close()
+
+		wf, err := client.New(srv.URL, client.WithHTTPClient(srv.Client())).Workflow(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		if !reflect.DeepEqual(expected, wf) {
+			t.Errorf("expected %+v, got %+v", expected, wf)
+		}
+	}
+}
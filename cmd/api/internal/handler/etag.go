@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+)
+
+/*
+The ETag of a todo is its version, e.g. "3". Writes sent with If-Match
+fail with 412 unless the todo is still at that version, so two clients
+editing the same todo cannot overwrite each other:
+
+	GET   /v1/todos/1                    ETag: "3"
+	PATCH /v1/todos/1  If-Match: "3"     200, ETag: "4"
+	PATCH /v1/todos/1  If-Match: "3"     412 version_mismatch
+
+Reads sent with If-None-Match get 304 while the todo is unchanged.
+Todos stored before versions existed have version 0, and no ETag until
+their next change.
+*/
+
+// etag returns the entity tag of todo, empty when it has no version
+func etag(todo model.Todo) string {
+	if todo.Version == repo.AnyVersion {
+		return ""
+	}
+
+	return `"` + strconv.FormatInt(todo.Version, 10) + `"`
+}
+
+// setETag sets the ETag header of w to the one of todo
+func setETag(w http.ResponseWriter, todo model.Todo) {
+	tag := etag(todo)
+	if tag != "" {
+		w.Header().Set("ETag", tag)
+	}
+}
+
+// etagList returns the entity tags of the If-Match or If-None-Match
+// headers of r
+func etagList(r *http.Request, header string) []string {
+	var tags []string
+	for _, value := range r.Header.Values(header) {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
+
+// tagVersion returns the version of a strong entity tag
+func tagVersion(tag string) (int64, bool) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+
+	return version, true
+}
+
+// expectedVersion returns the version If-Match asks the todo with id to
+// be at, repo.AnyVersion without the header or with "*". A list of tags
+// is narrowed to the one of the stored todo, if any. Weak tags never
+// match, as If-Match compares strongly.
+func (h *HandlerTodo) expectedVersion(r *http.Request, id string) (int64, error) {
+	tags := etagList(r, "If-Match")
+	if len(tags) == 0 || slices.Contains(tags, "*") {
+		return repo.AnyVersion, nil
+	}
+
+	var versions []int64
+	for _, tag := range tags {
+		version, ok := tagVersion(tag)
+		if ok {
+			versions = append(versions, version)
+		}
+	}
+
+	if len(versions) == 1 {
+		return versions[0], nil
+	}
+
+	if len(versions) > 1 {
+		todo, err := h.repo.Get(r.Context(), id)
+		if err != nil {
+			return 0, err
+		}
+
+		if slices.Contains(versions, todo.Version) {
+			return todo.Version, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: If-Match %s does not match todo '%s'", repo.ErrVersionMismatch, strings.Join(tags, ", "), id)
+}
+
+// notModified reports whether If-None-Match of r matches todo, comparing
+// weakly as RFC 9110 asks for reads
+func notModified(r *http.Request, todo model.Todo) bool {
+	current := etag(todo)
+	for _, tag := range etagList(r, "If-None-Match") {
+		if tag == "*" || (current != "" && strings.TrimPrefix(tag, "W/") == current) {
+			return true
+		}
+	}
+
+	return false
+}
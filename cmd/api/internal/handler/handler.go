@@ -2,11 +2,14 @@ package handler
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -17,10 +20,19 @@ import (
 
 type HandlerTodo struct {
 	repo repo.Repository
+
+	// workflow is the one of repo, for the defaults of the legacy routes
+	workflow model.Workflow
 }
 
-func New(repo repo.Repository) *HandlerTodo {
-	return &HandlerTodo{repo: repo}
+// New serves r. opts are those r was opened with, see repo.WithWorkflow.
+func New(r repo.Repository, opts ...repo.Option) *HandlerTodo {
+	wf := repo.NewOptions(opts...).Workflow
+	if len(wf.Statuses) == 0 {
+		wf = model.DefaultWorkflow
+	}
+
+	return &HandlerTodo{repo: r, workflow: wf}
 }
 
 func sendJson(w http.ResponseWriter, status int, data interface{}) { //
@@ -44,67 +56,173 @@ func readBody(r *http.Request) ([]byte, error) { //
 func (h *HandlerTodo) Add(w http.ResponseWriter, r *http.Request) {
 	b, err := readBody(r)
 	if err != nil {
-		sendJson(w, http.StatusBadRequest, map[string]interface{}{
-			"error":  "failed to read body",
-			"reason": err.Error(),
-		})
+		sendBadRequest(w, r, "failed to read body", err)
 		return
 	}
 
-	body := string(b)
+	// plain text body is the data, a json body can also set the other fields
 	todo := model.Todo{
-		Id:     uuid.NewString(),
-		Data:   body,
-		Status: model.StatusTodo,
+		Data: string(b),
 	}
 
-	ctx := context.Background()
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		todo = model.Todo{}
+		err = json.Unmarshal(b, &todo)
+		if err != nil {
+			sendBadRequest(w, r, "unmarshal body error", err)
+			return
+		}
+	}
+
+	// new todos start in the initial status of the repository's workflow
+	todo.Id = uuid.NewString()
+	todo.Status = ""
+	todo.CreatedAt = time.Time{}
+	todo.UpdatedAt = time.Time{}
+	todo.CompletedAt = nil
+	todo.Version = 0
+	todo.DeletedAt = nil
+
+	ctx := r.Context()
 	err = h.repo.Add(ctx, todo)
 	if err != nil {
-		sendJson(w, http.StatusInternalServerError, map[string]interface{}{
-			"error":  "failed to create todo",
-			"reason": err.Error(),
-		})
+		sendRepoError(w, r, err)
+		return
+	}
+
+	created, err := h.repo.Get(ctx, todo.Id)
+	if err != nil {
+		sendRepoError(w, r, err)
 		return
 	}
 
 	sendJson(w, http.StatusCreated, map[string]interface{}{
 		"success": "ok",
-		"created": todo,
+		"created": created,
 	})
 }
 
+// parseFilter reads a repo.Filter from the query string of a list request:
+//
+//	?status=TODO,IN_PROGRESS&q=milk&tag=home&tag=shop
+//	&due_from=2024-07-01&due_to=2024-08-01T00:00:00Z
+//	&sort=-priority&limit=20&cursor=...
+//
+// status and tag can be repeated or comma separated, dates are
+// 2006-01-02 (UTC midnight) or RFC3339.
+func parseFilter(query url.Values) (repo.Filter, error) {
+	filter := repo.Filter{
+		Text:   query.Get("q"),
+		Cursor: query.Get("cursor"),
+	}
+
+	for _, s := range splitValues(query["status"]) {
+		filter.Statuses = append(filter.Statuses, model.Status(s))
+	}
+
+	filter.Tags = splitValues(query["tag"])
+
+	for key, due := range map[string]**time.Time{"due_from": &filter.DueFrom, "due_to": &filter.DueTo} {
+		v := query.Get(key)
+		if v == "" {
+			continue
+		}
+
+		t, err := model.ParseTime(v)
+		if err != nil {
+			return repo.Filter{}, fmt.Errorf("%w: bad %s '%s'", repo.ErrInvalidQuery, key, v)
+		}
+
+		*due = &t
+	}
+
+	if v := query.Get("sort"); v != "" {
+		field, desc, err := repo.ParseSort(v)
+		if err != nil {
+			return repo.Filter{}, err
+		}
+
+		filter.Sort, filter.Desc = field, desc
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return repo.Filter{}, fmt.Errorf("%w: bad limit '%s'", repo.ErrInvalidQuery, v)
+		}
+
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+func splitValues(values []string) []string {
+	result := []string{}
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if s != "" {
+				result = append(result, s)
+			}
+		}
+	}
+
+	return result
+}
+
+// GetAll lists every todo. With a query string (see parseFilter) it runs
+// repo.Repository.Query instead, and sends the cursor of the next page in
+// the X-Next-Cursor header.
 func (h *HandlerTodo) GetAll(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	if len(r.URL.Query()) != 0 {
+		h.query(w, r)
+		return
+	}
+
+	ctx := r.Context()
 	todos, err := h.repo.GetAll(ctx)
 	if err != nil {
-		sendJson(w, http.StatusInternalServerError, map[string]interface{}{
-			"error":  "failed to get all todos",
-			"reason": err.Error(),
-		})
+		sendRepoError(w, r, err)
 		return
 	}
 
 	sendJson(w, http.StatusOK, todos)
 }
 
+func (h *HandlerTodo) query(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	page, err := h.repo.Query(ctx, filter)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	if page.Next != "" {
+		w.Header().Set("X-Next-Cursor", page.Next)
+	}
+
+	sendJson(w, http.StatusOK, page.Todos)
+}
+
 func (h *HandlerTodo) GetById(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)   //
 	id := vars["todo-id"] //
 	if id == "" {
-		sendJson(w, http.StatusBadRequest, map[string]interface{}{
-			"error": "missing id",
-		})
+		sendProblem(w, r, http.StatusBadRequest, CodeBadRequest, "missing id")
 		return
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	todo, err := h.repo.Get(ctx, id)
 	if err != nil {
-		sendJson(w, http.StatusInternalServerError, map[string]interface{}{
-			"error":  fmt.Sprintf("failed to get todo %s", id),
-			"reason": err.Error(),
-		})
+		sendRepoError(w, r, err)
 		return
 	}
 
@@ -114,10 +232,8 @@ func (h *HandlerTodo) GetById(w http.ResponseWriter, r *http.Request) {
 func (h *HandlerTodo) GetAllStatus(w http.ResponseWriter, r *http.Request) {
 	b, err := readBody(r)
 	if err != nil {
-		sendJson(w, http.StatusBadRequest, map[string]interface{}{
-			"error":  "failed to read body",
-			"reason": err.Error(),
-		})
+		sendBadRequest(w, r, "failed to read body", err)
+		return
 	}
 
 	type req struct {
@@ -127,32 +243,18 @@ func (h *HandlerTodo) GetAllStatus(w http.ResponseWriter, r *http.Request) {
 	var rr req
 	err = json.Unmarshal(b, &rr)
 	if err != nil {
-		sendJson(w, 400, map[string]interface{}{
-			"err":    "unmarshal body error",
-			"reason": err.Error(),
-		})
-		return
-	}
-
-	if !rr.Status.IsValid() {
-		sendJson(w, 400, map[string]interface{}{
-			"err":    "invalid status",
-			"reason": fmt.Sprintf("bad status '%s'", rr.Status),
-		})
+		sendBadRequest(w, r, "unmarshal body error", err)
 		return
 	}
 
 	if rr.Status == "" {
-		rr.Status = model.StatusTodo
+		rr.Status = h.workflow.InitialStatus()
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	statusTodoList, err := h.repo.GetByStatus(ctx, rr.Status)
 	if err != nil {
-		sendJson(w, 400, map[string]interface{}{
-			"err":    "invalid status",
-			"reason": err.Error(),
-		})
+		sendRepoError(w, r, err)
 		return
 	}
 
@@ -164,24 +266,19 @@ func (h *HandlerTodo) Delete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, ok := vars["todo-id"]
 	if !ok {
-		sendJson(w, http.StatusBadRequest, map[string]interface{}{
-			"error": "missing id",
-		})
+		sendProblem(w, r, http.StatusBadRequest, CodeBadRequest, "missing id")
 		return
 	}
 
-	ctx := context.Background()
-	todo, err := h.repo.Remove(ctx, id)
+	ctx := r.Context()
+	todo, err := h.repo.Remove(ctx, id, repo.AnyVersion)
 	if err != nil {
-		sendJson(w, http.StatusInternalServerError, map[string]interface{}{
-			"error":  fmt.Sprintf("failed to remove id %s", id),
-			"reason": err.Error(),
-		})
+		sendRepoError(w, r, err)
 		return
 	}
 
 	sendJson(w, http.StatusOK, map[string]interface{}{
-		"sucess":  "ok",
+		"success": "ok",
 		"deleted": todo,
 	})
 }
@@ -189,35 +286,27 @@ func (h *HandlerTodo) Delete(w http.ResponseWriter, r *http.Request) {
 func (h *HandlerTodo) UpdateId(w http.ResponseWriter, r *http.Request) {
 	b, err := readBody(r)
 	if err != nil {
-		sendJson(w, http.StatusBadRequest, map[string]interface{}{
-			"error":  "failed to read body",
-			"reason": err.Error(),
-		})
+		sendBadRequest(w, r, "failed to read body", err)
 		return
 	}
 
 	vars := mux.Vars(r)
 	id := vars["todo-id"]
 	if id == "" {
-		sendJson(w, http.StatusBadRequest, map[string]interface{}{
-			"err": "missing id",
-		})
+		sendProblem(w, r, http.StatusBadRequest, CodeBadRequest, "missing id")
 		return
 	}
 
-	ctx := context.Background()
-	todo, err := h.repo.UpdateData(ctx, id, string(b))
+	ctx := r.Context()
+	todo, err := h.repo.UpdateData(ctx, id, string(b), repo.AnyVersion)
 	if err != nil {
-		sendJson(w, http.StatusInternalServerError, map[string]interface{}{
-			"error":  fmt.Sprintf("failed to update id %s", id),
-			"reason": err.Error(),
-		})
+		sendRepoError(w, r, err)
 		return
 	}
 
 	sendJson(w, http.StatusOK, map[string]interface{}{
-		"sucess": fmt.Sprintf("update to id %s", id),
-		"update": todo,
+		"success": fmt.Sprintf("update to id %s", id),
+		"update":  todo,
 	})
 }
 
@@ -226,18 +315,13 @@ func (h *HandlerTodo) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["todo-id"]
 	if id == "" {
-		sendJson(w, http.StatusBadRequest, map[string]interface{}{
-			"err": "missing id",
-		})
+		sendProblem(w, r, http.StatusBadRequest, CodeBadRequest, "missing id")
 		return
 	}
 
 	b, err := readBody(r)
 	if err != nil {
-		sendJson(w, 400, map[string]interface{}{
-			"err":    "read body error",
-			"reason": err.Error(),
-		})
+		sendBadRequest(w, r, "read body error", err)
 		return
 	}
 
@@ -248,32 +332,19 @@ func (h *HandlerTodo) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 	var rr req
 	err = json.Unmarshal(b, &rr)
 	if err != nil {
-		sendJson(w, 400, map[string]interface{}{
-			"err":    "unmarshal body error",
-			"reason": err.Error(),
-		})
-		return
-	}
-
-	if !rr.Status.IsValid() {
-		sendJson(w, 400, map[string]interface{}{
-			"err":    "invalid status",
-			"reason": fmt.Sprintf("bad status '%s'", rr.Status),
-		})
+		sendBadRequest(w, r, "unmarshal body error", err)
 		return
 	}
 
 	if rr.Status == "" {
-		rr.Status = model.StatusTodo
+		sendRepoError(w, r, fmt.Errorf("%w: missing status", repo.ErrInvalidStatus))
+		return
 	}
 
-	ctx := context.Background()
-	status, err := h.repo.UpdateStatus(ctx, id, rr.Status)
+	ctx := r.Context()
+	status, err := h.repo.UpdateStatus(ctx, id, rr.Status, repo.AnyVersion)
 	if err != nil {
-		sendJson(w, 500, map[string]interface{}{
-			"err":    "update-status error",
-			"reason": err.Error(),
-		})
+		sendRepoError(w, r, err)
 		return
 	}
 
@@ -282,3 +353,58 @@ func (h *HandlerTodo) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 		"update-status": status,
 	})
 }
+
+// Update replaces the editable fields of a todo with the json body
+// {"data":"...","status":"TODO","due_at":"...","priority":1,"tags":["..."],"notes":"..."}
+// Without a status, the todo keeps the one it has.
+func (h *HandlerTodo) Update(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["todo-id"]
+	if id == "" {
+		sendProblem(w, r, http.StatusBadRequest, CodeBadRequest, "missing id")
+		return
+	}
+
+	b, err := readBody(r)
+	if err != nil {
+		sendBadRequest(w, r, "failed to read body", err)
+		return
+	}
+
+	var todo model.Todo
+	err = json.Unmarshal(b, &todo)
+	if err != nil {
+		sendBadRequest(w, r, "unmarshal body error", err)
+		return
+	}
+
+	todo.Id = id
+
+	ctx := r.Context()
+	if todo.Status == "" {
+		stored, err := h.repo.Get(ctx, id)
+		if err != nil {
+			sendRepoError(w, r, err)
+			return
+		}
+
+		todo.Status = stored.Status
+	}
+
+	_, err = h.repo.Update(ctx, todo)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	updated, err := h.repo.Get(ctx, id)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	sendJson(w, http.StatusOK, map[string]interface{}{
+		"success": fmt.Sprintf("update to id %s", id),
+		"update":  updated,
+	})
+}
@@ -0,0 +1,813 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/jsonfilemap"
+)
+
+// testWorkflow lets DONE todos stay done, to reach invalid transitions
+var testWorkflow = model.Workflow{
+	Initial:  model.StatusTodo,
+	Statuses: []model.Status{model.StatusTodo, model.StatusDone},
+	Completed: []model.Status{
+		model.StatusDone,
+	},
+	Transitions: map[model.Status][]model.Status{
+		model.StatusTodo: {model.StatusDone},
+	},
+}
+
+// newServer serves a repository holding todo "1" (TODO) and "2" (DONE)
+func newServer(t *testing.T) http.Handler {
+	t.Helper()
+
+	r := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.json"), repo.WithWorkflow(testWorkflow))
+	for _, todo := range []model.Todo{
+		{Id: "1", Data: "one", Status: model.StatusTodo, Tags: []string{"home"}},
+		{Id: "2", Data: "two", Status: model.StatusDone},
+	} {
+		err := r.Add(context.Background(), todo)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+
+	return newRouter(r, repo.WithWorkflow(testWorkflow))
+}
+
+// newRouter serves r, opened with opts
+func newRouter(r repo.Repository, opts ...repo.Option) http.Handler {
+	router := mux.NewRouter()
+	New(r, opts...).Register(router, true)
+	return router
+}
+
+// errRepo fails every call with err
+type errRepo struct {
+	err error
+}
+
+func (e errRepo) Add(context.Context, model.Todo) error           { return e.err }
+func (e errRepo) GetAll(context.Context) ([]model.Todo, error)    { return nil, e.err }
+func (e errRepo) Get(context.Context, string) (model.Todo, error) { return model.Todo{}, e.err }
+func (e errRepo) GetByStatus(context.Context, model.Status) ([]model.Todo, error) {
+	return nil, e.err
+}
+func (e errRepo) Query(context.Context, repo.Filter) (repo.Page, error) { return repo.Page{}, e.err }
+func (e errRepo) UpdateData(context.Context, string, string, int64) (model.Todo, error) {
+	return model.Todo{}, e.err
+}
+func (e errRepo) UpdateStatus(context.Context, string, model.Status, int64) (model.Todo, error) {
+	return model.Todo{}, e.err
+}
+func (e errRepo) Update(context.Context, model.Todo) (model.Todo, error) {
+	return model.Todo{}, e.err
+}
+func (e errRepo) Remove(context.Context, string, int64) (model.Todo, error) {
+	return model.Todo{}, e.err
+}
+func (e errRepo) AddMany(context.Context, []model.Todo) ([]model.Todo, error) { return nil, e.err }
+func (e errRepo) UpdateMany(context.Context, []model.Todo) ([]model.Todo, []model.Todo, error) {
+	return nil, nil, e.err
+}
+func (e errRepo) RemoveMany(context.Context, []string) ([]model.Todo, error) { return nil, e.err }
+func (e errRepo) Trash(context.Context) ([]model.Todo, error)                { return nil, e.err }
+func (e errRepo) Restore(context.Context, string) (model.Todo, error)        { return model.Todo{}, e.err }
+func (e errRepo) Purge(context.Context, string) (model.Todo, error)          { return model.Todo{}, e.err }
+func (e errRepo) PurgeBefore(context.Context, time.Time) ([]model.Todo, error) {
+	return nil, e.err
+}
+
+// goneRepo is r where every todo is gone by the time Get reads it, like
+// removed by another client right after a write
+type goneRepo struct {
+	repo.Repository
+}
+
+func (goneRepo) Get(_ context.Context, id string) (model.Todo, error) {
+	return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
+}
+
+// errReader fails every read, like a client dropping the connection
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+type testCase struct {
+	name        string
+	method      string
+	path        string
+	body        string
+	contentType string
+	header      map[string]string
+
+	// handler serves the request instead of newServer
+	handler http.Handler
+	reader  io.Reader
+
+	status int
+
+	// code is the expected problem code, for error responses
+	code string
+
+	// check looks at a successful body
+	check func(t *testing.T, body []byte)
+}
+
+func run(t *testing.T, tests []testCase) {
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := tc.handler
+			if handler == nil {
+				handler = newServer(t)
+			}
+
+			var body io.Reader = strings.NewReader(tc.body)
+			if tc.reader != nil {
+				body = tc.reader
+			}
+
+			req := httptest.NewRequest(tc.method, tc.path, body)
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Fatalf("expected status %d but got %d: %s", tc.status, rec.Code, rec.Body)
+			}
+
+			if tc.code != "" {
+				assertProblem(t, rec, tc.code)
+				return
+			}
+
+			if tc.check != nil {
+				tc.check(t, rec.Body.Bytes())
+			}
+		})
+	}
+}
+
+func assertProblem(t *testing.T, rec *httptest.ResponseRecorder, code string) {
+	t.Helper()
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("expected problem content type but got '%s'", ct)
+	}
+
+	var p Problem
+	err := json.Unmarshal(rec.Body.Bytes(), &p)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if p.Code != code || p.Status != rec.Code || p.Title != http.StatusText(rec.Code) || p.Type == "" || p.Instance == "" {
+		t.Errorf("unexpected problem for code '%s': %+v", code, p)
+	}
+}
+
+// decode unmarshals body into v, failing the test on error
+func decode(t *testing.T, body []byte, v interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(body, v)
+	if err != nil {
+		t.Fatalf("unexpected err: %s in %s", err, body)
+	}
+}
+
+func expectTodo(check func(t *testing.T, todo model.Todo)) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		var env struct {
+			Data model.Todo `json:"data"`
+		}
+
+		decode(t, body, &env)
+		check(t, env.Data)
+	}
+}
+
+func expectIds(ids ...string) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		var env struct {
+			Data []model.Todo `json:"data"`
+		}
+
+		decode(t, body, &env)
+		if len(env.Data) != len(ids) {
+			t.Fatalf("expected ids %v but got %d todos", ids, len(env.Data))
+		}
+
+		for i := range ids {
+			if env.Data[i].Id != ids[i] {
+				t.Errorf("expected ids %v but got '%s' at %d", ids, env.Data[i].Id, i)
+			}
+		}
+	}
+}
+
+func TestV1(t *testing.T) {
+	run(t, []testCase{
+		{name: "list", method: http.MethodGet, path: "/v1/todos", status: http.StatusOK, check: expectIds("1", "2")},
+		{name: "list filtered", method: http.MethodGet, path: "/v1/todos?status=DONE", status: http.StatusOK, check: expectIds("2")},
+		{
+			name: "list page", method: http.MethodGet, path: "/v1/todos?limit=1", status: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var env envelope
+				decode(t, body, &env)
+				if env.NextCursor == "" {
+					t.Errorf("expected next_cursor in %s", body)
+				}
+			},
+		},
+		{name: "list bad limit", method: http.MethodGet, path: "/v1/todos?limit=x", status: http.StatusBadRequest, code: CodeInvalidQuery},
+		{name: "list bad status", method: http.MethodGet, path: "/v1/todos?status=NOPE", status: http.StatusUnprocessableEntity, code: CodeInvalidStatus},
+		{name: "list storage", method: http.MethodGet, path: "/v1/todos", handler: newRouter(errRepo{repo.ErrStorage}), status: http.StatusInternalServerError, code: CodeInternal},
+
+		{
+			name: "create", method: http.MethodPost, path: "/v1/todos", body: `{"data":"new","status":"DONE","tags":["a"]}`, status: http.StatusCreated,
+			check: expectTodo(func(t *testing.T, todo model.Todo) {
+				if todo.Id == "" || todo.Data != "new" || todo.Status != model.StatusDone || todo.CompletedAt == nil {
+					t.Errorf("unexpected created todo: %+v", todo)
+				}
+			}),
+		},
+		{
+			name: "create with id", method: http.MethodPost, path: "/v1/todos", body: `{"id":"x","data":"new","created_at":"2024-01-02T03:04:05Z"}`, status: http.StatusCreated,
+			check: expectTodo(func(t *testing.T, todo model.Todo) {
+				if todo.Id != "x" || todo.Status != model.StatusTodo || todo.CreatedAt.Year() != 2024 {
+					t.Errorf("unexpected created todo: %+v", todo)
+				}
+			}),
+		},
+		{name: "create bad json", method: http.MethodPost, path: "/v1/todos", body: `{`, status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "create bad status", method: http.MethodPost, path: "/v1/todos", body: `{"data":"x","status":"NOPE"}`, status: http.StatusUnprocessableEntity, code: CodeInvalidStatus},
+		{name: "create bad priority", method: http.MethodPost, path: "/v1/todos", body: `{"data":"x","priority":99}`, status: http.StatusUnprocessableEntity, code: CodeInvalidTodo},
+		{name: "create conflict", method: http.MethodPost, path: "/v1/todos", body: `{"id":"1","data":"x"}`, status: http.StatusConflict, code: CodeConflict},
+		{name: "create body error", method: http.MethodPost, path: "/v1/todos", reader: errReader{}, status: http.StatusBadRequest, code: CodeBadRequest},
+
+		{
+			name: "read", method: http.MethodGet, path: "/v1/todos/1", status: http.StatusOK,
+			check: expectTodo(func(t *testing.T, todo model.Todo) {
+				if todo.Id != "1" || todo.Data != "one" {
+					t.Errorf("unexpected todo: %+v", todo)
+				}
+			}),
+		},
+		{name: "read missing", method: http.MethodGet, path: "/v1/todos/9", status: http.StatusNotFound, code: CodeNotFound},
+
+		{
+			name: "patch", method: http.MethodPatch, path: "/v1/todos/1", body: `{"status":"DONE","notes":"n"}`, status: http.StatusOK,
+			check: expectTodo(func(t *testing.T, todo model.Todo) {
+				if todo.Data != "one" || todo.Status != model.StatusDone || todo.Notes != "n" || len(todo.Tags) != 1 {
+					t.Errorf("unexpected patched todo: %+v", todo)
+				}
+			}),
+		},
+		{
+			name: "patch clear due", method: http.MethodPatch, path: "/v1/todos/1", body: `{"due_at":null}`, status: http.StatusOK,
+			check: expectTodo(func(t *testing.T, todo model.Todo) {
+				if todo.DueAt != nil {
+					t.Errorf("expected due_at cleared: %+v", todo)
+				}
+			}),
+		},
+		{name: "patch bad due", method: http.MethodPatch, path: "/v1/todos/1", body: `{"due_at":"tomorrow"}`, status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "patch bad json", method: http.MethodPatch, path: "/v1/todos/1", body: `[]`, status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "patch missing", method: http.MethodPatch, path: "/v1/todos/9", body: `{}`, status: http.StatusNotFound, code: CodeNotFound},
+		{name: "patch transition", method: http.MethodPatch, path: "/v1/todos/2", body: `{"status":"TODO"}`, status: http.StatusConflict, code: CodeInvalidTransition},
+
+		{
+			name: "put", method: http.MethodPut, path: "/v1/todos/1", body: `{"data":"new","status":"TODO"}`, status: http.StatusOK,
+			check: expectTodo(func(t *testing.T, todo model.Todo) {
+				if todo.Data != "new" || len(todo.Tags) != 0 {
+					t.Errorf("unexpected replaced todo: %+v", todo)
+				}
+			}),
+		},
+		{name: "put no status", method: http.MethodPut, path: "/v1/todos/1", body: `{"data":"new"}`, status: http.StatusUnprocessableEntity, code: CodeInvalidStatus},
+		{name: "put bad json", method: http.MethodPut, path: "/v1/todos/1", body: `x`, status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "put missing", method: http.MethodPut, path: "/v1/todos/9", body: `{"data":"new","status":"TODO"}`, status: http.StatusNotFound, code: CodeNotFound},
+
+		{name: "delete", method: http.MethodDelete, path: "/v1/todos/2", status: http.StatusOK, check: expectTodo(func(t *testing.T, todo model.Todo) {
+			if todo.Id != "2" {
+				t.Errorf("unexpected removed todo: %+v", todo)
+			}
+		})},
+		{name: "delete missing", method: http.MethodDelete, path: "/v1/todos/9", status: http.StatusNotFound, code: CodeNotFound},
+
+		{name: "unknown route", method: http.MethodGet, path: "/v2/todos", status: http.StatusNotFound, code: CodeNotFound},
+		{name: "method not allowed", method: http.MethodPost, path: "/v1/todos/1", status: http.StatusMethodNotAllowed, code: CodeMethodNotAllowed},
+	})
+}
+
+func expectBatch(todos []string, previous []string) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		var env struct {
+			Data batchResult `json:"data"`
+		}
+
+		decode(t, body, &env)
+		if !equalIds(env.Data.Todos, todos) || !equalIds(env.Data.Previous, previous) {
+			t.Errorf("expected todos %v and previous %v but got %s", todos, previous, body)
+		}
+	}
+}
+
+func equalIds(todos []model.Todo, ids []string) bool {
+	if len(todos) != len(ids) {
+		return false
+	}
+
+	for i := range ids {
+		if todos[i].Id != ids[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestBatch(t *testing.T) {
+	path := "/v1/todos:batch"
+
+	run(t, []testCase{
+		{name: "add", method: http.MethodPost, path: path, body: `{"action":"add","todos":[{"id":"3","data":"three"},{"id":"4","data":"four"}]}`, status: http.StatusOK, check: expectBatch([]string{"3", "4"}, nil)},
+		{
+			name: "add default id", method: http.MethodPost, path: path, body: `{"action":"add","todos":[{"data":"new"}]}`, status: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var env struct {
+					Data batchResult `json:"data"`
+				}
+
+				decode(t, body, &env)
+				if len(env.Data.Todos) != 1 || env.Data.Todos[0].Id == "" || env.Data.Todos[0].Status != model.StatusTodo {
+					t.Errorf("unexpected added todos: %s", body)
+				}
+			},
+		},
+		{name: "add conflict", method: http.MethodPost, path: path, body: `{"action":"add","todos":[{"id":"3","data":"three"},{"id":"1","data":"x"}]}`, status: http.StatusConflict, code: CodeConflict},
+		{name: "add invalid", method: http.MethodPost, path: path, body: `{"action":"add","todos":[{"data":"x","priority":99}]}`, status: http.StatusUnprocessableEntity, code: CodeInvalidTodo},
+		{name: "update", method: http.MethodPost, path: path, body: `{"action":"update","todos":[{"id":"1","data":"uno","status":"DONE"},{"id":"2","data":"dos","status":"DONE"}]}`, status: http.StatusOK, check: expectBatch([]string{"1", "2"}, []string{"1", "2"})},
+		{name: "update missing", method: http.MethodPost, path: path, body: `{"action":"update","todos":[{"id":"1","data":"uno","status":"DONE"},{"id":"9","data":"x","status":"TODO"}]}`, status: http.StatusNotFound, code: CodeNotFound},
+		{name: "update transition", method: http.MethodPost, path: path, body: `{"action":"update","todos":[{"id":"2","data":"two","status":"TODO"}]}`, status: http.StatusConflict, code: CodeInvalidTransition},
+		{name: "remove", method: http.MethodPost, path: path, body: `{"action":"remove","ids":["2","1"]}`, status: http.StatusOK, check: expectBatch(nil, []string{"2", "1"})},
+		{name: "remove missing", method: http.MethodPost, path: path, body: `{"action":"remove","ids":["1","9"]}`, status: http.StatusNotFound, code: CodeNotFound},
+		{name: "remove twice", method: http.MethodPost, path: path, body: `{"action":"remove","ids":["1","1"]}`, status: http.StatusConflict, code: CodeConflict},
+		{name: "unknown action", method: http.MethodPost, path: path, body: `{"action":"merge"}`, status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "bad json", method: http.MethodPost, path: path, body: `{`, status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "too large", method: http.MethodPost, path: path, body: `{"action":"remove","ids":[` + strings.Repeat(`"x",`, maxBatch) + `"x"]}`, status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "storage", method: http.MethodPost, path: path, body: `{"action":"remove","ids":["1"]}`, handler: newRouter(errRepo{repo.ErrStorage}), status: http.StatusInternalServerError, code: CodeInternal},
+	})
+
+	// the response is what the batch wrote, whatever happens after it
+	r := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.json"), repo.WithWorkflow(testWorkflow))
+	run(t, []testCase{
+		{name: "add then gone", method: http.MethodPost, path: path, body: `{"action":"add","todos":[{"id":"1","data":"one"}]}`, handler: newRouter(goneRepo{r}), status: http.StatusOK, check: expectBatch([]string{"1"}, nil)},
+		{name: "update then gone", method: http.MethodPost, path: path, body: `{"action":"update","todos":[{"id":"1","data":"uno","status":"DONE"}]}`, handler: newRouter(goneRepo{r}), status: http.StatusOK, check: expectBatch([]string{"1"}, []string{"1"})},
+	})
+
+	// a failed batch changes nothing, and its problem points at the todo
+	handler := newServer(t)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"action":"remove","ids":["1","9"]}`)))
+
+	var p Problem
+	decode(t, rec.Body.Bytes(), &p)
+	if p.Index == nil || *p.Index != 1 || strings.Contains(p.Detail, "of the batch") {
+		t.Errorf("expected the index 1 in the problem but got %+v", p)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/todos", nil))
+	expectIds("1", "2")(t, rec.Body.Bytes())
+}
+
+func TestETag(t *testing.T) {
+	handler := newServer(t)
+
+	send := func(method string, path string, header string, value string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	steps := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		body   string
+		status int
+		etag   string
+	}{
+		{name: "read", method: http.MethodGet, status: http.StatusOK, etag: `"1"`},
+		{name: "read unchanged", method: http.MethodGet, header: "If-None-Match", value: `"1"`, status: http.StatusNotModified, etag: `"1"`},
+		{name: "read unchanged weak", method: http.MethodGet, header: "If-None-Match", value: `"7", W/"1"`, status: http.StatusNotModified, etag: `"1"`},
+		{name: "read changed", method: http.MethodGet, header: "If-None-Match", value: `"7"`, status: http.StatusOK, etag: `"1"`},
+		{name: "patch", method: http.MethodPatch, header: "If-Match", value: `"1"`, body: `{"data":"uno"}`, status: http.StatusOK, etag: `"2"`},
+		{name: "patch stale", method: http.MethodPatch, header: "If-Match", value: `"1"`, body: `{"data":"lost"}`, status: http.StatusPreconditionFailed},
+		{name: "patch weak", method: http.MethodPatch, header: "If-Match", value: `W/"2"`, body: `{"data":"lost"}`, status: http.StatusPreconditionFailed},
+		{name: "patch any", method: http.MethodPatch, header: "If-Match", value: `*`, body: `{"notes":"n"}`, status: http.StatusOK, etag: `"3"`},
+		{name: "put stale body", method: http.MethodPut, body: `{"data":"lost","status":"TODO","version":2}`, status: http.StatusPreconditionFailed},
+		{name: "put", method: http.MethodPut, header: "If-Match", value: `"3"`, body: `{"data":"one","status":"TODO","version":2}`, status: http.StatusOK, etag: `"4"`},
+		{name: "delete stale", method: http.MethodDelete, header: "If-Match", value: `"3"`, status: http.StatusPreconditionFailed},
+		{name: "delete", method: http.MethodDelete, header: "If-Match", value: `"3", "4"`, status: http.StatusOK},
+		{name: "read deleted", method: http.MethodGet, header: "If-None-Match", value: `"4"`, status: http.StatusNotFound},
+	}
+
+	for _, step := range steps {
+		rec := send(step.method, "/v1/todos/1", step.header, step.value, step.body)
+		if rec.Code != step.status {
+			t.Fatalf("%s: expected status %d but got %d: %s", step.name, step.status, rec.Code, rec.Body)
+		}
+
+		if etag := rec.Header().Get("ETag"); etag != step.etag {
+			t.Errorf("%s: expected ETag '%s' but got '%s'", step.name, step.etag, etag)
+		}
+
+		switch rec.Code {
+		case http.StatusNotModified:
+			if rec.Body.Len() != 0 {
+				t.Errorf("%s: expected no body but got %s", step.name, rec.Body)
+			}
+		case http.StatusPreconditionFailed:
+			assertProblem(t, rec, CodeVersionMismatch)
+		}
+	}
+
+	// a stale todo of a batch fails the batch
+	rec := send(http.MethodPost, "/v1/todos:batch", "", "", `{"action":"update","todos":[{"id":"2","data":"two","status":"DONE","version":9}]}`)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status 412 but got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	handler := newServer(t)
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		check  func(t *testing.T, body []byte)
+	}{
+		{name: "empty", method: http.MethodGet, path: "/v1/trash", status: http.StatusOK, check: expectIds()},
+		{name: "remove", method: http.MethodDelete, path: "/v1/todos/2", status: http.StatusOK},
+		{name: "list", method: http.MethodGet, path: "/v1/trash", status: http.StatusOK, check: expectIds("2")},
+		{name: "hidden", method: http.MethodGet, path: "/v1/todos", status: http.StatusOK, check: expectIds("1")},
+		{name: "read removed", method: http.MethodGet, path: "/v1/todos/2", status: http.StatusNotFound},
+		{name: "create removed id", method: http.MethodPost, path: "/v1/todos", body: `{"id":"2","data":"x"}`, status: http.StatusConflict},
+		{name: "restore live", method: http.MethodPost, path: "/v1/trash/1:restore", status: http.StatusNotFound},
+		{
+			name: "restore", method: http.MethodPost, path: "/v1/trash/2:restore", status: http.StatusOK,
+			check: expectTodo(func(t *testing.T, todo model.Todo) {
+				if todo.Id != "2" || todo.DeletedAt != nil || todo.Version != 3 {
+					t.Errorf("unexpected restored todo: %+v", todo)
+				}
+			}),
+		},
+		{name: "restored", method: http.MethodGet, path: "/v1/todos", status: http.StatusOK, check: expectIds("1", "2")},
+		{name: "remove again", method: http.MethodPost, path: "/v1/todos:batch", body: `{"action":"remove","ids":["1","2"]}`, status: http.StatusOK},
+		{name: "purge live", method: http.MethodDelete, path: "/v1/trash/9", status: http.StatusNotFound},
+		{name: "purge", method: http.MethodDelete, path: "/v1/trash/1", status: http.StatusOK, check: expectTodo(func(t *testing.T, todo model.Todo) {
+			if todo.Id != "1" {
+				t.Errorf("unexpected purged todo: %+v", todo)
+			}
+		})},
+		{name: "purge twice", method: http.MethodDelete, path: "/v1/trash/1", status: http.StatusNotFound},
+		{name: "purge before", method: http.MethodDelete, path: "/v1/trash?before=2000-01-01", status: http.StatusOK, check: expectIds()},
+		{name: "purge bad before", method: http.MethodDelete, path: "/v1/trash?before=yesterday", status: http.StatusBadRequest},
+		{name: "purge all", method: http.MethodDelete, path: "/v1/trash", status: http.StatusOK, check: expectIds("2")},
+		{name: "purged", method: http.MethodGet, path: "/v1/trash", status: http.StatusOK, check: expectIds()},
+		{name: "create purged id", method: http.MethodPost, path: "/v1/todos", body: `{"id":"2","data":"x"}`, status: http.StatusCreated},
+	}
+
+	for _, step := range steps {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(step.method, step.path, strings.NewReader(step.body)))
+		if rec.Code != step.status {
+			t.Fatalf("%s: expected status %d but got %d: %s", step.name, step.status, rec.Code, rec.Body)
+		}
+
+		if step.check != nil {
+			step.check(t, rec.Body.Bytes())
+		}
+	}
+
+	run(t, []testCase{
+		{name: "list storage", method: http.MethodGet, path: "/v1/trash", handler: newRouter(errRepo{repo.ErrStorage}), status: http.StatusInternalServerError, code: CodeInternal},
+		{name: "restore storage", method: http.MethodPost, path: "/v1/trash/1:restore", handler: newRouter(errRepo{repo.ErrStorage}), status: http.StatusInternalServerError, code: CodeInternal},
+	})
+}
+
+func expectLegacy(key string, check func(t *testing.T, todo model.Todo)) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		var resp map[string]json.RawMessage
+		decode(t, body, &resp)
+
+		if _, ok := resp["success"]; !ok {
+			t.Errorf("expected success in %s", body)
+		}
+
+		var todo model.Todo
+		decode(t, resp[key], &todo)
+		check(t, todo)
+	}
+}
+
+func expectList(ids ...string) func(t *testing.T, body []byte) {
+	return func(t *testing.T, body []byte) {
+		var todos []model.Todo
+		decode(t, body, &todos)
+
+		if len(todos) != len(ids) {
+			t.Fatalf("expected ids %v but got %s", ids, body)
+		}
+
+		for i := range ids {
+			if todos[i].Id != ids[i] {
+				t.Errorf("expected ids %v but got %s", ids, body)
+			}
+		}
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	noCheck := func(t *testing.T, todo model.Todo) {}
+
+	run(t, []testCase{
+		{name: "get-all", method: http.MethodGet, path: "/get-all", status: http.StatusOK, check: expectList("1", "2")},
+		{name: "get-all query", method: http.MethodGet, path: "/get-all?tag=home", status: http.StatusOK, check: expectList("1")},
+		{name: "get-all bad query", method: http.MethodGet, path: "/get-all?sort=nope", status: http.StatusBadRequest, code: CodeInvalidQuery},
+		{name: "get-all storage", method: http.MethodGet, path: "/get-all", handler: newRouter(errRepo{repo.ErrStorage}), status: http.StatusInternalServerError, code: CodeInternal},
+		{name: "get-all query storage", method: http.MethodGet, path: "/get-all?q=x", handler: newRouter(errRepo{repo.ErrStorage}), status: http.StatusInternalServerError, code: CodeInternal},
+
+		{name: "get-all-status", method: http.MethodGet, path: "/get-all-status", body: `{"status":"DONE"}`, status: http.StatusOK, check: expectList("2")},
+		{name: "get-all-status default", method: http.MethodGet, path: "/get-all-status", body: `{}`, status: http.StatusOK, check: expectList("1")},
+		{name: "get-all-status bad status", method: http.MethodGet, path: "/get-all-status", body: `{"status":"NOPE"}`, status: http.StatusUnprocessableEntity, code: CodeInvalidStatus},
+		{name: "get-all-status bad json", method: http.MethodGet, path: "/get-all-status", body: `{`, status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "get-all-status body error", method: http.MethodGet, path: "/get-all-status", reader: errReader{}, status: http.StatusBadRequest, code: CodeBadRequest},
+
+		{name: "get", method: http.MethodGet, path: "/get/1", status: http.StatusOK, check: func(t *testing.T, body []byte) {
+			var todo model.Todo
+			decode(t, body, &todo)
+			if todo.Id != "1" {
+				t.Errorf("unexpected todo: %+v", todo)
+			}
+		}},
+		{name: "get missing", method: http.MethodGet, path: "/get/9", status: http.StatusNotFound, code: CodeNotFound},
+
+		{name: "add text", method: http.MethodPost, path: "/add", body: "plain", status: http.StatusCreated, check: expectLegacy("created", func(t *testing.T, todo model.Todo) {
+			if todo.Data != "plain" || todo.Status != model.StatusTodo {
+				t.Errorf("unexpected created todo: %+v", todo)
+			}
+		})},
+		{name: "add json", method: http.MethodPost, path: "/add", body: `{"data":"j","priority":2}`, contentType: "application/json", status: http.StatusCreated, check: expectLegacy("created", func(t *testing.T, todo model.Todo) {
+			if todo.Data != "j" || todo.Priority != model.PriorityMedium {
+				t.Errorf("unexpected created todo: %+v", todo)
+			}
+		})},
+		{name: "add bad json", method: http.MethodPost, path: "/add", body: `{`, contentType: "application/json", status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "add invalid", method: http.MethodPost, path: "/add", body: `{"data":"j","priority":-1}`, contentType: "application/json", status: http.StatusUnprocessableEntity, code: CodeInvalidTodo},
+		{name: "add body error", method: http.MethodPost, path: "/add", reader: errReader{}, status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "add storage", method: http.MethodPost, path: "/add", body: "x", handler: newRouter(errRepo{repo.ErrStorage}), status: http.StatusInternalServerError, code: CodeInternal},
+
+		{name: "delete", method: http.MethodDelete, path: "/delete/1", status: http.StatusOK, check: expectLegacy("deleted", noCheck)},
+		{name: "delete missing", method: http.MethodDelete, path: "/delete/9", status: http.StatusNotFound, code: CodeNotFound},
+
+		{name: "update data", method: http.MethodPatch, path: "/update/1", body: "new", status: http.StatusOK, check: expectLegacy("update", noCheck)},
+		{name: "update data missing", method: http.MethodPatch, path: "/update/9", body: "new", status: http.StatusNotFound, code: CodeNotFound},
+		{name: "update data body error", method: http.MethodPatch, path: "/update/1", reader: errReader{}, status: http.StatusBadRequest, code: CodeBadRequest},
+
+		{name: "update", method: http.MethodPut, path: "/update/1", body: `{"data":"new","status":"DONE"}`, status: http.StatusOK, check: expectLegacy("update", func(t *testing.T, todo model.Todo) {
+			if todo.Data != "new" || todo.Status != model.StatusDone {
+				t.Errorf("unexpected updated todo: %+v", todo)
+			}
+		})},
+		{name: "update missing", method: http.MethodPut, path: "/update/9", body: `{"data":"new"}`, status: http.StatusNotFound, code: CodeNotFound},
+		{name: "update bad json", method: http.MethodPut, path: "/update/1", body: `{`, status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "update transition", method: http.MethodPut, path: "/update/2", body: `{"data":"two","status":"TODO"}`, status: http.StatusConflict, code: CodeInvalidTransition},
+		{name: "update keeps status", method: http.MethodPut, path: "/update/2", body: `{"data":"dos"}`, status: http.StatusOK, check: expectLegacy("update", func(t *testing.T, todo model.Todo) {
+			if todo.Data != "dos" || todo.Status != model.StatusDone || todo.CompletedAt == nil {
+				t.Errorf("unexpected updated todo: %+v", todo)
+			}
+		})},
+		{name: "update body error", method: http.MethodPut, path: "/update/1", reader: errReader{}, status: http.StatusBadRequest, code: CodeBadRequest},
+
+		{name: "update-status", method: http.MethodPatch, path: "/update-status/1", body: `{"status":"DONE"}`, status: http.StatusOK, check: expectLegacy("update-status", noCheck)},
+		{name: "update-status missing", method: http.MethodPatch, path: "/update-status/9", body: `{"status":"DONE"}`, status: http.StatusNotFound, code: CodeNotFound},
+		{name: "update-status invalid", method: http.MethodPatch, path: "/update-status/1", body: `{"status":"NOPE"}`, status: http.StatusUnprocessableEntity, code: CodeInvalidStatus},
+		{name: "update-status transition", method: http.MethodPatch, path: "/update-status/2", body: `{"status":"TODO"}`, status: http.StatusConflict, code: CodeInvalidTransition},
+		{name: "update-status no status", method: http.MethodPatch, path: "/update-status/1", body: `{}`, status: http.StatusUnprocessableEntity, code: CodeInvalidStatus},
+		{name: "update-status bad json", method: http.MethodPatch, path: "/update-status/1", body: `{`, status: http.StatusBadRequest, code: CodeBadRequest},
+		{name: "update-status body error", method: http.MethodPatch, path: "/update-status/1", reader: errReader{}, status: http.StatusBadRequest, code: CodeBadRequest},
+	})
+}
+
+// TestLegacyWorkflow checks that the legacy defaults follow the workflow
+func TestLegacyWorkflow(t *testing.T) {
+	wf := model.Workflow{
+		Initial:   "OPEN",
+		Statuses:  []model.Status{"OPEN", "CLOSED"},
+		Completed: []model.Status{"CLOSED"},
+	}
+
+	r := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.json"), repo.WithWorkflow(wf))
+	for _, todo := range []model.Todo{
+		{Id: "1", Data: "one", Status: "OPEN"},
+		{Id: "2", Data: "two", Status: "CLOSED"},
+	} {
+		err := r.Add(context.Background(), todo)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+
+	handler := newRouter(r, repo.WithWorkflow(wf))
+
+	run(t, []testCase{
+		{name: "get-all-status default", method: http.MethodGet, path: "/get-all-status", body: `{}`, handler: handler, status: http.StatusOK, check: expectList("1")},
+		{name: "update no status", method: http.MethodPut, path: "/update/1", body: `{"data":"uno"}`, handler: handler, status: http.StatusOK, check: expectLegacy("update", func(t *testing.T, todo model.Todo) {
+			if todo.Data != "uno" || todo.Status != "OPEN" {
+				t.Errorf("unexpected updated todo: %+v", todo)
+			}
+		})},
+	})
+}
+
+func TestLegacyDisabled(t *testing.T) {
+	router := mux.NewRouter()
+	New(errRepo{}).Register(router, false)
+
+	run(t, []testCase{
+		{name: "get-all", method: http.MethodGet, path: "/get-all", handler: router, status: http.StatusNotFound, code: CodeNotFound},
+		{name: "add", method: http.MethodPost, path: "/add", handler: router, status: http.StatusNotFound, code: CodeNotFound},
+	})
+}
+
+// slowRepo blocks every call until its context is done
+type slowRepo struct {
+	errRepo
+}
+
+func (slowRepo) GetAll(ctx context.Context) ([]model.Todo, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (slowRepo) Query(ctx context.Context, _ repo.Filter) (repo.Page, error) {
+	<-ctx.Done()
+	return repo.Page{}, ctx.Err()
+}
+
+func TestTimeout(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Timeout(10 * time.Millisecond))
+	New(slowRepo{}).Register(router, true)
+
+	run(t, []testCase{
+		{name: "v1", method: http.MethodGet, path: "/v1/todos", handler: router, status: http.StatusServiceUnavailable, code: CodeTimeout},
+		{name: "legacy", method: http.MethodGet, path: "/get-all", handler: router, status: http.StatusServiceUnavailable, code: CodeTimeout},
+		{name: "canceled", method: http.MethodGet, path: "/v1/todos", handler: newRouter(errRepo{context.Canceled}), status: http.StatusServiceUnavailable, code: CodeCanceled},
+	})
+}
+
+func TestServerErrorDetail(t *testing.T) {
+	logs := bytes.NewBuffer(nil)
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	storage := fmt.Errorf("%w: open /var/lib/todo/todo.json: permission denied", repo.ErrStorage)
+	batch := &repo.BatchError{Index: 1, Err: storage}
+
+	tests := []struct {
+		err    error
+		detail string
+	}{
+		{err: storage, detail: "storage error"},
+		{err: batch, detail: "storage error"},
+		{err: fmt.Errorf("zrangebyscore redis err: %w", context.DeadlineExceeded), detail: "request timed out"},
+		{err: fmt.Errorf("%w: no id: 42", repo.ErrNotFound), detail: "not found: no id: 42"},
+	}
+
+	for _, tt := range tests {
+		logs.Reset()
+		rec := httptest.NewRecorder()
+		newRouter(errRepo{tt.err}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/todos", nil))
+
+		var p Problem
+		decode(t, rec.Body.Bytes(), &p)
+		if p.Detail != tt.detail {
+			t.Errorf("expected detail '%s' but got '%s'", tt.detail, p.Detail)
+		}
+
+		// server errors are logged, not sent
+		logged := strings.Contains(logs.String(), tt.err.Error())
+		if logged != (rec.Code >= http.StatusInternalServerError) {
+			t.Errorf("unexpected log for status %d: '%s'", rec.Code, logs)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Auth("secret"))
+	New(errRepo{repo.ErrNotFound}).Register(router, true)
+
+	run(t, []testCase{
+		{name: "no token", method: http.MethodGet, path: "/v1/todos", handler: router, status: http.StatusUnauthorized, code: CodeUnauthorized},
+		{name: "wrong token", method: http.MethodGet, path: "/v1/todos", header: map[string]string{"Authorization": "Bearer guess"}, handler: router, status: http.StatusUnauthorized, code: CodeUnauthorized},
+		{name: "not bearer", method: http.MethodGet, path: "/v1/todos", header: map[string]string{"Authorization": "secret"}, handler: router, status: http.StatusUnauthorized, code: CodeUnauthorized},
+		{name: "legacy", method: http.MethodGet, path: "/get-all", handler: router, status: http.StatusUnauthorized, code: CodeUnauthorized},
+		{name: "token", method: http.MethodGet, path: "/v1/todos/1", header: map[string]string{"Authorization": "Bearer secret"}, handler: router, status: http.StatusNotFound, code: CodeNotFound},
+	})
+}
+
+// every route served by Register is in the OpenAPI document, and the other way round
+func TestOpenAPI(t *testing.T) {
+	router := mux.NewRouter()
+	New(errRepo{}).Register(router, true)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rec.Code)
+	}
+
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	decode(t, rec.Body.Bytes(), &doc)
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("expected an OpenAPI 3 document but got version '%s'", doc.OpenAPI)
+	}
+
+	served := make(map[string]bool)
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, method := range methods {
+			op := strings.ToLower(method) + " " + path
+			served[op] = true
+			if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+				t.Errorf("route '%s' is missing from openapi.json", op)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if method != "parameters" && !served[method+" "+path] {
+				t.Errorf("openapi.json documents '%s %s' which is not served", method, path)
+			}
+		}
+	}
+}
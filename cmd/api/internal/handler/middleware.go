@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Timeout gives every request a deadline of d. Repository calls made with
+// the request context give up once it passes, and the request fails with
+// a timeout problem.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Auth rejects requests without the header "Authorization: Bearer <token>".
+// An empty token lets every request through.
+func Auth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if token == "" {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="todo"`)
+				sendProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "missing or invalid bearer token")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
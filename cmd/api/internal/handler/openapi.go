@@ -0,0 +1,17 @@
+package handler
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPI describes every route of Register, keep it in sync
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// OpenAPI handles GET /openapi.json
+func (h *HandlerTodo) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPI)
+}
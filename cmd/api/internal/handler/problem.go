@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/eymyong/todo/repo"
+)
+
+// Problem is the body of every error response, an RFC 7807 problem detail:
+//
+//	{
+//	  "type": "about:blank",
+//	  "title": "Not Found",
+//	  "status": 404,
+//	  "detail": "not found: no id: 42",
+//	  "instance": "/v1/todos/42",
+//	  "code": "not_found"
+//	}
+//
+// Code is the machine-readable reason, one of the Code* constants.
+// Index is set when a todo of a batch failed, to its index in the batch.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	Index    *int   `json:"index,omitempty"`
+}
+
+// Problem codes
+const (
+	CodeBadRequest        = "bad_request"
+	CodeNotFound          = "not_found"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeInvalidStatus     = "invalid_status"
+	CodeInvalidTodo       = "invalid_todo"
+	CodeInvalidQuery      = "invalid_query"
+	CodeInvalidTransition = "invalid_transition"
+	CodeConflict          = "conflict"
+	CodeVersionMismatch   = "version_mismatch"
+	CodeTimeout           = "timeout"
+	CodeCanceled          = "canceled"
+	CodeUnauthorized      = "unauthorized"
+	CodeInternal          = "internal"
+)
+
+// statusCode maps errors from repo.Repository to HTTP status codes
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		// the request ran out of time, or the server is shutting down
+		return http.StatusServiceUnavailable
+	case errors.Is(err, repo.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repo.ErrVersionMismatch):
+		// the todo changed since the version the client expects
+		return http.StatusPreconditionFailed
+	case errors.Is(err, repo.ErrInvalidQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, repo.ErrInvalidStatus), errors.Is(err, repo.ErrInvalidTodo):
+		// the request was well formed, but the todo in it is not acceptable
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, repo.ErrConflict), errors.Is(err, repo.ErrInvalidTransition):
+		// an invalid transition depends on the todo's current status,
+		// so it is reported like any other conflict with stored state
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+// errorCode maps errors from repo.Repository to problem codes
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeTimeout
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.Is(err, repo.ErrNotFound):
+		return CodeNotFound
+	case errors.Is(err, repo.ErrInvalidStatus):
+		return CodeInvalidStatus
+	case errors.Is(err, repo.ErrInvalidTodo):
+		return CodeInvalidTodo
+	case errors.Is(err, repo.ErrInvalidQuery):
+		return CodeInvalidQuery
+	case errors.Is(err, repo.ErrInvalidTransition):
+		return CodeInvalidTransition
+	case errors.Is(err, repo.ErrConflict):
+		return CodeConflict
+	case errors.Is(err, repo.ErrVersionMismatch):
+		return CodeVersionMismatch
+	}
+
+	return CodeInternal
+}
+
+func newProblem(r *http.Request, status int, code string, detail string) Problem {
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	}
+}
+
+func writeProblem(w http.ResponseWriter, problem Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+func sendProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	writeProblem(w, newProblem(r, status, code, detail))
+}
+
+// serverDetail is the detail of the problems of server errors, whose
+// messages (file paths, addresses, driver errors) stay in the server log
+func serverDetail(code string) string {
+	switch code {
+	case CodeTimeout:
+		return "request timed out"
+	case CodeCanceled:
+		return "request canceled"
+	}
+
+	return "storage error"
+}
+
+// sendRepoError reports an error from repo.Repository
+func sendRepoError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := statusCode(err), errorCode(err)
+
+	detail := err.Error()
+	var batchErr *repo.BatchError
+	if errors.As(err, &batchErr) {
+		detail = batchErr.Err.Error()
+	}
+
+	if status >= http.StatusInternalServerError {
+		log.Printf("%s %s: %s", r.Method, r.URL.Path, err)
+		detail = serverDetail(code)
+	}
+
+	problem := newProblem(r, status, code, detail)
+	if batchErr != nil {
+		// the index is in its own field, for clients to point at the todo
+		problem.Index = &batchErr.Index
+	}
+
+	writeProblem(w, problem)
+}
+
+// sendBadRequest reports a request that could not be read
+func sendBadRequest(w http.ResponseWriter, r *http.Request, message string, err error) {
+	sendProblem(w, r, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("%s: %s", message, err))
+}
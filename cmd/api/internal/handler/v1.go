@@ -0,0 +1,450 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+)
+
+/*
+The /v1 API is resource oriented:
+
+	GET    /v1/todos        list, with the query string of parseFilter
+	POST   /v1/todos        create from a json body
+	GET    /v1/todos/{id}   read
+	PATCH  /v1/todos/{id}   change the fields present in the json body
+	PUT    /v1/todos/{id}   replace the editable fields with the json body
+	DELETE /v1/todos/{id}   remove, moving the todo to the trash
+	POST   /v1/todos:batch  add, update or remove many todos at once
+
+	GET    /v1/workflow            the statuses todos follow, for clients to share them
+
+	GET    /v1/trash               list the todos in the trash, last removed first
+	DELETE /v1/trash               purge the trash, or only the todos removed before ?before=
+	POST   /v1/trash/{id}:restore  put a todo back
+	DELETE /v1/trash/{id}          purge a todo, deleting it for good
+
+Every response is json. Success is {"data": ...}, plus "next_cursor" on
+a list with more pages. Failure is a problem, see Problem.
+
+Responses with one todo carry its ETag, and writes to one todo honour
+If-Match, see etag.go.
+*/
+
+// envelope is the body of every successful /v1 response
+type envelope struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// decodeBody reads the json body of r into v
+func decodeBody(r *http.Request, v interface{}) error {
+	b, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// List handles GET /v1/todos
+func (h *HandlerTodo) List(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	page, err := h.repo.Query(r.Context(), filter)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	sendJson(w, http.StatusOK, envelope{Data: page.Todos, NextCursor: page.Next})
+}
+
+// Create handles POST /v1/todos. The id defaults to a new uuid, the status
+// to the workflow's initial one and the timestamps to now. Given ones are
+// kept, so todos can be imported with their history, but for deleted_at:
+// todos are created out of the trash.
+func (h *HandlerTodo) Create(w http.ResponseWriter, r *http.Request) {
+	var todo model.Todo
+	err := decodeBody(r, &todo)
+	if err != nil {
+		sendBadRequest(w, r, "bad body", err)
+		return
+	}
+
+	if todo.Id == "" {
+		todo.Id = uuid.NewString()
+	}
+
+	todo.DeletedAt = nil
+
+	ctx := r.Context()
+	err = h.repo.Add(ctx, todo)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	created, err := h.repo.Get(ctx, todo.Id)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/v1/todos/"+url.PathEscape(created.Id))
+	setETag(w, created)
+	sendJson(w, http.StatusCreated, envelope{Data: created})
+}
+
+// Read handles GET /v1/todos/{id}, with 304 when If-None-Match matches
+func (h *HandlerTodo) Read(w http.ResponseWriter, r *http.Request) {
+	todo, err := h.repo.Get(r.Context(), mux.Vars(r)["todo-id"])
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	setETag(w, todo)
+	if notModified(r, todo) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	sendJson(w, http.StatusOK, envelope{Data: todo})
+}
+
+// patch holds the fields of a PATCH body, nil when absent.
+// due_at is kept raw so that null (clear it) differs from absent.
+type patch struct {
+	Data     *string         `json:"data"`
+	Status   *model.Status   `json:"status"`
+	DueAt    json.RawMessage `json:"due_at"`
+	Priority *model.Priority `json:"priority"`
+	Tags     *[]string       `json:"tags"`
+	Notes    *string         `json:"notes"`
+}
+
+func (p patch) apply(todo *model.Todo) error {
+	if p.Data != nil {
+		todo.Data = *p.Data
+	}
+
+	if p.Status != nil {
+		todo.Status = *p.Status
+	}
+
+	if p.DueAt != nil {
+		var due *time.Time
+		err := json.Unmarshal(p.DueAt, &due)
+		if err != nil {
+			return fmt.Errorf("bad due_at: %w", err)
+		}
+
+		todo.DueAt = due
+	}
+
+	if p.Priority != nil {
+		todo.Priority = *p.Priority
+	}
+
+	if p.Tags != nil {
+		todo.Tags = *p.Tags
+	}
+
+	if p.Notes != nil {
+		todo.Notes = *p.Notes
+	}
+
+	return nil
+}
+
+// Patch handles PATCH /v1/todos/{id}, changing only the fields in the body
+func (h *HandlerTodo) Patch(w http.ResponseWriter, r *http.Request) {
+	var p patch
+	err := decodeBody(r, &p)
+	if err != nil {
+		sendBadRequest(w, r, "bad body", err)
+		return
+	}
+
+	id := mux.Vars(r)["todo-id"]
+	version, err := h.expectedVersion(r, id)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	// the version read is expected, so a change made in between is not lost
+	todo, err := h.repo.Get(r.Context(), id)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	if version != repo.AnyVersion {
+		todo.Version = version
+	}
+
+	err = p.apply(&todo)
+	if err != nil {
+		sendBadRequest(w, r, "bad body", err)
+		return
+	}
+
+	h.update(w, r, todo)
+}
+
+// Replace handles PUT /v1/todos/{id}. Fields missing from the body are
+// cleared, and a missing status is rejected. The version expected is the
+// one of If-Match, else the one of the body if any.
+func (h *HandlerTodo) Replace(w http.ResponseWriter, r *http.Request) {
+	var todo model.Todo
+	err := decodeBody(r, &todo)
+	if err != nil {
+		sendBadRequest(w, r, "bad body", err)
+		return
+	}
+
+	todo.Id = mux.Vars(r)["todo-id"]
+	version, err := h.expectedVersion(r, todo.Id)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	if version != repo.AnyVersion {
+		todo.Version = version
+	}
+
+	h.update(w, r, todo)
+}
+
+// update saves todo and sends it back as stored
+func (h *HandlerTodo) update(w http.ResponseWriter, r *http.Request, todo model.Todo) {
+	ctx := r.Context()
+	_, err := h.repo.Update(ctx, todo)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	updated, err := h.repo.Get(ctx, todo.Id)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	setETag(w, updated)
+	sendJson(w, http.StatusOK, envelope{Data: updated})
+}
+
+// Remove handles DELETE /v1/todos/{id} and sends back the removed todo
+func (h *HandlerTodo) Remove(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["todo-id"]
+	version, err := h.expectedVersion(r, id)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	todo, err := h.repo.Remove(r.Context(), id, version)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	sendJson(w, http.StatusOK, envelope{Data: todo})
+}
+
+// maxBatch is the largest batch accepted by /v1/todos:batch
+const maxBatch = 1000
+
+// Actions of a batch
+const (
+	BatchAdd    = "add"
+	BatchUpdate = "update"
+	BatchRemove = "remove"
+)
+
+// batchRequest is the body of POST /v1/todos:batch. Todos are those to add,
+// or to replace as with PUT, ids those to remove.
+type batchRequest struct {
+	Action string       `json:"action"`
+	Todos  []model.Todo `json:"todos"`
+	Ids    []string     `json:"ids"`
+}
+
+// batchResult is the data of a batch response: the todos as now stored,
+// for add and update, and as they were before, for update and remove
+type batchResult struct {
+	Todos    []model.Todo `json:"todos,omitempty"`
+	Previous []model.Todo `json:"previous,omitempty"`
+}
+
+// Batch handles POST /v1/todos:batch. The batch is all or nothing: when
+// a todo fails, none is changed and the problem has the index of the todo.
+func (h *HandlerTodo) Batch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	err := decodeBody(r, &req)
+	if err != nil {
+		sendBadRequest(w, r, "bad body", err)
+		return
+	}
+
+	if len(req.Todos) > maxBatch || len(req.Ids) > maxBatch {
+		sendBadRequest(w, r, "bad body", fmt.Errorf("more than %d todos in the batch", maxBatch))
+		return
+	}
+
+	ctx := r.Context()
+	result := batchResult{}
+
+	switch req.Action {
+	case BatchAdd:
+		for i := range req.Todos {
+			if req.Todos[i].Id == "" {
+				req.Todos[i].Id = uuid.NewString()
+			}
+
+			req.Todos[i].DeletedAt = nil
+		}
+
+		result.Todos, err = h.repo.AddMany(ctx, req.Todos)
+
+	case BatchUpdate:
+		result.Todos, result.Previous, err = h.repo.UpdateMany(ctx, req.Todos)
+
+	case BatchRemove:
+		result.Previous, err = h.repo.RemoveMany(ctx, req.Ids)
+
+	default:
+		sendBadRequest(w, r, "bad body", fmt.Errorf("unknown action '%s', expecting %s, %s or %s", req.Action, BatchAdd, BatchUpdate, BatchRemove))
+		return
+	}
+
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	sendJson(w, http.StatusOK, envelope{Data: result})
+}
+
+// Workflow handles GET /v1/workflow, for clients to follow the statuses
+// of the server
+func (h *HandlerTodo) Workflow(w http.ResponseWriter, r *http.Request) {
+	sendJson(w, http.StatusOK, envelope{Data: h.workflow})
+}
+
+// Trash handles GET /v1/trash
+func (h *HandlerTodo) Trash(w http.ResponseWriter, r *http.Request) {
+	todos, err := h.repo.Trash(r.Context())
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	sendJson(w, http.StatusOK, envelope{Data: todos})
+}
+
+// Restore handles POST /v1/trash/{id}:restore and sends back the todo restored
+func (h *HandlerTodo) Restore(w http.ResponseWriter, r *http.Request) {
+	todo, err := h.repo.Restore(r.Context(), mux.Vars(r)["todo-id"])
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	setETag(w, todo)
+	sendJson(w, http.StatusOK, envelope{Data: todo})
+}
+
+// Purge handles DELETE /v1/trash/{id} and sends back the purged todo
+func (h *HandlerTodo) Purge(w http.ResponseWriter, r *http.Request) {
+	todo, err := h.repo.Purge(r.Context(), mux.Vars(r)["todo-id"])
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	sendJson(w, http.StatusOK, envelope{Data: todo})
+}
+
+// PurgeTrash handles DELETE /v1/trash and sends back the purged todos.
+// ?before= (a date or RFC3339 time) keeps the todos removed since then.
+func (h *HandlerTodo) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	before := model.Now()
+	if v := r.URL.Query().Get("before"); v != "" {
+		t, err := model.ParseTime(v)
+		if err != nil {
+			sendRepoError(w, r, fmt.Errorf("%w: bad before '%s'", repo.ErrInvalidQuery, v))
+			return
+		}
+
+		before = t
+	}
+
+	todos, err := h.repo.PurgeBefore(r.Context(), before)
+	if err != nil {
+		sendRepoError(w, r, err)
+		return
+	}
+
+	sendJson(w, http.StatusOK, envelope{Data: todos})
+}
+
+// Register adds the /v1 routes to r, and the verb-style routes of the
+// first api when legacy is set, for scripts still using them
+func (h *HandlerTodo) Register(r *mux.Router, legacy bool) {
+	r.HandleFunc("/openapi.json", h.OpenAPI).Methods(http.MethodGet)
+
+	v1 := r.PathPrefix("/v1").Subrouter()
+	v1.HandleFunc("/workflow", h.Workflow).Methods(http.MethodGet)
+	v1.HandleFunc("/trash", h.Trash).Methods(http.MethodGet)
+	v1.HandleFunc("/trash", h.PurgeTrash).Methods(http.MethodDelete)
+	v1.HandleFunc("/trash/{todo-id}:restore", h.Restore).Methods(http.MethodPost)
+	v1.HandleFunc("/trash/{todo-id}", h.Purge).Methods(http.MethodDelete)
+
+	// mux forgets a method mismatch on any later route of the subrouter,
+	// so the /todos/{todo-id} routes go last to answer 405 rather than 404
+	v1.HandleFunc("/todos", h.List).Methods(http.MethodGet)
+	v1.HandleFunc("/todos", h.Create).Methods(http.MethodPost)
+	v1.HandleFunc("/todos:batch", h.Batch).Methods(http.MethodPost)
+	v1.HandleFunc("/todos/{todo-id}", h.Read).Methods(http.MethodGet)
+	v1.HandleFunc("/todos/{todo-id}", h.Patch).Methods(http.MethodPatch)
+	v1.HandleFunc("/todos/{todo-id}", h.Replace).Methods(http.MethodPut)
+	v1.HandleFunc("/todos/{todo-id}", h.Remove).Methods(http.MethodDelete)
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendProblem(w, r, http.StatusNotFound, CodeNotFound, fmt.Sprintf("no route %s", r.URL.Path))
+	})
+
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, fmt.Sprintf("method %s not allowed on %s", r.Method, r.URL.Path))
+	})
+
+	if !legacy {
+		return
+	}
+
+	r.HandleFunc("/get-all", h.GetAll).Methods(http.MethodGet)
+	r.HandleFunc("/get-all-status", h.GetAllStatus).Methods(http.MethodGet)
+	r.HandleFunc("/get/{todo-id}", h.GetById).Methods(http.MethodGet)
+	r.HandleFunc("/add", h.Add).Methods(http.MethodPost)
+	r.HandleFunc("/delete/{todo-id}", h.Delete).Methods(http.MethodDelete)
+	r.HandleFunc("/update/{todo-id}", h.UpdateId).Methods(http.MethodPatch)
+	r.HandleFunc("/update/{todo-id}", h.Update).Methods(http.MethodPut)
+	r.HandleFunc("/update-status/{todo-id}", h.UpdateStatus).Methods(http.MethodPatch)
+}
@@ -1,25 +1,54 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/eymyong/todo/cmd/api/internal/handler"
+	"github.com/eymyong/todo/model"
 	"github.com/eymyong/todo/repo"
 	"github.com/eymyong/todo/repo/jsonfile"
 	"github.com/eymyong/todo/repo/jsonfilemap"
+	"github.com/eymyong/todo/repo/sqlite"
 	"github.com/eymyong/todo/repo/textfile"
 	"github.com/eymyong/todo/repo/todoredis"
+	"github.com/eymyong/todo/repo/todotxt"
 )
 
 const JsonFile = "json"
 const JsonMap = "jsonmap"
 const TextFile = "text"
 const Redis = "redis"
+const Sqlite = "sqlite"
+const TodoTxt = "todotxt"
 
-func initRepo() repo.Repository {
+// repoOptions reads the workflow json file named by WORKFLOW.
+// Without it the repository uses model.DefaultWorkflow.
+func repoOptions() []repo.Option {
+	envWorkflow := os.Getenv("WORKFLOW")
+	if envWorkflow == "" {
+		return nil
+	}
+
+	wf, err := model.ReadWorkflow(envWorkflow)
+	if err != nil {
+		panic(err)
+	}
+
+	return []repo.Option{repo.WithWorkflow(wf)}
+}
+
+func initRepo(opts []repo.Option) repo.Repository {
 	envRepo := os.Getenv("REPO")
 	envFile := os.Getenv("FILENAME")
 
@@ -29,39 +58,104 @@ func initRepo() repo.Repository {
 		if envFile == "" {
 			envFile = "todo.json"
 		}
-		repo = jsonfile.New(envFile)
+		repo = jsonfile.New(envFile, opts...)
 
 	case JsonMap:
 		if envFile == "" {
 			envFile = "todo.map.json"
 		}
-		repo = jsonfilemap.New(envFile)
+		repo = jsonfilemap.New(envFile, opts...)
 
 	case TextFile:
 		if envFile == "" {
 			envFile = "todo.text"
 		}
-		repo = textfile.New(envFile)
+		repo = textfile.New(envFile, opts...)
 
 	case Redis:
-		repo = todoredis.New("127.0.0.1:6379")
+		repo = todoredis.New("127.0.0.1:6379", opts...)
+
+	case Sqlite:
+		if envFile == "" {
+			envFile = "todo.db"
+		}
+		repo = sqlite.New(envFile, opts...)
+
+	case TodoTxt:
+		if envFile == "" {
+			envFile = "todo.txt"
+		}
+		repo = todotxt.New(envFile, opts...)
 	}
 
 	return repo
 }
 
+// serve runs srv until SIGINT or SIGTERM, then stops taking new connections
+// and waits up to drain for in-flight requests. Requests still running after
+// that have their context canceled.
+func serve(srv *http.Server, drain time.Duration) error {
+	base, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	srv.BaseContext = func(net.Listener) context.Context {
+		return base
+	}
+
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop.Done():
+	}
+
+	log.Printf("shutting down, draining requests for up to %s", drain)
+
+	ctx, cancelDrain := context.WithTimeout(context.Background(), drain)
+	defer cancelDrain()
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		cancelRequests()
+		srv.Close()
+		return fmt.Errorf("requests still running after %s: %w", drain, err)
+	}
+
+	return nil
+}
+
 func main() {
-	repo := initRepo()
-	h := handler.New(repo)
+	legacy := flag.Bool("legacy-routes", false, "also serve the verb-style routes (/get-all, /add, ...) of the first api")
+	timeout := flag.Duration("timeout", 10*time.Second, "deadline of every request")
+	drain := flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests on shutdown")
+	flag.Parse()
+
+	opts := repoOptions()
+	repo := initRepo(opts)
+	h := handler.New(repo, opts...)
 
 	r := mux.NewRouter()
-	r.HandleFunc("/get-all", h.GetAll).Methods(http.MethodGet)
-	r.HandleFunc("/get-all-status", h.GetAllStatus).Methods(http.MethodGet)
-	r.HandleFunc("/get/{todo-id}", h.GetById).Methods(http.MethodGet)
-	r.HandleFunc("/add", h.Add).Methods(http.MethodPost)
-	r.HandleFunc("/delete/{todo-id}", h.Delete).Methods(http.MethodDelete)
-	r.HandleFunc("/update/{todo-id}", h.UpdateId).Methods(http.MethodPatch)
-	r.HandleFunc("/update-status/{todo-id}", h.UpdateStatus).Methods(http.MethodPatch)
-
-	http.ListenAndServe(":8000", r)
+	r.Use(handler.Timeout(*timeout))
+
+	// with TODO_TOKEN set, clients must send it as a bearer token
+	r.Use(handler.Auth(os.Getenv("TODO_TOKEN")))
+	h.Register(r, *legacy)
+
+	srv := &http.Server{
+		Addr:              ":8000",
+		Handler:           r,
+		ReadHeaderTimeout: *timeout,
+	}
+
+	err := serve(srv, *drain)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
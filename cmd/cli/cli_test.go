@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/jsonfilemap"
+)
+
+// newTestApp runs commands against a repository holding todo "1" (TODO)
+// and "2" (DONE)
+func newTestApp(t *testing.T) (*app, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+
+	return newTestAppWith(t, []model.Todo{
+		{Id: "1", Data: "one", Status: model.StatusTodo, Tags: []string{"home"}},
+		{Id: "2", Data: "two", Status: model.StatusDone},
+	})
+}
+
+func newTestAppWith(t *testing.T, todos []model.Todo) (*app, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+
+	dir := t.TempDir()
+	r := jsonfilemap.New(filepath.Join(dir, "todo.map.json"))
+	for _, todo := range todos {
+		err := r.Add(context.Background(), todo)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	a := &app{
+		prog:   "todo",
+		stdout: stdout,
+		stderr: stderr,
+		open: func(string, model.Workflow) (repo.Repository, error) {
+			return r, nil
+		},
+		repoKey: func(server string) string {
+			return "test " + server
+		},
+		statePath: filepath.Join(dir, "last-list.json"),
+		editor: func(string) error {
+			return nil
+		},
+	}
+
+	return a, stdout, stderr
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+		// stdout must contain every string of out, and none of notOut
+		out    []string
+		notOut []string
+	}{
+		{name: "no args lists", args: nil, code: ExitOk, out: []string{"#  ID  STATUS  PRI  DUE  TAGS  DATA\n1  1   TODO              home  one\n2  2   DONE                    two\n"}},
+		{name: "list", args: []string{"list", "--status", "DONE"}, code: ExitOk, out: []string{"1  2   DONE"}, notOut: []string{"one"}},
+		{name: "ls", args: []string{"ls", "--tag=home"}, code: ExitOk, out: []string{"1  1   TODO"}, notOut: []string{"two"}},
+		{name: "list empty", args: []string{"list", "--text", "nothing"}, code: ExitOk, out: []string{"No data"}},
+		{name: "list json", args: []string{"list", "-o", "json"}, code: ExitOk, out: []string{"[\n  {\n    \"id\": \"1\",\n    \"data\": \"one\""}},
+		{name: "list json empty", args: []string{"list", "--text", "nothing", "--output=json"}, code: ExitOk, out: []string{"[]\n"}},
+		{name: "list jsonl", args: []string{"list", "-o", "jsonl"}, code: ExitOk, out: []string{"{\"id\":\"1\",", "}\n{\"id\":\"2\","}},
+		{name: "list csv", args: []string{"list", "-o", "csv"}, code: ExitOk, out: []string{"id,data,status,priority,due_at,tags,notes,created_at,updated_at,completed_at,version,deleted_at\n1,one,TODO,,,home,,", ",1,\n2,two,DONE,"}},
+		{name: "list yaml", args: []string{"list", "-o", "yaml"}, code: ExitOk, out: []string{"- id: \"1\"\n  data: \"one\"\n  status: \"TODO\"\n", "  tags:\n    - \"home\"\n", "- id: \"2\""}},
+		{name: "list template", args: []string{"list", "--template", "{{.Id}}={{.Status}} {{join .Tags \"+\"}}"}, code: ExitOk, out: []string{"1=TODO home\n2=DONE \n"}},
+		{name: "list template json", args: []string{"list", "-o", "template", "--template", "{{json .Data}}"}, code: ExitOk, out: []string{"\"one\"\n\"two\"\n"}},
+		{name: "list template missing", args: []string{"list", "-o", "template"}, code: ExitUsage},
+		{name: "list template bad", args: []string{"list", "--template", "{{.Id"}, code: ExitUsage},
+		{name: "list template conflict", args: []string{"list", "-o", "json", "--template", "{{.Id}}"}, code: ExitUsage},
+		{name: "list bad format", args: []string{"list", "-o", "xml"}, code: ExitUsage},
+		{name: "list bad color", args: []string{"list", "--color", "sometimes"}, code: ExitUsage},
+		{name: "list color", args: []string{"list", "--color", "always"}, code: ExitOk, out: []string{"\x1b[1mID\x1b[0m", "\x1b[32mDONE\x1b[0m"}},
+		{name: "list no color", args: []string{"list"}, code: ExitOk, notOut: []string{"\x1b["}},
+		{name: "list bad sort", args: []string{"list", "--sort", "nope"}, code: ExitInvalid},
+		{name: "list extra arg", args: []string{"list", "x"}, code: ExitUsage},
+		{name: "add", args: []string{"add", "buy", "milk", "--priority", "2"}, code: ExitOk, out: []string{"buy milk [TODO] (priority 2)"}},
+		{name: "add due date", args: []string{"add", "x", "--due", "2026-10-20"}, code: ExitOk, out: []string{"x [TODO] due 2026-10-20\n"}},
+		{name: "add due date json", args: []string{"add", "x", "--due", "2026-10-20", "-o", "json"}, code: ExitOk, out: []string{"\"due_at\": \"2026-10-20T00:00:00Z\""}},
+		{name: "add json", args: []string{"add", "x", "-o", "json"}, code: ExitOk, out: []string{"\"data\": \"x\""}, notOut: []string{"Added"}},
+		{name: "add bad format", args: []string{"add", "x", "-o", "xml"}, code: ExitUsage},
+		{name: "add nothing", args: []string{"add"}, code: ExitUsage},
+		{name: "add bad priority", args: []string{"add", "x", "--priority", "high"}, code: ExitInvalid},
+		{name: "show", args: []string{"show", "1", "2"}, code: ExitOk, out: []string{"ID: 1\nData: one", "ID: 2\nData: two"}},
+		{name: "show jsonl", args: []string{"show", "2", "1", "-o", "jsonl"}, code: ExitOk, out: []string{"{\"id\":\"2\",", "}\n{\"id\":\"1\","}},
+		{name: "show table", args: []string{"show", "1", "-o", "table"}, code: ExitOk, out: []string{"ID  STATUS"}},
+		{name: "show missing", args: []string{"show", "3"}, code: ExitNotFound},
+		{name: "edit", args: []string{"edit", "1", "--data", "uno", "--tags", ""}, code: ExitOk, out: []string{"Data: uno\nStatus: TODO\nCreated"}},
+		{name: "edit unchanged", args: []string{"edit", "1"}, code: ExitOk, out: []string{"Nothing changed"}},
+		{name: "edit unchanged json", args: []string{"edit", "1", "-o", "json"}, code: ExitOk, out: []string{"[]"}},
+		{name: "edit template", args: []string{"edit", "1", "--status", "DONE", "--template", "{{.Status}} {{date \"2006\" .CompletedAt}}"}, code: ExitOk, out: []string{"DONE 20"}},
+		{name: "edit two ids", args: []string{"edit", "1", "2", "--data", "x"}, code: ExitUsage},
+		{name: "edit bad status", args: []string{"edit", "1", "--status", "LATER"}, code: ExitInvalid},
+		{name: "done", args: []string{"done", "1"}, code: ExitOk, out: []string{"Done 1: one [DONE]"}},
+		{name: "rm", args: []string{"rm", "1", "2"}, code: ExitOk, out: []string{"Removed 1: one", "Removed 2: two"}},
+		{name: "done csv", args: []string{"done", "1", "-o", "csv"}, code: ExitOk, out: []string{"\n1,one,DONE,"}, notOut: []string{"Done"}},
+		{name: "rm template", args: []string{"rm", "1", "2", "--template", "{{.Id}}"}, code: ExitOk, out: []string{"1\n2\n"}, notOut: []string{"Removed"}},
+		{name: "rm missing", args: []string{"remove", "3"}, code: ExitNotFound},
+		{name: "rm twice", args: []string{"rm", "1", "1"}, code: ExitOk, out: []string{"Removed 1: one [TODO] #home\n"}, notOut: []string{"two"}},
+		{name: "trash empty", args: []string{"trash"}, code: ExitOk, out: []string{"The trash is empty"}},
+		{name: "trash json empty", args: []string{"trash", "-o", "json"}, code: ExitOk, out: []string{"[]\n"}},
+		{name: "restore live", args: []string{"restore", "1"}, code: ExitNotFound},
+		{name: "purge live", args: []string{"purge", "1"}, code: ExitNotFound},
+		{name: "purge nothing", args: []string{"purge"}, code: ExitUsage},
+		{name: "purge ids and all", args: []string{"purge", "1", "--all"}, code: ExitUsage},
+		{name: "purge bad age", args: []string{"purge", "--older-than", "a week"}, code: ExitInvalid},
+		{name: "purge all empty", args: []string{"purge", "--all"}, code: ExitOk, out: []string{"Nothing to purge"}},
+		{name: "done twice", args: []string{"done", "1", "1", "-o", "jsonl"}, code: ExitOk, out: []string{"\"status\":\"DONE\""}},
+		{name: "unknown command", args: []string{"frobnicate"}, code: ExitUsage},
+		{name: "unknown flag", args: []string{"show", "--bogus", "1"}, code: ExitUsage},
+		{name: "help", args: []string{"help"}, code: ExitOk, out: []string{"Usage: todo [--server URL] <command>", "rm, remove"}},
+		{name: "--help", args: []string{"--help"}, code: ExitOk, out: []string{"Commands:"}},
+		{name: "help command", args: []string{"help", "edit"}, code: ExitOk, out: []string{"Usage: todo edit [flags] ID...", "-status string"}},
+		{name: "command -h", args: []string{"list", "-h"}, code: ExitOk, out: []string{"Usage: todo list [flags]"}},
+		{name: "help unknown", args: []string{"help", "nope"}, code: ExitUsage},
+		{name: "completion", args: []string{"completion", "bash"}, code: ExitOk, out: []string{"complete -o default -F _todo_complete todo"}},
+		{name: "completion unknown shell", args: []string{"completion", "tcsh"}, code: ExitUsage},
+		{name: "legacy get", args: []string{"--get", "1"}, code: ExitOk, out: []string{"ID: 1"}},
+		{name: "legacy update", args: []string{"--update", "1", "uno"}, code: ExitOk, out: []string{"Data: uno"}},
+		{name: "legacy get-status", args: []string{"--get-status", "DONE"}, code: ExitOk, out: []string{"1  2   DONE"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a, stdout, stderr := newTestApp(t)
+
+			code := a.run(tc.args)
+			if code != tc.code {
+				t.Fatalf("expected exit code %d but got %d\nstdout: %s\nstderr: %s", tc.code, code, stdout, stderr)
+			}
+
+			for _, out := range tc.out {
+				if !strings.Contains(stdout.String(), out) {
+					t.Errorf("expected '%s' in stdout:\n%s", out, stdout)
+				}
+			}
+
+			for _, out := range tc.notOut {
+				if strings.Contains(stdout.String(), out) {
+					t.Errorf("unexpected '%s' in stdout:\n%s", out, stdout)
+				}
+			}
+		})
+	}
+}
+
+// done changes every todo or, when one fails, none
+func TestDoneAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+
+	// archived todos cannot be done
+	wf := model.Workflow{
+		Initial:     model.StatusTodo,
+		Statuses:    []model.Status{model.StatusTodo, model.StatusDone, "ARCHIVED"},
+		Completed:   []model.Status{model.StatusDone},
+		Transitions: map[model.Status][]model.Status{model.StatusTodo: {model.StatusDone}},
+	}
+
+	r := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.map.json"), repo.WithWorkflow(wf))
+	for _, todo := range []model.Todo{
+		{Id: "1", Data: "one", Status: model.StatusTodo},
+		{Id: "2", Data: "two", Status: "ARCHIVED"},
+	} {
+		err := r.Add(ctx, todo)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+
+	a, _, stderr := newTestApp(t)
+	a.wf = wf
+	a.open = func(string, model.Workflow) (repo.Repository, error) {
+		return r, nil
+	}
+
+	code := a.run([]string{"done", "1", "2"})
+	if code != ExitConflict || !strings.Contains(stderr.String(), "todo '2': invalid status transition") {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr)
+	}
+
+	one, err := r.Get(ctx, "1")
+	if err != nil || one.Status != model.StatusTodo {
+		t.Fatalf("expected todo 1 unchanged but got %+v, %v", one, err)
+	}
+}
+
+// done moves todos to the first completed status of the workflow,
+// whatever the order of its completed statuses
+func TestDoneStatus(t *testing.T) {
+	wf := model.Workflow{
+		Initial:   model.StatusTodo,
+		Statuses:  []model.Status{model.StatusTodo, model.StatusDone, model.StatusCancelled},
+		Completed: []model.Status{model.StatusCancelled, model.StatusDone},
+	}
+
+	a, _, stderr := newTestApp(t)
+	a.wf = wf
+
+	code := a.run([]string{"done", "1"})
+	if code != ExitOk {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr)
+	}
+
+	r, _ := a.repository()
+	one, err := r.Get(context.Background(), "1")
+	if err != nil || one.Status != model.StatusDone {
+		t.Fatalf("expected todo 1 done but got %+v, %v", one, err)
+	}
+}
+
+// rm moves todos to the trash, from where restore and purge take them
+func TestTrash(t *testing.T) {
+	a, stdout, stderr := newTestApp(t)
+
+	run := func(args ...string) string {
+		t.Helper()
+
+		stdout.Reset()
+		stderr.Reset()
+		code := a.run(args)
+		if code != ExitOk {
+			t.Fatalf("%v: unexpected exit code %d: %s", args, code, stderr)
+		}
+
+		return stdout.String()
+	}
+
+	run("rm", "1", "2")
+	if out := run("list"); !strings.Contains(out, "No data") {
+		t.Errorf("expected removed todos hidden from list:\n%s", out)
+	}
+
+	out := run("trash")
+	if !strings.Contains(out, "ID  STATUS") || !strings.Contains(out, "one") || !strings.Contains(out, "two") {
+		t.Errorf("unexpected trash:\n%s", out)
+	}
+
+	if code := a.run([]string{"show", "1"}); code != ExitNotFound {
+		t.Errorf("expected removed todo not found, got exit code %d", code)
+	}
+
+	if out := run("restore", "1"); out != "Restored 1: one [TODO] #home\n" {
+		t.Errorf("unexpected output of restore:\n%s", out)
+	}
+
+	if out := run("list", "-o", "template", "--template", "{{.Id}}"); out != "1\n" {
+		t.Errorf("expected restored todo listed but got:\n%s", out)
+	}
+
+	if out := run("purge", "--older-than", "1h"); !strings.Contains(out, "Nothing to purge") {
+		t.Errorf("expected recently removed todos kept:\n%s", out)
+	}
+
+	if out := run("purge", "2"); out != "Purged 2: two [DONE]\n" {
+		t.Errorf("unexpected output of purge:\n%s", out)
+	}
+
+	run("rm", "1")
+	if out := run("purge", "--all", "-o", "template", "--template", "{{.Id}}"); out != "1\n" {
+		t.Errorf("unexpected output of purge --all:\n%s", out)
+	}
+
+	if out := run("trash"); !strings.Contains(out, "The trash is empty") {
+		t.Errorf("expected an empty trash:\n%s", out)
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		age      string
+		expected time.Duration
+		ok       bool
+	}{
+		{age: "30d", expected: 30 * 24 * time.Hour, ok: true},
+		{age: "0d", expected: 0, ok: true},
+		{age: "12h", expected: 12 * time.Hour, ok: true},
+		{age: "1h30m", expected: 90 * time.Minute, ok: true},
+		{age: "-1d"},
+		{age: "-5m"},
+		{age: "d"},
+		{age: "week"},
+	}
+
+	for _, tc := range tests {
+		actual, err := parseAge(tc.age)
+		if (err == nil) != tc.ok || actual != tc.expected {
+			t.Errorf("%s: expected %s, %t but got %s, %v", tc.age, tc.expected, tc.ok, actual, err)
+		}
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []string
+		data     string
+	}{
+		{args: []string{"1", "--data", "x"}, expected: []string{"1"}, data: "x"},
+		{args: []string{"--data=x", "1", "2"}, expected: []string{"1", "2"}, data: "x"},
+		{args: []string{"--", "--data", "x"}, expected: []string{"--data", "x"}},
+		{args: []string{"1", "--", "-2"}, expected: []string{"1", "-2"}},
+		{args: nil, expected: nil},
+	}
+
+	for _, tc := range tests {
+		fs := command{name: "test"}.flagSet()
+		data := fs.String("data", "", "")
+
+		actual, err := parseFlags(fs, tc.args)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		if !reflect.DeepEqual(tc.expected, actual) || *data != tc.data {
+			t.Errorf("%v: expected %v and data '%s' but got %v and '%s'", tc.args, tc.expected, tc.data, actual, *data)
+		}
+	}
+}
+
+func TestLegacyArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{args: []string{"--add", "milk"}, expected: []string{"add", "milk"}},
+		{args: []string{"--update-status", "1", "DONE"}, expected: []string{"edit", "--status=DONE", "1"}},
+		{args: []string{"--set-due", "1", ""}, expected: []string{"edit", "--due=", "1"}},
+		{args: []string{"--query", "--tag", "home"}, expected: []string{"list", "--tag", "home"}},
+		{args: []string{"list"}, expected: []string{"list"}},
+	}
+
+	for _, tc := range tests {
+		actual, _ := legacyArgs(tc.args)
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("%v: expected %v but got %v", tc.args, tc.expected, actual)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		words    []string
+		expected []string
+	}{
+		{words: []string{""}, expected: []string{"add", "list", "show", "edit", "done", "rm", "trash", "restore", "purge", "tui", "completion", "help"}},
+		{words: []string{"d"}, expected: []string{"done"}},
+		{words: []string{"-"}, expected: []string{"--server", "--help"}},
+		{words: []string{"show", ""}, expected: []string{"1", "2"}},
+		{words: []string{"--server", "http://localhost:1", "show", "1"}, expected: []string{"1"}},
+		{words: []string{"done", "2"}, expected: []string{"2"}},
+		{words: []string{"restore", ""}, expected: nil},
+		{words: []string{"edit", "--st"}, expected: []string{"--status"}},
+		{words: []string{"list", "--status", ""}, expected: []string{"TODO", "DONE"}},
+		{words: []string{"list", "--sort", "-d"}, expected: []string{"-due_at", "-data"}},
+		{words: []string{"add", ""}, expected: nil},
+		{words: []string{"show", "1", "-o", "j"}, expected: []string{"json", "jsonl"}},
+		{words: []string{"list", "--color", ""}, expected: []string{"auto", "always", "never"}},
+		{words: []string{"completion", ""}, expected: []string{"bash", "zsh", "fish"}},
+		{words: []string{"help", "e"}, expected: []string{"edit"}},
+		{words: []string{"--server", ""}, expected: nil},
+		{words: []string{"nope", ""}, expected: nil},
+	}
+
+	for _, tc := range tests {
+		a, _, _ := newTestApp(t)
+
+		var actual []string
+		for _, c := range a.complete(context.Background(), tc.words) {
+			actual = append(actual, c.value)
+		}
+
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("%q: expected %v but got %v", tc.words, tc.expected, actual)
+		}
+	}
+}
+
+func TestCompletionScript(t *testing.T) {
+	for _, shell := range shells {
+		script, err := completionScript(shell, "my-todo")
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		if !strings.Contains(script, "my-todo __complete") || !strings.Contains(script, "_my_todo_complete") {
+			t.Errorf("%s: unexpected script:\n%s", shell, script)
+		}
+	}
+}
+
+func TestIds(t *testing.T) {
+	a, stdout, stderr := newTestAppWith(t, []model.Todo{
+		{Id: "3fa85f64-5717-4562-b3fc-2c963f66afa6", Data: "milk"},
+		{Id: "3fb2c1d0-0000-4000-8000-000000000000", Data: "bread"},
+		{Id: "9c2d6e1a-1111-4111-8111-111111111111", Data: "eggs"},
+		{Id: "12", Data: "numeric"},
+	})
+
+	run := func(args ...string) int {
+		t.Helper()
+
+		stdout.Reset()
+		stderr.Reset()
+		return a.run(args)
+	}
+
+	if code := run("list", "--sort", "data"); code != ExitOk {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr)
+	}
+
+	expected := "" +
+		"#  ID   STATUS  PRI  DUE  TAGS  DATA\n" +
+		"1  3fb  TODO                    bread\n" +
+		"2  9c   TODO                    eggs\n" +
+		"3  3fa  TODO                    milk\n" +
+		"4  12   TODO                    numeric\n"
+	if stdout.String() != expected {
+		t.Fatalf("expected:\n%s\nbut got:\n%s", expected, stdout)
+	}
+
+	tests := []struct {
+		ref  string
+		code int
+		id   string
+	}{
+		{ref: "3fa", code: ExitOk, id: "3fa85f64-5717-4562-b3fc-2c963f66afa6"},
+		{ref: "9", code: ExitOk, id: "9c2d6e1a-1111-4111-8111-111111111111"},
+		{ref: "2", code: ExitOk, id: "9c2d6e1a-1111-4111-8111-111111111111"},
+		{ref: "12", code: ExitOk, id: "12"},
+		{ref: "1", code: ExitOk, id: "3fb2c1d0-0000-4000-8000-000000000000"},
+		{ref: "3f", code: ExitUsage},
+		{ref: "5", code: ExitNotFound},
+		{ref: "x", code: ExitNotFound},
+	}
+
+	for _, tc := range tests {
+		code := run("show", tc.ref, "-o", "template", "--template", "{{.Id}}")
+		if code != tc.code {
+			t.Errorf("%s: expected exit code %d but got %d: %s", tc.ref, tc.code, code, stderr)
+			continue
+		}
+
+		if tc.code == ExitOk && stdout.String() != tc.id+"\n" {
+			t.Errorf("%s: expected %s but got %s", tc.ref, tc.id, stdout)
+		}
+	}
+
+	run("show", "3f")
+	for _, s := range []string{"ambiguous id prefix '3f'", "3fa85f64-5717-4562-b3fc-2c963f66afa6  milk", "3fb2c1d0-0000-4000-8000-000000000000  bread"} {
+		if !strings.Contains(stderr.String(), s) {
+			t.Errorf("expected '%s' in stderr:\n%s", s, stderr)
+		}
+	}
+
+	// indexes are those of the last table, of the same repository
+	if code := run("rm", "1", "3"); code != ExitOk {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr)
+	}
+
+	if !strings.Contains(stdout.String(), "Removed 3fb2c1d0") || !strings.Contains(stdout.String(), "Removed 3fa85f64") {
+		t.Errorf("unexpected output of rm:\n%s", stdout)
+	}
+
+	a.server = "elsewhere"
+	if code := run("show", "2"); code != ExitNotFound {
+		t.Errorf("expected indexes of another repository to be ignored, got exit code %d", code)
+	}
+}
+
+func TestShortIds(t *testing.T) {
+	actual := shortIds([]string{"abc", "abd", "ab", "x", "123a", "1234", "ü1", "ü2", "é"})
+	expected := map[string]string{
+		"abc":  "abc",
+		"abd":  "abd",
+		"ab":   "ab",
+		"x":    "x",
+		"123a": "123a",
+		"1234": "1234",
+		"ü1":   "ü1",
+		"ü2":   "ü2",
+		"é":    "é",
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v but got %v", expected, actual)
+	}
+}
@@ -0,0 +1,1115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/eymyong/todo/client"
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+)
+
+// app holds what commands need. The repository is opened on first use,
+// so help and completion scripts work without one.
+type app struct {
+	prog   string
+	stdout io.Writer
+	stderr io.Writer
+
+	// tty is whether stdout is a terminal, to color tables, and
+	// interactive whether stdin is one too, to start the tui
+	tty         bool
+	interactive bool
+
+	server string
+	wf     model.Workflow
+	open   func(server string, wf model.Workflow) (repo.Repository, error)
+	repo   repo.Repository
+
+	// repoKey names the repository of server, statePath is the file
+	// keeping the last listing, empty to keep none
+	repoKey   func(server string) string
+	statePath string
+
+	// editor opens a file in the user's editor
+	editor func(file string) error
+}
+
+// workflow is the workflow of r: the server's in remote mode, where
+// WORKFLOW is ignored, otherwise the one of WORKFLOW
+func (a *app) workflow(ctx context.Context, r repo.Repository) (model.Workflow, error) {
+	c, remote := r.(*client.Client)
+	if !remote {
+		return a.wf, nil
+	}
+
+	return c.Workflow(ctx)
+}
+
+func (a *app) repository() (repo.Repository, error) {
+	if a.repo != nil {
+		return a.repo, nil
+	}
+
+	r, err := a.open(a.server, a.wf)
+	if err != nil {
+		return nil, err
+	}
+
+	a.repo = r
+	return r, nil
+}
+
+type runFunc func(ctx context.Context, a *app, args []string) error
+
+type command struct {
+	name    string
+	aliases []string
+
+	// args shows the arguments in the usage line, e.g. "ID..."
+	args    string
+	summary string
+	help    string
+
+	// minArgs and maxArgs bound the number of arguments, -1 for no bound
+	minArgs int
+	maxArgs int
+
+	// complete is what the arguments are, for shell completion
+	complete string
+
+	// setup defines the flags of the command and returns the function
+	// running it once they are parsed
+	setup func(fs *flag.FlagSet) runFunc
+}
+
+const idsHelp = "An ID is a full id, the # of a todo in the last list, or a unique id prefix."
+
+const editHelp = `With field flags, only the fields of the flags given change, an empty
+value clears a field.
+
+Without them, the todos open in $VISUAL or $EDITOR (vi by default) as
+a document of one block per todo. Once saved, the fields changed are
+applied. Several todos can be edited at once, e.g. the ones of a list:
+  PROG edit $(PROG list --status TODO -o template --template '{{.Id}}')
+` + idsHelp
+
+const trashHelp = `rm moves todos to the trash rather than deleting them. They are listed
+here, the last removed first, until restored with 'PROG restore' or
+deleted for good with 'PROG purge'.`
+
+const purgeHelp = `Purges the todos of the IDs, the todos removed longer ago than
+--older-than, or with --all the whole trash, e.g.
+  PROG purge --older-than 30d
+An ID is a full id or a unique id prefix of a todo in the trash.`
+
+// What the arguments of a command are, for shell completion
+const (
+	completeIds      = "ids"
+	completeTrash    = "trash"
+	completeCommands = "commands"
+	completeShells   = "shells"
+)
+
+// commands lists the commands in the order of the help text.
+// It is a function, as help refers back to it.
+func commands() []command {
+	return []command{
+		{
+			name:    "add",
+			args:    "TEXT...",
+			summary: "Add a todo",
+			help:    "The arguments, joined with spaces, are the text of the todo.",
+			minArgs: 1,
+			maxArgs: -1,
+			setup:   setupAdd,
+		},
+		{
+			name:    "list",
+			aliases: []string{"ls"},
+			summary: "List todos, filtered and sorted",
+			help:    "Without flags every todo is listed, oldest first.",
+			maxArgs: 0,
+			setup:   setupList,
+		},
+		{
+			name:     "show",
+			args:     "ID...",
+			summary:  "Show todos in full",
+			help:     idsHelp,
+			minArgs:  1,
+			maxArgs:  -1,
+			complete: completeIds,
+			setup:    setupShow,
+		},
+		{
+			name:     "edit",
+			args:     "ID...",
+			summary:  "Change fields of todos, with flags or in $EDITOR",
+			help:     editHelp,
+			minArgs:  1,
+			maxArgs:  -1,
+			complete: completeIds,
+			setup:    setupEdit,
+		},
+		{
+			name:     "done",
+			args:     "ID...",
+			summary:  "Complete todos",
+			help:     "Moves the todos to the first completed status of the workflow, DONE by default.\n" + idsHelp,
+			minArgs:  1,
+			maxArgs:  -1,
+			complete: completeIds,
+			setup:    setupDone,
+		},
+		{
+			name:     "rm",
+			aliases:  []string{"remove"},
+			args:     "ID...",
+			summary:  "Move todos to the trash",
+			help:     "Removed todos can be restored until purged, see 'PROG help trash'.\n" + idsHelp,
+			minArgs:  1,
+			maxArgs:  -1,
+			complete: completeIds,
+			setup:    setupRemove,
+		},
+		{
+			name:    "trash",
+			summary: "List the removed todos",
+			help:    trashHelp,
+			maxArgs: 0,
+			setup:   setupTrash,
+		},
+		{
+			name:     "restore",
+			args:     "ID...",
+			summary:  "Take todos out of the trash",
+			help:     "An ID is a full id or a unique id prefix of a todo in the trash.",
+			minArgs:  1,
+			maxArgs:  -1,
+			complete: completeTrash,
+			setup:    setupRestore,
+		},
+		{
+			name:     "purge",
+			args:     "[ID...]",
+			summary:  "Delete todos in the trash for good",
+			help:     purgeHelp,
+			maxArgs:  -1,
+			complete: completeTrash,
+			setup:    setupPurge,
+		},
+		{
+			name:    "tui",
+			summary: "Browse and change todos full screen",
+			help:    tuiCommandHelp,
+			setup:   setupTUI,
+		},
+		{
+			name:     "completion",
+			args:     "bash|zsh|fish",
+			summary:  "Print a shell completion script",
+			help:     completionHelp,
+			minArgs:  1,
+			maxArgs:  1,
+			complete: completeShells,
+			setup:    setupCompletion,
+		},
+		{
+			name:     "help",
+			args:     "[COMMAND]",
+			summary:  "Show help for a command",
+			maxArgs:  1,
+			complete: completeCommands,
+			setup:    setupHelp,
+		},
+	}
+}
+
+func lookup(name string) (command, bool) {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd, true
+		}
+
+		for _, alias := range cmd.aliases {
+			if alias == name {
+				return cmd, true
+			}
+		}
+	}
+
+	return command{}, false
+}
+
+func (cmd command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	return fs
+}
+
+// parseFlags parses flags given anywhere among the arguments, so that
+// "edit 42 --data milk" works like "edit --data milk 42".
+// Everything after "--" is an argument.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+
+		// fs.Parse stops after "--", and at the first argument otherwise
+		parsed := len(args) - len(rest)
+		if parsed != 0 && args[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// legacyModes maps the modes of the first cli, e.g. "--update ID DATA",
+// to commands. The last argument of a mode with a flag is that flag's value.
+var legacyModes = map[string]struct {
+	command string
+	flag    string
+}{
+	"--add":           {command: "add"},
+	"--get-all":       {command: "list"},
+	"--query":         {command: "list"},
+	"--get":           {command: "show"},
+	"--get-status":    {command: "list", flag: "status"},
+	"--update":        {command: "edit", flag: "data"},
+	"--update-status": {command: "edit", flag: "status"},
+	"--rm":            {command: "rm"},
+	"--set-due":       {command: "edit", flag: "due"},
+	"--set-priority":  {command: "edit", flag: "priority"},
+	"--set-tags":      {command: "edit", flag: "tags"},
+	"--set-notes":     {command: "edit", flag: "notes"},
+}
+
+// legacyArgs rewrites a command line of the first cli, reporting whether it was one
+func legacyArgs(args []string) ([]string, bool) {
+	mode, ok := legacyModes[args[0]]
+	if !ok {
+		return args, false
+	}
+
+	rest := args[1:]
+	if mode.flag != "" && len(rest) != 0 {
+		value := rest[len(rest)-1]
+		rest = append([]string{"--" + mode.flag + "=" + value}, rest[:len(rest)-1]...)
+	}
+
+	return append([]string{mode.command}, rest...), true
+}
+
+// run runs the command line args, without the program name,
+// and returns the exit code
+func (a *app) run(args []string) int {
+	args, server, err := serverFlag(args)
+	if err != nil {
+		return a.fail(err)
+	}
+
+	if server != "" {
+		a.server = server
+	}
+
+	if len(args) == 0 && a.interactive {
+		args = []string{"tui"}
+	}
+
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	if args[0] == completeCommand {
+		return a.runComplete(args[1:])
+	}
+
+	if args[0] == "-h" || args[0] == "--help" {
+		a.usage(a.stdout)
+		return ExitOk
+	}
+
+	if legacy, ok := legacyArgs(args); ok {
+		fmt.Fprintf(a.stderr, "%s is deprecated, use '%s %s'\n", args[0], a.prog, legacy[0])
+		args = legacy
+	}
+
+	cmd, ok := lookup(args[0])
+	if !ok {
+		fmt.Fprintf(a.stderr, "unknown command '%s'\n", args[0])
+		fmt.Fprintf(a.stderr, "Run '%s help' for usage.\n", a.prog)
+		return ExitUsage
+	}
+
+	fs := cmd.flagSet()
+	runCmd := cmd.setup(fs)
+
+	positional, err := parseFlags(fs, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		a.commandUsage(a.stdout, cmd)
+		return ExitOk
+	}
+
+	if err == nil {
+		err = checkArgs(cmd, positional)
+	}
+
+	if err != nil {
+		fmt.Fprintf(a.stderr, "%s: %s\n", cmd.name, err)
+		fmt.Fprintf(a.stderr, "Run '%s help %s' for usage.\n", a.prog, cmd.name)
+		return ExitUsage
+	}
+
+	err = runCmd(context.Background(), a, positional)
+	if err != nil {
+		return a.fail(err)
+	}
+
+	return ExitOk
+}
+
+func checkArgs(cmd command, args []string) error {
+	switch {
+	case len(args) < cmd.minArgs && cmd.args != "":
+		return fmt.Errorf("missing %s", cmd.args)
+	case cmd.maxArgs == 0 && len(args) != 0:
+		return fmt.Errorf("unexpected argument '%s'", args[0])
+	case cmd.maxArgs > 0 && len(args) > cmd.maxArgs:
+		return fmt.Errorf("too many arguments, expecting %s", cmd.args)
+	}
+
+	return nil
+}
+
+func (a *app) usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [--server URL] <command> [flags] [arguments]\n\n", a.prog)
+	fmt.Fprintln(w, "Commands:")
+
+	for _, cmd := range commands() {
+		name := strings.Join(append([]string{cmd.name}, cmd.aliases...), ", ")
+		fmt.Fprintf(w, "  %-12s %s\n", name, cmd.summary)
+	}
+
+	fmt.Fprintln(w, `
+Flags:
+  --server URL  use the api server at URL, like REPO=http
+
+Environment:
+  REPO        json (default), jsonmap, text, todotxt, sqlite, redis or http
+  FILENAME    file of the file backends
+  WORKFLOW    workflow json file of the local backends
+  SERVER      url of the api server for REPO=http, `+defaultServer+` by default
+  TODO_TOKEN  bearer token of the api server`)
+
+	fmt.Fprintf(w, "\nRun '%s help <command>' for the flags of a command.\n", a.prog)
+}
+
+func (a *app) commandUsage(w io.Writer, cmd command) {
+	fs := cmd.flagSet()
+	cmd.setup(fs)
+
+	line := a.prog + " " + cmd.name
+	if hasFlags(fs) {
+		line += " [flags]"
+	}
+
+	if cmd.args != "" {
+		line += " " + cmd.args
+	}
+
+	fmt.Fprintf(w, "Usage: %s\n\n%s.\n", line, cmd.summary)
+
+	if cmd.help != "" {
+		fmt.Fprintf(w, "%s\n", strings.ReplaceAll(cmd.help, "PROG", a.prog))
+	}
+
+	if len(cmd.aliases) != 0 {
+		fmt.Fprintf(w, "\nAliases: %s\n", strings.Join(cmd.aliases, ", "))
+	}
+
+	if hasFlags(fs) {
+		fmt.Fprintln(w, "\nFlags:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	has := false
+	fs.VisitAll(func(*flag.Flag) {
+		has = true
+	})
+
+	return has
+}
+
+// fields are the flags of add and edit setting the fields of a todo
+type fields struct {
+	data     *string
+	status   *string
+	due      *string
+	priority *string
+	tags     *string
+	notes    *string
+}
+
+func defineFields(fs *flag.FlagSet, withData bool) fields {
+	f := fields{}
+
+	if withData {
+		f.data = fs.String("data", "", "text of the todo")
+	}
+
+	f.status = fs.String("status", "", "status, one of the workflow")
+	f.due = fs.String("due", "", "due date, 2006-01-02 or RFC3339")
+	f.priority = fs.String("priority", "", "priority, 1 (highest) to 26")
+	f.tags = fs.String("tags", "", "comma separated tags, replacing the current ones")
+	f.notes = fs.String("notes", "", "free-form notes")
+
+	return f
+}
+
+// given reports whether any field is given as a flag
+func (f fields) given(fs *flag.FlagSet) bool {
+	given := false
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "data", "status", "due", "priority", "tags", "notes":
+			given = true
+		}
+	})
+
+	return given
+}
+
+// apply sets the fields of todo given as flags
+func (f fields) apply(fs *flag.FlagSet, todo *model.Todo) error {
+	var err error
+
+	fs.Visit(func(fl *flag.Flag) {
+		if err != nil {
+			return
+		}
+
+		value := fl.Value.String()
+
+		switch fl.Name {
+		case "data":
+			todo.Data = value
+
+		case "status":
+			todo.Status = model.Status(value)
+
+		case "due":
+			todo.DueAt = nil
+			if value != "" {
+				var due time.Time
+				due, err = parseDue(value)
+				todo.DueAt = &due
+			}
+
+		case "priority":
+			todo.Priority = model.PriorityNone
+			if value != "" {
+				var p int
+				p, err = strconv.Atoi(value)
+				if err != nil {
+					err = fmt.Errorf("%w: bad priority '%s'", repo.ErrInvalidTodo, value)
+				}
+
+				todo.Priority = model.Priority(p)
+			}
+
+		case "tags":
+			todo.Tags = splitList(value)
+
+		case "notes":
+			todo.Notes = value
+		}
+	})
+
+	return err
+}
+
+func setupAdd(fs *flag.FlagSet) runFunc {
+	f := defineFields(fs, false)
+	out := defineOutput(fs, "", "a message")
+
+	return func(ctx context.Context, a *app, args []string) error {
+		err := out.prepare()
+		if err != nil {
+			return err
+		}
+
+		todo := model.Todo{
+			Id:   uuid.NewString(),
+			Data: strings.Join(args, " "),
+		}
+
+		err = f.apply(fs, &todo)
+		if err != nil {
+			return err
+		}
+
+		r, err := a.repository()
+		if err != nil {
+			return err
+		}
+
+		err = r.Add(ctx, todo)
+		if err != nil {
+			return err
+		}
+
+		added, err := r.Get(ctx, todo.Id)
+		if err != nil {
+			return err
+		}
+
+		if !out.human() {
+			return out.write(a, []model.Todo{added})
+		}
+
+		fmt.Fprintf(a.stdout, "Added %s\n", todoLine(added))
+		return nil
+	}
+}
+
+// defineFilter defines the flags of list, returning the function reading
+// them into a filter:
+//
+//	list --status TODO,DONE --text milk --tag home,shop
+//	     --due-from 2024-07-01 --due-to 2024-08-01
+//	     --sort -priority --limit 20 --cursor ...
+func defineFilter(fs *flag.FlagSet) func() (repo.Filter, error) {
+	status := fs.String("status", "", "comma separated statuses")
+	text := fs.String("text", "", "text in data or notes")
+	tags := fs.String("tag", "", "comma separated tags, todos must have all of them")
+	dueFrom := fs.String("due-from", "", "due on or after, 2006-01-02 or RFC3339")
+	dueTo := fs.String("due-to", "", "due before, 2006-01-02 or RFC3339")
+	sortBy := fs.String("sort", "", "sort field, prefix with '-' for descending")
+	limit := fs.Int("limit", 0, "page size, 0 for no limit")
+	cursor := fs.String("cursor", "", "cursor printed after the previous page")
+
+	return func() (repo.Filter, error) {
+		filter := repo.Filter{
+			Text:   *text,
+			Limit:  *limit,
+			Cursor: *cursor,
+		}
+
+		for _, s := range splitList(*status) {
+			filter.Statuses = append(filter.Statuses, model.Status(s))
+		}
+
+		filter.Tags = splitList(*tags)
+
+		if *dueFrom != "" {
+			t, err := parseDue(*dueFrom)
+			if err != nil {
+				return repo.Filter{}, err
+			}
+
+			filter.DueFrom = &t
+		}
+
+		if *dueTo != "" {
+			t, err := parseDue(*dueTo)
+			if err != nil {
+				return repo.Filter{}, err
+			}
+
+			filter.DueTo = &t
+		}
+
+		var err error
+		filter.Sort, filter.Desc, err = repo.ParseSort(*sortBy)
+		if err != nil {
+			return repo.Filter{}, err
+		}
+
+		return filter, nil
+	}
+}
+
+func setupList(fs *flag.FlagSet) runFunc {
+	readFilter := defineFilter(fs)
+	out := defineOutput(fs, FormatTable, "")
+
+	return func(ctx context.Context, a *app, args []string) error {
+		err := out.prepare()
+		if err != nil {
+			return err
+		}
+
+		filter, err := readFilter()
+		if err != nil {
+			return err
+		}
+
+		r, err := a.repository()
+		if err != nil {
+			return err
+		}
+
+		page, err := r.Query(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		if len(page.Todos) == 0 && !out.machine() {
+			fmt.Fprintln(a.stdout, "No data")
+			return nil
+		}
+
+		if *out.format == FormatTable {
+			all, err := r.GetAll(ctx)
+			if err != nil {
+				return err
+			}
+
+			ids := make([]string, len(all))
+			for i := range all {
+				ids[i] = all[i].Id
+			}
+
+			out.short = shortIds(ids)
+			out.numbered = true
+
+			listed := make([]string, len(page.Todos))
+			for i := range page.Todos {
+				listed[i] = page.Todos[i].Id
+			}
+
+			a.saveListing(listed)
+		}
+
+		err = out.write(a, page.Todos)
+		if err != nil {
+			return err
+		}
+
+		if page.Next != "" {
+			// machine formats keep stdout parseable
+			hint := a.stdout
+			if out.machine() {
+				hint = a.stderr
+			}
+
+			fmt.Fprintf(hint, "Next page: --cursor %s\n", page.Next)
+		}
+
+		return nil
+	}
+}
+
+func setupShow(fs *flag.FlagSet) runFunc {
+	out := defineOutput(fs, "", "every field")
+
+	return func(ctx context.Context, a *app, ids []string) error {
+		err := out.prepare()
+		if err != nil {
+			return err
+		}
+
+		r, err := a.repository()
+		if err != nil {
+			return err
+		}
+
+		ids, err = a.resolveIds(ctx, r, ids)
+		if err != nil {
+			return err
+		}
+
+		todos := make([]model.Todo, len(ids))
+		for i, id := range ids {
+			todos[i], err = r.Get(ctx, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !out.human() {
+			return out.write(a, todos)
+		}
+
+		for i, todo := range todos {
+			if i != 0 {
+				fmt.Fprintln(a.stdout)
+			}
+
+			printTodo(a.stdout, todo)
+		}
+
+		return nil
+	}
+}
+
+func setupEdit(fs *flag.FlagSet) runFunc {
+	f := defineFields(fs, true)
+	out := defineOutput(fs, "", "a message and every field")
+
+	return func(ctx context.Context, a *app, args []string) error {
+		err := out.prepare()
+		if err != nil {
+			return err
+		}
+
+		if f.given(fs) && len(args) != 1 {
+			return usageError{errors.New("field flags change a single todo, edit several in $EDITOR")}
+		}
+
+		r, err := a.repository()
+		if err != nil {
+			return err
+		}
+
+		ids, err := a.resolveIds(ctx, r, args)
+		if err != nil {
+			return err
+		}
+
+		if !f.given(fs) {
+			updated, err := a.editInEditor(ctx, r, ids)
+			if !out.human() && err == nil {
+				return out.write(a, updated)
+			}
+
+			for _, todo := range updated {
+				fmt.Fprintf(a.stdout, "Updated %s\n", todoLine(todo))
+			}
+
+			if len(updated) == 0 && err == nil {
+				fmt.Fprintln(a.stdout, "Nothing changed")
+			}
+
+			return err
+		}
+
+		todo, err := r.Get(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+
+		err = f.apply(fs, &todo)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.Update(ctx, todo)
+		if err != nil {
+			return err
+		}
+
+		todo, err = r.Get(ctx, todo.Id)
+		if err != nil {
+			return err
+		}
+
+		if !out.human() {
+			return out.write(a, []model.Todo{todo})
+		}
+
+		fmt.Fprintln(a.stdout, "Updated")
+		printTodo(a.stdout, todo)
+		return nil
+	}
+}
+
+// batchFailure names the todo of ids at which a batch of them failed
+func batchFailure(err error, ids []string) error {
+	var batchErr *repo.BatchError
+	if errors.As(err, &batchErr) && batchErr.Index < len(ids) {
+		return fmt.Errorf("todo '%s': %w", ids[batchErr.Index], batchErr.Err)
+	}
+
+	return err
+}
+
+func setupDone(fs *flag.FlagSet) runFunc {
+	out := defineOutput(fs, "", "a message")
+
+	return func(ctx context.Context, a *app, ids []string) error {
+		err := out.prepare()
+		if err != nil {
+			return err
+		}
+
+		r, err := a.repository()
+		if err != nil {
+			return err
+		}
+
+		ids, err = a.resolveIds(ctx, r, ids)
+		if err != nil {
+			return err
+		}
+
+		wf, err := a.workflow(ctx, r)
+		if err != nil {
+			return err
+		}
+
+		ids = uniqueIds(ids)
+		status := wf.DoneStatus()
+		todos := make([]model.Todo, len(ids))
+		for i, id := range ids {
+			todos[i], err = r.Get(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			todos[i].Status = status
+		}
+
+		// all or nothing, and a single write of the storage
+		_, _, err = r.UpdateMany(ctx, todos)
+		if err != nil {
+			return batchFailure(err, ids)
+		}
+
+		for i, id := range ids {
+			todos[i], err = r.Get(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			if out.human() {
+				fmt.Fprintf(a.stdout, "Done %s\n", todoLine(todos[i]))
+			}
+		}
+
+		if !out.human() {
+			return out.write(a, todos)
+		}
+
+		return nil
+	}
+}
+
+func setupRemove(fs *flag.FlagSet) runFunc {
+	out := defineOutput(fs, "", "a message")
+
+	return func(ctx context.Context, a *app, ids []string) error {
+		err := out.prepare()
+		if err != nil {
+			return err
+		}
+
+		r, err := a.repository()
+		if err != nil {
+			return err
+		}
+
+		ids, err = a.resolveIds(ctx, r, ids)
+		if err != nil {
+			return err
+		}
+
+		ids = uniqueIds(ids)
+		todos, err := r.RemoveMany(ctx, ids)
+		if err != nil {
+			return batchFailure(err, ids)
+		}
+
+		for _, removed := range todos {
+			if out.human() {
+				fmt.Fprintf(a.stdout, "Removed %s\n", todoLine(removed))
+			}
+		}
+
+		if !out.human() {
+			return out.write(a, todos)
+		}
+
+		return nil
+	}
+}
+
+func setupTrash(fs *flag.FlagSet) runFunc {
+	out := defineOutput(fs, FormatTable, "")
+
+	return func(ctx context.Context, a *app, args []string) error {
+		err := out.prepare()
+		if err != nil {
+			return err
+		}
+
+		r, err := a.repository()
+		if err != nil {
+			return err
+		}
+
+		todos, err := r.Trash(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(todos) == 0 && !out.machine() {
+			fmt.Fprintln(a.stdout, "The trash is empty")
+			return nil
+		}
+
+		ids := make([]string, len(todos))
+		for i := range todos {
+			ids[i] = todos[i].Id
+		}
+
+		out.short = shortIds(ids)
+		return out.write(a, todos)
+	}
+}
+
+func setupRestore(fs *flag.FlagSet) runFunc {
+	out := defineOutput(fs, "", "a message")
+
+	return func(ctx context.Context, a *app, ids []string) error {
+		err := out.prepare()
+		if err != nil {
+			return err
+		}
+
+		r, err := a.repository()
+		if err != nil {
+			return err
+		}
+
+		ids, err = resolveTrashIds(ctx, r, ids)
+		if err != nil {
+			return err
+		}
+
+		ids = uniqueIds(ids)
+		todos := make([]model.Todo, len(ids))
+		for i, id := range ids {
+			todos[i], err = r.Restore(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			if out.human() {
+				fmt.Fprintf(a.stdout, "Restored %s\n", todoLine(todos[i]))
+			}
+		}
+
+		if !out.human() {
+			return out.write(a, todos)
+		}
+
+		return nil
+	}
+}
+
+func setupPurge(fs *flag.FlagSet) runFunc {
+	all := fs.Bool("all", false, "purge the whole trash")
+	olderThan := fs.String("older-than", "", "purge the todos removed longer ago, e.g. 30d or 12h")
+	out := defineOutput(fs, "", "a message")
+
+	return func(ctx context.Context, a *app, ids []string) error {
+		err := out.prepare()
+		if err != nil {
+			return err
+		}
+
+		given := 0
+		for _, set := range []bool{len(ids) != 0, *all, *olderThan != ""} {
+			if set {
+				given++
+			}
+		}
+
+		if given != 1 {
+			return usageError{errors.New("expecting IDs, --older-than or --all")}
+		}
+
+		before := model.Now()
+		if *olderThan != "" {
+			age, err := parseAge(*olderThan)
+			if err != nil {
+				return err
+			}
+
+			before = before.Add(-age)
+		}
+
+		r, err := a.repository()
+		if err != nil {
+			return err
+		}
+
+		var todos []model.Todo
+		if len(ids) == 0 {
+			todos, err = r.PurgeBefore(ctx, before)
+			if err != nil {
+				return err
+			}
+		} else {
+			ids, err = resolveTrashIds(ctx, r, ids)
+			if err != nil {
+				return err
+			}
+
+			ids = uniqueIds(ids)
+			todos = make([]model.Todo, len(ids))
+			for i, id := range ids {
+				todos[i], err = r.Purge(ctx, id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if !out.human() {
+			return out.write(a, todos)
+		}
+
+		for _, purged := range todos {
+			fmt.Fprintf(a.stdout, "Purged %s\n", todoLine(purged))
+		}
+
+		if len(todos) == 0 {
+			fmt.Fprintln(a.stdout, "Nothing to purge")
+		}
+
+		return nil
+	}
+}
+
+func setupHelp(fs *flag.FlagSet) runFunc {
+	return func(ctx context.Context, a *app, args []string) error {
+		if len(args) == 0 {
+			a.usage(a.stdout)
+			return nil
+		}
+
+		cmd, ok := lookup(args[0])
+		if !ok {
+			return usageError{fmt.Errorf("unknown command '%s'", args[0])}
+		}
+
+		a.commandUsage(a.stdout, cmd)
+		return nil
+	}
+}
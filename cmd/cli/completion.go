@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/eymyong/todo/repo"
+)
+
+// completeCommand is the hidden command the completion scripts call with
+// the words typed so far, the last one being completed. It prints one
+// candidate per line, with a tab before its description.
+const completeCommand = "__complete"
+
+const completionHelp = `Load the completions in the current shell with:
+  bash: source <(PROG completion bash)
+  zsh:  source <(PROG completion zsh)
+  fish: PROG completion fish | source
+Ids are completed from the active repository, REPO or --server.`
+
+var shells = []string{"bash", "zsh", "fish"}
+
+const bashCompletion = `# bash completion for PROG
+_FUNC_complete() {
+	local IFS=$'\n'
+	COMPREPLY=($(PROG __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1))
+}
+complete -o default -F _FUNC_complete PROG
+`
+
+const zshCompletion = `#compdef PROG
+# zsh completion for PROG
+_FUNC_complete() {
+	local -a candidates
+	local line
+	for line in "${(@f)$(PROG __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}"; do
+		[[ -n $line ]] && candidates+=("${${line%%$'\t'*}//:/\\:}:${line#*$'\t'}")
+	done
+	_describe 'PROG' candidates
+}
+compdef _FUNC_complete PROG
+`
+
+const fishCompletion = `# fish completion for PROG
+function __FUNC_complete
+	set -l words (commandline -opc) (commandline -ct)
+	PROG __complete $words[2..-1] 2>/dev/null
+end
+complete -c PROG -f -a '(__FUNC_complete)'
+`
+
+var nonWord = regexp.MustCompile(`\W`)
+
+// completionScript returns the script of shell completing prog
+func completionScript(shell string, prog string) (string, error) {
+	var script string
+
+	switch shell {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		return "", usageError{fmt.Errorf("unknown shell '%s', expecting %s", shell, strings.Join(shells, ", "))}
+	}
+
+	script = strings.ReplaceAll(script, "FUNC", nonWord.ReplaceAllString(prog, "_"))
+	return strings.ReplaceAll(script, "PROG", prog), nil
+}
+
+func setupCompletion(fs *flag.FlagSet) runFunc {
+	return func(ctx context.Context, a *app, args []string) error {
+		script, err := completionScript(args[0], a.prog)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(a.stdout, script)
+		return nil
+	}
+}
+
+type candidate struct {
+	value       string
+	description string
+}
+
+func (a *app) runComplete(words []string) int {
+	for _, c := range a.complete(context.Background(), words) {
+		fmt.Fprintf(a.stdout, "%s\t%s\n", c.value, c.description)
+	}
+
+	return ExitOk
+}
+
+// complete returns the candidates for the last of words
+func (a *app) complete(ctx context.Context, words []string) []candidate {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+
+	current := words[len(words)-1]
+
+	typed, server, err := serverFlag(words[:len(words)-1])
+	if err != nil {
+		// completing the url of --server
+		return nil
+	}
+
+	if server != "" {
+		a.server = server
+	}
+
+	var candidates []candidate
+	if len(typed) == 0 {
+		if strings.HasPrefix(current, "-") {
+			candidates = []candidate{{"--server", "use the api server at URL"}, {"--help", "show usage"}}
+		} else {
+			candidates = a.completeKind(ctx, completeCommands)
+		}
+
+		return withPrefix(candidates, current)
+	}
+
+	cmd, ok := lookup(typed[0])
+	if !ok {
+		return nil
+	}
+
+	fs := cmd.flagSet()
+	cmd.setup(fs)
+
+	previous := typed[len(typed)-1]
+
+	// the flag whose value is being completed, like "--sort" before "-pri"
+	var valueOf *flag.Flag
+	if len(typed) > 1 && strings.HasPrefix(previous, "-") && !strings.Contains(previous, "=") {
+		valueOf = fs.Lookup(strings.TrimLeft(previous, "-"))
+	}
+
+	switch {
+	case valueOf != nil:
+		candidates = a.completeFlag(valueOf.Name)
+
+	case strings.HasPrefix(current, "-"):
+		fs.VisitAll(func(f *flag.Flag) {
+			candidates = append(candidates, candidate{"--" + f.Name, f.Usage})
+		})
+
+	default:
+		candidates = a.completeKind(ctx, cmd.complete)
+	}
+
+	return withPrefix(candidates, current)
+}
+
+var sortFields = []repo.SortField{
+	repo.SortCreatedAt,
+	repo.SortUpdatedAt,
+	repo.SortDueAt,
+	repo.SortPriority,
+	repo.SortStatus,
+	repo.SortData,
+	repo.SortId,
+}
+
+func (a *app) completeFlag(name string) []candidate {
+	var candidates []candidate
+
+	switch name {
+	case "status":
+		for _, s := range a.wf.AllStatuses() {
+			candidates = append(candidates, candidate{string(s), ""})
+		}
+
+	case "output", "o":
+		for _, f := range formats {
+			candidates = append(candidates, candidate{f, ""})
+		}
+
+	case "color":
+		for _, c := range colorModes {
+			candidates = append(candidates, candidate{c, ""})
+		}
+
+	case "sort":
+		for _, f := range sortFields {
+			candidates = append(candidates, candidate{string(f), "ascending"}, candidate{"-" + string(f), "descending"})
+		}
+	}
+
+	return candidates
+}
+
+// maxDescription is the length todo texts are cut to in descriptions
+const maxDescription = 50
+
+func (a *app) completeKind(ctx context.Context, kind string) []candidate {
+	var candidates []candidate
+
+	switch kind {
+	case completeCommands:
+		for _, cmd := range commands() {
+			candidates = append(candidates, candidate{cmd.name, cmd.summary})
+		}
+
+	case completeShells:
+		for _, s := range shells {
+			candidates = append(candidates, candidate{s, ""})
+		}
+
+	case completeIds, completeTrash:
+		r, err := a.repository()
+		if err != nil {
+			return nil
+		}
+
+		list := r.GetAll
+		if kind == completeTrash {
+			list = r.Trash
+		}
+
+		todos, err := list(ctx)
+		if err != nil {
+			return nil
+		}
+
+		for _, todo := range todos {
+			description := []rune(strings.Join(strings.Fields(todo.Data), " "))
+			if len(description) > maxDescription {
+				description = append(description[:maxDescription-3], []rune("...")...)
+			}
+
+			candidates = append(candidates, candidate{todo.Id, string(description)})
+		}
+	}
+
+	return candidates
+}
+
+func withPrefix(candidates []candidate, prefix string) []candidate {
+	var result []candidate
+	for _, c := range candidates {
+		if strings.HasPrefix(c.value, prefix) {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eymyong/todo/client"
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+)
+
+// Editing in $EDITOR writes the todos to a temporary file, one block per todo:
+//
+//	=== 3f2a6c1e-9d1b-4c4e-8f0a-2b7d5e9c1a04
+//	Data: buy milk
+//	Status: TODO
+//	Priority: 2
+//	Due: 2024-05-01
+//	Tags: home, errands
+//	Notes:
+//	everything up to the next block,
+//	over as many lines as needed
+//
+// Note lines that would start a block ("=== ", after any spaces) are
+// written with one more space in front, taken off when read back.
+//
+// Once the editor exits, the fields changed are applied to the todos.
+// When the file does not parse, the editor opens again with the error.
+
+const blockPrefix = "=== "
+
+// escapeNotes indents the lines of notes that would read as the start of
+// a block, and those already looking escaped, by one space
+func escapeNotes(notes string) string {
+	lines := strings.Split(notes, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimLeft(line, " "), blockPrefix) {
+			lines[i] = " " + line
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// unescapeNote reads back a line of notes written by escapeNotes
+func unescapeNote(line string) string {
+	if strings.HasPrefix(line, " ") && strings.HasPrefix(strings.TrimLeft(line, " "), blockPrefix) {
+		return line[1:]
+	}
+
+	return line
+}
+
+const editorHelp = `# Change the fields of the todos below, then save and quit.
+# Data is one line, Notes go on until the next '=== ID' line.
+# Due is 2006-01-02 or RFC3339, Priority 1 (highest) to 26, empty for none.
+# A todo removed from the file is left unchanged, an empty file changes nothing.
+# Lines starting with '#' are ignored here, before the first todo.
+`
+
+// editorCommand is the editor of VISUAL or EDITOR, split on spaces to allow
+// flags like "code --wait"
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		fields := strings.Fields(os.Getenv(env))
+		if len(fields) != 0 {
+			return fields
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+
+	return []string{"vi"}
+}
+
+// runEditor opens file in the user's editor, on the terminal of the cli
+func runEditor(file string) error {
+	editor := editorCommand()
+
+	cmd := exec.Command(editor[0], append(editor[1:], file)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("editor %s: %w", editor[0], err)
+	}
+
+	return nil
+}
+
+// editText lets the user edit text in a temporary file and returns the result
+func (a *app) editText(text string) (string, error) {
+	f, err := os.CreateTemp("", "todo-*.txt")
+	if err != nil {
+		return "", err
+	}
+
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(text)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = a.editor(f.Name())
+	if err != nil {
+		return "", err
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+
+	// editors on windows may save CRLF line endings
+	return strings.ReplaceAll(string(b), "\r\n", "\n"), nil
+}
+
+// formatDue writes due dates as parseDue reads them back
+func formatDue(due *time.Time) string {
+	if due == nil {
+		return ""
+	}
+
+	return formatDueIn(*due, time.RFC3339)
+}
+
+// document writes todos in the format described above, with problem
+// written as a comment on top when the last edit did not parse
+func document(todos []model.Todo, statuses []model.Status, problem error) string {
+	var b strings.Builder
+
+	if problem != nil {
+		for _, line := range strings.Split(problem.Error(), "\n") {
+			fmt.Fprintf(&b, "# ERROR: %s\n", line)
+		}
+
+		b.WriteString("#\n")
+	}
+
+	b.WriteString(editorHelp)
+
+	if len(statuses) != 0 {
+		names := make([]string, len(statuses))
+		for i, status := range statuses {
+			names[i] = string(status)
+		}
+
+		fmt.Fprintf(&b, "# Statuses: %s\n", strings.Join(names, ", "))
+	}
+
+	for _, todo := range todos {
+		priority := ""
+		if todo.Priority != model.PriorityNone {
+			priority = strconv.Itoa(int(todo.Priority))
+		}
+
+		fmt.Fprintf(&b, "\n%s%s\n", blockPrefix, todo.Id)
+		fmt.Fprintf(&b, "Data: %s\n", strings.ReplaceAll(todo.Data, "\n", " "))
+		fmt.Fprintf(&b, "Status: %s\n", todo.Status)
+		fmt.Fprintf(&b, "Priority: %s\n", priority)
+		fmt.Fprintf(&b, "Due: %s\n", formatDue(todo.DueAt))
+		fmt.Fprintf(&b, "Tags: %s\n", strings.Join(todo.Tags, ", "))
+		fmt.Fprintf(&b, "Notes:\n")
+
+		if todo.Notes != "" {
+			fmt.Fprintln(&b, escapeNotes(todo.Notes))
+		}
+	}
+
+	return b.String()
+}
+
+// withoutComments removes the comments before the first todo, to write
+// them again when the document is edited once more
+func withoutComments(text string) string {
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, blockPrefix) {
+			return strings.Join(lines[i:], "")
+		}
+
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+			return strings.Join(lines[i:], "")
+		}
+	}
+
+	return ""
+}
+
+// parseDocument reads back the todos of a document. The todos only have
+// their editable fields, and are checked against the ids opened.
+func parseDocument(text string, ids []string) ([]model.Todo, error) {
+	var (
+		todos []model.Todo
+		todo  *model.Todo
+		notes []string
+		// inNotes is set once the Notes line of the block is read
+		inNotes bool
+	)
+
+	end := func() {
+		if todo != nil {
+			todo.Notes = strings.Trim(strings.Join(notes, "\n"), "\n")
+		}
+	}
+
+	for i, line := range strings.Split(text, "\n") {
+		n := i + 1
+
+		if id, ok := strings.CutPrefix(line, blockPrefix); ok {
+			end()
+
+			id = strings.TrimSpace(id)
+			if !slices.Contains(ids, id) {
+				return nil, fmt.Errorf("line %d: todo '%s' was not opened for editing", n, id)
+			}
+
+			for _, t := range todos {
+				if t.Id == id {
+					return nil, fmt.Errorf("line %d: todo '%s' is in the file twice", n, id)
+				}
+			}
+
+			todos = append(todos, model.Todo{Id: id})
+			todo = &todos[len(todos)-1]
+			notes = nil
+			inNotes = false
+			continue
+		}
+
+		if todo == nil {
+			if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+				return nil, fmt.Errorf("line %d: expecting a todo starting with '%sID'", n, blockPrefix)
+			}
+
+			continue
+		}
+
+		if inNotes {
+			notes = append(notes, unescapeNote(strings.TrimRight(line, " \t")))
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expecting 'Field: value', got '%s'", n, line)
+		}
+
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "data":
+			todo.Data = value
+
+		case "status":
+			todo.Status = model.Status(value)
+
+		case "priority":
+			if value == "" {
+				continue
+			}
+
+			p, err := strconv.Atoi(value)
+			if err != nil || !model.Priority(p).IsValid() {
+				return nil, fmt.Errorf("line %d: bad priority '%s', expecting 1 to %d", n, value, model.PriorityMax)
+			}
+
+			todo.Priority = model.Priority(p)
+
+		case "due":
+			if value == "" {
+				continue
+			}
+
+			due, err := parseDue(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad due date '%s', expecting 2006-01-02 or RFC3339", n, value)
+			}
+
+			todo.DueAt = &due
+
+		case "tags":
+			todo.Tags = splitList(value)
+
+		case "notes":
+			inNotes = true
+			if value != "" {
+				notes = append(notes, value)
+			}
+
+		default:
+			return nil, fmt.Errorf("line %d: unknown field '%s'", n, name)
+		}
+	}
+
+	end()
+
+	for _, todo := range todos {
+		switch {
+		case strings.TrimSpace(todo.Data) == "":
+			return nil, fmt.Errorf("todo '%s': data cannot be empty", todo.Id)
+		case todo.Status == "":
+			return nil, fmt.Errorf("todo '%s': status cannot be empty", todo.Id)
+		}
+	}
+
+	return todos, nil
+}
+
+// applyChanges sets on todo the fields that differ between before and
+// after, reporting whether there was any
+func applyChanges(todo *model.Todo, before model.Todo, after model.Todo) bool {
+	changed := false
+
+	if after.Data != before.Data {
+		todo.Data = after.Data
+		changed = true
+	}
+
+	if after.Status != before.Status {
+		todo.Status = after.Status
+		changed = true
+	}
+
+	if after.Priority != before.Priority {
+		todo.Priority = after.Priority
+		changed = true
+	}
+
+	if formatDue(after.DueAt) != formatDue(before.DueAt) {
+		todo.DueAt = after.DueAt
+		changed = true
+	}
+
+	if !slices.Equal(after.Tags, before.Tags) {
+		todo.Tags = after.Tags
+		changed = true
+	}
+
+	if after.Notes != before.Notes {
+		todo.Notes = after.Notes
+		changed = true
+	}
+
+	return changed
+}
+
+// editInEditor edits the todos of ids in the user's editor and returns
+// the todos updated
+func (a *app) editInEditor(ctx context.Context, r repo.Repository, ids []string) ([]model.Todo, error) {
+	ids = uniqueIds(ids)
+
+	todos := make([]model.Todo, len(ids))
+	for i, id := range ids {
+		todo, err := r.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		todos[i] = todo
+	}
+
+	// the workflow of remote repositories is the server's
+	statuses := a.wf.AllStatuses()
+	if _, remote := r.(*client.Client); remote {
+		statuses = nil
+	}
+
+	text := document(todos, statuses, nil)
+
+	// before is the document as written, read back the same way as the
+	// edited one, so that fields the format cannot hold exactly (e.g.
+	// data over several lines) are only changed when edited
+	before, err := parseDocument(text, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		after   []model.Todo
+		problem error
+	)
+
+	for {
+		edited, err := a.editText(text)
+		if err != nil {
+			return nil, err
+		}
+
+		if edited == text && problem != nil {
+			return nil, usageError{fmt.Errorf("edit canceled: %w", problem)}
+		}
+
+		after, problem = parseDocument(edited, ids)
+		if problem == nil && statuses != nil {
+			problem = checkStatuses(after, a.wf)
+		}
+
+		if problem == nil {
+			break
+		}
+
+		text = document(nil, statuses, problem) + "\n" + withoutComments(edited)
+	}
+
+	changed := []model.Todo{}
+	for _, edited := range after {
+		i := slices.Index(ids, edited.Id)
+
+		// changes by others while editing are kept, but for the fields
+		// edited. The update is checked against the version read here, so
+		// that a change made after fails instead of being overwritten.
+		todo, err := r.Get(ctx, edited.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		if applyChanges(&todo, before[i], edited) {
+			changed = append(changed, todo)
+		}
+	}
+
+	updated, _, err := r.UpdateMany(ctx, changed)
+	if err != nil {
+		return nil, batchFailure(err, repo.TodoIds(changed))
+	}
+
+	return updated, nil
+}
+
+func checkStatuses(todos []model.Todo, wf model.Workflow) error {
+	var errs []error
+	for _, todo := range todos {
+		if !wf.IsValid(todo.Status) {
+			errs = append(errs, fmt.Errorf("todo '%s': unknown status '%s'", todo.Id, todo.Status))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+)
+
+func TestDocument(t *testing.T) {
+	due := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	todos := []model.Todo{
+		{Id: "1", Data: "one", Status: model.StatusTodo, Priority: model.PriorityHigh, DueAt: &due, Tags: []string{"a", "b"}, Notes: "first\n\n# not a comment\n=== not a block\n  === nor this\nlast"},
+		{Id: "2", Data: "two", Status: model.StatusDone},
+	}
+
+	text := document(todos, model.Workflow{}.AllStatuses(), nil)
+	if !strings.Contains(text, "# Statuses: TODO, DONE\n") {
+		t.Fatalf("expected the statuses in:\n%s", text)
+	}
+
+	parsed, err := parseDocument(text, []string{"1", "2"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if !reflect.DeepEqual(todos, parsed) {
+		t.Fatalf("expected %+v, got %+v", todos, parsed)
+	}
+}
+
+func TestParseDocumentErrors(t *testing.T) {
+	block := "=== 1\nData: one\nStatus: TODO\n"
+
+	tests := []struct {
+		name string
+		text string
+		err  string
+	}{
+		{name: "text before todos", text: "hello\n" + block, err: "line 1: expecting a todo"},
+		{name: "unknown id", text: block + "=== 3\nData: x\nStatus: TODO\n", err: "line 4: todo '3' was not opened"},
+		{name: "twice", text: block + block, err: "line 4: todo '1' is in the file twice"},
+		{name: "unknown field", text: block + "Colour: red\n", err: "line 4: unknown field 'Colour'"},
+		{name: "no colon", text: block + "red\n", err: "line 4: expecting 'Field: value'"},
+		{name: "bad priority", text: block + "Priority: 27\n", err: "line 4: bad priority '27'"},
+		{name: "bad due", text: block + "Due: tomorrow\n", err: "line 4: bad due date 'tomorrow'"},
+		{name: "no data", text: "=== 1\nStatus: TODO\n", err: "todo '1': data cannot be empty"},
+		{name: "no status", text: "=== 1\nData: one\n", err: "todo '1': status cannot be empty"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseDocument(tc.text, []string{"1", "2"})
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected err '%s', got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+// editWith returns an editor applying each edit in turn to the file
+func editWith(t *testing.T, edits ...func(string) string) func(string) error {
+	return func(file string) error {
+		if len(edits) == 0 {
+			t.Fatalf("unexpected edit")
+		}
+
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		edit := edits[0]
+		edits = edits[1:]
+		return os.WriteFile(file, []byte(edit(string(b))), 0o600)
+	}
+}
+
+// racingRepo is r where another client changes every todo of a batch
+// right before it is written
+type racingRepo struct {
+	repo.Repository
+}
+
+func (r racingRepo) UpdateMany(ctx context.Context, todos []model.Todo) ([]model.Todo, []model.Todo, error) {
+	for _, todo := range todos {
+		_, err := r.Repository.UpdateData(ctx, todo.Id, "changed elsewhere", repo.AnyVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return r.Repository.UpdateMany(ctx, todos)
+}
+
+func TestEditInEditor(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("bulk", func(t *testing.T) {
+		a, stdout, _ := newTestApp(t)
+		a.editor = editWith(t, func(s string) string {
+			s = strings.Replace(s, "Data: one", "Data: uno", 1)
+			s = strings.Replace(s, "Status: DONE", "Status: TODO", 1)
+			return strings.Replace(s, "Tags: home\nNotes:\n", "Tags: home, work\nNotes:\nover\ntwo lines\n", 1)
+		})
+
+		code := a.run([]string{"edit", "1", "2"})
+		if code != ExitOk {
+			t.Fatalf("unexpected exit code %d", code)
+		}
+
+		r, _ := a.repository()
+		one, _ := r.Get(ctx, "1")
+		two, _ := r.Get(ctx, "2")
+
+		if one.Data != "uno" || !reflect.DeepEqual(one.Tags, []string{"home", "work"}) || one.Notes != "over\ntwo lines" {
+			t.Fatalf("unexpected todo %+v", one)
+		}
+
+		if two.Status != model.StatusTodo || two.CompletedAt != nil {
+			t.Fatalf("unexpected todo %+v", two)
+		}
+
+		if !strings.Contains(stdout.String(), "Updated 1: uno") || !strings.Contains(stdout.String(), "Updated 2: two") {
+			t.Fatalf("unexpected output %s", stdout.String())
+		}
+	})
+
+	t.Run("only changed todos", func(t *testing.T) {
+		a, stdout, _ := newTestApp(t)
+		a.editor = editWith(t, func(s string) string {
+			return strings.Replace(s, "Data: two", "Data: deux", 1)
+		})
+
+		code := a.run([]string{"edit", "1", "2"})
+		if code != ExitOk || strings.Contains(stdout.String(), "Updated 1") {
+			t.Fatalf("unexpected exit code %d, output %s", code, stdout.String())
+		}
+	})
+
+	t.Run("error then fixed", func(t *testing.T) {
+		a, _, _ := newTestApp(t)
+		a.editor = editWith(t,
+			func(s string) string {
+				return strings.Replace(s, "Status: TODO", "Status: LATER", 1)
+			},
+			func(s string) string {
+				if !strings.HasPrefix(s, "# ERROR: todo '1': unknown status 'LATER'\n") {
+					t.Fatalf("expected the error on top of:\n%s", s)
+				}
+
+				return strings.Replace(s, "Status: LATER", "Status: DONE", 1)
+			},
+		)
+
+		code := a.run([]string{"edit", "1"})
+		if code != ExitOk {
+			t.Fatalf("unexpected exit code %d", code)
+		}
+
+		r, _ := a.repository()
+		one, _ := r.Get(ctx, "1")
+		if one.Status != model.StatusDone {
+			t.Fatalf("unexpected status %s", one.Status)
+		}
+	})
+
+	t.Run("error then canceled", func(t *testing.T) {
+		a, _, stderr := newTestApp(t)
+		a.editor = editWith(t,
+			func(s string) string {
+				return strings.Replace(s, "Priority: ", "Priority: high", 1)
+			},
+			func(s string) string {
+				return s
+			},
+		)
+
+		code := a.run([]string{"edit", "1"})
+		if code != ExitUsage || !strings.Contains(stderr.String(), "edit canceled: line") {
+			t.Fatalf("unexpected exit code %d, stderr %s", code, stderr.String())
+		}
+	})
+
+	t.Run("changed while editing", func(t *testing.T) {
+		a, _, _ := newTestApp(t)
+		a.editor = editWith(t, func(s string) string {
+			return strings.Replace(s, "Data: one", "Data: uno", 1)
+		})
+
+		r, _ := a.repository()
+		_, err := a.editInEditor(ctx, racingRepo{r}, []string{"1"})
+		if !errors.Is(err, repo.ErrVersionMismatch) {
+			t.Fatalf("expected version mismatch but got '%v'", err)
+		}
+
+		one, _ := r.Get(ctx, "1")
+		if one.Data != "changed elsewhere" {
+			t.Fatalf("expected the other change to be kept, got %+v", one)
+		}
+	})
+
+	t.Run("flags and several ids", func(t *testing.T) {
+		a, _, _ := newTestApp(t)
+		code := a.run([]string{"edit", "1", "2", "--data", "x"})
+		if code != ExitUsage {
+			t.Fatalf("unexpected exit code %d", code)
+		}
+	})
+}
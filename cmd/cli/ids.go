@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+)
+
+// Commands taking ids also accept, tried in this order:
+//   - the full id
+//   - the number of a todo in the last table printed by list, starting at 1
+//   - a prefix of a single id, like git's abbreviated hashes
+//
+// Listings show the shortest prefix telling each todo apart. Those prefixes
+// are never all digits, as numbers are read as list indexes.
+
+// lastListing is the state file holding the ids of the last listing
+type lastListing struct {
+	// Repo is the repository listed, see repoKey
+	Repo string   `json:"repo"`
+	Ids  []string `json:"ids"`
+}
+
+// statePath is where the last listing is kept, empty when there is no
+// cache directory
+func statePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "todo", "last-list.json")
+}
+
+// saveListing keeps ids for the list indexes of the next commands.
+// Failing to keep them only loses the indexes, so errors are ignored.
+func (a *app) saveListing(ids []string) {
+	if a.statePath == "" {
+		return
+	}
+
+	b, err := json.Marshal(lastListing{Repo: a.repoKey(a.server), Ids: ids})
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(a.statePath), 0o700)
+	if err != nil {
+		return
+	}
+
+	os.WriteFile(a.statePath, b, 0o600)
+}
+
+// loadListing returns the ids of the last listing of the current repository
+func (a *app) loadListing() []string {
+	if a.statePath == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(a.statePath)
+	if err != nil {
+		return nil
+	}
+
+	var last lastListing
+	err = json.Unmarshal(b, &last)
+	if err != nil || last.Repo != a.repoKey(a.server) {
+		return nil
+	}
+
+	return last.Ids
+}
+
+// uniqueIds returns ids without repeats, in order
+func uniqueIds(ids []string) []string {
+	var unique []string
+	for _, id := range ids {
+		if !slices.Contains(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+
+	return unique
+}
+
+// resolveIds turns the ids, indexes and prefixes given to a command into ids
+func (a *app) resolveIds(ctx context.Context, r repo.Repository, refs []string) ([]string, error) {
+	var (
+		all     []model.Todo
+		listing []string
+	)
+
+	ids := make([]string, len(refs))
+	for i, ref := range refs {
+		_, err := r.Get(ctx, ref)
+		if err == nil {
+			ids[i] = ref
+			continue
+		}
+
+		if !errors.Is(err, repo.ErrNotFound) {
+			return nil, err
+		}
+
+		if isNumber(ref) {
+			n, _ := strconv.Atoi(ref)
+			if listing == nil {
+				listing = a.loadListing()
+			}
+
+			if n >= 1 && n <= len(listing) {
+				ids[i] = listing[n-1]
+				continue
+			}
+		}
+
+		if all == nil {
+			all, err = r.GetAll(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ids[i], err = matchPrefix(all, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if ids[i] == "" {
+			return nil, fmt.Errorf("%w: no todo with id, index or id prefix '%s'", repo.ErrNotFound, ref)
+		}
+	}
+
+	return ids, nil
+}
+
+// resolveTrashIds turns the ids and prefixes given to a command into ids
+// of todos in the trash. List indexes are those of list, not of the trash.
+func resolveTrashIds(ctx context.Context, r repo.Repository, refs []string) ([]string, error) {
+	trash, err := r.Trash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(refs))
+	for i, ref := range refs {
+		if slices.ContainsFunc(trash, func(todo model.Todo) bool { return todo.Id == ref }) {
+			ids[i] = ref
+			continue
+		}
+
+		ids[i], err = matchPrefix(trash, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if ids[i] == "" {
+			return nil, fmt.Errorf("%w: no todo in the trash with id or id prefix '%s'", repo.ErrNotFound, ref)
+		}
+	}
+
+	return ids, nil
+}
+
+// matchPrefix returns the id of the single todo of todos starting with
+// prefix, empty when there is none
+func matchPrefix(todos []model.Todo, prefix string) (string, error) {
+	var matches []model.Todo
+	for _, todo := range todos {
+		if strings.HasPrefix(todo.Id, prefix) {
+			matches = append(matches, todo)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", nil
+	case 1:
+		return matches[0].Id, nil
+	}
+
+	return "", ambiguous(prefix, matches)
+}
+
+// maxCandidates is how many matches of an ambiguous prefix are listed
+const maxCandidates = 10
+
+func ambiguous(prefix string, matches []model.Todo) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ambiguous id prefix '%s', it matches:", prefix)
+
+	for i, todo := range matches {
+		if i == maxCandidates {
+			fmt.Fprintf(&b, "\n  and %d more", len(matches)-maxCandidates)
+			break
+		}
+
+		fmt.Fprintf(&b, "\n  %s  %s", todo.Id, todo.Data)
+	}
+
+	return usageError{errors.New(b.String())}
+}
+
+// shortIds maps each id to its shortest prefix matching no other id.
+// Prefixes are extended past digits, not to be read as indexes, and an
+// id that is a prefix of another one is shown whole.
+func shortIds(ids []string) map[string]string {
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	short := make(map[string]string, len(sorted))
+	for i, id := range sorted {
+		n := 0
+		if i > 0 {
+			n = max(n, commonPrefix(id, sorted[i-1]))
+		}
+
+		if i < len(sorted)-1 {
+			n = max(n, commonPrefix(id, sorted[i+1]))
+		}
+
+		n = min(n+1, len(id))
+		for n < len(id) && (isNumber(id[:n]) || !utf8.RuneStart(id[n])) {
+			n++
+		}
+
+		short[id] = id[:n]
+	}
+
+	return short
+}
+
+func commonPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
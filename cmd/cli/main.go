@@ -1,18 +1,25 @@
 package main
 
 import (
-	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/eymyong/todo/client"
 	"github.com/eymyong/todo/model"
 	"github.com/eymyong/todo/repo"
 	"github.com/eymyong/todo/repo/jsonfile"
 	"github.com/eymyong/todo/repo/jsonfilemap"
+	"github.com/eymyong/todo/repo/sqlite"
 	"github.com/eymyong/todo/repo/textfile"
 	"github.com/eymyong/todo/repo/todoredis"
-	"github.com/google/uuid"
+	"github.com/eymyong/todo/repo/todotxt"
 )
 
 /*
@@ -26,36 +33,106 @@ git push origin
 /*
 $Env:REPO = "text"
 $Env:FILENAME = ""
-*/
 
-type Mode string
+Remote mode, against a running cmd/api:
+$Env:REPO = "http"
+$Env:SERVER = "http://localhost:8000"
+$Env:TODO_TOKEN = ""
+or: cli --server http://localhost:8000 list
+*/
 
+// Exit codes, so scripts can tell failures apart without parsing output
 const (
-	ModeAdd          Mode = "--add"
-	ModeGetAll       Mode = "--get-all"
-	ModeGetById      Mode = "--get"
-	ModeGetByStatus  Mode = "--get-status"
-	ModeUpdateData   Mode = "--update"
-	ModeUpdateStatus Mode = "--update-status"
-	ModeRemove       Mode = "--rm"
+	ExitOk       = 0
+	ExitError    = 1
+	ExitUsage    = 2
+	ExitNotFound = 3
+	ExitInvalid  = 4
+	ExitConflict = 5
+	ExitStorage  = 6
 )
 
-type job struct {
-	id     string
-	data   string
-	status model.Status
-	mode   Mode
-}
-
 const JsonFile = "json"
 const JsonMap = "jsonmap"
 const TextFile = "text"
 const Redis = "redis"
+const Sqlite = "sqlite"
+const TodoTxt = "todotxt"
+const Http = "http"
+
+const defaultServer = "http://localhost:8000"
+
+// remoteTimeout bounds every call to the server in remote mode
+const remoteTimeout = 30 * time.Second
+
+// readWorkflow reads the workflow json file named by WORKFLOW.
+// Without it the repository uses model.DefaultWorkflow.
+func readWorkflow() (model.Workflow, error) {
+	envWorkflow := os.Getenv("WORKFLOW")
+	if envWorkflow == "" {
+		return model.Workflow{}, nil
+	}
+
+	return model.ReadWorkflow(envWorkflow)
+}
+
+// serverFlag removes "--server URL" or "--server=URL" from args.
+// A server given there switches the cli to remote mode.
+func serverFlag(args []string) ([]string, string, error) {
+	rest := make([]string, 0, len(args))
+	server := ""
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if value, ok := strings.CutPrefix(arg, "--server="); ok {
+			server = value
+			continue
+		}
 
-func initRepo() repo.Repository {
+		if arg == "--server" {
+			if i+1 == len(args) {
+				return nil, "", usageError{errors.New("--server needs a url")}
+			}
+
+			server = args[i+1]
+			i++
+			continue
+		}
+
+		rest = append(rest, arg)
+	}
+
+	return rest, server, nil
+}
+
+// initRemote returns a client of the api server at url, authenticated with
+// TODO_TOKEN when set. The workflow is the server's, WORKFLOW is ignored.
+func initRemote(url string) repo.Repository {
+	if url == "" {
+		url = defaultServer
+	}
+
+	return client.New(url,
+		client.WithToken(os.Getenv("TODO_TOKEN")),
+		client.WithHTTPClient(&http.Client{Timeout: remoteTimeout}),
+	)
+}
+
+func initRepo(server string, wf model.Workflow) repo.Repository {
 	envRepo := os.Getenv("REPO")
 	envFile := os.Getenv("FILENAME")
 
+	if server != "" {
+		return initRemote(server)
+	}
+
+	if envRepo == Http {
+		return initRemote(os.Getenv("SERVER"))
+	}
+
+	opts := []repo.Option{repo.WithWorkflow(wf)}
+
 	var repo repo.Repository
 
 	switch envRepo {
@@ -64,277 +141,258 @@ func initRepo() repo.Repository {
 			envFile = "todo.map.json"
 		}
 
-		repo = jsonfilemap.New(envFile)
+		repo = jsonfilemap.New(envFile, opts...)
 
 	case TextFile:
 		if envFile == "" {
 			envFile = "todo.text"
 		}
 
-		repo = textfile.New(envFile)
+		repo = textfile.New(envFile, opts...)
 
 	case Redis:
-		repo = todoredis.New("127.0.0.1:6379")
+		repo = todoredis.New("127.0.0.1:6379", opts...)
+
+	case Sqlite:
+		if envFile == "" {
+			envFile = "todo.db"
+		}
+
+		repo = sqlite.New(envFile, opts...)
+
+	case TodoTxt:
+		if envFile == "" {
+			envFile = "todo.txt"
+		}
+
+		repo = todotxt.New(envFile, opts...)
 
 	default:
 		if envFile == "" {
 			envFile = "todo.json"
 		}
 
-		repo = jsonfile.New(envFile)
+		repo = jsonfile.New(envFile, opts...)
 	}
 
 	return repo
 }
 
-func main() {
-	args := os.Args
-	job, err := parse(args)
-	if err != nil {
-		panic(err)
+// repoKey names the repository initRepo opens, to keep list indexes apart
+func repoKey(server string) string {
+	if server != "" {
+		return Http + " " + server
 	}
 
-	repo := initRepo()
+	envRepo := os.Getenv("REPO")
+	if envRepo == Http {
+		return Http + " " + os.Getenv("SERVER")
+	}
 
-	switch job.mode {
-	case ModeAdd:
-		err = methodAdd(repo, job.data)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		fmt.Println("Succeed")
-		return
-	case ModeGetAll:
-		todoList, err := methodGetAll(repo)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
+	// file names are relative to the working directory
+	file, err := filepath.Abs(os.Getenv("FILENAME"))
+	if err != nil {
+		file = os.Getenv("FILENAME")
+	}
 
-		if len(todoList) == 0 {
-			fmt.Println("No data")
-			return
-		}
+	return envRepo + " " + file
+}
 
-		for _, todo := range todoList {
-			fmt.Println(todo)
+// openRepo is initRepo reporting the panics of the backend constructors,
+// e.g. on an unreadable file, as storage errors
+func openRepo(server string, wf model.Workflow) (r repo.Repository, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%w: cannot open repository: %v", repo.ErrStorage, p)
 		}
+	}()
 
-	case ModeGetById:
-		data, err := methodGetById(repo, job.id)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
+	return initRepo(server, wf), nil
+}
 
-		fmt.Printf("Get to ID: %s\nData: %s\nStatus: %s", data.Id, data.Data, data.Status)
-		return
+func main() {
+	wf, err := readWorkflow()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitError)
+	}
 
-	case ModeGetByStatus:
-		todos, err := methodGetByStatus(repo, job.status)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
+	a := &app{
+		prog:   filepath.Base(os.Args[0]),
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		tty:    isTerminal(os.Stdout),
+		wf:     wf,
+		open:   openRepo,
 
-		if len(todos) == 0 {
-			fmt.Printf("Not found data to staus: %s", job.status)
-			return
-		}
+		interactive: isTerminal(os.Stdin) && isTerminal(os.Stdout),
 
-		fmt.Printf("Status: `%s`\n", job.status)
-		for _, todo := range todos {
-			fmt.Println(todo)
-		}
-
-		return
+		repoKey:   repoKey,
+		statePath: statePath(),
+		editor:    runEditor,
+	}
 
-	case ModeUpdateData:
-		old, err := methodUpdateData(repo, job.id, job.data)
-		if err != nil {
-			fmt.Println(err)
-			return
+	os.Exit(a.run(os.Args[1:]))
+}
 
-		}
+// isTerminal reports whether f is a character device, like a terminal
+// and unlike a file or a pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
 
-		new := model.Todo{
-			Id:   job.id,
-			Data: job.data,
-		}
-		fmt.Println("Succeed")
-		fmt.Printf("Old todo ID: %s, data: %s\n", old.Id, old.Data)
-		fmt.Printf("New todo ID: %s, data: %s\n", new.Id, new.Data)
-		return
-
-	case ModeUpdateStatus:
-		old, err := methodUpdateStatus(repo, job.id, job.status)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
+	return info.Mode()&os.ModeCharDevice != 0
+}
 
-		new := model.Todo{
-			Id:     job.id,
-			Status: job.status,
-		}
-		fmt.Println("Succeed")
-		fmt.Printf("Old status ID: %s, status: %s\n", old.Id, old.Status)
-		fmt.Printf("New status ID: %s, status: %s\n", new.Id, new.Status)
-		return
-
-	case ModeRemove:
-		data, err := methodRemove(repo, job.id)
-		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		fmt.Println("Succeed")
-		fmt.Printf("Remove to ID: %s\ntodo: %s", data.Id, data)
-		return
+// usageError is a mistake in the command line, as opposed to a failure
+// of the repository
+type usageError struct {
+	err error
+}
 
-	default:
-		fmt.Println("Incorrect Mode")
-		return
-	}
+func (e usageError) Error() string {
+	return e.err.Error()
+}
 
+func (e usageError) Unwrap() error {
+	return e.err
 }
 
-func parse(args []string) (job, error) {
-	if len(args) == 1 {
-		return job{mode: ModeGetAll}, nil
+func exitCode(err error) int {
+	var usage usageError
+
+	switch {
+	case err == nil:
+		return ExitOk
+	case errors.As(err, &usage):
+		return ExitUsage
+	case errors.Is(err, repo.ErrNotFound):
+		return ExitNotFound
+	case errors.Is(err, repo.ErrInvalidStatus), errors.Is(err, repo.ErrInvalidTodo), errors.Is(err, repo.ErrInvalidQuery):
+		return ExitInvalid
+	case errors.Is(err, repo.ErrConflict), errors.Is(err, repo.ErrInvalidTransition), errors.Is(err, repo.ErrVersionMismatch):
+		return ExitConflict
+	case errors.Is(err, repo.ErrStorage):
+		return ExitStorage
 	}
 
-	if len(args) == 2 {
-		if args[1] == "--add" {
-			return job{}, errors.New("there is no information to add")
-		}
+	return ExitError
+}
 
-		if args[1] == "--get" {
-			return job{}, errors.New("there is no information to get")
-		}
+// fail prints err to stderr, with a hint for the errors of remote mode,
+// and returns the exit code mapped from err
+func (a *app) fail(err error) int {
+	fmt.Fprintln(a.stderr, err)
 
-		if args[1] == "--get-status" {
-			return job{}, errors.New("there is no information to get-status")
-		}
+	switch {
+	case errors.Is(err, client.ErrUnreachable):
+		fmt.Fprintln(a.stderr, "is the api server running? set its url with SERVER or --server")
+	case errors.Is(err, client.ErrUnauthorized):
+		fmt.Fprintln(a.stderr, "set TODO_TOKEN to the token the api server was started with")
+	}
 
-		if args[1] == "--update" {
-			return job{}, errors.New("there is no information to update")
-		}
+	return exitCode(err)
+}
 
-		if args[1] == "--rm" {
-			return job{}, errors.New("there is no information to rm")
+func splitList(s string) []string {
+	var result []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			result = append(result, v)
 		}
 	}
 
-	if len(args) == 3 {
-		if args[1] == "--add" {
-			return job{mode: ModeAdd, data: args[2]}, nil
-		}
-		if args[1] == "--get" {
-			return job{mode: ModeGetById, id: args[2]}, nil
-		}
-
-		if args[1] == "--get-status" {
-			return job{mode: ModeGetByStatus, status: model.Status(args[2])}, nil
-		}
+	return result
+}
 
-		if args[1] == "--update" {
-			return job{}, errors.New("Not data to update-data")
-		}
+// parseDue accepts a date (2006-01-02) or a RFC3339 time, see model.ParseTime
+func parseDue(s string) (time.Time, error) {
+	t, err := model.ParseTime(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: bad due date '%s', expecting 2006-01-02 or RFC3339", repo.ErrInvalidTodo, s)
+	}
 
-		if args[1] == "--update-status" {
-			return job{}, errors.New("Not data to update-status")
-		}
+	return t, nil
+}
 
-		if args[1] == "--rm" {
-			return job{mode: ModeRemove, id: args[2]}, nil
+// parseAge accepts a Go duration (36h, 90m) or a number of days (30d)
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n >= 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
 		}
+	}
 
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%w: bad age '%s', expecting a duration like 30d or 12h", repo.ErrInvalidQuery, s)
 	}
 
-	if len(args) == 4 {
-		if args[1] == "--update" {
-			return job{mode: ModeUpdateData, id: args[2], data: args[3]}, nil
-		}
+	return d, nil
+}
 
-		if args[1] == "--update-status" {
-			return job{mode: ModeUpdateStatus, id: args[2], status: model.Status(args[3])}, nil
-		}
+// formatDueIn shows a due date as a date, and a due time in local time
+// with layout
+func formatDueIn(due time.Time, layout string) string {
+	if model.IsDate(due) {
+		return due.UTC().Format(time.DateOnly)
 	}
 
-	return job{}, errors.New("input incorrect")
-
+	return due.Local().Format(layout)
 }
 
-func methodAdd(r repo.Repository, data string) error {
-	ctx := context.Background()
-	err := r.Add(ctx, model.Todo{
-		Id:     uuid.NewString(),
-		Data:   data,
-		Status: "TODO",
-	})
-	if err != nil {
-		return err
+// todoLine formats todo on one line for listings
+func todoLine(todo model.Todo) string {
+	line := fmt.Sprintf("%s: %s [%s]", todo.Id, todo.Data, todo.Status)
+
+	if todo.Priority != model.PriorityNone {
+		line += fmt.Sprintf(" (priority %d)", todo.Priority)
 	}
-	return nil
-}
 
-func methodGetAll(r repo.Repository) ([]model.Todo, error) {
-	ctx := context.Background()
-	todoList, err := r.GetAll(ctx)
-	if err != nil {
-		return nil, err
+	if todo.DueAt != nil {
+		line += " due " + formatDueIn(*todo.DueAt, time.DateTime)
 	}
-	return todoList, nil
-}
 
-func methodGetById(r repo.Repository, id string) (model.Todo, error) {
-	ctx := context.Background()
-	todo, err := r.Get(ctx, id)
-	if err != nil {
-		return model.Todo{}, err
+	if len(todo.Tags) != 0 {
+		line += " #" + strings.Join(todo.Tags, " #")
 	}
 
-	return todo, nil
+	return line
 }
 
-func methodGetByStatus(r repo.Repository, status model.Status) ([]model.Todo, error) {
-	ctx := context.Background()
-	todo, err := r.GetByStatus(ctx, status)
-	if err != nil {
-		return []model.Todo{}, err
-	}
+func printTodo(w io.Writer, todo model.Todo) {
+	fmt.Fprintf(w, "ID: %s\nData: %s\nStatus: %s\n", todo.Id, todo.Data, todo.Status)
 
-	return todo, nil
-}
+	if todo.Priority != model.PriorityNone {
+		fmt.Fprintf(w, "Priority: %d\n", todo.Priority)
+	}
 
-func methodUpdateData(r repo.Repository, id string, newdata string) (model.Todo, error) {
-	ctx := context.Background()
-	todo, err := r.UpdateData(ctx, id, newdata)
-	if err != nil {
-		return model.Todo{}, err
+	if todo.DueAt != nil {
+		fmt.Fprintf(w, "Due: %s\n", formatDueIn(*todo.DueAt, time.DateTime))
 	}
 
-	return todo, nil
-}
+	if len(todo.Tags) != 0 {
+		fmt.Fprintf(w, "Tags: %s\n", strings.Join(todo.Tags, ", "))
+	}
 
-func methodUpdateStatus(r repo.Repository, id string, status model.Status) (model.Todo, error) {
-	ctx := context.Background()
-	todo, err := r.UpdateStatus(ctx, id, status)
-	if err != nil {
-		return model.Todo{}, err
+	if todo.Notes != "" {
+		fmt.Fprintf(w, "Notes: %s\n", todo.Notes)
 	}
 
-	return todo, nil
-}
+	if !todo.CreatedAt.IsZero() {
+		fmt.Fprintf(w, "Created: %s\n", todo.CreatedAt.Local().Format(time.DateTime))
+		fmt.Fprintf(w, "Updated: %s\n", todo.UpdatedAt.Local().Format(time.DateTime))
+	}
 
-func methodRemove(r repo.Repository, id string) (model.Todo, error) {
-	ctx := context.Background()
-	todo, err := r.Remove(ctx, id)
-	if err != nil {
-		return model.Todo{}, err
+	if todo.CompletedAt != nil {
+		fmt.Fprintf(w, "Completed: %s\n", todo.CompletedAt.Local().Format(time.DateTime))
 	}
 
-	return todo, nil
+	if todo.DeletedAt != nil {
+		fmt.Fprintf(w, "Removed: %s\n", todo.DeletedAt.Local().Format(time.DateTime))
+	}
 }
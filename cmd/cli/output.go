@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eymyong/todo/model"
+)
+
+// Output formats of --output
+const (
+	FormatTable    = "table"
+	FormatJson     = "json"
+	FormatJsonl    = "jsonl"
+	FormatCsv      = "csv"
+	FormatYaml     = "yaml"
+	FormatTemplate = "template"
+)
+
+var formats = []string{FormatTable, FormatJson, FormatJsonl, FormatCsv, FormatYaml, FormatTemplate}
+
+// Values of --color
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+var colorModes = []string{ColorAuto, ColorAlways, ColorNever}
+
+// output holds the flags choosing how a command prints todos.
+// An empty format is the command's own human-readable output.
+type output struct {
+	format *string
+	text   *string
+	color  *string
+
+	tmpl *template.Template
+
+	// short maps ids to the prefixes shown in tables, and numbered adds
+	// the # column of list indexes
+	short    map[string]string
+	numbered bool
+}
+
+// defineOutput defines the output flags with the default format, and human
+// describing the command's own output when that is empty
+func defineOutput(fs *flag.FlagSet, format string, human string) *output {
+	o := &output{
+		format: new(string),
+		text:   fs.String("template", "", "Go text/template printed for each todo, implies --output template"),
+		color:  fs.String("color", ColorAuto, "color tables: auto, always or never"),
+	}
+
+	usage := "output format: " + strings.Join(formats, ", ")
+	if format == "" {
+		usage += ", " + human + " by default"
+	}
+
+	fs.StringVar(o.format, "output", format, usage)
+	fs.StringVar(o.format, "o", format, "shorthand for --output")
+
+	return o
+}
+
+// prepare checks the flags before the command changes anything
+func (o *output) prepare() error {
+	if *o.text != "" && *o.format != FormatTemplate {
+		if *o.format != "" && *o.format != FormatTable {
+			return usageError{fmt.Errorf("--template needs --output %s, not %s", FormatTemplate, *o.format)}
+		}
+
+		*o.format = FormatTemplate
+	}
+
+	switch *o.format {
+	case "", FormatTable, FormatJson, FormatJsonl, FormatCsv, FormatYaml:
+	case FormatTemplate:
+		if *o.text == "" {
+			return usageError{errors.New("--output template needs --template")}
+		}
+
+		tmpl, err := template.New("todo").Funcs(templateFuncs).Parse(*o.text)
+		if err != nil {
+			return usageError{err}
+		}
+
+		o.tmpl = tmpl
+
+	default:
+		return usageError{fmt.Errorf("unknown output format '%s', expecting %s", *o.format, strings.Join(formats, ", "))}
+	}
+
+	switch *o.color {
+	case ColorAuto, ColorAlways, ColorNever:
+	default:
+		return usageError{fmt.Errorf("unknown color mode '%s', expecting %s", *o.color, strings.Join(colorModes, ", "))}
+	}
+
+	return nil
+}
+
+// human reports whether the command prints its own output
+func (o *output) human() bool {
+	return *o.format == ""
+}
+
+// machine reports whether the output is meant for other programs,
+// so that hints go to stderr
+func (o *output) machine() bool {
+	return *o.format != "" && *o.format != FormatTable
+}
+
+func (o *output) colored(a *app) bool {
+	switch *o.color {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+
+	return a.tty && os.Getenv("NO_COLOR") == ""
+}
+
+func (o *output) write(a *app, todos []model.Todo) error {
+	w := a.stdout
+
+	switch *o.format {
+	case FormatJson:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(todos)
+
+	case FormatJsonl:
+		enc := json.NewEncoder(w)
+		for _, todo := range todos {
+			err := enc.Encode(todo)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case FormatCsv:
+		return writeCsv(w, todos)
+
+	case FormatYaml:
+		writeYaml(w, todos)
+		return nil
+
+	case FormatTemplate:
+		for _, todo := range todos {
+			err := o.tmpl.Execute(w, todo)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(w)
+		}
+
+		return nil
+	}
+
+	writeTable(w, todos, table{color: o.colored(a), short: o.short, numbered: o.numbered})
+	return nil
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"date": func(layout string, t interface{}) string {
+		switch t := t.(type) {
+		case time.Time:
+			return t.Local().Format(layout)
+		case *time.Time:
+			if t != nil {
+				return t.Local().Format(layout)
+			}
+		}
+
+		return ""
+	},
+}
+
+var csvHeader = []string{"id", "data", "status", "priority", "due_at", "tags", "notes", "created_at", "updated_at", "completed_at", "version", "deleted_at"}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
+
+func writeCsv(w io.Writer, todos []model.Todo) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+
+	for _, todo := range todos {
+		priority := ""
+		if todo.Priority != model.PriorityNone {
+			priority = strconv.Itoa(int(todo.Priority))
+		}
+
+		cw.Write([]string{
+			todo.Id,
+			todo.Data,
+			string(todo.Status),
+			priority,
+			formatTime(todo.DueAt),
+			strings.Join(todo.Tags, ","),
+			todo.Notes,
+			formatTime(&todo.CreatedAt),
+			formatTime(&todo.UpdatedAt),
+			formatTime(todo.CompletedAt),
+			strconv.FormatInt(todo.Version, 10),
+			formatTime(todo.DeletedAt),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeYaml writes todos as a yaml sequence. Strings are double-quoted,
+// Go escapes being valid in yaml double-quoted scalars.
+func writeYaml(w io.Writer, todos []model.Todo) {
+	if len(todos) == 0 {
+		fmt.Fprintln(w, "[]")
+		return
+	}
+
+	for _, todo := range todos {
+		fmt.Fprintf(w, "- id: %s\n", strconv.Quote(todo.Id))
+		fmt.Fprintf(w, "  data: %s\n", strconv.Quote(todo.Data))
+		fmt.Fprintf(w, "  status: %s\n", strconv.Quote(string(todo.Status)))
+		fmt.Fprintf(w, "  created_at: %s\n", strconv.Quote(formatTime(&todo.CreatedAt)))
+		fmt.Fprintf(w, "  updated_at: %s\n", strconv.Quote(formatTime(&todo.UpdatedAt)))
+
+		if todo.CompletedAt != nil {
+			fmt.Fprintf(w, "  completed_at: %s\n", strconv.Quote(formatTime(todo.CompletedAt)))
+		}
+
+		fmt.Fprintf(w, "  version: %d\n", todo.Version)
+
+		if todo.DeletedAt != nil {
+			fmt.Fprintf(w, "  deleted_at: %s\n", strconv.Quote(formatTime(todo.DeletedAt)))
+		}
+
+		if todo.DueAt != nil {
+			fmt.Fprintf(w, "  due_at: %s\n", strconv.Quote(formatTime(todo.DueAt)))
+		}
+
+		if todo.Priority != model.PriorityNone {
+			fmt.Fprintf(w, "  priority: %d\n", todo.Priority)
+		}
+
+		if len(todo.Tags) != 0 {
+			fmt.Fprintln(w, "  tags:")
+			for _, tag := range todo.Tags {
+				fmt.Fprintf(w, "    - %s\n", strconv.Quote(tag))
+			}
+		}
+
+		if todo.Notes != "" {
+			fmt.Fprintf(w, "  notes: %s\n", strconv.Quote(todo.Notes))
+		}
+	}
+}
+
+// ANSI escapes of the colored table
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiDim    = "\x1b[2m"
+)
+
+type cell struct {
+	text  string
+	color string
+}
+
+type table struct {
+	color    bool
+	short    map[string]string
+	numbered bool
+}
+
+// writeTable aligns todos in columns. The widths are computed on the
+// plain text, so colors do not shift the columns.
+func writeTable(w io.Writer, todos []model.Todo, t table) {
+	header := []string{"ID", "STATUS", "PRI", "DUE", "TAGS", "DATA"}
+	if t.numbered {
+		header = append([]string{"#"}, header...)
+	}
+
+	rows := make([][]cell, 0, len(todos)+1)
+
+	row := make([]cell, len(header))
+	for i, h := range header {
+		row[i] = cell{h, ansiBold}
+	}
+	rows = append(rows, row)
+
+	now := time.Now()
+	for _, todo := range todos {
+		done := todo.CompletedAt != nil
+
+		status := cell{string(todo.Status), ansiYellow}
+		if done {
+			status.color = ansiGreen
+		}
+
+		priority := cell{}
+		if todo.Priority != model.PriorityNone {
+			priority.text = strconv.Itoa(int(todo.Priority))
+			if todo.Priority == model.PriorityHigh {
+				priority.color = ansiRed
+			}
+		}
+
+		due := cell{}
+		if todo.DueAt != nil {
+			due.text = formatDueIn(*todo.DueAt, "2006-01-02 15:04")
+			if !done && todo.DueAt.Before(now) {
+				due.color = ansiRed
+			}
+		}
+
+		data := cell{text: strings.Join(strings.Fields(todo.Data), " ")}
+		if done {
+			data.color = ansiDim
+		}
+
+		id := todo.Id
+		if short, ok := t.short[id]; ok {
+			id = short
+		}
+
+		row := []cell{
+			{text: id},
+			status,
+			priority,
+			due,
+			{text: strings.Join(todo.Tags, ",")},
+			data,
+		}
+
+		if t.numbered {
+			row = append([]cell{{text: strconv.Itoa(len(rows))}}, row...)
+		}
+
+		rows = append(rows, row)
+	}
+
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for i, c := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(c.text))
+		}
+	}
+
+	for _, row := range rows {
+		var line strings.Builder
+		for i, c := range row {
+			text := c.text
+			if t.color && c.color != "" && text != "" {
+				text = c.color + text + ansiReset
+			}
+
+			line.WriteString(text)
+
+			// the last column is not padded, to keep no trailing spaces
+			if i != len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c.text)+2))
+			}
+		}
+
+		fmt.Fprintln(w, strings.TrimRight(line.String(), " "))
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoTerminal = errors.New("the tui is not supported on this platform")
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errNoTerminal
+}
+
+func termSize(fd int) (int, int, error) {
+	return 0, 0, errNoTerminal
+}
+
+func resized() <-chan os.Signal {
+	return nil
+}
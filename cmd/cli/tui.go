@@ -0,0 +1,725 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+)
+
+// Escapes driving the terminal
+const (
+	escEnterScreen = "\x1b[?1049h\x1b[?25l"
+	escLeaveScreen = "\x1b[?25h\x1b[?1049l"
+	escHome        = "\x1b[H"
+	escClearLine   = "\x1b[K"
+	escClearBelow  = "\x1b[J"
+	escReverse     = "\x1b[7m"
+)
+
+// key is a key press, either a printable rune or a named key
+type key struct {
+	r    rune
+	name string
+}
+
+// Names of the keys that are not printable
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyPageUp    = "pgup"
+	keyPageDown  = "pgdn"
+	keyHome      = "home"
+	keyEnd       = "end"
+	keyEnter     = "enter"
+	keyEsc       = "esc"
+	keyBackspace = "backspace"
+	keyCtrlC     = "ctrl-c"
+	keyCtrlU     = "ctrl-u"
+)
+
+// parseKeys splits what the terminal sent for one or more key presses
+func parseKeys(b []byte) []key {
+	var keys []key
+
+	for len(b) != 0 {
+		c := b[0]
+
+		switch {
+		case c == 0x1b && len(b) == 1:
+			keys = append(keys, key{name: keyEsc})
+			b = b[1:]
+
+		case c == 0x1b && (b[1] == '[' || b[1] == 'O'):
+			// a CSI or SS3 sequence, ending with a byte in 0x40-0x7e
+			end := 2
+			for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+				end++
+			}
+
+			if end == len(b) {
+				end--
+			}
+
+			switch string(b[2 : end+1]) {
+			case "A":
+				keys = append(keys, key{name: keyUp})
+			case "B":
+				keys = append(keys, key{name: keyDown})
+			case "H", "1~", "7~":
+				keys = append(keys, key{name: keyHome})
+			case "F", "4~", "8~":
+				keys = append(keys, key{name: keyEnd})
+			case "5~":
+				keys = append(keys, key{name: keyPageUp})
+			case "6~":
+				keys = append(keys, key{name: keyPageDown})
+			}
+
+			b = b[end+1:]
+
+		case c == 0x1b:
+			keys = append(keys, key{name: keyEsc})
+			b = b[1:]
+
+		case c == '\r' || c == '\n':
+			keys = append(keys, key{name: keyEnter})
+			b = b[1:]
+
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, key{name: keyBackspace})
+			b = b[1:]
+
+		case c == 0x03:
+			keys = append(keys, key{name: keyCtrlC})
+			b = b[1:]
+
+		case c == 0x15:
+			keys = append(keys, key{name: keyCtrlU})
+			b = b[1:]
+
+		case c < 0x20:
+			b = b[1:]
+
+		default:
+			r, size := utf8.DecodeRune(b)
+			keys = append(keys, key{r: r})
+			b = b[size:]
+		}
+	}
+
+	return keys
+}
+
+// prompt reads a line at the bottom of the screen
+type prompt struct {
+	label string
+	input []rune
+
+	// done is called with the line on enter
+	done func(ctx context.Context, line string) error
+}
+
+// confirmation asks a yes or no question
+type confirmation struct {
+	question string
+	yes      func(ctx context.Context) error
+}
+
+// tui is the state of the interactive mode. It knows nothing of the
+// terminal: keys come in through handle, and draw returns the frame.
+type tui struct {
+	r  repo.Repository
+	wf model.Workflow
+
+	todos  []model.Todo
+	cursor int
+	// offset is the first todo shown in the list pane
+	offset int
+
+	// status is the index of the status filter in the workflow's statuses,
+	// -1 for every status
+	status int
+	text   string
+
+	message string
+	failed  bool
+
+	prompt  *prompt
+	confirm *confirmation
+}
+
+func newTUI(r repo.Repository, wf model.Workflow) *tui {
+	return &tui{r: r, wf: wf, status: -1}
+}
+
+func (t *tui) filter() repo.Filter {
+	filter := repo.Filter{Text: t.text}
+	if t.status >= 0 {
+		filter.Statuses = []model.Status{t.wf.AllStatuses()[t.status]}
+	}
+
+	return filter
+}
+
+func (t *tui) selected() (model.Todo, bool) {
+	if t.cursor < 0 || t.cursor >= len(t.todos) {
+		return model.Todo{}, false
+	}
+
+	return t.todos[t.cursor], true
+}
+
+// reload queries the todos again, keeping the todo with id selected if
+// it is still listed. It is also how changes by others show up.
+func (t *tui) reload(ctx context.Context, id string) {
+	page, err := t.r.Query(ctx, t.filter())
+	if err != nil {
+		t.fail(err)
+		return
+	}
+
+	t.todos = page.Todos
+
+	for i := range t.todos {
+		if t.todos[i].Id == id {
+			t.cursor = i
+			return
+		}
+	}
+
+	t.cursor = min(t.cursor, len(t.todos)-1)
+	t.cursor = max(t.cursor, 0)
+}
+
+// refresh reloads keeping the current selection
+func (t *tui) refresh(ctx context.Context) {
+	todo, _ := t.selected()
+	t.reload(ctx, todo.Id)
+}
+
+// stale explains a write refused because todo changed since it was shown,
+// and shows it as it is now
+func (t *tui) stale(ctx context.Context, todo model.Todo, err error) error {
+	if !errors.Is(err, repo.ErrVersionMismatch) {
+		return err
+	}
+
+	t.reload(ctx, todo.Id)
+	return fmt.Errorf("'%s' was changed elsewhere meanwhile, try again", todo.Data)
+}
+
+func (t *tui) fail(err error) {
+	t.message = err.Error()
+	t.failed = true
+}
+
+func (t *tui) inform(format string, args ...interface{}) {
+	t.message = fmt.Sprintf(format, args...)
+	t.failed = false
+}
+
+// handle applies a key, reporting false once the tui should quit
+func (t *tui) handle(ctx context.Context, k key) bool {
+	if k.name == keyCtrlC {
+		return false
+	}
+
+	if t.confirm != nil {
+		c := t.confirm
+		t.confirm = nil
+
+		if k.r == 'y' || k.r == 'Y' {
+			err := c.yes(ctx)
+			if err != nil {
+				t.fail(err)
+			}
+		} else {
+			t.inform("Canceled")
+		}
+
+		return true
+	}
+
+	if t.prompt != nil {
+		t.edit(ctx, k)
+		return true
+	}
+
+	todo, ok := t.selected()
+
+	switch {
+	case k.r == 'q':
+		return false
+
+	case k.name == keyUp || k.r == 'k':
+		t.move(-1)
+	case k.name == keyDown || k.r == 'j':
+		t.move(1)
+	case k.name == keyPageUp:
+		t.move(-10)
+	case k.name == keyPageDown:
+		t.move(10)
+	case k.name == keyHome || k.r == 'g':
+		t.move(-len(t.todos))
+	case k.name == keyEnd || k.r == 'G':
+		t.move(len(t.todos))
+
+	case k.r == 'a':
+		t.prompt = &prompt{label: "Add: ", done: t.add}
+
+	case (k.r == 'e' || k.name == keyEnter) && ok:
+		t.prompt = &prompt{label: "Edit: ", input: []rune(todo.Data), done: func(ctx context.Context, data string) error {
+			_, err := t.r.UpdateData(ctx, todo.Id, data, todo.Version)
+			if err != nil {
+				return t.stale(ctx, todo, err)
+			}
+
+			t.inform("Updated %s", data)
+			t.reload(ctx, todo.Id)
+			return nil
+		}}
+
+	case (k.r == ' ' || k.r == 'x') && ok:
+		t.toggle(ctx, todo)
+
+	case k.r == 'd' && ok:
+		t.confirm = &confirmation{
+			question: fmt.Sprintf("Move '%s' to the trash? (y/n)", todo.Data),
+			yes: func(ctx context.Context) error {
+				_, err := t.r.Remove(ctx, todo.Id, todo.Version)
+				if err != nil {
+					return t.stale(ctx, todo, err)
+				}
+
+				t.inform("Moved %s to the trash", todo.Data)
+				t.refresh(ctx)
+				return nil
+			},
+		}
+
+	case k.r == 's':
+		t.status++
+		if t.status == len(t.wf.AllStatuses()) {
+			t.status = -1
+		}
+
+		t.refresh(ctx)
+
+	case k.r == '/':
+		t.prompt = &prompt{label: "Search: ", input: []rune(t.text), done: func(ctx context.Context, text string) error {
+			t.text = text
+			t.refresh(ctx)
+			return nil
+		}}
+
+	case k.name == keyEsc:
+		t.status = -1
+		t.text = ""
+		t.refresh(ctx)
+
+	case k.r == 'r':
+		t.refresh(ctx)
+		t.inform("Refreshed")
+	}
+
+	return true
+}
+
+// edit applies a key to the prompt
+func (t *tui) edit(ctx context.Context, k key) {
+	p := t.prompt
+
+	switch {
+	case k.name == keyEsc:
+		t.prompt = nil
+
+	case k.name == keyEnter:
+		t.prompt = nil
+		err := p.done(ctx, string(p.input))
+		if err != nil {
+			t.fail(err)
+		}
+
+	case k.name == keyBackspace:
+		if len(p.input) != 0 {
+			p.input = p.input[:len(p.input)-1]
+		}
+
+	case k.name == keyCtrlU:
+		p.input = nil
+
+	case k.r != 0:
+		p.input = append(p.input, k.r)
+	}
+}
+
+func (t *tui) move(n int) {
+	t.cursor = max(0, min(t.cursor+n, len(t.todos)-1))
+}
+
+func (t *tui) add(ctx context.Context, data string) error {
+	if strings.TrimSpace(data) == "" {
+		return errors.New("nothing to add")
+	}
+
+	id := uuid.NewString()
+	err := t.r.Add(ctx, model.Todo{Id: id, Data: data})
+	if err != nil {
+		return err
+	}
+
+	t.inform("Added %s", data)
+	t.reload(ctx, id)
+	return nil
+}
+
+// toggle completes the todo, or reopens it when completed
+func (t *tui) toggle(ctx context.Context, todo model.Todo) {
+	status := t.wf.DoneStatus()
+	if t.wf.IsCompleted(todo.Status) {
+		status = t.wf.InitialStatus()
+	}
+
+	_, err := t.r.UpdateStatus(ctx, todo.Id, status, todo.Version)
+	if err != nil {
+		t.fail(t.stale(ctx, todo, err))
+		return
+	}
+
+	t.inform("%s %s", status, todo.Data)
+	t.reload(ctx, todo.Id)
+}
+
+const tuiHelp = "j/k move  a add  e edit  space done  d delete  s status  / search  q quit"
+
+// draw renders the screen of width columns and height rows
+func (t *tui) draw(width int, height int) string {
+	width = max(width, 20)
+	height = max(height, 5)
+
+	var b strings.Builder
+	b.WriteString(escHome)
+
+	line := func(s string) {
+		b.WriteString(fit(s, width))
+		b.WriteString(escClearLine)
+		b.WriteString("\r\n")
+	}
+
+	header := fmt.Sprintf(" todo  %d todos", len(t.todos))
+	if t.status >= 0 {
+		header += "  status: " + string(t.wf.AllStatuses()[t.status])
+	}
+
+	if t.text != "" {
+		header += fmt.Sprintf("  search: %q", t.text)
+	}
+
+	line(escReverse + pad(header, width) + ansiReset)
+
+	rows := height - 3
+	listWidth := width
+	if width >= 60 {
+		listWidth = width * 3 / 5
+	}
+
+	// keep the cursor in view
+	if t.cursor < t.offset {
+		t.offset = t.cursor
+	}
+
+	if t.cursor >= t.offset+rows {
+		t.offset = t.cursor - rows + 1
+	}
+
+	list := t.listLines(listWidth, rows)
+	detail := t.detailLines(width-listWidth-3, rows)
+
+	for i := 0; i < rows; i++ {
+		row := list[i]
+		if listWidth < width {
+			row = pad(row, listWidth) + " │ " + detail[i]
+		}
+
+		line(row)
+	}
+
+	message := t.message
+	if t.failed {
+		message = ansiRed + message + ansiReset
+	}
+
+	line(message)
+
+	switch {
+	case t.confirm != nil:
+		b.WriteString(fit(t.confirm.question, width))
+	case t.prompt != nil:
+		b.WriteString(fit(t.prompt.label+string(t.prompt.input)+"█", width))
+	default:
+		b.WriteString(fit(ansiDim+tuiHelp+ansiReset, width))
+	}
+
+	b.WriteString(escClearBelow)
+	return b.String()
+}
+
+func (t *tui) listLines(width int, rows int) []string {
+	lines := make([]string, rows)
+
+	if len(t.todos) == 0 {
+		lines[0] = " No todos"
+		return lines
+	}
+
+	ids := make([]string, len(t.todos))
+	idWidth := 0
+	statusWidth := 0
+	for i := range t.todos {
+		ids[i] = t.todos[i].Id
+		statusWidth = max(statusWidth, utf8.RuneCountInString(string(t.todos[i].Status)))
+	}
+
+	short := shortIds(ids)
+	for _, s := range short {
+		idWidth = max(idWidth, utf8.RuneCountInString(s))
+	}
+
+	for i := 0; i < rows && t.offset+i < len(t.todos); i++ {
+		todo := t.todos[t.offset+i]
+
+		marker := "  "
+		if t.offset+i == t.cursor {
+			marker = "> "
+		}
+
+		text := marker + pad(short[todo.Id], idWidth) + "  " + pad(string(todo.Status), statusWidth) + "  " + strings.Join(strings.Fields(todo.Data), " ")
+		if t.offset+i == t.cursor {
+			text = escReverse + pad(text, width) + ansiReset
+		} else if t.wf.IsCompleted(todo.Status) {
+			text = ansiDim + fit(text, width) + ansiReset
+		}
+
+		lines[i] = text
+	}
+
+	return lines
+}
+
+func (t *tui) detailLines(width int, rows int) []string {
+	lines := make([]string, rows)
+
+	todo, ok := t.selected()
+	if !ok || width <= 0 {
+		return lines
+	}
+
+	var buf bytes.Buffer
+	printTodo(&buf, todo)
+
+	i := 0
+	for _, l := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		for _, w := range wrap(l, width) {
+			if i == rows {
+				return lines
+			}
+
+			lines[i] = w
+			i++
+		}
+	}
+
+	return lines
+}
+
+// visible counts the runes of s shown on screen, skipping escapes
+func visible(s string) int {
+	n := 0
+	escaped := false
+	for _, r := range s {
+		switch {
+		case r == 0x1b:
+			escaped = true
+		case escaped:
+			if r >= 0x40 && r <= 0x7e && r != '[' {
+				escaped = false
+			}
+		default:
+			n++
+		}
+	}
+
+	return n
+}
+
+// fit cuts s to width visible runes, keeping its escapes
+func fit(s string, width int) string {
+	if visible(s) <= width {
+		return s
+	}
+
+	var b strings.Builder
+	n := 0
+	escaped := false
+	for _, r := range s {
+		switch {
+		case r == 0x1b:
+			escaped = true
+		case escaped:
+			if r >= 0x40 && r <= 0x7e && r != '[' {
+				escaped = false
+			}
+		default:
+			if n == width {
+				continue
+			}
+
+			n++
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// pad fits s to exactly width visible runes
+func pad(s string, width int) string {
+	s = fit(s, width)
+	return s + strings.Repeat(" ", width-visible(s))
+}
+
+// wrap splits s in lines of at most width runes
+func wrap(s string, width int) []string {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return []string{""}
+	}
+
+	var lines []string
+	for len(runes) > width {
+		lines = append(lines, string(runes[:width]))
+		runes = runes[width:]
+	}
+
+	return append(lines, string(runes))
+}
+
+// readKeys sends the keys read from r until it fails
+func readKeys(r io.Reader, keys chan<- []key) {
+	defer close(keys)
+
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			keys <- parseKeys(buf[:n])
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// runTUI runs the interactive mode on the terminal until the user quits
+func (a *app) runTUI(ctx context.Context, r repo.Repository, interval time.Duration) error {
+	in, out := os.Stdin, os.Stdout
+
+	restore, err := makeRaw(int(in.Fd()))
+	if err != nil {
+		return fmt.Errorf("the tui needs a terminal: %w", err)
+	}
+	defer restore()
+
+	io.WriteString(out, escEnterScreen)
+	defer io.WriteString(out, escLeaveScreen)
+
+	keys := make(chan []key)
+	go readKeys(in, keys)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	resize := resized()
+
+	wf, err := a.workflow(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	t := newTUI(r, wf)
+	t.refresh(ctx)
+
+	for {
+		width, height, err := termSize(int(out.Fd()))
+		if err != nil {
+			width, height = 80, 24
+		}
+
+		io.WriteString(out, t.draw(width, height))
+
+		select {
+		case ks, ok := <-keys:
+			if !ok {
+				return nil
+			}
+
+			for _, k := range ks {
+				if !t.handle(ctx, k) {
+					return nil
+				}
+			}
+
+		case <-ticker.C:
+			t.refresh(ctx)
+
+		case <-resize:
+		}
+	}
+}
+
+func setupTUI(fs *flag.FlagSet) runFunc {
+	interval := fs.Duration("refresh", time.Second, "how often to check the repository for changes")
+
+	return func(ctx context.Context, a *app, args []string) error {
+		if *interval <= 0 {
+			return usageError{errors.New("--refresh must be positive")}
+		}
+
+		r, err := a.repository()
+		if err != nil {
+			return err
+		}
+
+		return a.runTUI(ctx, r, *interval)
+	}
+}
+
+const tuiCommandHelp = `Lists the todos in one pane and the selected todo in another, and
+reloads them as the repository changes, in files, Redis or the server.
+Without a command and on a terminal, the cli starts the tui.
+
+Keys:
+  j, k, arrows, g, G    move
+  a                     add a todo
+  e, enter              edit the text of the todo
+  space, x              complete the todo, or reopen it
+  d                     move the todo to the trash, after confirming with y
+  s                     cycle the status filter
+  /                     search the text of todos
+  esc                   clear the filters
+  r                     reload
+  q, ctrl-c             quit`
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eymyong/todo/model"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []key
+	}{
+		{in: "q", expected: []key{{r: 'q'}}},
+		{in: "ab", expected: []key{{r: 'a'}, {r: 'b'}}},
+		{in: "é", expected: []key{{r: 'é'}}},
+		{in: "\x1b[A\x1b[B", expected: []key{{name: keyUp}, {name: keyDown}}},
+		{in: "\x1bOA", expected: []key{{name: keyUp}}},
+		{in: "\x1b[5~\x1b[6~", expected: []key{{name: keyPageUp}, {name: keyPageDown}}},
+		{in: "\x1b[H\x1b[4~", expected: []key{{name: keyHome}, {name: keyEnd}}},
+		{in: "\x1b", expected: []key{{name: keyEsc}}},
+		{in: "\x1bq", expected: []key{{name: keyEsc}, {r: 'q'}}},
+		{in: "\r\x7f\x03\x15", expected: []key{{name: keyEnter}, {name: keyBackspace}, {name: keyCtrlC}, {name: keyCtrlU}}},
+		{in: "\x1b[1;5C", expected: nil},
+	}
+
+	for _, tc := range tests {
+		actual := parseKeys([]byte(tc.in))
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Fatalf("%q: expected %v, got %v", tc.in, tc.expected, actual)
+		}
+	}
+}
+
+// typeKeys sends s to the tui, one key per rune, then the named keys
+func typeKeys(t *testing.T, ui *tui, s string, names ...string) {
+	t.Helper()
+
+	for _, r := range s {
+		if !ui.handle(context.Background(), key{r: r}) {
+			t.Fatalf("unexpected quit on %q", r)
+		}
+	}
+
+	for _, name := range names {
+		if !ui.handle(context.Background(), key{name: name}) {
+			t.Fatalf("unexpected quit on %s", name)
+		}
+	}
+}
+
+func newTestTUI(t *testing.T) *tui {
+	t.Helper()
+
+	a, _, _ := newTestApp(t)
+	r, err := a.repository()
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	ui := newTUI(r, a.wf)
+	ui.refresh(context.Background())
+	return ui
+}
+
+func listed(ui *tui) []string {
+	var data []string
+	for _, todo := range ui.todos {
+		data = append(data, todo.Data)
+	}
+
+	return data
+}
+
+func TestTUI(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("add", func(t *testing.T) {
+		ui := newTestTUI(t)
+		typeKeys(t, ui, "athree", keyEnter)
+
+		if expected := []string{"one", "two", "three"}; !reflect.DeepEqual(expected, listed(ui)) {
+			t.Fatalf("expected %v, got %v", expected, listed(ui))
+		}
+
+		todo, _ := ui.selected()
+		if todo.Data != "three" || todo.Status != model.StatusTodo {
+			t.Fatalf("unexpected selected todo %+v", todo)
+		}
+	})
+
+	t.Run("add canceled", func(t *testing.T) {
+		ui := newTestTUI(t)
+		typeKeys(t, ui, "athree", keyEsc)
+
+		if len(ui.todos) != 2 || ui.prompt != nil {
+			t.Fatalf("unexpected todos %v", listed(ui))
+		}
+	})
+
+	t.Run("edit", func(t *testing.T) {
+		ui := newTestTUI(t)
+		typeKeys(t, ui, "e", keyBackspace, keyBackspace, keyBackspace)
+		typeKeys(t, ui, "uno", keyEnter)
+
+		todo, err := ui.r.Get(ctx, "1")
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		if todo.Data != "uno" {
+			t.Fatalf("unexpected data %s", todo.Data)
+		}
+	})
+
+	t.Run("toggle", func(t *testing.T) {
+		ui := newTestTUI(t)
+		typeKeys(t, ui, " j ")
+
+		one, _ := ui.r.Get(ctx, "1")
+		two, _ := ui.r.Get(ctx, "2")
+		if one.Status != model.StatusDone || two.Status != model.StatusTodo {
+			t.Fatalf("unexpected statuses %s %s", one.Status, two.Status)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		ui := newTestTUI(t)
+		typeKeys(t, ui, "dn")
+		if len(ui.todos) != 2 {
+			t.Fatalf("deleted without confirmation")
+		}
+
+		typeKeys(t, ui, "dy")
+		if expected := []string{"two"}; !reflect.DeepEqual(expected, listed(ui)) {
+			t.Fatalf("expected %v, got %v", expected, listed(ui))
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		ui := newTestTUI(t)
+		typeKeys(t, ui, "s")
+		if expected := []string{"one"}; !reflect.DeepEqual(expected, listed(ui)) {
+			t.Fatalf("expected %v, got %v", expected, listed(ui))
+		}
+
+		typeKeys(t, ui, "", keyEsc)
+		typeKeys(t, ui, "/tw", keyEnter)
+		if expected := []string{"two"}; !reflect.DeepEqual(expected, listed(ui)) {
+			t.Fatalf("expected %v, got %v", expected, listed(ui))
+		}
+
+		typeKeys(t, ui, "", keyEsc)
+		if len(ui.todos) != 2 {
+			t.Fatalf("unexpected todos %v", listed(ui))
+		}
+	})
+
+	t.Run("quit", func(t *testing.T) {
+		ui := newTestTUI(t)
+		if ui.handle(ctx, key{r: 'q'}) {
+			t.Fatalf("expected to quit on q")
+		}
+
+		typeKeys(t, ui, "/")
+		if !ui.handle(ctx, key{r: 'q'}) {
+			t.Fatalf("expected q to be typed in the prompt")
+		}
+
+		if ui.handle(ctx, key{name: keyCtrlC}) {
+			t.Fatalf("expected to quit on ctrl-c")
+		}
+	})
+}
+
+func TestTUIDraw(t *testing.T) {
+	ui := newTestTUI(t)
+	typeKeys(t, ui, "j")
+
+	frame := ui.draw(80, 10)
+	for _, s := range []string{"2 todos", "one", "│ ID: 2", "Status: DONE", "q quit"} {
+		if !strings.Contains(frame, s) {
+			t.Fatalf("expected %q in frame:\n%s", s, frame)
+		}
+	}
+
+	if lines := strings.Count(frame, "\r\n"); lines != 9 {
+		t.Fatalf("expected 9 line breaks, got %d", lines)
+	}
+
+	for _, line := range strings.Split(frame, "\r\n") {
+		if n := visible(line); n > 80 {
+			t.Fatalf("line of %d columns: %q", n, line)
+		}
+	}
+
+	// narrow terminals have no detail pane
+	frame = ui.draw(40, 10)
+	if strings.Contains(frame, "│") {
+		t.Fatalf("unexpected detail pane:\n%s", frame)
+	}
+}
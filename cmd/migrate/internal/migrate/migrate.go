@@ -0,0 +1,237 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/todotxt"
+)
+
+// ErrVerify is returned when the destination does not hold what was written
+var ErrVerify = errors.New("verification failed")
+
+type Options struct {
+	// DryRun checks the migration without writing anything
+	DryRun bool
+
+	// SkipExisting leaves todos whose id is already in the destination alone,
+	// instead of failing the migration
+	SkipExisting bool
+
+	// DateOnly is for todo.txt destinations, which keep timestamps to the
+	// day: verification compares created_at and completed_at by day, skips
+	// updated_at, and expects the tags ending the data among the tags
+	DateOnly bool
+}
+
+type Report struct {
+	// Read is the number of todos read from the source, Trashed the
+	// number of them in its trash
+	Read    int
+	Trashed int
+
+	// Written is the number of todos added to the destination,
+	// or that would be added in a dry run
+	Written int
+
+	// Skipped lists the ids already in the destination
+	Skipped []string
+}
+
+// Run copies every todo of from into to, those in the trash included,
+// then verifies the copy.
+// Nothing is written if the source has duplicate ids, if some ids are
+// already in the destination and opts.SkipExisting is not set, or if the
+// destination rejects one of the todos.
+func Run(ctx context.Context, from repo.Repository, to repo.Repository, opts Options) (Report, error) {
+	todos, trashed, err := readAll(ctx, from)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to read source: %w", err)
+	}
+
+	report := Report{Read: len(todos), Trashed: trashed}
+
+	dups := duplicates(todos)
+	if len(dups) != 0 {
+		return report, fmt.Errorf("%w: duplicate ids in source: %s", repo.ErrConflict, strings.Join(dups, ", "))
+	}
+
+	// ids in the trash of the destination are taken too
+	before, _, err := readAll(ctx, to)
+	if err != nil {
+		return report, fmt.Errorf("failed to read destination: %w", err)
+	}
+
+	existing := make(map[string]bool, len(before))
+	for _, todo := range before {
+		existing[todo.Id] = true
+	}
+
+	pending := []model.Todo{}
+	for _, todo := range todos {
+		if existing[todo.Id] {
+			report.Skipped = append(report.Skipped, todo.Id)
+			continue
+		}
+
+		pending = append(pending, todo)
+	}
+
+	if len(report.Skipped) != 0 && !opts.SkipExisting {
+		return report, fmt.Errorf("%w: ids already in destination: %s", repo.ErrConflict, strings.Join(report.Skipped, ", "))
+	}
+
+	if opts.DryRun {
+		report.Written = len(pending)
+		return report, nil
+	}
+
+	// one batch, so that a failed todo leaves the destination as it was.
+	// AddMany may fill in the todos it is given, verify against the source.
+	_, err = to.AddMany(ctx, slices.Clone(pending))
+	if err != nil {
+		var batchErr *repo.BatchError
+		if errors.As(err, &batchErr) {
+			return report, fmt.Errorf("failed to write '%s', nothing written: %w", pending[batchErr.Index].Id, err)
+		}
+
+		return report, fmt.Errorf("failed to write: %w", err)
+	}
+
+	report.Written = len(pending)
+
+	err = Verify(ctx, pending, to, len(before), opts.DateOnly)
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// Verify checks that to holds exactly countBefore todos plus written,
+// counting those in the trash, and that every written todo reads back
+// unchanged. With dateOnly, timestamps are compared as todo.txt keeps them.
+func Verify(ctx context.Context, written []model.Todo, to repo.Repository, countBefore int, dateOnly bool) error {
+	after, _, err := readAll(ctx, to)
+	if err != nil {
+		return fmt.Errorf("failed to read destination: %w", err)
+	}
+
+	if len(after) != countBefore+len(written) {
+		return fmt.Errorf("%w: expected %d todos in destination but found %d", ErrVerify, countBefore+len(written), len(after))
+	}
+
+	stored := make(map[string]model.Todo, len(after))
+	for _, todo := range after {
+		stored[todo.Id] = todo
+	}
+
+	for _, expected := range written {
+		actual, ok := stored[expected.Id]
+		if !ok {
+			return fmt.Errorf("%w: todo '%s' not in destination", ErrVerify, expected.Id)
+		}
+
+		if dateOnly {
+			expected = toDates(todotxt.SplitTags(expected))
+			actual.UpdatedAt = expected.UpdatedAt
+		}
+
+		diff := compare(expected, actual)
+		if diff != "" {
+			return fmt.Errorf("%w: todo '%s' differs in %s", ErrVerify, expected.Id, diff)
+		}
+	}
+
+	return nil
+}
+
+// readAll returns the todos of r followed by those in its trash,
+// and the number of the latter
+func readAll(ctx context.Context, r repo.Repository) ([]model.Todo, int, error) {
+	todos, err := r.GetAll(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	trash, err := r.Trash(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return append(todos, trash...), len(trash), nil
+}
+
+func duplicates(todos []model.Todo) []string {
+	seen := make(map[string]int, len(todos))
+	for _, todo := range todos {
+		seen[todo.Id]++
+	}
+
+	dups := []string{}
+	for id, n := range seen {
+		if n > 1 {
+			dups = append(dups, id)
+		}
+	}
+
+	sort.Strings(dups)
+
+	return dups
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Equal(*b)
+}
+
+// toDates truncates the timestamps of todo to the day
+func toDates(todo model.Todo) model.Todo {
+	day := 24 * time.Hour
+	todo.CreatedAt = todo.CreatedAt.UTC().Truncate(day)
+	if todo.CompletedAt != nil {
+		completed := todo.CompletedAt.UTC().Truncate(day)
+		todo.CompletedAt = &completed
+	}
+
+	return todo
+}
+
+// compare returns the name of the first field that differs, or ""
+func compare(expected model.Todo, actual model.Todo) string {
+	switch {
+	case expected.Data != actual.Data:
+		return "data"
+	case expected.Status != "" && expected.Status != actual.Status:
+		// todos stored without a status get the workflow's initial one
+		return "status"
+	case !expected.CreatedAt.IsZero() && !expected.CreatedAt.Equal(actual.CreatedAt):
+		return "created_at"
+	case !expected.UpdatedAt.IsZero() && !expected.UpdatedAt.Equal(actual.UpdatedAt):
+		return "updated_at"
+	case expected.CompletedAt != nil && !sameTime(expected.CompletedAt, actual.CompletedAt):
+		return "completed_at"
+	case !sameTime(expected.DueAt, actual.DueAt):
+		return "due_at"
+	case expected.Priority != actual.Priority:
+		return "priority"
+	case fmt.Sprintf("%q", expected.Tags) != fmt.Sprintf("%q", actual.Tags):
+		return "tags"
+	case expected.Notes != actual.Notes:
+		return "notes"
+	case !sameTime(expected.DeletedAt, actual.DeletedAt):
+		return "deleted_at"
+	}
+
+	return ""
+}
@@ -0,0 +1,333 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/jsonfile"
+	"github.com/eymyong/todo/repo/jsonfilemap"
+	"github.com/eymyong/todo/repo/sqlite"
+	"github.com/eymyong/todo/repo/textfile"
+	"github.com/eymyong/todo/repo/todoredis"
+	"github.com/eymyong/todo/repo/todotxt"
+)
+
+func seed(t *testing.T, r repo.Repository) []model.Todo {
+	t.Helper()
+
+	due := time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC)
+	todos := []model.Todo{
+		{Id: "1", Data: "one", Status: model.StatusTodo},
+		{Id: "2", Data: "two", Status: model.StatusDone},
+		{Id: "3", Data: "three", Status: model.StatusTodo, DueAt: &due, Priority: model.PriorityHigh, Tags: []string{"a", "b"}, Notes: "n"},
+	}
+
+	for _, todo := range todos {
+		err := r.Add(context.Background(), todo)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+
+	all, err := r.GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	return all
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	backends := map[string]func() repo.Repository{
+		"todotxt": func() repo.Repository { return todotxt.New(filepath.Join(dir, "todo.txt")) },
+		"jsonmap": func() repo.Repository { return jsonfilemap.New(filepath.Join(dir, "todo.map.json")) },
+		"text":    func() repo.Repository { return textfile.New(filepath.Join(dir, "todo.text")) },
+		"sqlite":  func() repo.Repository { return sqlite.New(filepath.Join(dir, "todo.db")) },
+		"redis":   func() repo.Repository { return todoredis.New(miniredis.RunT(t).Addr()) },
+	}
+
+	ctx := context.Background()
+	for name, newRepo := range backends {
+		t.Run(name, func(t *testing.T) {
+			from := jsonfile.New(filepath.Join(t.TempDir(), "todo.json"))
+			seed(t, from)
+			_, err := from.Remove(ctx, "2", repo.AnyVersion)
+			if err != nil {
+				t.Fatalf("unexpected err: %s", err)
+			}
+
+			source, _, err := readAll(ctx, from)
+			if err != nil {
+				t.Fatalf("unexpected err: %s", err)
+			}
+
+			to := newRepo()
+			report, err := Run(ctx, from, to, Options{DateOnly: name == "todotxt"})
+			if err != nil {
+				t.Fatalf("unexpected err: %s", err)
+			}
+
+			if report.Read != 3 || report.Trashed != 1 || report.Written != 3 {
+				t.Errorf("unexpected report: %+v", report)
+			}
+
+			trash, err := to.Trash(ctx)
+			if err != nil {
+				t.Fatalf("unexpected err: %s", err)
+			}
+
+			if len(trash) != 1 || trash[0].Id != "2" {
+				t.Fatalf("expected todo '2' in the trash but got %+v", trash)
+			}
+
+			for _, expected := range source {
+				actual, err := to.Get(ctx, expected.Id)
+				if expected.Id == "2" {
+					actual, err = trash[0], nil
+				}
+
+				if err != nil {
+					t.Fatalf("unexpected err: %s", err)
+				}
+
+				if name == "todotxt" {
+					expected = toDates(expected)
+					actual.UpdatedAt = expected.UpdatedAt
+				}
+
+				diff := compare(expected, actual)
+				if diff != "" {
+					t.Errorf("todo '%s' differs in %s", expected.Id, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	ctx := context.Background()
+	from := jsonfile.New(filepath.Join(t.TempDir(), "todo.json"))
+	seed(t, from)
+	to := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.map.json"))
+
+	report, err := Run(ctx, from, to, Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if report.Written != 3 {
+		t.Errorf("expected 3 todos to write but got %d", report.Written)
+	}
+
+	todos, err := to.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(todos) != 0 {
+		t.Errorf("dry run wrote %d todos", len(todos))
+	}
+}
+
+func TestRunExisting(t *testing.T) {
+	ctx := context.Background()
+	from := jsonfile.New(filepath.Join(t.TempDir(), "todo.json"))
+	seed(t, from)
+
+	to := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.map.json"))
+	err := to.Add(ctx, model.Todo{Id: "2", Data: "kept", Status: model.StatusTodo})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	_, err = Run(ctx, from, to, Options{})
+	if !errors.Is(err, repo.ErrConflict) {
+		t.Fatalf("expected conflict but got '%v'", err)
+	}
+
+	todos, err := to.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(todos) != 1 {
+		t.Errorf("failed migration wrote %d todos", len(todos)-1)
+	}
+
+	report, err := Run(ctx, from, to, Options{SkipExisting: true})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if report.Written != 2 || len(report.Skipped) != 1 || report.Skipped[0] != "2" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	kept, err := to.Get(ctx, "2")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if kept.Data != "kept" {
+		t.Errorf("existing todo overwritten with '%s'", kept.Data)
+	}
+}
+
+func TestRunRejected(t *testing.T) {
+	ctx := context.Background()
+	from := jsonfile.New(filepath.Join(t.TempDir(), "todo.json"))
+	for _, todo := range []model.Todo{
+		{Id: "1", Data: "one", Status: model.StatusTodo},
+		{Id: "2", Data: "two\nlines", Status: model.StatusTodo},
+		{Id: "3", Data: "three", Status: model.StatusTodo},
+	} {
+		err := from.Add(ctx, todo)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+
+	// todo.txt has no room for the line break of the second todo
+	to := todotxt.New(filepath.Join(t.TempDir(), "todo.txt"))
+	_, err := Run(ctx, from, to, Options{DateOnly: true})
+	var batchErr *repo.BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 1 {
+		t.Fatalf("expected the second todo to fail but got '%v'", err)
+	}
+
+	if !errors.Is(err, repo.ErrInvalidTodo) {
+		t.Errorf("expected invalid todo but got '%v'", err)
+	}
+
+	todos, err := to.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(todos) != 0 {
+		t.Errorf("failed migration wrote %d todos", len(todos))
+	}
+}
+
+func TestRunTodoTxtTags(t *testing.T) {
+	ctx := context.Background()
+	from := jsonfile.New(filepath.Join(t.TempDir(), "todo.json"))
+	err := from.Add(ctx, model.Todo{Id: "1", Data: "call mom +family", Status: model.StatusTodo})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	to := todotxt.New(filepath.Join(t.TempDir(), "todo.txt"))
+	_, err = Run(ctx, from, to, Options{DateOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	todo, err := to.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if todo.Data != "call mom" || len(todo.Tags) != 1 || todo.Tags[0] != "family" {
+		t.Errorf("unexpected todo: %+v", todo)
+	}
+}
+
+func TestRunExistingTrash(t *testing.T) {
+	ctx := context.Background()
+	from := jsonfile.New(filepath.Join(t.TempDir(), "todo.json"))
+	seed(t, from)
+
+	to := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.map.json"))
+	err := to.Add(ctx, model.Todo{Id: "2", Data: "kept", Status: model.StatusTodo})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	_, err = to.Remove(ctx, "2", repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	_, err = Run(ctx, from, to, Options{})
+	if !errors.Is(err, repo.ErrConflict) {
+		t.Fatalf("expected conflict but got '%v'", err)
+	}
+
+	report, err := Run(ctx, from, to, Options{SkipExisting: true})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if report.Written != 2 || len(report.Skipped) != 1 || report.Skipped[0] != "2" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestRunDuplicateSource(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "todo.json")
+	err := os.WriteFile(fname, []byte(`[{"id":"1","data":"one","status":"TODO"},{"id":"1","data":"again","status":"TODO"}]`), 0664)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	to := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.map.json"))
+	_, err = Run(ctx, jsonfile.New(fname), to, Options{})
+	if !errors.Is(err, repo.ErrConflict) {
+		t.Errorf("expected conflict but got '%v'", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	to := jsonfilemap.New(filepath.Join(t.TempDir(), "todo.map.json"))
+	written := seed(t, to)
+
+	err := Verify(ctx, written, to, 0, false)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	err = Verify(ctx, written, to, 1, false)
+	if !errors.Is(err, ErrVerify) {
+		t.Errorf("expected count mismatch but got '%v'", err)
+	}
+
+	// todos in the trash are counted and compared too
+	_, err = to.Remove(ctx, written[1].Id, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	err = Verify(ctx, written, to, 0, false)
+	if !errors.Is(err, ErrVerify) {
+		t.Errorf("expected deleted_at mismatch but got '%v'", err)
+	}
+
+	trash, err := to.Trash(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	written[1] = trash[0]
+	err = Verify(ctx, written, to, 0, false)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	written[0].Data = "changed"
+	err = Verify(ctx, written, to, 0, false)
+	if !errors.Is(err, ErrVerify) {
+		t.Errorf("expected content mismatch but got '%v'", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/eymyong/todo/cmd/migrate/internal/migrate"
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/jsonfile"
+	"github.com/eymyong/todo/repo/jsonfilemap"
+	"github.com/eymyong/todo/repo/sqlite"
+	"github.com/eymyong/todo/repo/textfile"
+	"github.com/eymyong/todo/repo/todoredis"
+	"github.com/eymyong/todo/repo/todotxt"
+)
+
+/*
+Copy every todo from one backend to another:
+
+	migrate --from text:todo.text --to jsonmap:todo.map.json
+	migrate --from json --to redis:127.0.0.1:6379 --dry-run
+
+A backend is written as kind[:target], where kind is one of the REPO values
+(json, jsonmap, text, redis, sqlite, todotxt) and target is its file name or redis
+address. WORKFLOW names the workflow json file, as for the api and cli.
+*/
+
+const (
+	ExitOk       = 0
+	ExitError    = 1
+	ExitUsage    = 2
+	ExitConflict = 5
+)
+
+const JsonFile = "json"
+const JsonMap = "jsonmap"
+const TextFile = "text"
+const Redis = "redis"
+const Sqlite = "sqlite"
+const TodoTxt = "todotxt"
+
+// openRepo opens the backend described by spec, kind[:target].
+// The file backends create missing files, so a source file is checked first
+// to keep a typo from migrating an empty store.
+func openRepo(spec string, source bool, opts ...repo.Option) (repo.Repository, error) {
+	kind, target, _ := strings.Cut(spec, ":")
+
+	if source && kind != Redis && target != "" {
+		_, err := os.Stat(target)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch kind {
+	case JsonFile:
+		if target == "" {
+			target = "todo.json"
+		}
+		return jsonfile.New(target, opts...), nil
+
+	case JsonMap:
+		if target == "" {
+			target = "todo.map.json"
+		}
+		return jsonfilemap.New(target, opts...), nil
+
+	case TextFile:
+		if target == "" {
+			target = "todo.text"
+		}
+		return textfile.New(target, opts...), nil
+
+	case Redis:
+		if target == "" {
+			target = "127.0.0.1:6379"
+		}
+		return todoredis.New(target, opts...), nil
+
+	case Sqlite:
+		if target == "" {
+			target = "todo.db"
+		}
+		return sqlite.New(target, opts...), nil
+
+	case TodoTxt:
+		if target == "" {
+			target = "todo.txt"
+		}
+		return todotxt.New(target, opts...), nil
+	}
+
+	return nil, fmt.Errorf("unknown backend '%s'", kind)
+}
+
+func main() {
+	from := flag.String("from", "", "source backend, kind[:target]")
+	to := flag.String("to", "", "destination backend, kind[:target]")
+	dryRun := flag.Bool("dry-run", false, "check the migration without writing")
+	skipExisting := flag.Bool("skip-existing", false, "skip todos whose id is already in the destination")
+	flag.Parse()
+
+	if *from == "" || *to == "" || *from == *to {
+		fmt.Fprintln(os.Stderr, "usage: migrate --from kind[:target] --to kind[:target] [--dry-run] [--skip-existing]")
+		os.Exit(ExitUsage)
+	}
+
+	var opts []repo.Option
+	envWorkflow := os.Getenv("WORKFLOW")
+	if envWorkflow != "" {
+		wf, err := model.ReadWorkflow(envWorkflow)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(ExitError)
+		}
+
+		opts = append(opts, repo.WithWorkflow(wf))
+	}
+
+	src, err := openRepo(*from, true, opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitUsage)
+	}
+
+	dst, err := openRepo(*to, false, opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitUsage)
+	}
+
+	toKind, _, _ := strings.Cut(*to, ":")
+	report, err := migrate.Run(context.Background(), src, dst, migrate.Options{
+		DryRun:       *dryRun,
+		SkipExisting: *skipExisting,
+		DateOnly:     toKind == TodoTxt,
+	})
+
+	fmt.Printf("Read: %d\n", report.Read)
+	if report.Trashed != 0 {
+		fmt.Printf("In the trash: %d\n", report.Trashed)
+	}
+	if len(report.Skipped) != 0 {
+		fmt.Printf("Skipped: %d (%s)\n", len(report.Skipped), strings.Join(report.Skipped, ", "))
+	}
+
+	if *dryRun {
+		fmt.Printf("Would write: %d\n", report.Written)
+	} else {
+		fmt.Printf("Written: %d\n", report.Written)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, repo.ErrConflict) {
+			os.Exit(ExitConflict)
+		}
+
+		os.Exit(ExitError)
+	}
+
+	if !*dryRun {
+		fmt.Println("Verified")
+	}
+}
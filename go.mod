@@ -4,10 +4,23 @@ go 1.22.1
 
 require github.com/google/uuid v1.6.0
 
-require github.com/gorilla/mux v1.8.1
+require (
+	github.com/alicebob/miniredis/v2 v2.39.0
+	github.com/gorilla/mux v1.8.1
+	github.com/redis/go-redis/v9 v9.6.0
+	golang.org/x/sys v0.28.0
+	modernc.org/sqlite v1.34.5
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/redis/go-redis/v9 v9.6.0 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
 )
@@ -1,11 +1,47 @@
 package model
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type Todo struct {
 	Id     string `json:"id"`
 	Data   string `json:"data"`
 	Status Status `json:"status"`
+
+	// maintained by the repository, callers do not need to set them
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	// DeletedAt is set while the todo is in the trash, see repo.Repository.Remove
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Version is 1 once added and goes up by one on every change, so
+	// writers can tell whether the todo changed since they read it
+	Version int64 `json:"version"`
+
+	DueAt    *time.Time `json:"due_at,omitempty"`
+	Priority Priority   `json:"priority,omitempty"`
+	Tags     []string   `json:"tags,omitempty"`
+	Notes    string     `json:"notes,omitempty"`
+}
+
+// Priority goes from 1 (highest) to 26 (lowest), like todo.txt's (A) to (Z).
+// The zero value means no priority.
+type Priority int
+
+const (
+	PriorityNone   Priority = 0
+	PriorityHigh   Priority = 1
+	PriorityMedium Priority = 2
+	PriorityLow    Priority = 3
+	PriorityMax    Priority = 26
+)
+
+func (p Priority) IsValid() bool {
+	return p >= PriorityNone && p <= PriorityMax
 }
 
 type Status string
@@ -18,18 +54,111 @@ func (s Status) MarshalBinary() (data []byte, err error) {
 	return []byte(s), nil
 }
 
+// Statuses of DefaultWorkflow. Which statuses are valid is decided by
+// the Workflow a repository is configured with, see Workflow.IsValid.
 const (
 	StatusTodo Status = "TODO"
 	StatusDone Status = "DONE"
 )
 
-func (s Status) IsValid() bool {
-	switch s {
-	case StatusTodo, StatusDone, "":
-		return true
+// Now returns the time backends stamp todos with.
+// It is in UTC and has no monotonic reading, so it survives encoding round trips.
+func Now() time.Time {
+	return time.Now().UTC()
+}
+
+// ParseTime reads the times users give, e.g. due dates: a date
+// (2006-01-02) is midnight UTC, as todo.txt keeps dates, else it must be
+// a RFC3339 time. The cli and the api share it, so that the same date is
+// the same instant whichever way it is given.
+func ParseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.DateOnly, s)
+	if err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// IsDate reports whether t is midnight UTC, a date as ParseTime reads them
+func IsDate(t time.Time) bool {
+	t = t.UTC()
+	return t.Equal(t.Truncate(24 * time.Hour))
+}
+
+// Created fills in the timestamps and version of a todo about to be added.
+// Those already set (e.g. when copying todos between backends) are kept.
+func (t *Todo) Created(wf Workflow, now time.Time) {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = now
+	}
+
+	if t.UpdatedAt.IsZero() {
+		t.UpdatedAt = t.CreatedAt
 	}
 
-	return false
+	if t.Version == 0 {
+		t.Version = 1
+	}
+
+	if wf.IsCompleted(t.Status) && t.CompletedAt == nil {
+		completed := t.UpdatedAt
+		t.CompletedAt = &completed
+	}
+}
+
+// SetStatus changes the status and keeps CompletedAt in sync with it
+func (t *Todo) SetStatus(wf Workflow, status Status, now time.Time) {
+	if wf.IsCompleted(status) && (!wf.IsCompleted(t.Status) || t.CompletedAt == nil) {
+		t.CompletedAt = &now
+	}
+
+	if !wf.IsCompleted(status) {
+		t.CompletedAt = nil
+	}
+
+	t.Status = status
+	t.Touch(now)
+}
+
+// SetData changes the data
+func (t *Todo) SetData(data string, now time.Time) {
+	t.Data = data
+	t.Touch(now)
+}
+
+// Touch records a change made at now
+func (t *Todo) Touch(now time.Time) {
+	t.UpdatedAt = now
+	t.Version++
+}
+
+// IsDeleted reports whether the todo is in the trash
+func (t Todo) IsDeleted() bool {
+	return t.DeletedAt != nil
+}
+
+// Delete moves the todo to the trash
+func (t *Todo) Delete(now time.Time) {
+	t.DeletedAt = &now
+	t.Touch(now)
+}
+
+// Restore takes the todo out of the trash
+func (t *Todo) Restore(now time.Time) {
+	t.DeletedAt = nil
+	t.Touch(now)
+}
+
+// Apply copies the editable fields of other into t.
+// Id and the timestamps maintained by the repository are left alone.
+func (t *Todo) Apply(wf Workflow, other Todo, now time.Time) {
+	t.SetStatus(wf, other.Status, now)
+	t.Data = other.Data
+	t.DueAt = other.DueAt
+	t.Priority = other.Priority
+	t.Tags = other.Tags
+	t.Notes = other.Notes
 }
 
 type TestTodo struct {
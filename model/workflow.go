@@ -0,0 +1,177 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	StatusInProgress Status = "IN_PROGRESS"
+	StatusBlocked    Status = "BLOCKED"
+	StatusCancelled  Status = "CANCELLED"
+)
+
+// Workflow defines the statuses a todo can have and how it moves between them.
+//
+//	{
+//	  "initial": "TODO",
+//	  "statuses": ["TODO", "IN_PROGRESS", "BLOCKED", "DONE", "CANCELLED"],
+//	  "completed": ["DONE", "CANCELLED"],
+//	  "transitions": {
+//	    "TODO": ["IN_PROGRESS", "CANCELLED"],
+//	    "IN_PROGRESS": ["TODO", "BLOCKED", "DONE", "CANCELLED"],
+//	    "BLOCKED": ["IN_PROGRESS", "CANCELLED"],
+//	    "DONE": ["TODO"],
+//	    "CANCELLED": ["TODO"]
+//	  }
+//	}
+//
+// The zero value behaves like DefaultWorkflow.
+type Workflow struct {
+	// Initial is the status of new todos added without one
+	Initial Status `json:"initial"`
+
+	// Statuses lists every valid status
+	Statuses []Status `json:"statuses"`
+
+	// Completed lists the statuses that close a todo and set its CompletedAt
+	Completed []Status `json:"completed"`
+
+	// Transitions maps a status to the statuses it may move to.
+	// A nil map allows every transition.
+	Transitions map[Status][]Status `json:"transitions"`
+}
+
+// DefaultWorkflow is the plain TODO/DONE workflow, free to move either way
+var DefaultWorkflow = Workflow{
+	Initial:   StatusTodo,
+	Statuses:  []Status{StatusTodo, StatusDone},
+	Completed: []Status{StatusDone},
+}
+
+func (w Workflow) orDefault() Workflow {
+	if len(w.Statuses) == 0 {
+		return DefaultWorkflow
+	}
+
+	return w
+}
+
+func contains(statuses []Status, s Status) bool {
+	for _, v := range statuses {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InitialStatus returns the status new todos start in
+func (w Workflow) InitialStatus() Status {
+	return w.orDefault().Initial
+}
+
+// AllStatuses returns every valid status, in the order they were configured
+func (w Workflow) AllStatuses() []Status {
+	return w.orDefault().Statuses
+}
+
+// IsValid reports whether s is a status of w
+func (w Workflow) IsValid(s Status) bool {
+	return contains(w.orDefault().Statuses, s)
+}
+
+// IsCompleted reports whether s closes a todo
+func (w Workflow) IsCompleted(s Status) bool {
+	return contains(w.orDefault().Completed, s)
+}
+
+// DoneStatus returns the status todos are marked done with, the first
+// completed status in the order of the statuses
+func (w Workflow) DoneStatus() Status {
+	w = w.orDefault()
+	for _, s := range w.Statuses {
+		if contains(w.Completed, s) {
+			return s
+		}
+	}
+
+	return StatusDone
+}
+
+// CanTransition reports whether a todo may move from one status to another.
+// Staying in the same status is always allowed.
+func (w Workflow) CanTransition(from Status, to Status) bool {
+	w = w.orDefault()
+
+	if !w.IsValid(to) {
+		return false
+	}
+
+	if from == to || w.Transitions == nil {
+		return true
+	}
+
+	return contains(w.Transitions[from], to)
+}
+
+// Check reports configuration mistakes, e.g. a transition to an unknown status
+func (w Workflow) Check() error {
+	if len(w.Statuses) == 0 {
+		return errors.New("workflow has no statuses")
+	}
+
+	for _, s := range w.Statuses {
+		if s == "" {
+			return errors.New("workflow has an empty status")
+		}
+	}
+
+	if !w.IsValid(w.Initial) {
+		return fmt.Errorf("initial status '%s' is not in statuses", w.Initial)
+	}
+
+	for _, s := range w.Completed {
+		if !w.IsValid(s) {
+			return fmt.Errorf("completed status '%s' is not in statuses", s)
+		}
+	}
+
+	for from, tos := range w.Transitions {
+		if !w.IsValid(from) {
+			return fmt.Errorf("transition from unknown status '%s'", from)
+		}
+
+		for _, to := range tos {
+			if !w.IsValid(to) {
+				return fmt.Errorf("transition from '%s' to unknown status '%s'", from, to)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReadWorkflow loads a workflow from a json file
+func ReadWorkflow(fileName string) (Workflow, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return Workflow{}, fmt.Errorf("failed to read workflow: %w", err)
+	}
+
+	var w Workflow
+	err = json.Unmarshal(b, &w)
+	if err != nil {
+		return Workflow{}, fmt.Errorf("failed to unmarshal workflow: %w", err)
+	}
+
+	err = w.Check()
+	if err != nil {
+		return Workflow{}, fmt.Errorf("bad workflow %s: %w", fileName, err)
+	}
+
+	return w, nil
+}
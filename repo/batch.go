@@ -0,0 +1,196 @@
+package repo
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/eymyong/todo/model"
+)
+
+// The batch methods of Repository (AddMany, UpdateMany and RemoveMany)
+// are all or nothing: either every todo of the batch is written, in a
+// single write of the storage, or none is and the error is a *BatchError
+// telling which todo failed. The helpers below implement them for the
+// backends holding every todo in memory.
+
+// BatchError is the failure of the todo at Index in a batch
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("todo %d of the batch: %s", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// CheckBatchIds rejects a batch naming the same id twice
+func CheckBatchIds(ids []string) error {
+	seen := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		if seen[id] {
+			return &BatchError{Index: i, Err: fmt.Errorf("%w: id '%s' is twice in the batch", ErrConflict, id)}
+		}
+
+		seen[id] = true
+	}
+
+	return nil
+}
+
+// TodoIds returns the ids of todos
+func TodoIds(todos []model.Todo) []string {
+	ids := make([]string, len(todos))
+	for i := range todos {
+		ids[i] = todos[i].Id
+	}
+
+	return ids
+}
+
+// PrepareAdd fills in and checks the todos of an AddMany before they are
+// stored, like Add does for one todo. The todos are changed in place.
+func PrepareAdd(wf model.Workflow, batch []model.Todo, now time.Time) error {
+	err := CheckBatchIds(TodoIds(batch))
+	if err != nil {
+		return err
+	}
+
+	for i := range batch {
+		if batch[i].Status == "" {
+			batch[i].Status = wf.InitialStatus()
+		}
+
+		err := Validate(wf, batch[i])
+		if err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+
+		batch[i].Created(wf, now)
+	}
+
+	return nil
+}
+
+// AddTodos returns todos followed by the todos of batch, see AddMany
+func AddTodos(wf model.Workflow, todos []model.Todo, batch []model.Todo, now time.Time) ([]model.Todo, error) {
+	batch = slices.Clone(batch)
+
+	err := PrepareAdd(wf, batch, now)
+	if err != nil {
+		return nil, err
+	}
+
+	index := indexTodos(todos)
+	for i := range batch {
+		_, ok := index[batch[i].Id]
+		if ok {
+			return nil, &BatchError{Index: i, Err: fmt.Errorf("%w: duplicate id '%s'", ErrConflict, batch[i].Id)}
+		}
+	}
+
+	return append(slices.Clone(todos), batch...), nil
+}
+
+// UpdateTodo applies update to todo as Update does
+func UpdateTodo(wf model.Workflow, todo *model.Todo, update model.Todo, now time.Time) error {
+	err := CheckVersion(*todo, update.Version)
+	if err != nil {
+		return err
+	}
+
+	err = Validate(wf, update)
+	if err != nil {
+		return err
+	}
+
+	err = CheckTransition(wf, todo.Status, update.Status)
+	if err != nil {
+		return err
+	}
+
+	todo.Apply(wf, update, now)
+	return nil
+}
+
+// UpdateTodos returns todos with the todos of batch applied, and the
+// previous version of each todo of the batch, see UpdateMany
+func UpdateTodos(wf model.Workflow, todos []model.Todo, batch []model.Todo, now time.Time) ([]model.Todo, []model.Todo, error) {
+	err := CheckBatchIds(TodoIds(batch))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	todos = slices.Clone(todos)
+	index := indexTodos(todos)
+	old := make([]model.Todo, len(batch))
+
+	for i, update := range batch {
+		j, ok := index[update.Id]
+		if !ok || todos[j].IsDeleted() {
+			return nil, nil, &BatchError{Index: i, Err: fmt.Errorf("%w: id '%s' not found", ErrNotFound, update.Id)}
+		}
+
+		old[i] = todos[j]
+
+		err := UpdateTodo(wf, &todos[j], update, now)
+		if err != nil {
+			return nil, nil, &BatchError{Index: i, Err: err}
+		}
+	}
+
+	return todos, old, nil
+}
+
+// RemoveTodos returns todos with the todos of ids moved to the trash, and
+// those todos as they were before in the order of ids, see RemoveMany
+func RemoveTodos(todos []model.Todo, ids []string, now time.Time) ([]model.Todo, []model.Todo, error) {
+	err := CheckBatchIds(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	todos = slices.Clone(todos)
+	index := indexTodos(todos)
+	removed := make([]model.Todo, len(ids))
+	for i, id := range ids {
+		j, ok := index[id]
+		if !ok || todos[j].IsDeleted() {
+			return nil, nil, &BatchError{Index: i, Err: fmt.Errorf("%w: id '%s' not found", ErrNotFound, id)}
+		}
+
+		removed[i] = todos[j]
+		todos[j].Delete(now)
+	}
+
+	return todos, removed, nil
+}
+
+// PickTodos returns the todos of todos with ids, in the order of ids,
+// e.g. the todos of a batch once stored
+func PickTodos(todos []model.Todo, ids []string) []model.Todo {
+	index := indexTodos(todos)
+	picked := make([]model.Todo, 0, len(ids))
+	for _, id := range ids {
+		i, ok := index[id]
+		if ok {
+			picked = append(picked, todos[i])
+		}
+	}
+
+	return picked
+}
+
+// indexTodos maps the ids of todos to their index
+func indexTodos(todos []model.Todo) map[string]int {
+	index := make(map[string]int, len(todos))
+	for i := range todos {
+		index[todos[i].Id] = i
+	}
+
+	return index
+}
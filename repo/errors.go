@@ -0,0 +1,37 @@
+package repo
+
+import "errors"
+
+// Errors shared by every Repository implementation.
+// Backends wrap these with fmt.Errorf("%w: ...") so callers can
+// check them with errors.Is instead of matching on message strings.
+var (
+	// ErrNotFound is returned when no todo exists with the given id.
+	ErrNotFound = errors.New("not found")
+
+	// ErrInvalidStatus is returned when a status is not one the repository accepts.
+	ErrInvalidStatus = errors.New("invalid status")
+
+	// ErrInvalidTransition is returned when the workflow does not allow
+	// a todo to move from its current status to the requested one.
+	ErrInvalidTransition = errors.New("invalid status transition")
+
+	// ErrInvalidTodo is returned when a todo has a field the repository rejects,
+	// e.g. a priority out of range.
+	ErrInvalidTodo = errors.New("invalid todo")
+
+	// ErrInvalidQuery is returned when a Filter cannot be run,
+	// e.g. an unknown sort field or a malformed cursor.
+	ErrInvalidQuery = errors.New("invalid query")
+
+	// ErrConflict is returned when a write conflicts with existing data,
+	// e.g. adding a todo whose id is already taken.
+	ErrConflict = errors.New("conflict")
+
+	// ErrVersionMismatch is returned when a write expects a version of the
+	// todo other than the stored one, i.e. someone changed it in between.
+	ErrVersionMismatch = errors.New("version mismatch")
+
+	// ErrStorage is returned when the underlying storage (file, redis, ...) fails.
+	ErrStorage = errors.New("storage error")
+)
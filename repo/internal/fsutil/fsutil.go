@@ -0,0 +1,136 @@
+// Package fsutil holds the file helpers shared by the file backends:
+// locking for read-modify-write cycles and atomic writes.
+package fsutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// semaphores holds one channel per absolute file path, used as a mutex that
+// can be given up on, so every repository instance in this process shares
+// the same lock for a file.
+var semaphores sync.Map
+
+func semaphoreFor(name string) chan struct{} {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		abs = name
+	}
+
+	sem, _ := semaphores.LoadOrStore(abs, make(chan struct{}, 1))
+	return sem.(chan struct{})
+}
+
+// Lock takes an exclusive lock on name: an in-process mutex for goroutines,
+// plus an advisory OS lock on "<name>.lock" for other processes (e.g. the cli
+// running next to the api). The lock lives on a separate file because
+// WriteFile replaces name with a new inode on every write.
+//
+// Lock gives up with ctx.Err() when ctx is done before the lock is taken.
+// Callers must call the returned unlock func when done.
+func Lock(ctx context.Context, name string) (unlock func(), err error) {
+	err = ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	sem := semaphoreFor(name)
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	f, err := os.OpenFile(name+".lock", os.O_CREATE|os.O_RDWR, 0664)
+	if err != nil {
+		<-sem
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	// flock cannot be interrupted, so wait for it aside
+	locked := make(chan error, 1)
+	go func() {
+		locked <- lockFile(f)
+	}()
+
+	select {
+	case err = <-locked:
+	case <-ctx.Done():
+		// release the OS lock whenever it is finally taken
+		go func() {
+			if <-locked == nil {
+				unlockFile(f)
+			}
+			f.Close()
+			<-sem
+		}()
+
+		return nil, ctx.Err()
+	}
+
+	if err != nil {
+		f.Close()
+		<-sem
+		return nil, fmt.Errorf("failed to lock file: %w", err)
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+		<-sem
+	}, nil
+}
+
+// WriteFile writes data to name atomically: data goes to a temp file in the
+// same directory, which is fsynced and then renamed over name.
+// Readers see either the old or the new content, never a partial write.
+func WriteFile(name string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(name)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	tmpName := tmp.Name()
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+
+	err = tmp.Chmod(perm)
+	if err != nil {
+		return fmt.Errorf("failed to chmod temp file: %w", err)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	err = os.Rename(tmpName, name)
+	if err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	ok = true
+
+	return syncDir(dir)
+}
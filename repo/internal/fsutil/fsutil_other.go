@@ -0,0 +1,18 @@
+//go:build !unix && !windows
+
+package fsutil
+
+import "os"
+
+// no advisory locks on this platform, Lock only serializes within the process
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
+
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,127 @@
+package fsutil
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteFile_Happy(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "foo.json")
+
+	for _, data := range []string{"[]", `[{"id":"1"}]`} {
+		err := WriteFile(fname, []byte(data), 0664)
+		if err != nil {
+			t.Errorf("unexpected err: `%s`", err)
+			return
+		}
+
+		b, err := os.ReadFile(fname)
+		if err != nil {
+			t.Errorf("unexpected err: `%s`", err)
+			return
+		}
+
+		if string(b) != data {
+			t.Errorf("expected `%s` but got `%s`", data, string(b))
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(fname))
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
+		return
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("expected only the target file but found %d entries, temp files left behind?", len(entries))
+	}
+}
+
+func TestWriteFile_Err(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "not_exist", "foo.json")
+
+	err := WriteFile(fname, []byte("[]"), 0664)
+	if err == nil {
+		t.Errorf("expected err but got nil")
+	}
+}
+
+func TestLock_Exclusive(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "foo.json")
+
+	unlock, err := Lock(context.Background(), fname)
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
+		return
+	}
+
+	var mu sync.Mutex
+	acquired := false
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		unlock2, err := Lock(context.Background(), fname)
+		if err != nil {
+			t.Errorf("unexpected err: `%s`", err)
+			return
+		}
+
+		mu.Lock()
+		acquired = true
+		mu.Unlock()
+
+		unlock2()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	if acquired {
+		t.Errorf("second lock acquired while the first is held")
+	}
+	mu.Unlock()
+
+	unlock()
+	<-done
+
+	if !acquired {
+		t.Errorf("second lock never acquired")
+	}
+}
+
+func TestLock_Cancel(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "foo.json")
+
+	unlock, err := Lock(context.Background(), fname)
+	if err != nil {
+		t.Fatalf("unexpected err: `%s`", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = Lock(ctx, fname)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded but got `%v`", err)
+	}
+
+	unlock()
+
+	// the abandoned attempt must not keep the lock
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	unlock, err = Lock(ctx, fname)
+	if err != nil {
+		t.Fatalf("unexpected err: `%s`", err)
+	}
+
+	unlock()
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package fsutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// the in-process mutex hides the OS lock from goroutines,
+// so check the flock directly as another process would see it
+func TestLock_OSLock(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "foo.json")
+
+	unlock, err := Lock(context.Background(), fname)
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
+		return
+	}
+
+	f, err := os.OpenFile(fname+".lock", os.O_RDWR, 0664)
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
+		return
+	}
+	defer f.Close()
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != syscall.EWOULDBLOCK {
+		t.Errorf("expected EWOULDBLOCK while locked but got `%v`", err)
+	}
+
+	unlock()
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		t.Errorf("unexpected err after unlock: `%s`", err)
+	}
+}
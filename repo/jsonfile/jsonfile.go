@@ -5,20 +5,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/eymyong/todo/model"
 	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/internal/fsutil"
 )
 
 type RepoJsonFile struct {
 	fileName string
+	workflow model.Workflow
 }
 
 // ถ้าไม่มีข้อมูลในไฟล์ return []model.Todo{}
 func readDecode(fname string) ([]model.Todo, error) {
 	j, err := os.ReadFile(fname)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read jsonfile: %w", err)
+		return nil, fmt.Errorf("%w: failed to read jsonfile: %w", repo.ErrStorage, err)
 	}
 
 	if len(j) == 0 {
@@ -28,7 +31,7 @@ func readDecode(fname string) ([]model.Todo, error) {
 	todos := []model.Todo{}
 	err = json.Unmarshal(j, &todos)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal jsonfile: %w", err)
+		return nil, fmt.Errorf("%w: failed to unmarshal jsonfile: %w", repo.ErrStorage, err)
 	}
 
 	return todos, nil
@@ -37,23 +40,56 @@ func readDecode(fname string) ([]model.Todo, error) {
 func writeEncode(fileName string, data interface{}) error {
 	b, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal jsonfile: `%w`", err)
+		return fmt.Errorf("%w: failed to marshal jsonfile: `%w`", repo.ErrStorage, err)
 	}
 
-	err = os.WriteFile(fileName, b, 0664)
+	err = fsutil.WriteFile(fileName, b, 0664)
 	if err != nil {
-		return fmt.Errorf("failed to write jsonfile: `%w`", err)
+		return fmt.Errorf("%w: failed to write jsonfile: `%w`", repo.ErrStorage, err)
 	}
 
 	return nil
 }
 
-func (j *RepoJsonFile) Add(_ context.Context, todo model.Todo) error {
+// lock serializes read-modify-write cycles on the file
+// across goroutines and processes
+func (j *RepoJsonFile) lock(ctx context.Context) (func(), error) {
+	unlock, err := fsutil.Lock(ctx, j.fileName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to lock jsonfile: %w", repo.ErrStorage, err)
+	}
+
+	return unlock, nil
+}
+
+func (j *RepoJsonFile) Add(ctx context.Context, todo model.Todo) error {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to add jsonfile: %w", err)
+	}
+	defer unlock()
+
+	if todo.Status == "" {
+		todo.Status = j.workflow.InitialStatus()
+	}
+
+	err = repo.Validate(j.workflow, todo)
+	if err != nil {
+		return err
+	}
+
 	todoList, err := readDecode(j.fileName)
 	if err != nil {
 		return fmt.Errorf("failed to add jsonfile: %w", err)
 	}
 
+	for i := range todoList {
+		if todoList[i].Id == todo.Id {
+			return fmt.Errorf("%w: duplicate id '%s'", repo.ErrConflict, todo.Id)
+		}
+	}
+
+	todo.Created(j.workflow, model.Now())
 	todoList = append(todoList, todo)
 
 	err = writeEncode(j.fileName, todoList)
@@ -64,11 +100,26 @@ func (j *RepoJsonFile) Add(_ context.Context, todo model.Todo) error {
 	return nil
 }
 
-func (j *RepoJsonFile) GetAll(_ context.Context) ([]model.Todo, error) {
-	return readDecode(j.fileName)
+func (j *RepoJsonFile) GetAll(ctx context.Context) ([]model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	todoList, err := readDecode(j.fileName)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return repo.Live(todoList), nil
 }
 
-func (j *RepoJsonFile) Get(_ context.Context, id string) (model.Todo, error) {
+func (j *RepoJsonFile) Get(ctx context.Context, id string) (model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return model.Todo{}, err
+	}
+
 	todoList, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, fmt.Errorf("failed to get jsonfile: %w", err)
@@ -76,15 +127,25 @@ func (j *RepoJsonFile) Get(_ context.Context, id string) (model.Todo, error) {
 	}
 
 	for _, todo := range todoList {
-		if todo.Id == id {
+		if todo.Id == id && !todo.IsDeleted() {
 			return todo, nil
 		}
 	}
 
-	return model.Todo{}, fmt.Errorf("no id: %s", id)
+	return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
 }
 
-func (j *RepoJsonFile) GetByStatus(_ context.Context, status model.Status) ([]model.Todo, error) {
+func (j *RepoJsonFile) GetByStatus(ctx context.Context, status model.Status) ([]model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	err = repo.ValidateStatus(j.workflow, status)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
 	todoList, err := readDecode(j.fileName)
 	if err != nil {
 		return []model.Todo{}, fmt.Errorf("failed to get-status jsonfile: %w", err)
@@ -92,7 +153,7 @@ func (j *RepoJsonFile) GetByStatus(_ context.Context, status model.Status) ([]mo
 
 	var statusTodoList []model.Todo
 	for _, v := range todoList {
-		if v.Status == status {
+		if v.Status == status && !v.IsDeleted() {
 			statusTodoList = append(statusTodoList, v)
 		}
 	}
@@ -100,18 +161,49 @@ func (j *RepoJsonFile) GetByStatus(_ context.Context, status model.Status) ([]mo
 	return statusTodoList, nil
 }
 
-func (j *RepoJsonFile) UpdateData(_ context.Context, id string, newdata string) (model.Todo, error) {
+func (j *RepoJsonFile) Query(ctx context.Context, filter repo.Filter) (repo.Page, error) {
+	err := ctx.Err()
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	filter, err = repo.ValidateFilter(j.workflow, filter)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	todoList, err := readDecode(j.fileName)
+	if err != nil {
+		return repo.Page{}, fmt.Errorf("failed to query jsonfile: %w", err)
+	}
+
+	return repo.ApplyFilter(todoList, filter)
+}
+
+func (j *RepoJsonFile) UpdateData(ctx context.Context, id string, newdata string, version int64) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to update-data jsonfile: %w", err)
+	}
+	defer unlock()
+
 	todoList, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, fmt.Errorf("failed to update-data jsonfile: %w", err)
 	}
 
 	newTodoLists := []model.Todo{}
-	old := model.Todo{}
+	var old *model.Todo
 	for _, todo := range todoList {
-		if id == todo.Id {
-			old = todo
-			todo.Data = newdata
+		if id == todo.Id && !todo.IsDeleted() {
+			err = repo.CheckVersion(todo, version)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
+			found := todo
+			old = &found
+			todo.SetData(newdata, model.Now())
 			newTodoLists = append(newTodoLists, todo)
 			continue
 		}
@@ -119,15 +211,30 @@ func (j *RepoJsonFile) UpdateData(_ context.Context, id string, newdata string)
 		newTodoLists = append(newTodoLists, todo)
 	}
 
+	if old == nil {
+		return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
+	}
+
 	err = writeEncode(j.fileName, newTodoLists)
 	if err != nil {
 		return model.Todo{}, fmt.Errorf("failed to update-data jsonfile: %w", err)
 	}
 
-	return old, nil
+	return *old, nil
 }
 
-func (j *RepoJsonFile) UpdateStatus(_ context.Context, id string, status model.Status) (model.Todo, error) {
+func (j *RepoJsonFile) UpdateStatus(ctx context.Context, id string, status model.Status, version int64) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to update-status jsonfile: %w", err)
+	}
+	defer unlock()
+
+	err = repo.ValidateStatus(j.workflow, status)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
 	todos, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, fmt.Errorf("failed to update-status jsonfile: %w", err)
@@ -137,15 +244,26 @@ func (j *RepoJsonFile) UpdateStatus(_ context.Context, id string, status model.S
 
 	for i := range todos {
 		t := &todos[i]
-		if id == t.Id {
-			old = t
-			t.Status = status
+		if id == t.Id && !t.IsDeleted() {
+			err = repo.CheckVersion(*t, version)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
+			err = repo.CheckTransition(j.workflow, t.Status, status)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
+			found := *t
+			old = &found
+			t.SetStatus(j.workflow, status, model.Now())
 		}
 
 	}
 
 	if old == nil {
-		return model.Todo{}, fmt.Errorf("id '%s' not found", id)
+		return model.Todo{}, fmt.Errorf("%w: id '%s' not found", repo.ErrNotFound, id)
 	}
 
 	err = writeEncode(j.fileName, todos)
@@ -156,31 +274,260 @@ func (j *RepoJsonFile) UpdateStatus(_ context.Context, id string, status model.S
 	return *old, nil
 }
 
-func (j *RepoJsonFile) Remove(_ context.Context, id string) (model.Todo, error) {
+func (j *RepoJsonFile) Update(ctx context.Context, todo model.Todo) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to update jsonfile: %w", err)
+	}
+	defer unlock()
+
+	err = repo.Validate(j.workflow, todo)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todos, err := readDecode(j.fileName)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to update jsonfile: %w", err)
+	}
+
+	var old *model.Todo
+
+	for i := range todos {
+		t := &todos[i]
+		if todo.Id == t.Id && !t.IsDeleted() {
+			err = repo.CheckVersion(*t, todo.Version)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
+			err = repo.CheckTransition(j.workflow, t.Status, todo.Status)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
+			found := *t
+			old = &found
+			t.Apply(j.workflow, todo, model.Now())
+		}
+	}
+
+	if old == nil {
+		return model.Todo{}, fmt.Errorf("%w: id '%s' not found", repo.ErrNotFound, todo.Id)
+	}
+
+	err = writeEncode(j.fileName, todos)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to update jsonfile: %w", err)
+	}
+
+	return *old, nil
+}
+
+func (j *RepoJsonFile) Remove(ctx context.Context, id string, version int64) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to remove jsonfile: %w", err)
+	}
+	defer unlock()
+
 	todoList, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, fmt.Errorf("failed to Remove: %w", err)
 	}
 
-	newTodoList := []model.Todo{}
-	old := model.Todo{}
-	for _, todo := range todoList {
-		if id == todo.Id {
-			old = todo
-			continue
+	var old *model.Todo
+	for i := range todoList {
+		t := &todoList[i]
+		if id == t.Id && !t.IsDeleted() {
+			err = repo.CheckVersion(*t, version)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
+			found := *t
+			old = &found
+			t.Delete(model.Now())
 		}
-		newTodoList = append(newTodoList, todo)
 	}
 
-	err = writeEncode(j.fileName, newTodoList)
+	if old == nil {
+		return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
+	}
+
+	err = writeEncode(j.fileName, todoList)
 	if err != nil {
 		return model.Todo{}, fmt.Errorf("failed to remove jsonfile: %w", err)
 	}
 
-	return old, nil
+	return *old, nil
+}
+
+func (j *RepoJsonFile) AddMany(ctx context.Context, todos []model.Todo) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add-many jsonfile: %w", err)
+	}
+	defer unlock()
+
+	todoList, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add-many jsonfile: %w", err)
+	}
+
+	todoList, err = repo.AddTodos(j.workflow, todoList, todos, model.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeEncode(j.fileName, todoList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add-many jsonfile: %w", err)
+	}
+
+	return repo.PickTodos(todoList, repo.TodoIds(todos)), nil
+}
+
+func (j *RepoJsonFile) UpdateMany(ctx context.Context, todos []model.Todo) ([]model.Todo, []model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to update-many jsonfile: %w", err)
+	}
+	defer unlock()
+
+	todoList, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to update-many jsonfile: %w", err)
+	}
+
+	todoList, old, err := repo.UpdateTodos(j.workflow, todoList, todos, model.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = writeEncode(j.fileName, todoList)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to update-many jsonfile: %w", err)
+	}
+
+	return repo.PickTodos(todoList, repo.TodoIds(todos)), old, nil
+}
+
+func (j *RepoJsonFile) RemoveMany(ctx context.Context, ids []string) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove-many jsonfile: %w", err)
+	}
+	defer unlock()
+
+	todoList, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove-many jsonfile: %w", err)
+	}
+
+	todoList, removed, err := repo.RemoveTodos(todoList, ids, model.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeEncode(j.fileName, todoList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove-many jsonfile: %w", err)
+	}
+
+	return removed, nil
+}
+
+func (j *RepoJsonFile) Trash(ctx context.Context) ([]model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	todoList, err := readDecode(j.fileName)
+	if err != nil {
+		return []model.Todo{}, fmt.Errorf("failed to trash jsonfile: %w", err)
+	}
+
+	return repo.TrashTodos(todoList), nil
+}
+
+func (j *RepoJsonFile) Restore(ctx context.Context, id string) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to restore jsonfile: %w", err)
+	}
+	defer unlock()
+
+	todoList, err := readDecode(j.fileName)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to restore jsonfile: %w", err)
+	}
+
+	restored, err := repo.RestoreTodo(todoList, id, model.Now())
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = writeEncode(j.fileName, todoList)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to restore jsonfile: %w", err)
+	}
+
+	return restored, nil
+}
+
+func (j *RepoJsonFile) Purge(ctx context.Context, id string) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to purge jsonfile: %w", err)
+	}
+	defer unlock()
+
+	todoList, err := readDecode(j.fileName)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to purge jsonfile: %w", err)
+	}
+
+	todoList, purged, err := repo.PurgeTodo(todoList, id)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = writeEncode(j.fileName, todoList)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("failed to purge jsonfile: %w", err)
+	}
+
+	return purged, nil
+}
+
+func (j *RepoJsonFile) PurgeBefore(ctx context.Context, t time.Time) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge-before jsonfile: %w", err)
+	}
+	defer unlock()
+
+	todoList, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge-before jsonfile: %w", err)
+	}
+
+	todoList, purged := repo.PurgeTodosBefore(todoList, t)
+	if len(purged) == 0 {
+		return purged, nil
+	}
+
+	err = writeEncode(j.fileName, todoList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge-before jsonfile: %w", err)
+	}
+
+	return purged, nil
 }
 
-func New(fileName string) repo.Repository {
+func New(fileName string, opts ...repo.Option) repo.Repository {
 	b, err := os.ReadFile(fileName)
 	if err != nil || len(b) == 0 {
 		err := os.WriteFile(fileName, []byte("[]"), os.ModePerm)
@@ -190,6 +537,7 @@ func New(fileName string) repo.Repository {
 	}
 	return &RepoJsonFile{
 		fileName: fileName,
+		workflow: repo.NewOptions(opts...).Workflow,
 	}
 }
 
@@ -1,12 +1,17 @@
 package jsonfile
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/repotest"
 )
 
 /*
@@ -15,6 +20,7 @@ go test -v
 */
 const fileName = "mock/test_foo.json"
 const fileNameErr = "error.json"
+const fileNameNotExist = "mock/not_exist/test_foo.json"
 
 func TestReadDecodeHappy(t *testing.T) {
 	todosNoData, err := readDecode(fileName)
@@ -62,8 +68,13 @@ func TestReadDecodeHappy(t *testing.T) {
 func TestReadDecodeFileName_Err(t *testing.T) {
 	expectedErr := "failed to read jsonfile"
 	_, err := readDecode("expected err")
-	if err != nil {
+	if err == nil {
 		t.Errorf("expected error but got nil:")
+		return
+	}
+
+	if !errors.Is(err, repo.ErrStorage) {
+		t.Errorf("expected error to wrap '%s' but got '%s'", repo.ErrStorage, err.Error())
 	}
 
 	// error contain expected error ?
@@ -193,7 +204,7 @@ func TestAddHappy(t *testing.T) {
 	expectedTodos := makeTodos()
 	newTodo := expectedTodos[0]
 
-	err := repo.Add(nil, newTodo)
+	err := repo.Add(context.Background(), newTodo)
 	if err != nil {
 		t.Errorf("unexpected err: %s", err.Error())
 		return
@@ -223,8 +234,8 @@ func TestAddHappy(t *testing.T) {
 }
 
 func TestAddFileError(t *testing.T) {
-	repo := RepoJsonFile{
-		fileName: fileNameErr,
+	repoJson := RepoJsonFile{
+		fileName: fileNameNotExist,
 	}
 	expectedErr := "failed to add"
 
@@ -232,15 +243,83 @@ func TestAddFileError(t *testing.T) {
 
 	dataToAdd := expectedTodos[0]
 
-	err := repo.Add(nil, dataToAdd)
+	err := repoJson.Add(context.Background(), dataToAdd)
 	if err == nil {
 		t.Errorf("expected error but got nil")
+		return
 	}
 
 	// error contain expected error ?
 	if !strings.Contains(err.Error(), expectedErr) {
 		t.Errorf("expected '%s' but got '%s'", expectedErr, err.Error())
 	}
+
+	if !errors.Is(err, repo.ErrStorage) {
+		t.Errorf("expected error to wrap '%s' but got '%s'", repo.ErrStorage, err.Error())
+	}
+}
+
+func TestAddDuplicateId(t *testing.T) {
+	repoJson := RepoJsonFile{
+		fileName: fileName,
+	}
+
+	expectedTodos := makeTodos()
+
+	err := writeEncode(repoJson.fileName, expectedTodos)
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
+		return
+	}
+
+	err = repoJson.Add(context.Background(), expectedTodos[0])
+	if !errors.Is(err, repo.ErrConflict) {
+		t.Errorf("expected error to wrap '%s' but got '%v'", repo.ErrConflict, err)
+	}
+
+	err = os.WriteFile(fileName, []byte{}, 0664)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err.Error())
+		return
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	repoJson := RepoJsonFile{
+		fileName: fileName,
+	}
+
+	err := writeEncode(repoJson.fileName, makeTodos())
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
+		return
+	}
+
+	_, err = repoJson.Get(context.Background(), "no-such-id")
+	if !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("get: expected error to wrap '%s' but got '%v'", repo.ErrNotFound, err)
+	}
+
+	_, err = repoJson.UpdateData(context.Background(), "no-such-id", "data", repo.AnyVersion)
+	if !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("update-data: expected error to wrap '%s' but got '%v'", repo.ErrNotFound, err)
+	}
+
+	_, err = repoJson.UpdateStatus(context.Background(), "no-such-id", model.StatusDone, repo.AnyVersion)
+	if !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("update-status: expected error to wrap '%s' but got '%v'", repo.ErrNotFound, err)
+	}
+
+	_, err = repoJson.Remove(context.Background(), "no-such-id", repo.AnyVersion)
+	if !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("remove: expected error to wrap '%s' but got '%v'", repo.ErrNotFound, err)
+	}
+
+	err = os.WriteFile(fileName, []byte{}, 0664)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err.Error())
+		return
+	}
 }
 
 func TestUpdateDataHappy(t *testing.T) {
@@ -259,7 +338,7 @@ func TestUpdateDataHappy(t *testing.T) {
 
 	newData := "pak"
 
-	_, err = repo.UpdateData(nil, updateTo.Id, newData)
+	_, err = repo.UpdateData(context.Background(), updateTo.Id, newData, 0)
 	if err != nil {
 		t.Errorf("unexpected err: %s", err)
 		return
@@ -308,7 +387,7 @@ func TestUpdateStatusHappy(t *testing.T) {
 		return
 	}
 
-	_, err = repo.UpdateStatus(nil, updateTo.Id, newStatus)
+	_, err = repo.UpdateStatus(context.Background(), updateTo.Id, newStatus, 0)
 	if err != nil {
 		t.Errorf("unexpected err: %s", err.Error())
 	}
@@ -346,7 +425,7 @@ func TestDeleteHappy(t *testing.T) {
 		return
 	}
 
-	_, err = repo.Remove(nil, deleteToID)
+	_, err = repo.Remove(context.Background(), deleteToID, 0)
 	if err != nil {
 		t.Errorf("unexpected err: %s", err.Error())
 		return
@@ -360,8 +439,33 @@ func TestDeleteHappy(t *testing.T) {
 
 	lengthTodos := len(todos)
 
+	// the removed todo stays in the file, in the trash
+	if lengthTodos != lengthExpectedTodos {
+		t.Errorf("unexpected length-todos != '%v'", lengthTodos)
+		return
+	}
+
+	for _, v := range todos {
+		if deleteToID == v.Id && v.DeletedAt == nil {
+			t.Errorf("expected deleted_at on removed id: '%s'", deleteToID)
+		}
+	}
+
+	_, err = repo.Purge(context.Background(), deleteToID)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err.Error())
+		return
+	}
+
+	todos, err = readDecode(fileName)
+	if err != nil {
+		t.Errorf("unexpected err: %s", err.Error())
+		return
+	}
+
+	lengthTodos = len(todos)
+
 	if lengthTodos != lengthExpectedTodos-1 {
-		//t.Errorf("expected length-todos = '%v' but got length-todos != '%v'", lengthTodos, lengthTodos)
 		t.Errorf("unexpected length-todos != '%v'", lengthTodos)
 		return
 	}
@@ -392,7 +496,7 @@ func TestGetAll(t *testing.T) {
 		return
 	}
 
-	todos, err := repo.GetAll(nil)
+	todos, err := repo.GetAll(context.Background())
 	if err != nil {
 		t.Errorf("unexpected err: %s", err.Error())
 		return
@@ -431,7 +535,7 @@ func TestGet(t *testing.T) {
 		return
 	}
 
-	todo, err := repo.Get(nil, get.Id)
+	todo, err := repo.Get(context.Background(), get.Id)
 	if err != nil {
 		t.Errorf("unexpected err: %s", err.Error())
 		return
@@ -468,7 +572,7 @@ func TestGetSatatus(t *testing.T) {
 	var allStatus model.Status
 	switch allStatus {
 	case TODO:
-		todosStatusTodo, err := repo.GetByStatus(nil, TODO)
+		todosStatusTodo, err := repo.GetByStatus(context.Background(), TODO)
 		if err != nil {
 			t.Errorf("unexpected err: `%s`", err.Error())
 		}
@@ -482,7 +586,7 @@ func TestGetSatatus(t *testing.T) {
 		fallthrough
 
 	default:
-		todosStatusDone, err := repo.GetByStatus(nil, DONE)
+		todosStatusDone, err := repo.GetByStatus(context.Background(), DONE)
 		if err != nil {
 			t.Errorf("unexpected err: `%s`", err.Error())
 		}
@@ -519,7 +623,7 @@ func TestGetSatatus2(t *testing.T) {
 		return
 	}
 
-	todosStatusTodo, err := repo.GetByStatus(nil, statusTODO)
+	todosStatusTodo, err := repo.GetByStatus(context.Background(), statusTODO)
 	if err != nil {
 		t.Errorf("unexpected err: `%s`", err.Error())
 	}
@@ -530,7 +634,7 @@ func TestGetSatatus2(t *testing.T) {
 		}
 	}
 
-	todosStatusDone, err := repo.GetByStatus(nil, statusDONE)
+	todosStatusDone, err := repo.GetByStatus(context.Background(), statusDONE)
 	if err != nil {
 		t.Errorf("unexpected err: `%s`", err.Error())
 	}
@@ -548,3 +652,15 @@ func TestGetSatatus2(t *testing.T) {
 	}
 
 }
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(opts ...repo.Option) repo.Repository {
+		return New(filepath.Join(t.TempDir(), "todo.json"), opts...)
+	})
+}
+
+// separate instances on one file behave like the api and cli sharing it
+func TestConcurrentAddInstances(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "todo.json")
+	repotest.ConcurrentAdd(t, New(fname), New(fname), New(fname))
+}
@@ -5,19 +5,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"time"
 
 	"github.com/eymyong/todo/model"
 	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/internal/fsutil"
 )
 
 type RepoJsonFileMap struct {
 	fileName string
+	workflow model.Workflow
 }
 
 func readDecode(fileName string) (map[string]model.Todo, error) {
 	b, err := os.ReadFile(fileName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read jsonfile: %w", err)
+		return nil, fmt.Errorf("%w: failed to read jsonfile: %w", repo.ErrStorage, err)
 	}
 
 	if len(b) == 0 {
@@ -27,7 +31,7 @@ func readDecode(fileName string) (map[string]model.Todo, error) {
 	todos := make(map[string]model.Todo)
 	err = json.Unmarshal(b, &todos)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: failed to unmarshal jsonfile: %w", repo.ErrStorage, err)
 	}
 
 	return todos, nil
@@ -36,34 +40,81 @@ func readDecode(fileName string) (map[string]model.Todo, error) {
 func writeEncode(fileName string, data interface{}) error {
 	b, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal: %w", err)
+		return fmt.Errorf("%w: failed to marshal: %w", repo.ErrStorage, err)
 	}
 
-	err = os.WriteFile(fileName, b, 0664)
+	err = fsutil.WriteFile(fileName, b, 0664)
 	if err != nil {
-		return fmt.Errorf("failed to writefile jsonfile: %w", err)
+		return fmt.Errorf("%w: failed to writefile jsonfile: %w", repo.ErrStorage, err)
 	}
 
 	return nil
 }
-func (j *RepoJsonFileMap) Add(_ context.Context, todo model.Todo) error {
+
+// lock serializes read-modify-write cycles on the file
+// across goroutines and processes
+func (j *RepoJsonFileMap) lock(ctx context.Context) (func(), error) {
+	unlock, err := fsutil.Lock(ctx, j.fileName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to lock jsonfile: %w", repo.ErrStorage, err)
+	}
+
+	return unlock, nil
+}
+
+func (j *RepoJsonFileMap) Add(ctx context.Context, todo model.Todo) error {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if todo.Status == "" {
+		todo.Status = j.workflow.InitialStatus()
+	}
+
+	err = repo.Validate(j.workflow, todo)
+	if err != nil {
+		return err
+	}
+
 	todoMap, err := readDecode(j.fileName)
 	if err != nil {
 		return err
 	}
 
+	_, ok := todoMap[todo.Id]
+	if ok {
+		return fmt.Errorf("%w: duplicate id '%s'", repo.ErrConflict, todo.Id)
+	}
+
+	todo.Created(j.workflow, model.Now())
 	todoMap[todo.Id] = todo
-	writeEncode(j.fileName, todoMap)
+	err = writeEncode(j.fileName, todoMap)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func (j *RepoJsonFileMap) GetAll(_ context.Context) ([]model.Todo, error) {
+func (j *RepoJsonFileMap) GetAll(ctx context.Context) ([]model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
 	todoMap, err := readDecode(j.fileName)
 	if err != nil {
 		return []model.Todo{}, err
 	}
 
+	return repo.Live(sortedTodos(todoMap)), nil
+}
+
+// sortedTodos returns the todos in todoMap ordered by id,
+// so listings do not depend on map iteration order
+func sortedTodos(todoMap map[string]model.Todo) []model.Todo {
 	todoList := make([]model.Todo, len(todoMap))
 
 	i := 0
@@ -72,24 +123,38 @@ func (j *RepoJsonFileMap) GetAll(_ context.Context) ([]model.Todo, error) {
 		i++
 	}
 
-	return todoList, nil
+	sort.Slice(todoList, func(i, j int) bool {
+		return todoList[i].Id < todoList[j].Id
+	})
+
+	return todoList
 }
 
-func (j *RepoJsonFileMap) Get(_ context.Context, id string) (model.Todo, error) {
+func (j *RepoJsonFileMap) Get(ctx context.Context, id string) (model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return model.Todo{}, err
+	}
+
 	todoMap, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, err
 	}
 
 	todo, ok := todoMap[id]
-	if !ok {
-		return model.Todo{}, fmt.Errorf("no id: %s", id)
+	if !ok || todo.IsDeleted() {
+		return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
 	}
 
 	return todo, nil
 }
 
-func (j *RepoJsonFileMap) GetByStatus(_ context.Context, status model.Status) ([]model.Todo, error) {
+func (j *RepoJsonFileMap) GetByStatus(ctx context.Context, status model.Status) ([]model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
 	todoMap, err := readDecode(j.fileName)
 	if err != nil {
 		return []model.Todo{}, err
@@ -97,15 +162,15 @@ func (j *RepoJsonFileMap) GetByStatus(_ context.Context, status model.Status) ([
 
 	// if todoMap = map[]  จะให้ return err ออกเลยและแจ้งว่า `no data`
 
-	checkStatus := status.IsValid()
-	if !checkStatus {
-		return []model.Todo{}, fmt.Errorf("bad status: `%s`", status)
+	err = repo.ValidateStatus(j.workflow, status)
+	if err != nil {
+		return []model.Todo{}, err
 	}
 
 	newTodos := []model.Todo{}
 
-	for _, todo := range todoMap {
-		if todo.Status == status {
+	for _, todo := range sortedTodos(todoMap) {
+		if todo.Status == status && !todo.IsDeleted() {
 			newTodos = append(newTodos, todo)
 		}
 	}
@@ -113,19 +178,56 @@ func (j *RepoJsonFileMap) GetByStatus(_ context.Context, status model.Status) ([
 	return newTodos, nil
 }
 
-func (j *RepoJsonFileMap) UpdateData(_ context.Context, id string, newData string) (model.Todo, error) {
+func (j *RepoJsonFileMap) Query(ctx context.Context, filter repo.Filter) (repo.Page, error) {
+	err := ctx.Err()
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	filter, err = repo.ValidateFilter(j.workflow, filter)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	todoMap, err := readDecode(j.fileName)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	matched := []model.Todo{}
+	for _, todo := range todoMap {
+		if filter.Match(todo) {
+			matched = append(matched, todo)
+		}
+	}
+
+	return repo.SortPage(matched, filter)
+}
+
+func (j *RepoJsonFileMap) UpdateData(ctx context.Context, id string, newData string, version int64) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
 	todoMap, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, err
 	}
 
 	old, ok := todoMap[id]
-	if !ok {
-		return model.Todo{}, fmt.Errorf("no id: %s", id)
+	if !ok || old.IsDeleted() {
+		return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
+	}
+
+	err = repo.CheckVersion(old, version)
+	if err != nil {
+		return model.Todo{}, err
 	}
 
 	copy := old
-	copy.Data = newData
+	copy.SetData(newData, model.Now())
 	todoMap[id] = copy
 
 	err = writeEncode(j.fileName, todoMap)
@@ -136,19 +238,40 @@ func (j *RepoJsonFileMap) UpdateData(_ context.Context, id string, newData strin
 	return old, nil
 }
 
-func (j *RepoJsonFileMap) UpdateStatus(_ context.Context, id string, newStatus model.Status) (model.Todo, error) {
+func (j *RepoJsonFileMap) UpdateStatus(ctx context.Context, id string, newStatus model.Status, version int64) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
+	err = repo.ValidateStatus(j.workflow, newStatus)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
 	todoMap, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, err
 	}
 
 	old, ok := todoMap[id]
-	if !ok {
-		return model.Todo{}, fmt.Errorf("no id: %s", id)
+	if !ok || old.IsDeleted() {
+		return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
+	}
+
+	err = repo.CheckVersion(old, version)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = repo.CheckTransition(j.workflow, old.Status, newStatus)
+	if err != nil {
+		return model.Todo{}, err
 	}
 
 	copy := old
-	copy.Status = newStatus
+	copy.SetStatus(j.workflow, newStatus, model.Now())
 	todoMap[id] = copy
 
 	err = writeEncode(j.fileName, todoMap)
@@ -159,37 +282,231 @@ func (j *RepoJsonFileMap) UpdateStatus(_ context.Context, id string, newStatus m
 	return old, nil
 }
 
-func (j *RepoJsonFileMap) Remove(_ context.Context, id string) (model.Todo, error) {
+func (j *RepoJsonFileMap) Update(ctx context.Context, todo model.Todo) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
+	err = repo.Validate(j.workflow, todo)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todoMap, err := readDecode(j.fileName)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	old, ok := todoMap[todo.Id]
+	if !ok || old.IsDeleted() {
+		return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, todo.Id)
+	}
+
+	err = repo.CheckVersion(old, todo.Version)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = repo.CheckTransition(j.workflow, old.Status, todo.Status)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	copy := old
+	copy.Apply(j.workflow, todo, model.Now())
+	todoMap[todo.Id] = copy
+
+	err = writeEncode(j.fileName, todoMap)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return old, nil
+}
+
+func (j *RepoJsonFileMap) Remove(ctx context.Context, id string, version int64) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
 	todoMap, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, err
 	}
 
 	todo, ok := todoMap[id]
-	if !ok {
-		return model.Todo{}, fmt.Errorf("no id: %s", id)
+	if !ok || todo.IsDeleted() {
+		return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
 	}
 
-	//delete(todoMap, id)
-	// ถ้าใช้ delete ไม่ต้องทำข้างล่าง
+	err = repo.CheckVersion(todo, version)
+	if err != nil {
+		return model.Todo{}, err
+	}
 
-	todos := []model.Todo{}
-	for _, v := range todoMap {
-		if v.Id == id {
-			continue
-		}
+	copy := todo
+	copy.Delete(model.Now())
+	todoMap[id] = copy
+
+	err = writeEncode(j.fileName, todoMap)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return todo, nil
+}
 
-		todos = append(todos, v)
+// todoMap is the file content for todos, keyed by id
+func todoMap(todos []model.Todo) map[string]model.Todo {
+	todoMap := make(map[string]model.Todo, len(todos))
+	for _, todo := range todos {
+		todoMap[todo.Id] = todo
 	}
 
-	newTodoMap := make(map[string]model.Todo)
-	for i := range todos {
-		value := todos[i]
-		key := value.Id
-		newTodoMap[key] = value
+	return todoMap
+}
+
+func (j *RepoJsonFileMap) AddMany(ctx context.Context, todos []model.Todo) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer unlock()
 
-	err = writeEncode(j.fileName, newTodoMap)
+	stored, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	todoList, err := repo.AddTodos(j.workflow, sortedTodos(stored), todos, model.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeEncode(j.fileName, todoMap(todoList))
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.PickTodos(todoList, repo.TodoIds(todos)), nil
+}
+
+func (j *RepoJsonFileMap) UpdateMany(ctx context.Context, todos []model.Todo) ([]model.Todo, []model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	stored, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	todoList, old, err := repo.UpdateTodos(j.workflow, sortedTodos(stored), todos, model.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = writeEncode(j.fileName, todoMap(todoList))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return repo.PickTodos(todoList, repo.TodoIds(todos)), old, nil
+}
+
+func (j *RepoJsonFileMap) RemoveMany(ctx context.Context, ids []string) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	stored, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	todoList, removed, err := repo.RemoveTodos(sortedTodos(stored), ids, model.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeEncode(j.fileName, todoMap(todoList))
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+func (j *RepoJsonFileMap) Trash(ctx context.Context) ([]model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	todoMap, err := readDecode(j.fileName)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return repo.TrashTodos(sortedTodos(todoMap)), nil
+}
+
+func (j *RepoJsonFileMap) Restore(ctx context.Context, id string) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
+	todoMap, err := readDecode(j.fileName)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todo, ok := todoMap[id]
+	if !ok || !todo.IsDeleted() {
+		return model.Todo{}, repo.NotInTrash(id)
+	}
+
+	todo.Restore(model.Now())
+	todoMap[id] = todo
+
+	err = writeEncode(j.fileName, todoMap)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return todo, nil
+}
+
+func (j *RepoJsonFileMap) Purge(ctx context.Context, id string) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
+	todoMap, err := readDecode(j.fileName)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todo, ok := todoMap[id]
+	if !ok || !todo.IsDeleted() {
+		return model.Todo{}, repo.NotInTrash(id)
+	}
+
+	delete(todoMap, id)
+
+	err = writeEncode(j.fileName, todoMap)
 	if err != nil {
 		return model.Todo{}, err
 	}
@@ -197,7 +514,32 @@ func (j *RepoJsonFileMap) Remove(_ context.Context, id string) (model.Todo, erro
 	return todo, nil
 }
 
-func New(fileName string) repo.Repository {
+func (j *RepoJsonFileMap) PurgeBefore(ctx context.Context, t time.Time) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	stored, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	todoList, purged := repo.PurgeTodosBefore(sortedTodos(stored), t)
+	if len(purged) == 0 {
+		return purged, nil
+	}
+
+	err = writeEncode(j.fileName, todoMap(todoList))
+	if err != nil {
+		return nil, err
+	}
+
+	return purged, nil
+}
+
+func New(fileName string, opts ...repo.Option) repo.Repository {
 	fileBytes, err := os.ReadFile(fileName)
 	if err != nil || len(fileBytes) == 0 {
 		err := os.WriteFile(fileName, []byte("{}"), os.ModePerm)
@@ -208,5 +550,6 @@ func New(fileName string) repo.Repository {
 
 	return &RepoJsonFileMap{
 		fileName: fileName,
+		workflow: repo.NewOptions(opts...).Workflow,
 	}
 }
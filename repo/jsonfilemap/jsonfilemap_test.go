@@ -1,12 +1,18 @@
 package jsonfilemap
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/repotest"
 )
 
 const fileName = "mock/test_foo.json"
@@ -211,7 +217,7 @@ func TestAdd_Happy(t *testing.T) {
 	}
 
 	repo := RepoJsonFileMap{fileName: fileName}
-	err = repo.Add(nil, newData)
+	err = repo.Add(context.Background(), newData)
 	if err != nil {
 		t.Errorf("unexpect err: `%s`", err)
 		return
@@ -270,7 +276,7 @@ func TestGetAll_Happy(t *testing.T) {
 	}
 
 	repo := RepoJsonFileMap{fileName: fileName}
-	newTodos, err := repo.GetAll(nil)
+	newTodos, err := repo.GetAll(context.Background())
 	if err != nil {
 		t.Errorf("unexpected err: `%s`", err)
 		return
@@ -279,7 +285,7 @@ func TestGetAll_Happy(t *testing.T) {
 	for i := range todos {
 		expected := todos[i]
 		actual := newTodos[i]
-		if expected != actual {
+		if !reflect.DeepEqual(expected, actual) {
 			t.Errorf("unexpected value, expecting='%+v', got='%+v'", expected, actual)
 			return
 		}
@@ -327,13 +333,13 @@ func TestGetData_Happy(t *testing.T) {
 	get := todos[0]
 
 	repo := RepoJsonFileMap{fileName: fileName}
-	todo, err := repo.Get(nil, get.Id)
+	todo, err := repo.Get(context.Background(), get.Id)
 	if err != nil {
 		t.Errorf("unexpected err: `%s`", err)
 		return
 	}
 
-	if get != todo {
+	if !reflect.DeepEqual(get, todo) {
 		t.Errorf("expected todo: `%+v` but got %+v", get, todo)
 	}
 
@@ -380,7 +386,7 @@ func TestGetStatus_Happy(t *testing.T) {
 	getStatus := model.StatusTodo
 
 	repo := RepoJsonFileMap{fileName: fileName}
-	todoStatus, err := repo.GetByStatus(nil, getStatus)
+	todoStatus, err := repo.GetByStatus(context.Background(), getStatus)
 	if err != nil {
 		t.Errorf("unexpected err: `%s`", err)
 		return
@@ -394,7 +400,7 @@ func TestGetStatus_Happy(t *testing.T) {
 	for i := range todos {
 		expected := todos[i]
 		actuals := todoStatus[i]
-		if expected != actuals {
+		if !reflect.DeepEqual(expected, actuals) {
 			t.Errorf("unexpected value, expecting='%+v', got='%+v'", expected, actuals)
 			return
 		}
@@ -409,7 +415,23 @@ func TestGetStatus_Happy(t *testing.T) {
 }
 
 func TestGetStatus_Err(t *testing.T) {
-	// status.IsValid()
+	err := writeEncode(fileName, map[string]model.Todo{})
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
+		return
+	}
+
+	repoMap := RepoJsonFileMap{fileName: fileName}
+	_, err = repoMap.GetByStatus(context.Background(), model.Status("foo"))
+	if !errors.Is(err, repo.ErrInvalidStatus) {
+		t.Errorf("expected err to wrap %s but got %v", repo.ErrInvalidStatus, err)
+	}
+
+	err = os.WriteFile(fileName, []byte{}, 0664)
+	if err != nil {
+		t.Errorf("unexpected writefile err: `%s`", err)
+		return
+	}
 }
 
 func TestUpdateData_Happy(t *testing.T) {
@@ -448,7 +470,7 @@ func TestUpdateData_Happy(t *testing.T) {
 	newData := "oneone"
 
 	repo := RepoJsonFileMap{fileName: fileName}
-	_, err = repo.UpdateData(nil, id, newData)
+	_, err = repo.UpdateData(context.Background(), id, newData, 0)
 	if err != nil {
 		t.Errorf("unexpected err: `%s`", err)
 		return
@@ -508,7 +530,7 @@ func TestUpdateStatus_Happy(t *testing.T) {
 	newStatus := model.StatusDone
 
 	repo := RepoJsonFileMap{fileName: fileName}
-	_, err = repo.UpdateStatus(nil, id, newStatus)
+	_, err = repo.UpdateStatus(context.Background(), id, newStatus, 0)
 	if err != nil {
 		t.Errorf("unexpected err: `%s`", err)
 		return
@@ -567,7 +589,7 @@ func TestRemove_Happy(t *testing.T) {
 	id := "1"
 
 	repo := RepoJsonFileMap{fileName: fileName}
-	_, err = repo.Remove(nil, id)
+	_, err = repo.Remove(context.Background(), id, 0)
 	if err != nil {
 		t.Errorf("unexpected err: `%s`", err)
 		return
@@ -579,7 +601,25 @@ func TestRemove_Happy(t *testing.T) {
 		return
 	}
 
-	_, ok := newTodosMap[id]
+	// the removed todo stays in the file, in the trash
+	removed, ok := newTodosMap[id]
+	if !ok || removed.DeletedAt == nil {
+		t.Errorf("expected id: `%s` in the trash", id)
+	}
+
+	_, err = repo.Purge(context.Background(), id)
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
+		return
+	}
+
+	newTodosMap, err = readDecode(repo.fileName)
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
+		return
+	}
+
+	_, ok = newTodosMap[id]
 	if ok {
 		t.Errorf("unexpected found id: `%s` but found id: `%s`", id, newTodosMap[id].Id)
 	}
@@ -628,8 +668,8 @@ func TestRemove_IdErr(t *testing.T) {
 
 	id := "66"
 
-	repo := RepoJsonFileMap{fileName: fileName}
-	_, err = repo.Remove(nil, id)
+	repoMap := RepoJsonFileMap{fileName: fileName}
+	_, err = repoMap.Remove(context.Background(), id, repo.AnyVersion)
 	if err == nil {
 		t.Errorf("expected err but got nil")
 		return
@@ -639,6 +679,10 @@ func TestRemove_IdErr(t *testing.T) {
 		t.Errorf("expected %s but got %s", expectedErr, err)
 	}
 
+	if !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("expected err to wrap %s but got %s", repo.ErrNotFound, err)
+	}
+
 	err = os.WriteFile(fileName, []byte{}, 0664)
 	if err != nil {
 		t.Errorf("unexpected writefile err: `%s`", err)
@@ -646,3 +690,15 @@ func TestRemove_IdErr(t *testing.T) {
 	}
 
 }
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(opts ...repo.Option) repo.Repository {
+		return New(filepath.Join(t.TempDir(), "todo.map.json"), opts...)
+	})
+}
+
+// separate instances on one file behave like the api and cli sharing it
+func TestConcurrentAddInstances(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "todo.map.json")
+	repotest.ConcurrentAdd(t, New(fname), New(fname), New(fname))
+}
@@ -0,0 +1,316 @@
+package repo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eymyong/todo/model"
+)
+
+// SortField is a todo field Query can order by
+type SortField string
+
+const (
+	SortCreatedAt SortField = "created_at"
+	SortUpdatedAt SortField = "updated_at"
+	SortDueAt     SortField = "due_at"
+	SortPriority  SortField = "priority"
+	SortStatus    SortField = "status"
+	SortData      SortField = "data"
+	SortId        SortField = "id"
+)
+
+func (f SortField) IsValid() bool {
+	switch f {
+	case SortCreatedAt, SortUpdatedAt, SortDueAt, SortPriority, SortStatus, SortData, SortId:
+		return true
+	}
+
+	return false
+}
+
+// ParseSort reads a sort order like "due_at" or "-priority",
+// a leading '-' sorts in descending order
+func ParseSort(s string) (SortField, bool, error) {
+	desc := strings.HasPrefix(s, "-")
+	field := SortField(strings.TrimPrefix(s, "-"))
+	if field == "" {
+		field = SortCreatedAt
+	}
+
+	if !field.IsValid() {
+		return "", false, fmt.Errorf("%w: bad sort field '%s'", ErrInvalidQuery, field)
+	}
+
+	return field, desc, nil
+}
+
+// Filter selects, orders and pages the todos returned by Query.
+// The zero value returns every todo, oldest first.
+type Filter struct {
+	// Statuses keeps todos in any of these statuses
+	Statuses []model.Status
+
+	// Text keeps todos whose data or notes contain it, ignoring case
+	Text string
+
+	// Tags keeps todos that have every one of these tags
+	Tags []string
+
+	// DueFrom and DueTo keep todos due in [DueFrom, DueTo).
+	// Todos without a due date are left out when either is set.
+	DueFrom *time.Time
+	DueTo   *time.Time
+
+	// Sort orders the result, ties are broken by id.
+	// Todos without a due date or priority come last in ascending order.
+	Sort SortField
+	Desc bool
+
+	// Limit is the maximum number of todos in a page, 0 means no limit
+	Limit int
+
+	// Cursor is Page.Next of the previous page
+	Cursor string
+}
+
+// Page is one page of Query results
+type Page struct {
+	Todos []model.Todo `json:"todos"`
+
+	// Next is the cursor of the following page, empty on the last page
+	Next string `json:"next,omitempty"`
+}
+
+// Cursor marks the last todo of a page. It keeps the sort key rather than
+// a position, so todos added or removed between pages do not shift it.
+type Cursor struct {
+	Sort SortField `json:"s"`
+	Desc bool      `json:"d,omitempty"`
+	Key  string    `json:"k"`
+	Id   string    `json:"i"`
+}
+
+// NextCursor returns the cursor of the page following last
+func NextCursor(filter Filter, last model.Todo) string {
+	b, _ := json.Marshal(Cursor{
+		Sort: filter.Sort,
+		Desc: filter.Desc,
+		Key:  SortKey(last, filter.Sort),
+		Id:   last.Id,
+	})
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor reads a cursor made by NextCursor
+func DecodeCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: bad cursor: %w", ErrInvalidQuery, err)
+	}
+
+	var c Cursor
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: bad cursor: %w", ErrInvalidQuery, err)
+	}
+
+	return c, nil
+}
+
+// ValidateFilter checks filter against wf and fills in the default sort field
+func ValidateFilter(wf model.Workflow, filter Filter) (Filter, error) {
+	for _, s := range filter.Statuses {
+		err := ValidateStatus(wf, s)
+		if err != nil {
+			return Filter{}, err
+		}
+	}
+
+	if filter.Sort == "" {
+		filter.Sort = SortCreatedAt
+	}
+
+	if !filter.Sort.IsValid() {
+		return Filter{}, fmt.Errorf("%w: bad sort field '%s'", ErrInvalidQuery, filter.Sort)
+	}
+
+	if filter.Limit < 0 {
+		return Filter{}, fmt.Errorf("%w: bad limit %d", ErrInvalidQuery, filter.Limit)
+	}
+
+	if filter.DueFrom != nil && filter.DueTo != nil && filter.DueTo.Before(*filter.DueFrom) {
+		return Filter{}, fmt.Errorf("%w: due range ends before it starts", ErrInvalidQuery)
+	}
+
+	if filter.Cursor != "" {
+		c, err := DecodeCursor(filter.Cursor)
+		if err != nil {
+			return Filter{}, err
+		}
+
+		if c.Sort != filter.Sort || c.Desc != filter.Desc {
+			return Filter{}, fmt.Errorf("%w: cursor is for a different sort order", ErrInvalidQuery)
+		}
+	}
+
+	return filter, nil
+}
+
+// Match reports whether todo passes the status, text, tag and due filters.
+// Todos in the trash never do.
+func (f Filter) Match(todo model.Todo) bool {
+	if todo.IsDeleted() {
+		return false
+	}
+
+	if len(f.Statuses) != 0 {
+		found := false
+		for _, s := range f.Statuses {
+			if todo.Status == s {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if f.Text != "" {
+		text := strings.ToLower(f.Text)
+		if !strings.Contains(strings.ToLower(todo.Data), text) && !strings.Contains(strings.ToLower(todo.Notes), text) {
+			return false
+		}
+	}
+
+	for _, tag := range f.Tags {
+		found := false
+		for _, t := range todo.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if f.DueFrom != nil || f.DueTo != nil {
+		if todo.DueAt == nil {
+			return false
+		}
+
+		if f.DueFrom != nil && todo.DueAt.Before(*f.DueFrom) {
+			return false
+		}
+
+		if f.DueTo != nil && !todo.DueAt.Before(*f.DueTo) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SortTime is the layout of the time sort keys. Times in UTC formatted
+// with it compare in time order as strings.
+const SortTime = "2006-01-02T15:04:05.000000000Z"
+
+// SortKey returns the value todo is ordered by, as a string that compares
+// in the wanted order. Missing due dates and priorities sort last.
+func SortKey(todo model.Todo, field SortField) string {
+	switch field {
+	case SortCreatedAt:
+		return todo.CreatedAt.UTC().Format(SortTime)
+	case SortUpdatedAt:
+		return todo.UpdatedAt.UTC().Format(SortTime)
+	case SortDueAt:
+		if todo.DueAt == nil {
+			return "~"
+		}
+
+		return todo.DueAt.UTC().Format(SortTime)
+	case SortPriority:
+		if todo.Priority == model.PriorityNone {
+			return "~"
+		}
+
+		return fmt.Sprintf("%02d", todo.Priority)
+	case SortStatus:
+		return string(todo.Status)
+	case SortData:
+		return strings.ToLower(todo.Data)
+	}
+
+	return ""
+}
+
+func less(keyA, idA, keyB, idB string, desc bool) bool {
+	if keyA != keyB {
+		return (keyA < keyB) != desc
+	}
+
+	if idA == idB {
+		return false
+	}
+
+	return (idA < idB) != desc
+}
+
+// ApplyFilter matches, sorts and pages todos.
+// Backends that cannot filter natively load their todos and call it;
+// filter must have gone through ValidateFilter.
+func ApplyFilter(todos []model.Todo, filter Filter) (Page, error) {
+	matched := []model.Todo{}
+	for _, todo := range todos {
+		if filter.Match(todo) {
+			matched = append(matched, todo)
+		}
+	}
+
+	return SortPage(matched, filter)
+}
+
+// SortPage sorts todos that already match filter and cuts the page
+// after filter.Cursor
+func SortPage(todos []model.Todo, filter Filter) (Page, error) {
+	keys := make(map[string]string, len(todos))
+	for _, todo := range todos {
+		keys[todo.Id] = SortKey(todo, filter.Sort)
+	}
+
+	sort.Slice(todos, func(i, j int) bool {
+		a, b := todos[i], todos[j]
+		return less(keys[a.Id], a.Id, keys[b.Id], b.Id, filter.Desc)
+	})
+
+	if filter.Cursor != "" {
+		c, err := DecodeCursor(filter.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+
+		start := sort.Search(len(todos), func(i int) bool {
+			return less(c.Key, c.Id, keys[todos[i].Id], todos[i].Id, filter.Desc)
+		})
+
+		todos = todos[start:]
+	}
+
+	page := Page{Todos: todos}
+	if filter.Limit > 0 && len(todos) > filter.Limit {
+		page.Todos = todos[:filter.Limit]
+		page.Next = NextCursor(filter, page.Todos[len(page.Todos)-1])
+	}
+
+	return page, nil
+}
@@ -2,6 +2,8 @@ package repo
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/eymyong/todo/model"
 )
@@ -11,7 +13,111 @@ type Repository interface {
 	GetAll(ctx context.Context) ([]model.Todo, error)
 	Get(ctx context.Context, id string) (model.Todo, error)
 	GetByStatus(ctx context.Context, status model.Status) ([]model.Todo, error)
-	UpdateData(ctx context.Context, id string, newdata string) (model.Todo, error)
-	UpdateStatus(ctx context.Context, id string, status model.Status) (model.Todo, error)
-	Remove(ctx context.Context, id string) (model.Todo, error)
+	// Query returns one page of the todos matching filter, see Filter
+	Query(ctx context.Context, filter Filter) (Page, error)
+	// UpdateData, UpdateStatus and Remove fail with ErrVersionMismatch
+	// unless the todo is at version, or version is AnyVersion
+	UpdateData(ctx context.Context, id string, newdata string, version int64) (model.Todo, error)
+	UpdateStatus(ctx context.Context, id string, status model.Status, version int64) (model.Todo, error)
+	// Update replaces the editable fields (data, status, due date, priority,
+	// tags and notes) of the todo with todo.Id, and returns the previous todo.
+	// todo.Version is checked as the version of UpdateData.
+	Update(ctx context.Context, todo model.Todo) (model.Todo, error)
+	Remove(ctx context.Context, id string, version int64) (model.Todo, error)
+
+	// AddMany, UpdateMany and RemoveMany are Add, Update and Remove for
+	// many todos in one write, all or nothing, see BatchError.
+	// AddMany and UpdateMany return the todos as stored by the write, and
+	// UpdateMany and RemoveMany the previous todos, in batch order.
+	AddMany(ctx context.Context, todos []model.Todo) ([]model.Todo, error)
+	UpdateMany(ctx context.Context, todos []model.Todo) ([]model.Todo, []model.Todo, error)
+	RemoveMany(ctx context.Context, ids []string) ([]model.Todo, error)
+
+	// Remove and RemoveMany move todos to the trash, see trash.go. Only the
+	// methods below see todos in the trash, the others act as if they were
+	// gone, but for their ids staying taken.
+
+	// Trash returns the todos in the trash, the last removed first
+	Trash(ctx context.Context) ([]model.Todo, error)
+	// Restore takes the todo with id out of the trash and returns it
+	Restore(ctx context.Context, id string) (model.Todo, error)
+	// Purge deletes the todo with id from the trash for good and returns it
+	Purge(ctx context.Context, id string) (model.Todo, error)
+	// PurgeBefore deletes for good the todos removed before t and returns
+	// them, in the order of Trash
+	PurgeBefore(ctx context.Context, t time.Time) ([]model.Todo, error)
+}
+
+// AnyVersion is the version to write a todo whatever its version
+const AnyVersion int64 = 0
+
+// Options holds the settings shared by every Repository implementation
+type Options struct {
+	// Workflow decides the valid statuses and transitions.
+	// The zero value is model.DefaultWorkflow.
+	Workflow model.Workflow
+}
+
+type Option func(*Options)
+
+// WithWorkflow makes the repository enforce wf
+func WithWorkflow(wf model.Workflow) Option {
+	return func(o *Options) {
+		o.Workflow = wf
+	}
+}
+
+// NewOptions applies opts in order for backend constructors
+func NewOptions(opts ...Option) Options {
+	o := Options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// Validate checks the caller-supplied fields of todo before it is stored
+func Validate(wf model.Workflow, todo model.Todo) error {
+	if !wf.IsValid(todo.Status) {
+		return fmt.Errorf("%w: bad status: `%s`", ErrInvalidStatus, todo.Status)
+	}
+
+	if !todo.Priority.IsValid() {
+		return fmt.Errorf("%w: bad priority: %d", ErrInvalidTodo, todo.Priority)
+	}
+
+	return nil
+}
+
+// ValidateStatus checks that status is part of wf
+func ValidateStatus(wf model.Workflow, status model.Status) error {
+	if !wf.IsValid(status) {
+		return fmt.Errorf("%w: bad status: `%s`", ErrInvalidStatus, status)
+	}
+
+	return nil
+}
+
+// CheckTransition checks that wf allows a todo to move from one status to another
+func CheckTransition(wf model.Workflow, from model.Status, to model.Status) error {
+	err := ValidateStatus(wf, to)
+	if err != nil {
+		return err
+	}
+
+	if !wf.CanTransition(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+
+	return nil
+}
+
+// CheckVersion checks that todo is at version, see ErrVersionMismatch
+func CheckVersion(todo model.Todo, version int64) error {
+	if version != AnyVersion && version != todo.Version {
+		return fmt.Errorf("%w: todo '%s' is at version %d, not %d", ErrVersionMismatch, todo.Id, todo.Version, version)
+	}
+
+	return nil
 }
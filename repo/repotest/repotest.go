@@ -0,0 +1,1833 @@
+// Package repotest is a backend-agnostic conformance suite for repo.Repository.
+//
+// Each backend runs it from its own test file:
+//
+//	func TestConformance(t *testing.T) {
+//		repotest.Run(t, func(opts ...repo.Option) repo.Repository {
+//			return jsonfile.New(filepath.Join(t.TempDir(), "todo.json"), opts...)
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+)
+
+// Run runs the full behavioral contract of repo.Repository.
+// newRepo must return a new, empty repository configured with opts
+// every time it is called. Tests named in skip are skipped, for storage
+// formats that cannot meet them (e.g. todo.txt only keeps dates).
+func Run(t *testing.T, newRepo func(opts ...repo.Option) repo.Repository, skip ...string) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, repo.Repository)
+	}{
+		{"EmptyStore", testEmptyStore},
+		{"AddGet", testAddGet},
+		{"AddDuplicate", testAddDuplicate},
+		{"AddInitialStatus", testAddInitialStatus},
+		{"GetMissing", testGetMissing},
+		{"GetAll", testGetAll},
+		{"GetByStatus", testGetByStatus},
+		{"GetByStatusInvalid", testGetByStatusInvalid},
+		{"UpdateData", testUpdateData},
+		{"UpdateDataMissing", testUpdateDataMissing},
+		{"UpdateStatus", testUpdateStatus},
+		{"UpdateStatusMissing", testUpdateStatusMissing},
+		{"UpdateStatusInvalid", testUpdateStatusInvalid},
+		{"Remove", testRemove},
+		{"RemoveMissing", testRemoveMissing},
+		{"Update", testUpdate},
+		{"UpdateMissing", testUpdateMissing},
+		{"UpdateInvalid", testUpdateInvalid},
+		{"Timestamps", testTimestamps},
+		{"KeepTimestamps", testKeepTimestamps},
+		{"Versions", testVersions},
+		{"Ordering", testOrdering},
+		{"QueryAll", testQueryAll},
+		{"QueryFilter", testQueryFilter},
+		{"QuerySort", testQuerySort},
+		{"QueryPages", testQueryPages},
+		{"QueryInvalid", testQueryInvalid},
+		{"AddMany", testAddMany},
+		{"AddManyAtomic", testAddManyAtomic},
+		{"UpdateMany", testUpdateMany},
+		{"UpdateManyAtomic", testUpdateManyAtomic},
+		{"RemoveMany", testRemoveMany},
+		{"RemoveManyAtomic", testRemoveManyAtomic},
+		{"Trash", testTrash},
+		{"TrashOrder", testTrashOrder},
+		{"Restore", testRestore},
+		{"Purge", testPurge},
+		{"PurgeBefore", testPurgeBefore},
+		{"ConcurrentAdd", func(t *testing.T, r repo.Repository) { ConcurrentAdd(t, r) }},
+		{"Canceled", testCanceled},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if slices.Contains(skip, tc.name) {
+				t.Skip("not supported by this backend")
+			}
+
+			tc.fn(t, newRepo())
+		})
+	}
+
+	workflowTests := []struct {
+		name string
+		fn   func(*testing.T, repo.Repository)
+	}{
+		{"WorkflowAddInitialStatus", testWorkflowAddInitialStatus},
+		{"WorkflowGetByStatus", testWorkflowGetByStatus},
+		{"WorkflowTransitions", testWorkflowTransitions},
+		{"WorkflowUpdateTransition", testWorkflowUpdateTransition},
+		{"WorkflowCompleted", testWorkflowCompleted},
+	}
+
+	for _, tc := range workflowTests {
+		t.Run(tc.name, func(t *testing.T) {
+			if slices.Contains(skip, tc.name) {
+				t.Skip("not supported by this backend")
+			}
+
+			tc.fn(t, newRepo(repo.WithWorkflow(testWorkflow)))
+		})
+	}
+}
+
+// testWorkflow is the workflow the Workflow* tests run with
+var testWorkflow = model.Workflow{
+	Initial:   model.StatusTodo,
+	Statuses:  []model.Status{model.StatusTodo, model.StatusInProgress, model.StatusBlocked, model.StatusDone, model.StatusCancelled},
+	Completed: []model.Status{model.StatusDone, model.StatusCancelled},
+	Transitions: map[model.Status][]model.Status{
+		model.StatusTodo:       {model.StatusInProgress, model.StatusCancelled},
+		model.StatusInProgress: {model.StatusBlocked, model.StatusDone, model.StatusCancelled},
+		model.StatusBlocked:    {model.StatusInProgress, model.StatusCancelled},
+		model.StatusDone:       {model.StatusTodo},
+		model.StatusCancelled:  {model.StatusTodo},
+	},
+}
+
+func makeTodos() []model.Todo {
+	due := time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC)
+
+	return []model.Todo{
+		{Id: "1", Data: "one", Status: model.StatusTodo},
+		{Id: "2", Data: "two", Status: model.StatusDone},
+		{
+			Id:       "3",
+			Data:     "three",
+			Status:   model.StatusTodo,
+			DueAt:    &due,
+			Priority: model.PriorityHigh,
+			Tags:     []string{"work", "home"},
+			Notes:    "some notes",
+		},
+	}
+}
+
+func seed(t *testing.T, r repo.Repository, todos []model.Todo) {
+	t.Helper()
+
+	for _, todo := range todos {
+		err := r.Add(context.Background(), todo)
+		if err != nil {
+			t.Fatalf("unexpected err adding '%s': %s", todo.Id, err)
+		}
+	}
+}
+
+func assertErrorIs(t *testing.T, err error, target error) {
+	t.Helper()
+
+	if !errors.Is(err, target) {
+		t.Errorf("expected err to wrap '%s' but got '%v'", target, err)
+	}
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Equal(*b)
+}
+
+// assertTodo compares the fields callers control.
+// Timestamps maintained by the repository are checked by testTimestamps.
+func assertTodo(t *testing.T, expected model.Todo, actual model.Todo) {
+	t.Helper()
+
+	same := expected.Id == actual.Id &&
+		expected.Data == actual.Data &&
+		expected.Status == actual.Status &&
+		sameTime(expected.DueAt, actual.DueAt) &&
+		expected.Priority == actual.Priority &&
+		fmt.Sprint(expected.Tags) == fmt.Sprint(actual.Tags) &&
+		expected.Notes == actual.Notes
+
+	if !same {
+		t.Errorf("unexpected value, expecting='%+v', got='%+v'", expected, actual)
+	}
+}
+
+func ids(todos []model.Todo) []string {
+	result := make([]string, len(todos))
+	for i := range todos {
+		result[i] = todos[i].Id
+	}
+
+	return result
+}
+
+func testEmptyStore(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+
+	todos, err := r.GetAll(ctx)
+	if err != nil {
+		t.Errorf("get-all: unexpected err: %s", err)
+	}
+	if len(todos) != 0 {
+		t.Errorf("get-all: expected empty store but got %d todos", len(todos))
+	}
+
+	todos, err = r.GetByStatus(ctx, model.StatusTodo)
+	if err != nil {
+		t.Errorf("get-by-status: unexpected err: %s", err)
+	}
+	if len(todos) != 0 {
+		t.Errorf("get-by-status: expected empty store but got %d todos", len(todos))
+	}
+
+	_, err = r.Get(ctx, "1")
+	assertErrorIs(t, err, repo.ErrNotFound)
+}
+
+func testAddGet(t *testing.T, r repo.Repository) {
+	expecteds := makeTodos()
+	seed(t, r, expecteds)
+
+	for _, expected := range expecteds {
+		actual, err := r.Get(context.Background(), expected.Id)
+		if err != nil {
+			t.Errorf("unexpected err getting '%s': %s", expected.Id, err)
+			continue
+		}
+
+		assertTodo(t, expected, actual)
+	}
+}
+
+func testAddDuplicate(t *testing.T, r repo.Repository) {
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	dup := todos[0]
+	dup.Data = "duplicate"
+	err := r.Add(context.Background(), dup)
+	assertErrorIs(t, err, repo.ErrConflict)
+
+	actual, err := r.Get(context.Background(), dup.Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, todos[0], actual)
+}
+
+func testAddInitialStatus(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	err := r.Add(ctx, model.Todo{Id: "1", Data: "one"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	actual, err := r.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, model.Todo{Id: "1", Data: "one", Status: model.StatusTodo}, actual)
+}
+
+func testGetMissing(t *testing.T, r repo.Repository) {
+	seed(t, r, makeTodos())
+
+	_, err := r.Get(context.Background(), "no-such-id")
+	assertErrorIs(t, err, repo.ErrNotFound)
+}
+
+func testGetAll(t *testing.T, r repo.Repository) {
+	expecteds := makeTodos()
+	seed(t, r, expecteds)
+
+	actuals, err := r.GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(actuals) != len(expecteds) {
+		t.Fatalf("unexpected length '%d', expecting '%d'", len(actuals), len(expecteds))
+	}
+
+	found := make(map[string]model.Todo)
+	for _, actual := range actuals {
+		if _, ok := found[actual.Id]; ok {
+			t.Errorf("duplicate id '%s' in get-all", actual.Id)
+		}
+
+		found[actual.Id] = actual
+	}
+
+	for _, expected := range expecteds {
+		actual, ok := found[expected.Id]
+		if !ok {
+			t.Errorf("missing id '%s' in get-all", expected.Id)
+			continue
+		}
+
+		assertTodo(t, expected, actual)
+	}
+}
+
+func testGetByStatus(t *testing.T, r repo.Repository) {
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	for _, status := range []model.Status{model.StatusTodo, model.StatusDone} {
+		expected := 0
+		for _, todo := range todos {
+			if todo.Status == status {
+				expected++
+			}
+		}
+
+		actuals, err := r.GetByStatus(context.Background(), status)
+		if err != nil {
+			t.Errorf("unexpected err for status '%s': %s", status, err)
+			continue
+		}
+
+		if len(actuals) != expected {
+			t.Errorf("unexpected length '%d' for status '%s', expecting '%d'", len(actuals), status, expected)
+		}
+
+		for _, actual := range actuals {
+			if actual.Status != status {
+				t.Errorf("expected status '%s' but got '%s'", status, actual.Status)
+			}
+		}
+	}
+}
+
+func testGetByStatusInvalid(t *testing.T, r repo.Repository) {
+	seed(t, r, makeTodos())
+
+	_, err := r.GetByStatus(context.Background(), model.Status("foo"))
+	assertErrorIs(t, err, repo.ErrInvalidStatus)
+}
+
+func testUpdateData(t *testing.T, r repo.Repository) {
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	ctx := context.Background()
+	old, err := r.UpdateData(ctx, todos[1].Id, "new data", repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, todos[1], old)
+
+	expected := todos[1]
+	expected.Data = "new data"
+
+	actual, err := r.Get(ctx, expected.Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, expected, actual)
+
+	// other todos must be left untouched
+	for _, todo := range []model.Todo{todos[0], todos[2]} {
+		actual, err := r.Get(ctx, todo.Id)
+		if err != nil {
+			t.Errorf("unexpected err: %s", err)
+			continue
+		}
+
+		assertTodo(t, todo, actual)
+	}
+}
+
+func testUpdateDataMissing(t *testing.T, r repo.Repository) {
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	ctx := context.Background()
+	_, err := r.UpdateData(ctx, "no-such-id", "new data", repo.AnyVersion)
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	_, err = r.Get(ctx, "no-such-id")
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	all, err := r.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(all) != len(todos) {
+		t.Errorf("unexpected length '%d', expecting '%d'", len(all), len(todos))
+	}
+}
+
+func testUpdateStatus(t *testing.T, r repo.Repository) {
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	ctx := context.Background()
+	old, err := r.UpdateStatus(ctx, todos[0].Id, model.StatusDone, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, todos[0], old)
+
+	expected := todos[0]
+	expected.Status = model.StatusDone
+
+	actual, err := r.Get(ctx, expected.Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, expected, actual)
+}
+
+func testUpdateStatusMissing(t *testing.T, r repo.Repository) {
+	seed(t, r, makeTodos())
+
+	ctx := context.Background()
+	_, err := r.UpdateStatus(ctx, "no-such-id", model.StatusDone, repo.AnyVersion)
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	_, err = r.Get(ctx, "no-such-id")
+	assertErrorIs(t, err, repo.ErrNotFound)
+}
+
+func testUpdateStatusInvalid(t *testing.T, r repo.Repository) {
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	ctx := context.Background()
+	_, err := r.UpdateStatus(ctx, todos[0].Id, model.Status("foo"), repo.AnyVersion)
+	assertErrorIs(t, err, repo.ErrInvalidStatus)
+
+	actual, err := r.Get(ctx, todos[0].Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, todos[0], actual)
+}
+
+func testUpdate(t *testing.T, r repo.Repository) {
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	due := time.Date(2026, 12, 24, 18, 0, 0, 0, time.UTC)
+	expected := model.Todo{
+		Id:       todos[0].Id,
+		Data:     "new data",
+		Status:   model.StatusDone,
+		DueAt:    &due,
+		Priority: model.PriorityLow,
+		Tags:     []string{"errand"},
+		Notes:    "new notes",
+	}
+
+	ctx := context.Background()
+	old, err := r.Update(ctx, expected)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, todos[0], old)
+
+	actual, err := r.Get(ctx, expected.Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, expected, actual)
+
+	// clearing optional fields
+	expected = model.Todo{Id: todos[2].Id, Data: todos[2].Data, Status: todos[2].Status}
+	_, err = r.Update(ctx, expected)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	actual, err = r.Get(ctx, expected.Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, expected, actual)
+}
+
+func testUpdateMissing(t *testing.T, r repo.Repository) {
+	seed(t, r, makeTodos())
+
+	ctx := context.Background()
+	_, err := r.Update(ctx, model.Todo{Id: "no-such-id", Data: "data", Status: model.StatusTodo})
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	_, err = r.Get(ctx, "no-such-id")
+	assertErrorIs(t, err, repo.ErrNotFound)
+}
+
+func testUpdateInvalid(t *testing.T, r repo.Repository) {
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	ctx := context.Background()
+	invalid := todos[0]
+	invalid.Priority = model.PriorityMax + 1
+
+	_, err := r.Update(ctx, invalid)
+	assertErrorIs(t, err, repo.ErrInvalidTodo)
+
+	invalid = todos[0]
+	invalid.Status = model.Status("foo")
+
+	_, err = r.Update(ctx, invalid)
+	assertErrorIs(t, err, repo.ErrInvalidStatus)
+
+	actual, err := r.Get(ctx, todos[0].Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, todos[0], actual)
+}
+
+func testTimestamps(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	before := model.Now()
+
+	seed(t, r, makeTodos())
+
+	todo, err := r.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if todo.CreatedAt.Before(before) || todo.CreatedAt.After(model.Now()) {
+		t.Errorf("created_at '%s' not set to the time of add", todo.CreatedAt)
+	}
+
+	if !todo.UpdatedAt.Equal(todo.CreatedAt) {
+		t.Errorf("expected updated_at '%s' to equal created_at '%s'", todo.UpdatedAt, todo.CreatedAt)
+	}
+
+	if todo.CompletedAt != nil {
+		t.Errorf("unexpected completed_at '%s' on a todo not done", todo.CompletedAt)
+	}
+
+	done, err := r.Get(ctx, "2")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if done.CompletedAt == nil {
+		t.Errorf("expected completed_at on a todo added as done")
+	}
+
+	// UpdateData bumps updated_at only
+	time.Sleep(time.Millisecond)
+	_, err = r.UpdateData(ctx, "1", "new data", repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	updated, err := r.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if !updated.CreatedAt.Equal(todo.CreatedAt) {
+		t.Errorf("created_at changed from '%s' to '%s'", todo.CreatedAt, updated.CreatedAt)
+	}
+
+	if !updated.UpdatedAt.After(todo.UpdatedAt) {
+		t.Errorf("expected updated_at after '%s' but got '%s'", todo.UpdatedAt, updated.UpdatedAt)
+	}
+
+	// UpdateStatus to done sets completed_at, and back to todo clears it
+	_, err = r.UpdateStatus(ctx, "1", model.StatusDone, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	completed, err := r.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if completed.CompletedAt == nil || completed.CompletedAt.Before(updated.UpdatedAt) {
+		t.Errorf("expected completed_at after '%s' but got '%v'", updated.UpdatedAt, completed.CompletedAt)
+	}
+
+	_, err = r.UpdateStatus(ctx, "1", model.StatusTodo, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	reopened, err := r.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if reopened.CompletedAt != nil {
+		t.Errorf("expected completed_at cleared but got '%s'", reopened.CompletedAt)
+	}
+}
+
+// testKeepTimestamps checks that timestamps given to Add are kept,
+// so todos copied between backends keep their history.
+func testKeepTimestamps(t *testing.T, r repo.Repository) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	updated := created.Add(time.Hour)
+	completed := updated.Add(time.Hour)
+
+	expected := model.Todo{
+		Id:          "1",
+		Data:        "old todo",
+		Status:      model.StatusDone,
+		CreatedAt:   created,
+		UpdatedAt:   updated,
+		CompletedAt: &completed,
+		Version:     7,
+	}
+
+	seed(t, r, []model.Todo{expected})
+
+	actual, err := r.Get(context.Background(), expected.Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if !actual.CreatedAt.Equal(created) || !actual.UpdatedAt.Equal(updated) || !sameTime(actual.CompletedAt, &completed) || actual.Version != 7 {
+		t.Errorf("unexpected timestamps, expecting='%+v', got='%+v'", expected, actual)
+	}
+}
+
+// every write goes up one version, and is refused with another version
+// than the stored one
+func testVersions(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	seed(t, r, makeTodos())
+
+	get := func(id string) model.Todo {
+		t.Helper()
+
+		todo, err := r.Get(ctx, id)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		return todo
+	}
+
+	if v := get("1").Version; v != 1 {
+		t.Fatalf("expected version 1 for a new todo but got %d", v)
+	}
+
+	writes := []struct {
+		name  string
+		write func(version int64) error
+	}{
+		{"UpdateData", func(version int64) error {
+			_, err := r.UpdateData(ctx, "1", "new data", version)
+			return err
+		}},
+		{"UpdateStatus", func(version int64) error {
+			_, err := r.UpdateStatus(ctx, "1", model.StatusDone, version)
+			return err
+		}},
+		{"Update", func(version int64) error {
+			_, err := r.Update(ctx, model.Todo{Id: "1", Data: "updated", Status: model.StatusTodo, Version: version})
+			return err
+		}},
+		{"UpdateMany", func(version int64) error {
+			_, _, err := r.UpdateMany(ctx, []model.Todo{{Id: "1", Data: "batch", Status: model.StatusTodo, Version: version}})
+			return err
+		}},
+	}
+
+	for _, w := range writes {
+		before := get("1")
+
+		err := w.write(before.Version + 1)
+		assertErrorIs(t, err, repo.ErrVersionMismatch)
+		assertUnchanged(t, r, append([]model.Todo{before}, makeTodos()[1:]...))
+
+		err = w.write(before.Version)
+		if err != nil {
+			t.Fatalf("%s: unexpected err: %s", w.name, err)
+		}
+
+		if v := get("1").Version; v != before.Version+1 {
+			t.Errorf("%s: expected version %d but got %d", w.name, before.Version+1, v)
+		}
+	}
+
+	_, err := r.UpdateData(ctx, "2", "any version", repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	_, err = r.Remove(ctx, "2", 1)
+	assertErrorIs(t, err, repo.ErrVersionMismatch)
+
+	_, err = r.Remove(ctx, "2", 2)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	_, err = r.Get(ctx, "2")
+	assertErrorIs(t, err, repo.ErrNotFound)
+}
+
+func testRemove(t *testing.T, r repo.Repository) {
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	ctx := context.Background()
+	removed, err := r.Remove(ctx, todos[1].Id, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, todos[1], removed)
+
+	_, err = r.Get(ctx, todos[1].Id)
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	_, err = r.Remove(ctx, todos[1].Id, repo.AnyVersion)
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	all, err := r.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(all) != len(todos)-1 {
+		t.Errorf("unexpected length '%d', expecting '%d'", len(all), len(todos)-1)
+	}
+
+	for _, todo := range all {
+		if todo.Id == todos[1].Id {
+			t.Errorf("unexpected found removed id '%s'", todo.Id)
+		}
+	}
+
+	// removing the last todos leaves an empty, usable store
+	for _, todo := range all {
+		_, err := r.Remove(ctx, todo.Id, repo.AnyVersion)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+
+	all, err = r.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(all) != 0 {
+		t.Errorf("expected empty store but got %d todos", len(all))
+	}
+
+	assertReAdd(t, r, todos[0])
+}
+
+// assertReAdd checks that the id of todo, just removed, stays taken until
+// the todo is purged from the trash
+func assertReAdd(t *testing.T, r repo.Repository, todo model.Todo) {
+	t.Helper()
+
+	ctx := context.Background()
+	err := r.Add(ctx, todo)
+	assertErrorIs(t, err, repo.ErrConflict)
+
+	_, err = r.Purge(ctx, todo.Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	seed(t, r, []model.Todo{todo})
+}
+
+func testRemoveMissing(t *testing.T, r repo.Repository) {
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	_, err := r.Remove(context.Background(), "no-such-id", repo.AnyVersion)
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	all, err := r.GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(all) != len(todos) {
+		t.Errorf("unexpected length '%d', expecting '%d'", len(all), len(todos))
+	}
+}
+
+// assertBatchIndex checks that err is a *repo.BatchError for the todo at index
+func assertBatchIndex(t *testing.T, err error, index int) {
+	t.Helper()
+
+	var batchErr *repo.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Errorf("expected a batch error but got '%v'", err)
+		return
+	}
+
+	if batchErr.Index != index {
+		t.Errorf("expected the batch error of todo %d but got %d", index, batchErr.Index)
+	}
+}
+
+// assertUnchanged checks that r still holds exactly expecteds
+func assertUnchanged(t *testing.T, r repo.Repository, expecteds []model.Todo) {
+	t.Helper()
+
+	all, err := r.GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(all) != len(expecteds) {
+		t.Fatalf("expected %d todos but got %d", len(expecteds), len(all))
+	}
+
+	for _, expected := range expecteds {
+		actual, err := r.Get(context.Background(), expected.Id)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		assertTodo(t, expected, actual)
+	}
+}
+
+// assertStored checks that the todos returned by a batch are those r
+// reads back
+func assertStored(t *testing.T, r repo.Repository, stored []model.Todo) {
+	t.Helper()
+
+	for _, expected := range stored {
+		actual, err := r.Get(context.Background(), expected.Id)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		assertTodo(t, expected, actual)
+		if expected.Version != actual.Version ||
+			!expected.CreatedAt.Equal(actual.CreatedAt) ||
+			!expected.UpdatedAt.Equal(actual.UpdatedAt) ||
+			!sameTime(expected.CompletedAt, actual.CompletedAt) {
+			t.Errorf("expected the stored todo '%+v' but got '%+v'", expected, actual)
+		}
+	}
+}
+
+func testAddMany(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	todos := makeTodos()
+	todos[0].Status = ""
+
+	added, err := r.AddMany(ctx, todos)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	todos[0].Status = model.StatusTodo
+	assertUnchanged(t, r, todos)
+
+	if len(added) != len(todos) {
+		t.Fatalf("expected %d added todos but got %d", len(todos), len(added))
+	}
+
+	for i := range todos {
+		assertTodo(t, todos[i], added[i])
+	}
+
+	assertStored(t, r, added)
+
+	actual, err := r.Get(ctx, todos[1].Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if actual.CreatedAt.IsZero() || actual.CompletedAt == nil {
+		t.Errorf("expected timestamps to be set, got %+v", actual)
+	}
+
+	added, err = r.AddMany(ctx, nil)
+	if err != nil || len(added) != 0 {
+		t.Fatalf("unexpected result adding no todo: %v, %v", added, err)
+	}
+
+	assertUnchanged(t, r, todos)
+}
+
+func testAddManyAtomic(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	todos := makeTodos()
+	seed(t, r, todos[:1])
+
+	// the last todo of the batch is already stored
+	_, err := r.AddMany(ctx, []model.Todo{todos[1], todos[2], todos[0]})
+	assertErrorIs(t, err, repo.ErrConflict)
+	assertBatchIndex(t, err, 2)
+	assertUnchanged(t, r, todos[:1])
+
+	_, err = r.AddMany(ctx, []model.Todo{todos[1], todos[1]})
+	assertErrorIs(t, err, repo.ErrConflict)
+	assertBatchIndex(t, err, 1)
+	assertUnchanged(t, r, todos[:1])
+
+	invalid := todos[2]
+	invalid.Status = "NO-SUCH-STATUS"
+	_, err = r.AddMany(ctx, []model.Todo{todos[1], invalid})
+	assertErrorIs(t, err, repo.ErrInvalidStatus)
+	assertBatchIndex(t, err, 1)
+	assertUnchanged(t, r, todos[:1])
+}
+
+func testUpdateMany(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	updates := []model.Todo{
+		{Id: todos[2].Id, Data: "three changed", Status: model.StatusDone, Tags: []string{"new"}},
+		{Id: todos[0].Id, Data: "one changed", Status: model.StatusDone, Priority: model.PriorityLow},
+	}
+
+	updated, old, err := r.UpdateMany(ctx, updates)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(old) != len(updates) || len(updated) != len(updates) {
+		t.Fatalf("expected %d updated and previous todos but got %d and %d", len(updates), len(updated), len(old))
+	}
+
+	assertTodo(t, todos[2], old[0])
+	assertTodo(t, todos[0], old[1])
+	assertTodo(t, updates[0], updated[0])
+	assertTodo(t, updates[1], updated[1])
+	assertStored(t, r, updated)
+
+	assertUnchanged(t, r, []model.Todo{updates[1], todos[1], updates[0]})
+
+	actual, err := r.Get(ctx, todos[0].Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if actual.CompletedAt == nil {
+		t.Errorf("expected completed_at to be set")
+	}
+
+	updated, old, err = r.UpdateMany(ctx, nil)
+	if err != nil || len(old) != 0 || len(updated) != 0 {
+		t.Fatalf("unexpected result updating no todo: %v, %v, %v", updated, old, err)
+	}
+}
+
+func testUpdateManyAtomic(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	changed := todos[0]
+	changed.Data = "changed"
+
+	_, _, err := r.UpdateMany(ctx, []model.Todo{changed, {Id: "no-such-id", Data: "x", Status: model.StatusTodo}})
+	assertErrorIs(t, err, repo.ErrNotFound)
+	assertBatchIndex(t, err, 1)
+	assertUnchanged(t, r, todos)
+
+	invalid := todos[1]
+	invalid.Priority = model.PriorityMax + 1
+	_, _, err = r.UpdateMany(ctx, []model.Todo{changed, invalid})
+	assertErrorIs(t, err, repo.ErrInvalidTodo)
+	assertBatchIndex(t, err, 1)
+	assertUnchanged(t, r, todos)
+
+	_, _, err = r.UpdateMany(ctx, []model.Todo{changed, changed})
+	assertErrorIs(t, err, repo.ErrConflict)
+	assertBatchIndex(t, err, 1)
+	assertUnchanged(t, r, todos)
+}
+
+func testRemoveMany(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	removed, err := r.RemoveMany(ctx, []string{todos[2].Id, todos[0].Id})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(removed) != 2 {
+		t.Fatalf("expected 2 removed todos but got %d", len(removed))
+	}
+
+	assertTodo(t, todos[2], removed[0])
+	assertTodo(t, todos[0], removed[1])
+	assertUnchanged(t, r, todos[1:2])
+
+	removed, err = r.RemoveMany(ctx, nil)
+	if err != nil || len(removed) != 0 {
+		t.Fatalf("unexpected result removing no todo: %v, %v", removed, err)
+	}
+
+	// removing the last todos leaves an empty, usable store
+	_, err = r.RemoveMany(ctx, []string{todos[1].Id})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertUnchanged(t, r, nil)
+	assertReAdd(t, r, todos[0])
+}
+
+func testRemoveManyAtomic(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	_, err := r.RemoveMany(ctx, []string{todos[0].Id, "no-such-id"})
+	assertErrorIs(t, err, repo.ErrNotFound)
+	assertBatchIndex(t, err, 1)
+	assertUnchanged(t, r, todos)
+
+	_, err = r.RemoveMany(ctx, []string{todos[0].Id, todos[0].Id})
+	assertErrorIs(t, err, repo.ErrConflict)
+	assertBatchIndex(t, err, 1)
+	assertUnchanged(t, r, todos)
+}
+
+// testTrash checks that removed todos move to the trash, where every
+// method but the trash ones ignores them
+func testTrash(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	trash, err := r.Trash(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(trash) != 0 {
+		t.Fatalf("expected an empty trash but got %v", ids(trash))
+	}
+
+	before, err := r.Get(ctx, todos[0].Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	_, err = r.Remove(ctx, todos[0].Id, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	_, err = r.RemoveMany(ctx, []string{todos[2].Id})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	trash, err = r.Trash(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	trashed := ids(trash)
+	slices.Sort(trashed)
+	if fmt.Sprint(trashed) != fmt.Sprint([]string{"1", "3"}) {
+		t.Fatalf("unexpected trash %v", trashed)
+	}
+
+	for _, todo := range trash {
+		if todo.DeletedAt == nil {
+			t.Errorf("expected deleted_at on trashed todo '%s'", todo.Id)
+		}
+
+		if todo.Id == before.Id && todo.Version != before.Version+1 {
+			t.Errorf("expected version %d for a removed todo but got %d", before.Version+1, todo.Version)
+		}
+	}
+
+	assertTodo(t, todos[0], trash[slices.IndexFunc(trash, func(todo model.Todo) bool { return todo.Id == todos[0].Id })])
+	assertUnchanged(t, r, todos[1:2])
+
+	_, err = r.Get(ctx, todos[0].Id)
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	byStatus, err := r.GetByStatus(ctx, model.StatusTodo)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(byStatus) != 0 {
+		t.Errorf("unexpected trashed todos %v by status", ids(byStatus))
+	}
+
+	page := query(t, r, repo.Filter{})
+	assertIds(t, []string{todos[1].Id}, page.Todos)
+
+	_, err = r.UpdateData(ctx, todos[0].Id, "changed", repo.AnyVersion)
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	_, err = r.UpdateStatus(ctx, todos[0].Id, model.StatusDone, repo.AnyVersion)
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	_, err = r.Update(ctx, model.Todo{Id: todos[0].Id, Data: "changed", Status: model.StatusDone})
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	_, _, err = r.UpdateMany(ctx, []model.Todo{{Id: todos[0].Id, Data: "changed", Status: model.StatusDone}})
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	_, err = r.Remove(ctx, todos[0].Id, repo.AnyVersion)
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	_, err = r.RemoveMany(ctx, []string{todos[0].Id})
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	err = r.Add(ctx, todos[0])
+	assertErrorIs(t, err, repo.ErrConflict)
+
+	_, err = r.AddMany(ctx, []model.Todo{todos[2]})
+	assertErrorIs(t, err, repo.ErrConflict)
+}
+
+// testTrashOrder checks that the trash lists the last removed todo first
+func testTrashOrder(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	seed(t, r, makeTodos())
+
+	for _, id := range []string{"2", "1", "3"} {
+		time.Sleep(10 * time.Millisecond)
+		_, err := r.Remove(ctx, id, repo.AnyVersion)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+
+	trash, err := r.Trash(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertIds(t, []string{"3", "1", "2"}, trash)
+}
+
+func testRestore(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	removed, err := r.Remove(ctx, todos[2].Id, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	restored, err := r.Restore(ctx, todos[2].Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, todos[2], restored)
+	if restored.DeletedAt != nil {
+		t.Errorf("unexpected deleted_at '%s' on a restored todo", restored.DeletedAt)
+	}
+
+	if restored.Version != removed.Version+2 {
+		t.Errorf("expected version %d but got %d", removed.Version+2, restored.Version)
+	}
+
+	assertUnchanged(t, r, todos)
+
+	all, err := r.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertIds(t, ids(todos), all)
+
+	trash, err := r.Trash(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(trash) != 0 {
+		t.Errorf("unexpected trash %v after restore", ids(trash))
+	}
+
+	// only todos in the trash can be restored
+	for _, id := range []string{todos[2].Id, todos[0].Id, "no-such-id"} {
+		_, err = r.Restore(ctx, id)
+		assertErrorIs(t, err, repo.ErrNotFound)
+	}
+
+	assertUnchanged(t, r, todos)
+}
+
+func testPurge(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	_, err := r.Remove(ctx, todos[1].Id, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	// only todos in the trash can be purged
+	for _, id := range []string{todos[0].Id, "no-such-id"} {
+		_, err = r.Purge(ctx, id)
+		assertErrorIs(t, err, repo.ErrNotFound)
+	}
+
+	purged, err := r.Purge(ctx, todos[1].Id)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, todos[1], purged)
+
+	_, err = r.Purge(ctx, todos[1].Id)
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	_, err = r.Restore(ctx, todos[1].Id)
+	assertErrorIs(t, err, repo.ErrNotFound)
+
+	trash, err := r.Trash(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(trash) != 0 {
+		t.Errorf("unexpected trash %v after purge", ids(trash))
+	}
+
+	assertUnchanged(t, r, []model.Todo{todos[0], todos[2]})
+	seed(t, r, todos[1:2])
+	assertUnchanged(t, r, todos)
+}
+
+func testPurgeBefore(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	todos := makeTodos()
+	seed(t, r, todos)
+
+	remove := func(id string) {
+		t.Helper()
+
+		_, err := r.Remove(ctx, id, repo.AnyVersion)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+	}
+
+	remove(todos[0].Id)
+	remove(todos[2].Id)
+	time.Sleep(10 * time.Millisecond)
+	cutoff := model.Now()
+	time.Sleep(10 * time.Millisecond)
+	remove(todos[1].Id)
+
+	purged, err := r.PurgeBefore(ctx, cutoff)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertIds(t, []string{todos[0].Id, todos[2].Id}, sortedById(purged))
+
+	trash, err := r.Trash(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertIds(t, []string{todos[1].Id}, trash)
+
+	purged, err = r.PurgeBefore(ctx, cutoff)
+	if err != nil || len(purged) != 0 {
+		t.Fatalf("unexpected result purging again: %v, %v", ids(purged), err)
+	}
+
+	purged, err = r.PurgeBefore(ctx, model.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertIds(t, []string{todos[1].Id}, purged)
+	assertUnchanged(t, r, nil)
+	seed(t, r, todos)
+}
+
+func sortedById(todos []model.Todo) []model.Todo {
+	sorted := slices.Clone(todos)
+	slices.SortFunc(sorted, func(a, b model.Todo) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	return sorted
+}
+
+// testOrdering checks that listings are deterministic: repeated calls return
+// the same order, and GetByStatus keeps the relative order of GetAll.
+func testOrdering(t *testing.T, r repo.Repository) {
+	todos := []model.Todo{}
+	for i := 0; i < 20; i++ {
+		status := model.StatusTodo
+		if i%3 == 0 {
+			status = model.StatusDone
+		}
+
+		todos = append(todos, model.Todo{
+			Id:     fmt.Sprintf("id-%02d", (i*7)%20),
+			Data:   fmt.Sprintf("data %d", i),
+			Status: status,
+		})
+	}
+
+	seed(t, r, todos)
+
+	ctx := context.Background()
+	first, err := r.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		again, err := r.GetAll(ctx)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		if fmt.Sprint(ids(first)) != fmt.Sprint(ids(again)) {
+			t.Fatalf("get-all order is not stable: '%v' then '%v'", ids(first), ids(again))
+		}
+	}
+
+	done, err := r.GetByStatus(ctx, model.StatusDone)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	expected := []string{}
+	for _, todo := range first {
+		if todo.Status == model.StatusDone {
+			expected = append(expected, todo.Id)
+		}
+	}
+
+	if fmt.Sprint(expected) != fmt.Sprint(ids(done)) {
+		t.Errorf("get-by-status order '%v' does not follow get-all order '%v'", ids(done), expected)
+	}
+}
+
+// testCanceled checks that every method gives up on a done context,
+// without touching the stored todos
+func testCanceled(t *testing.T, r repo.Repository) {
+	seed(t, r, makeTodos())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := map[string]func() error{
+		"Add": func() error { return r.Add(ctx, model.Todo{Id: "4", Data: "four", Status: model.StatusTodo}) },
+		"GetAll": func() error {
+			_, err := r.GetAll(ctx)
+			return err
+		},
+		"Get": func() error {
+			_, err := r.Get(ctx, "1")
+			return err
+		},
+		"GetByStatus": func() error {
+			_, err := r.GetByStatus(ctx, model.StatusTodo)
+			return err
+		},
+		"Query": func() error {
+			_, err := r.Query(ctx, repo.Filter{})
+			return err
+		},
+		"UpdateData": func() error {
+			_, err := r.UpdateData(ctx, "1", "changed", repo.AnyVersion)
+			return err
+		},
+		"UpdateStatus": func() error {
+			_, err := r.UpdateStatus(ctx, "1", model.StatusDone, repo.AnyVersion)
+			return err
+		},
+		"Update": func() error {
+			_, err := r.Update(ctx, model.Todo{Id: "1", Data: "changed", Status: model.StatusDone})
+			return err
+		},
+		"Remove": func() error {
+			_, err := r.Remove(ctx, "1", repo.AnyVersion)
+			return err
+		},
+		"AddMany": func() error {
+			_, err := r.AddMany(ctx, []model.Todo{{Id: "4", Data: "four", Status: model.StatusTodo}})
+			return err
+		},
+		"UpdateMany": func() error {
+			_, _, err := r.UpdateMany(ctx, []model.Todo{{Id: "1", Data: "changed", Status: model.StatusDone}})
+			return err
+		},
+		"RemoveMany": func() error {
+			_, err := r.RemoveMany(ctx, []string{"1"})
+			return err
+		},
+		"Trash": func() error {
+			_, err := r.Trash(ctx)
+			return err
+		},
+		"Restore": func() error {
+			_, err := r.Restore(ctx, "1")
+			return err
+		},
+		"Purge": func() error {
+			_, err := r.Purge(ctx, "1")
+			return err
+		},
+		"PurgeBefore": func() error {
+			_, err := r.PurgeBefore(ctx, model.Now())
+			return err
+		},
+	}
+
+	for name, call := range calls {
+		err := call()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected err to wrap '%s' but got '%v'", name, context.Canceled, err)
+		}
+	}
+
+	todos, err := r.GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	expected := makeTodos()
+	if len(todos) != len(expected) {
+		t.Fatalf("expected %d todos but got %d", len(expected), len(todos))
+	}
+
+	for i := range expected {
+		assertTodo(t, expected[i], todos[i])
+	}
+}
+
+// ConcurrentAdd hammers Add from many goroutines, spread over repos,
+// and checks that no todo was lost. Passing several repositories backed by
+// the same storage checks that separate instances do not overwrite each other.
+func ConcurrentAdd(t *testing.T, repos ...repo.Repository) {
+	const workers = 16
+	const perWorker = 8
+
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			r := repos[w%len(repos)]
+			for i := 0; i < perWorker; i++ {
+				err := r.Add(ctx, model.Todo{
+					Id:     fmt.Sprintf("w%02d-%02d", w, i),
+					Data:   fmt.Sprintf("worker %d todo %d", w, i),
+					Status: model.StatusTodo,
+				})
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("unexpected err: %s", err)
+	}
+
+	for _, r := range repos {
+		all, err := r.GetAll(ctx)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		if len(all) != workers*perWorker {
+			t.Errorf("lost updates: expected %d todos but got %d", workers*perWorker, len(all))
+		}
+	}
+}
+
+func testWorkflowAddInitialStatus(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	err := r.Add(ctx, model.Todo{Id: "1", Data: "one"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	actual, err := r.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if actual.Status != testWorkflow.Initial {
+		t.Errorf("expected initial status '%s' but got '%s'", testWorkflow.Initial, actual.Status)
+	}
+
+	err = r.Add(ctx, model.Todo{Id: "2", Data: "two", Status: model.Status("foo")})
+	assertErrorIs(t, err, repo.ErrInvalidStatus)
+}
+
+func testWorkflowGetByStatus(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	seed(t, r, []model.Todo{
+		{Id: "1", Data: "one", Status: model.StatusInProgress},
+		{Id: "2", Data: "two", Status: model.StatusBlocked},
+		{Id: "3", Data: "three", Status: model.StatusInProgress},
+	})
+
+	actual, err := r.GetByStatus(ctx, model.StatusInProgress)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if fmt.Sprint(ids(actual)) != fmt.Sprint([]string{"1", "3"}) {
+		t.Errorf("unexpected ids, expecting=[1 3], got=%v", ids(actual))
+	}
+
+	_, err = r.GetByStatus(ctx, model.Status(""))
+	assertErrorIs(t, err, repo.ErrInvalidStatus)
+}
+
+func testWorkflowTransitions(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	seed(t, r, []model.Todo{{Id: "1", Data: "one", Status: model.StatusTodo}})
+
+	steps := []struct {
+		to  model.Status
+		err error
+	}{
+		{to: model.StatusDone, err: repo.ErrInvalidTransition},
+		{to: model.Status("foo"), err: repo.ErrInvalidStatus},
+		{to: model.StatusTodo},
+		{to: model.StatusInProgress},
+		{to: model.StatusBlocked},
+		{to: model.StatusDone, err: repo.ErrInvalidTransition},
+		{to: model.StatusInProgress},
+		{to: model.StatusDone},
+		{to: model.StatusBlocked, err: repo.ErrInvalidTransition},
+		{to: model.StatusTodo},
+	}
+
+	status := model.StatusTodo
+	for _, step := range steps {
+		_, err := r.UpdateStatus(ctx, "1", step.to, repo.AnyVersion)
+		if step.err != nil {
+			assertErrorIs(t, err, step.err)
+		} else if err != nil {
+			t.Fatalf("unexpected err moving %s -> %s: %s", status, step.to, err)
+		} else {
+			status = step.to
+		}
+
+		actual, err := r.Get(ctx, "1")
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		if actual.Status != status {
+			t.Errorf("expected status '%s' after moving to '%s' but got '%s'", status, step.to, actual.Status)
+		}
+	}
+}
+
+func testWorkflowUpdateTransition(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	seed(t, r, []model.Todo{{Id: "1", Data: "one", Status: model.StatusTodo}})
+
+	_, err := r.Update(ctx, model.Todo{Id: "1", Data: "new", Status: model.StatusDone})
+	assertErrorIs(t, err, repo.ErrInvalidTransition)
+
+	actual, err := r.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	assertTodo(t, model.Todo{Id: "1", Data: "one", Status: model.StatusTodo}, actual)
+
+	_, err = r.Update(ctx, model.Todo{Id: "1", Data: "new", Status: model.StatusInProgress})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+}
+
+func testWorkflowCompleted(t *testing.T, r repo.Repository) {
+	ctx := context.Background()
+	seed(t, r, []model.Todo{
+		{Id: "1", Data: "one", Status: model.StatusTodo},
+		{Id: "2", Data: "two", Status: model.StatusCancelled},
+	})
+
+	added, err := r.Get(ctx, "2")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if added.CompletedAt == nil {
+		t.Errorf("expected completed_at on a todo added as '%s'", added.Status)
+	}
+
+	_, err = r.UpdateStatus(ctx, "1", model.StatusCancelled, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	cancelled, err := r.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if cancelled.CompletedAt == nil {
+		t.Errorf("expected completed_at after moving to '%s'", cancelled.Status)
+	}
+
+	_, err = r.UpdateStatus(ctx, "1", model.StatusTodo, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	reopened, err := r.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if reopened.CompletedAt != nil {
+		t.Errorf("expected completed_at cleared but got '%s'", reopened.CompletedAt)
+	}
+}
+
+func makeQueryTodos() []model.Todo {
+	day := func(d int) *time.Time {
+		t := time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+
+	return []model.Todo{
+		{Id: "a", Data: "Buy milk", Status: model.StatusTodo, DueAt: day(3), Priority: model.PriorityLow, Tags: []string{"home", "shop"}},
+		{Id: "b", Data: "write report", Status: model.StatusDone, DueAt: day(1), Tags: []string{"work"}},
+		{Id: "c", Data: "call bank", Status: model.StatusTodo, Priority: model.PriorityHigh, Notes: "about the MILK money"},
+		{Id: "d", Data: "fix bike", Status: model.StatusTodo, DueAt: day(2), Priority: model.PriorityHigh, Tags: []string{"home"}},
+		{Id: "e", Data: "plan trip", Status: model.StatusDone, DueAt: day(5), Priority: model.PriorityMedium, Tags: []string{"home", "fun"}},
+	}
+}
+
+func query(t *testing.T, r repo.Repository, filter repo.Filter) repo.Page {
+	t.Helper()
+
+	page, err := r.Query(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("unexpected err querying %+v: %s", filter, err)
+	}
+
+	return page
+}
+
+func assertIds(t *testing.T, expected []string, todos []model.Todo) {
+	t.Helper()
+
+	if fmt.Sprint(expected) != fmt.Sprint(ids(todos)) {
+		t.Errorf("unexpected ids, expecting=%v, got=%v", expected, ids(todos))
+	}
+}
+
+func testQueryAll(t *testing.T, r repo.Repository) {
+	page := query(t, r, repo.Filter{})
+	assertIds(t, []string{}, page.Todos)
+
+	todos := makeQueryTodos()
+	for _, todo := range todos {
+		seed(t, r, []model.Todo{todo})
+		// keep created_at apart so the default order is the add order
+		time.Sleep(time.Millisecond)
+	}
+
+	page = query(t, r, repo.Filter{})
+	assertIds(t, []string{"a", "b", "c", "d", "e"}, page.Todos)
+
+	if page.Next != "" {
+		t.Errorf("unexpected next cursor '%s' without a limit", page.Next)
+	}
+
+	for i := range todos {
+		assertTodo(t, todos[i], page.Todos[i])
+	}
+}
+
+func testQueryFilter(t *testing.T, r repo.Repository) {
+	seed(t, r, makeQueryTodos())
+
+	from := time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		filter   repo.Filter
+		expected []string
+	}{
+		{"status", repo.Filter{Statuses: []model.Status{model.StatusDone}}, []string{"b", "e"}},
+		{"statuses", repo.Filter{Statuses: []model.Status{model.StatusDone, model.StatusTodo}}, []string{"a", "b", "c", "d", "e"}},
+		{"text in data or notes", repo.Filter{Text: "milk"}, []string{"a", "c"}},
+		{"text no match", repo.Filter{Text: "nothing"}, []string{}},
+		{"tag", repo.Filter{Tags: []string{"home"}}, []string{"a", "d", "e"}},
+		{"every tag", repo.Filter{Tags: []string{"home", "fun"}}, []string{"e"}},
+		{"unknown tag", repo.Filter{Tags: []string{"nope"}}, []string{}},
+		{"due from", repo.Filter{DueFrom: &from}, []string{"a", "d", "e"}},
+		{"due to", repo.Filter{DueTo: &to}, []string{"a", "b", "d"}},
+		{"due range", repo.Filter{DueFrom: &from, DueTo: &to}, []string{"a", "d"}},
+		{"combined", repo.Filter{Statuses: []model.Status{model.StatusTodo}, Tags: []string{"home"}, DueTo: &to}, []string{"a", "d"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.filter.Sort = repo.SortId
+			page := query(t, r, tc.filter)
+			assertIds(t, tc.expected, page.Todos)
+		})
+	}
+}
+
+func testQuerySort(t *testing.T, r repo.Repository) {
+	seed(t, r, makeQueryTodos())
+
+	tests := []struct {
+		sort     string
+		expected []string
+	}{
+		{"id", []string{"a", "b", "c", "d", "e"}},
+		{"-id", []string{"e", "d", "c", "b", "a"}},
+		{"data", []string{"a", "c", "d", "e", "b"}},
+		{"due_at", []string{"b", "d", "a", "e", "c"}},
+		{"-due_at", []string{"c", "e", "a", "d", "b"}},
+		{"priority", []string{"c", "d", "e", "a", "b"}},
+		{"status", []string{"b", "e", "a", "c", "d"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.sort, func(t *testing.T) {
+			field, desc, err := repo.ParseSort(tc.sort)
+			if err != nil {
+				t.Fatalf("unexpected err: %s", err)
+			}
+
+			page := query(t, r, repo.Filter{Sort: field, Desc: desc})
+			assertIds(t, tc.expected, page.Todos)
+		})
+	}
+}
+
+func testQueryPages(t *testing.T, r repo.Repository) {
+	seed(t, r, makeQueryTodos())
+
+	filter := repo.Filter{Sort: repo.SortDueAt, Limit: 2}
+	first := query(t, r, filter)
+	assertIds(t, []string{"b", "d"}, first.Todos)
+
+	if first.Next == "" {
+		t.Fatalf("expected a next cursor")
+	}
+
+	// the cursor holds the last sort key, so removing a todo already seen
+	// does not make the next page skip one
+	_, err := r.Remove(context.Background(), "b", repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	filter.Cursor = first.Next
+	second := query(t, r, filter)
+	assertIds(t, []string{"a", "e"}, second.Todos)
+
+	filter.Cursor = second.Next
+	last := query(t, r, filter)
+	assertIds(t, []string{"c"}, last.Todos)
+
+	if last.Next != "" {
+		t.Errorf("unexpected next cursor '%s' on the last page", last.Next)
+	}
+
+	// a filtered page
+	page := query(t, r, repo.Filter{Tags: []string{"home"}, Sort: repo.SortId, Desc: true, Limit: 1})
+	assertIds(t, []string{"e"}, page.Todos)
+
+	page = query(t, r, repo.Filter{Tags: []string{"home"}, Sort: repo.SortId, Desc: true, Limit: 1, Cursor: page.Next})
+	assertIds(t, []string{"d"}, page.Todos)
+}
+
+func testQueryInvalid(t *testing.T, r repo.Repository) {
+	seed(t, r, makeQueryTodos())
+
+	ctx := context.Background()
+	_, err := r.Query(ctx, repo.Filter{Statuses: []model.Status{"foo"}})
+	assertErrorIs(t, err, repo.ErrInvalidStatus)
+
+	_, err = r.Query(ctx, repo.Filter{Sort: repo.SortField("foo")})
+	assertErrorIs(t, err, repo.ErrInvalidQuery)
+
+	_, err = r.Query(ctx, repo.Filter{Limit: -1})
+	assertErrorIs(t, err, repo.ErrInvalidQuery)
+
+	_, err = r.Query(ctx, repo.Filter{Cursor: "not a cursor"})
+	assertErrorIs(t, err, repo.ErrInvalidQuery)
+
+	page := query(t, r, repo.Filter{Sort: repo.SortId, Limit: 1})
+	_, err = r.Query(ctx, repo.Filter{Sort: repo.SortData, Limit: 1, Cursor: page.Next})
+	assertErrorIs(t, err, repo.ErrInvalidQuery)
+}
@@ -0,0 +1,735 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+)
+
+// migrations are applied in order, PRAGMA user_version counts the ones done.
+// Only append to this list, never edit a migration that has shipped.
+var migrations = []string{
+	`CREATE TABLE todos (
+		id           TEXT PRIMARY KEY,
+		data         TEXT NOT NULL,
+		status       TEXT NOT NULL,
+		created_at   TEXT NOT NULL,
+		updated_at   TEXT NOT NULL,
+		completed_at TEXT,
+		due_at       TEXT,
+		priority     INTEGER NOT NULL DEFAULT 0,
+		notes        TEXT NOT NULL DEFAULT '',
+		data_lower   TEXT NOT NULL DEFAULT '',
+		notes_lower  TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX todos_status ON todos (status);
+	CREATE INDEX todos_due_at ON todos (due_at);
+
+	CREATE TABLE todo_tags (
+		todo_id  TEXT NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		tag      TEXT NOT NULL,
+		PRIMARY KEY (todo_id, position)
+	);
+	CREATE INDEX todo_tags_tag ON todo_tags (tag);`,
+
+	`ALTER TABLE todos ADD COLUMN version INTEGER NOT NULL DEFAULT 0;`,
+
+	`ALTER TABLE todos ADD COLUMN deleted_at TEXT;
+	CREATE INDEX todos_deleted_at ON todos (deleted_at);`,
+}
+
+// columns selected for a todo, in the order scanTodo reads them.
+// Times are stored as repo.SortTime text, so they sort as they compare.
+const columns = `id, data, status, created_at, updated_at, completed_at, due_at, priority, notes, version, deleted_at,
+	(SELECT json_group_array(tag ORDER BY position) FROM todo_tags WHERE todo_id = todos.id)`
+
+type RepoSqlite struct {
+	db       *sql.DB
+	workflow model.Workflow
+}
+
+// dsn turns on foreign keys, waits for locks held by other processes
+// and takes the write lock when a transaction starts, so read-modify-write
+// transactions cannot deadlock upgrading their lock
+func dsn(fileName string) string {
+	return "file:" + fileName + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+}
+
+func migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	err = tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this program (%d)", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		_, err = tx.ExecContext(ctx, migrations[i])
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(migrations)))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func New(fileName string, opts ...repo.Option) repo.Repository {
+	db, err := sql.Open("sqlite", dsn(fileName))
+	if err != nil {
+		panic("failed to open sqlite: " + err.Error())
+	}
+
+	err = migrate(context.Background(), db)
+	if err != nil {
+		panic("failed to migrate sqlite: " + err.Error())
+	}
+
+	return &RepoSqlite{
+		db:       db,
+		workflow: repo.NewOptions(opts...).Workflow,
+	}
+}
+
+func formatTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+
+	return t.UTC().Format(repo.SortTime)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(repo.SortTime, s)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTodo(row scanner) (model.Todo, error) {
+	var todo model.Todo
+	var created, updated, tags string
+	var completed, due, deleted sql.NullString
+
+	err := row.Scan(&todo.Id, &todo.Data, &todo.Status, &created, &updated, &completed, &due, &todo.Priority, &todo.Notes, &todo.Version, &deleted, &tags)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todo.CreatedAt, err = parseTime(created)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: bad created_at of todo '%s': %w", repo.ErrStorage, todo.Id, err)
+	}
+
+	todo.UpdatedAt, err = parseTime(updated)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: bad updated_at of todo '%s': %w", repo.ErrStorage, todo.Id, err)
+	}
+
+	for _, v := range []struct {
+		s   sql.NullString
+		dst **time.Time
+	}{{completed, &todo.CompletedAt}, {due, &todo.DueAt}, {deleted, &todo.DeletedAt}} {
+		if !v.s.Valid {
+			continue
+		}
+
+		t, err := parseTime(v.s.String)
+		if err != nil {
+			return model.Todo{}, fmt.Errorf("%w: bad time of todo '%s': %w", repo.ErrStorage, todo.Id, err)
+		}
+
+		*v.dst = &t
+	}
+
+	err = json.Unmarshal([]byte(tags), &todo.Tags)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: bad tags of todo '%s': %w", repo.ErrStorage, todo.Id, err)
+	}
+
+	if len(todo.Tags) == 0 {
+		todo.Tags = nil
+	}
+
+	return todo, nil
+}
+
+func (j *RepoSqlite) list(ctx context.Context, query string, args ...interface{}) ([]model.Todo, error) {
+	rows, err := j.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to query sqlite: %w", repo.ErrStorage, err)
+	}
+	defer rows.Close()
+
+	todos := []model.Todo{}
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to scan sqlite: %w", repo.ErrStorage, err)
+		}
+
+		todos = append(todos, todo)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to query sqlite: %w", repo.ErrStorage, err)
+	}
+
+	return todos, nil
+}
+
+// get reads one todo, not in the trash, inside tx
+func get(ctx context.Context, tx *sql.Tx, id string) (model.Todo, error) {
+	todo, err := scanTodo(tx.QueryRowContext(ctx, "SELECT "+columns+" FROM todos WHERE id = ? AND deleted_at IS NULL", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
+	}
+
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: failed to get sqlite: %w", repo.ErrStorage, err)
+	}
+
+	return todo, nil
+}
+
+// getTrashed reads one todo in the trash inside tx
+func getTrashed(ctx context.Context, tx *sql.Tx, id string) (model.Todo, error) {
+	todo, err := scanTodo(tx.QueryRowContext(ctx, "SELECT "+columns+" FROM todos WHERE id = ? AND deleted_at IS NOT NULL", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.Todo{}, repo.NotInTrash(id)
+	}
+
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: failed to get sqlite: %w", repo.ErrStorage, err)
+	}
+
+	return todo, nil
+}
+
+// save inserts todo, or replaces the stored one with the same id, inside tx
+func save(ctx context.Context, tx *sql.Tx, todo model.Todo) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO todos
+		(id, data, status, created_at, updated_at, completed_at, due_at, priority, notes, version, deleted_at, data_lower, notes_lower)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			data = excluded.data,
+			status = excluded.status,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at,
+			completed_at = excluded.completed_at,
+			due_at = excluded.due_at,
+			priority = excluded.priority,
+			notes = excluded.notes,
+			version = excluded.version,
+			deleted_at = excluded.deleted_at,
+			data_lower = excluded.data_lower,
+			notes_lower = excluded.notes_lower`,
+		todo.Id, todo.Data, string(todo.Status),
+		formatTime(&todo.CreatedAt), formatTime(&todo.UpdatedAt), formatTime(todo.CompletedAt), formatTime(todo.DueAt),
+		int(todo.Priority), todo.Notes, todo.Version, formatTime(todo.DeletedAt), strings.ToLower(todo.Data), strings.ToLower(todo.Notes),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: failed to save sqlite: %w", repo.ErrStorage, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM todo_tags WHERE todo_id = ?", todo.Id)
+	if err != nil {
+		return fmt.Errorf("%w: failed to save tags sqlite: %w", repo.ErrStorage, err)
+	}
+
+	for i, tag := range todo.Tags {
+		_, err = tx.ExecContext(ctx, "INSERT INTO todo_tags (todo_id, position, tag) VALUES (?, ?, ?)", todo.Id, i, tag)
+		if err != nil {
+			return fmt.Errorf("%w: failed to save tags sqlite: %w", repo.ErrStorage, err)
+		}
+	}
+
+	return nil
+}
+
+// update runs fn on the stored todo with id, once checked to be at version,
+// in one transaction and saves the todo it returns. It returns the todo as
+// it was before.
+func (j *RepoSqlite) update(ctx context.Context, id string, version int64, fn func(todo model.Todo) (model.Todo, error)) (model.Todo, error) {
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: failed to begin sqlite: %w", repo.ErrStorage, err)
+	}
+	defer tx.Rollback()
+
+	old, err := get(ctx, tx, id)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = repo.CheckVersion(old, version)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	updated, err := fn(old)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = save(ctx, tx, updated)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: failed to commit sqlite: %w", repo.ErrStorage, err)
+	}
+
+	return old, nil
+}
+
+func (j *RepoSqlite) Add(ctx context.Context, todo model.Todo) error {
+	if todo.Status == "" {
+		todo.Status = j.workflow.InitialStatus()
+	}
+
+	err := repo.Validate(j.workflow, todo)
+	if err != nil {
+		return err
+	}
+
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%w: failed to begin sqlite: %w", repo.ErrStorage, err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM todos WHERE id = ?", todo.Id).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("%w: failed to add sqlite: %w", repo.ErrStorage, err)
+	}
+
+	if exists > 0 {
+		return fmt.Errorf("%w: duplicate id '%s'", repo.ErrConflict, todo.Id)
+	}
+
+	todo.Created(j.workflow, model.Now())
+
+	err = save(ctx, tx, todo)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%w: failed to commit sqlite: %w", repo.ErrStorage, err)
+	}
+
+	return nil
+}
+
+func (j *RepoSqlite) GetAll(ctx context.Context) ([]model.Todo, error) {
+	return j.list(ctx, "SELECT "+columns+" FROM todos WHERE deleted_at IS NULL ORDER BY rowid")
+}
+
+func (j *RepoSqlite) Get(ctx context.Context, id string) (model.Todo, error) {
+	todos, err := j.list(ctx, "SELECT "+columns+" FROM todos WHERE id = ? AND deleted_at IS NULL", id)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	if len(todos) == 0 {
+		return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
+	}
+
+	return todos[0], nil
+}
+
+func (j *RepoSqlite) GetByStatus(ctx context.Context, status model.Status) ([]model.Todo, error) {
+	err := repo.ValidateStatus(j.workflow, status)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return j.list(ctx, "SELECT "+columns+" FROM todos WHERE status = ? AND deleted_at IS NULL ORDER BY rowid", string(status))
+}
+
+// sortExpr is the SQL for repo.SortKey of each sort field
+var sortExpr = map[repo.SortField]string{
+	repo.SortCreatedAt: "created_at",
+	repo.SortUpdatedAt: "updated_at",
+	repo.SortDueAt:     "coalesce(due_at, '~')",
+	repo.SortPriority:  "CASE WHEN priority = 0 THEN '~' ELSE printf('%02d', priority) END",
+	repo.SortStatus:    "status",
+	repo.SortData:      "data_lower",
+	repo.SortId:        "''",
+}
+
+func (j *RepoSqlite) Query(ctx context.Context, filter repo.Filter) (repo.Page, error) {
+	filter, err := repo.ValidateFilter(j.workflow, filter)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	where := []string{"deleted_at IS NULL"}
+	args := []interface{}{}
+
+	if len(filter.Statuses) != 0 {
+		marks := make([]string, len(filter.Statuses))
+		for i, s := range filter.Statuses {
+			marks[i] = "?"
+			args = append(args, string(s))
+		}
+
+		where = append(where, "status IN ("+strings.Join(marks, ", ")+")")
+	}
+
+	if filter.Text != "" {
+		text := strings.ToLower(filter.Text)
+		where = append(where, "(instr(data_lower, ?) > 0 OR instr(notes_lower, ?) > 0)")
+		args = append(args, text, text)
+	}
+
+	for _, tag := range filter.Tags {
+		where = append(where, "EXISTS (SELECT 1 FROM todo_tags WHERE todo_id = todos.id AND tag = ?)")
+		args = append(args, tag)
+	}
+
+	if filter.DueFrom != nil {
+		where = append(where, "due_at >= ?")
+		args = append(args, formatTime(filter.DueFrom))
+	}
+
+	if filter.DueTo != nil {
+		where = append(where, "due_at < ?")
+		args = append(args, formatTime(filter.DueTo))
+	}
+
+	key := sortExpr[filter.Sort]
+	order, after := "ASC", ">"
+	if filter.Desc {
+		order, after = "DESC", "<"
+	}
+
+	if filter.Cursor != "" {
+		c, err := repo.DecodeCursor(filter.Cursor)
+		if err != nil {
+			return repo.Page{}, err
+		}
+
+		where = append(where, fmt.Sprintf("(%s, id) %s (?, ?)", key, after))
+		args = append(args, c.Key, c.Id)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM todos WHERE %s ORDER BY %s %s, id %s",
+		columns, strings.Join(where, " AND "), key, order, order)
+
+	// one extra row tells whether there is a next page
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit+1)
+	}
+
+	todos, err := j.list(ctx, query, args...)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	page := repo.Page{Todos: todos}
+	if filter.Limit > 0 && len(todos) > filter.Limit {
+		page.Todos = todos[:filter.Limit]
+		page.Next = repo.NextCursor(filter, page.Todos[len(page.Todos)-1])
+	}
+
+	return page, nil
+}
+
+func (j *RepoSqlite) UpdateData(ctx context.Context, id string, newData string, version int64) (model.Todo, error) {
+	return j.update(ctx, id, version, func(todo model.Todo) (model.Todo, error) {
+		todo.SetData(newData, model.Now())
+
+		return todo, nil
+	})
+}
+
+func (j *RepoSqlite) UpdateStatus(ctx context.Context, id string, status model.Status, version int64) (model.Todo, error) {
+	err := repo.ValidateStatus(j.workflow, status)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return j.update(ctx, id, version, func(todo model.Todo) (model.Todo, error) {
+		err := repo.CheckTransition(j.workflow, todo.Status, status)
+		if err != nil {
+			return model.Todo{}, err
+		}
+
+		todo.SetStatus(j.workflow, status, model.Now())
+
+		return todo, nil
+	})
+}
+
+func (j *RepoSqlite) Update(ctx context.Context, todo model.Todo) (model.Todo, error) {
+	err := repo.Validate(j.workflow, todo)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return j.update(ctx, todo.Id, todo.Version, func(old model.Todo) (model.Todo, error) {
+		err := repo.CheckTransition(j.workflow, old.Status, todo.Status)
+		if err != nil {
+			return model.Todo{}, err
+		}
+
+		old.Apply(j.workflow, todo, model.Now())
+
+		return old, nil
+	})
+}
+
+func (j *RepoSqlite) Remove(ctx context.Context, id string, version int64) (model.Todo, error) {
+	return j.update(ctx, id, version, func(todo model.Todo) (model.Todo, error) {
+		todo.Delete(model.Now())
+
+		return todo, nil
+	})
+}
+
+func (j *RepoSqlite) AddMany(ctx context.Context, todos []model.Todo) ([]model.Todo, error) {
+	todos = slices.Clone(todos)
+
+	err := repo.PrepareAdd(j.workflow, todos, model.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin sqlite: %w", repo.ErrStorage, err)
+	}
+	defer tx.Rollback()
+
+	for i, todo := range todos {
+		var exists int
+		err = tx.QueryRowContext(ctx, "SELECT count(*) FROM todos WHERE id = ?", todo.Id).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to add-many sqlite: %w", repo.ErrStorage, err)
+		}
+
+		if exists > 0 {
+			return nil, &repo.BatchError{Index: i, Err: fmt.Errorf("%w: duplicate id '%s'", repo.ErrConflict, todo.Id)}
+		}
+
+		err = save(ctx, tx, todo)
+		if err != nil {
+			return nil, &repo.BatchError{Index: i, Err: err}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to commit sqlite: %w", repo.ErrStorage, err)
+	}
+
+	return todos, nil
+}
+
+func (j *RepoSqlite) UpdateMany(ctx context.Context, todos []model.Todo) ([]model.Todo, []model.Todo, error) {
+	err := repo.CheckBatchIds(repo.TodoIds(todos))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to begin sqlite: %w", repo.ErrStorage, err)
+	}
+	defer tx.Rollback()
+
+	now := model.Now()
+	old := make([]model.Todo, len(todos))
+	updated := make([]model.Todo, len(todos))
+	for i, todo := range todos {
+		old[i], err = get(ctx, tx, todo.Id)
+		if err != nil {
+			return nil, nil, &repo.BatchError{Index: i, Err: err}
+		}
+
+		updated[i] = old[i]
+		err = repo.UpdateTodo(j.workflow, &updated[i], todo, now)
+		if err != nil {
+			return nil, nil, &repo.BatchError{Index: i, Err: err}
+		}
+
+		err = save(ctx, tx, updated[i])
+		if err != nil {
+			return nil, nil, &repo.BatchError{Index: i, Err: err}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to commit sqlite: %w", repo.ErrStorage, err)
+	}
+
+	return updated, old, nil
+}
+
+func (j *RepoSqlite) RemoveMany(ctx context.Context, ids []string) ([]model.Todo, error) {
+	err := repo.CheckBatchIds(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin sqlite: %w", repo.ErrStorage, err)
+	}
+	defer tx.Rollback()
+
+	now := model.Now()
+	old := make([]model.Todo, len(ids))
+	for i, id := range ids {
+		old[i], err = get(ctx, tx, id)
+		if err != nil {
+			return nil, &repo.BatchError{Index: i, Err: err}
+		}
+
+		removed := old[i]
+		removed.Delete(now)
+
+		err = save(ctx, tx, removed)
+		if err != nil {
+			return nil, &repo.BatchError{Index: i, Err: err}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to commit sqlite: %w", repo.ErrStorage, err)
+	}
+
+	return old, nil
+}
+
+func (j *RepoSqlite) Trash(ctx context.Context) ([]model.Todo, error) {
+	return j.list(ctx, "SELECT "+columns+" FROM todos WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id")
+}
+
+func (j *RepoSqlite) Restore(ctx context.Context, id string) (model.Todo, error) {
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: failed to begin sqlite: %w", repo.ErrStorage, err)
+	}
+	defer tx.Rollback()
+
+	todo, err := getTrashed(ctx, tx, id)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todo.Restore(model.Now())
+
+	err = save(ctx, tx, todo)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: failed to commit sqlite: %w", repo.ErrStorage, err)
+	}
+
+	return todo, nil
+}
+
+func (j *RepoSqlite) Purge(ctx context.Context, id string) (model.Todo, error) {
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: failed to begin sqlite: %w", repo.ErrStorage, err)
+	}
+	defer tx.Rollback()
+
+	todo, err := getTrashed(ctx, tx, id)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM todos WHERE id = ?", id)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: failed to purge sqlite: %w", repo.ErrStorage, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: failed to commit sqlite: %w", repo.ErrStorage, err)
+	}
+
+	return todo, nil
+}
+
+func (j *RepoSqlite) PurgeBefore(ctx context.Context, t time.Time) ([]model.Todo, error) {
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin sqlite: %w", repo.ErrStorage, err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+columns+" FROM todos WHERE deleted_at < ? ORDER BY deleted_at DESC, id", formatTime(&t))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to query sqlite: %w", repo.ErrStorage, err)
+	}
+	defer rows.Close()
+
+	purged := []model.Todo{}
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to scan sqlite: %w", repo.ErrStorage, err)
+		}
+
+		purged = append(purged, todo)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to query sqlite: %w", repo.ErrStorage, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM todos WHERE deleted_at < ?", formatTime(&t))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to purge-before sqlite: %w", repo.ErrStorage, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to commit sqlite: %w", repo.ErrStorage, err)
+	}
+
+	return purged, nil
+}
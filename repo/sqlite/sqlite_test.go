@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(opts ...repo.Option) repo.Repository {
+		return New(filepath.Join(t.TempDir(), "todo.db"), opts...)
+	})
+}
+
+func TestConcurrentAddInstances(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "todo.db")
+	repotest.ConcurrentAdd(t, New(fname), New(fname), New(fname))
+}
+
+func TestReopen(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "todo.db")
+	ctx := context.Background()
+
+	err := New(fname).Add(ctx, model.Todo{Id: "1", Data: "one", Tags: []string{"b", "a"}})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	// opening again must not re-run the migrations
+	actual, err := New(fname).Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if actual.Data != "one" || len(actual.Tags) != 2 || actual.Tags[0] != "b" {
+		t.Errorf("unexpected todo after reopen: %+v", actual)
+	}
+
+	db, err := sql.Open("sqlite", dsn(fname))
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+	defer db.Close()
+
+	var version int
+	err = db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if version != len(migrations) {
+		t.Errorf("expected schema version %d but got %d", len(migrations), version)
+	}
+}
+
+func TestRemoveTags(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "todo.db")
+	ctx := context.Background()
+	r := New(fname)
+
+	err := r.Add(ctx, model.Todo{Id: "1", Data: "one", Tags: []string{"home"}})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	countTags := func() int {
+		t.Helper()
+
+		var count int
+		err := r.(*RepoSqlite).db.QueryRow("SELECT count(*) FROM todo_tags").Scan(&count)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		return count
+	}
+
+	_, err = r.Remove(ctx, "1", repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if count := countTags(); count != 1 {
+		t.Errorf("expected tags of a todo in the trash to be kept, %d left", count)
+	}
+
+	_, err = r.Purge(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if count := countTags(); count != 0 {
+		t.Errorf("expected tags of a purged todo to be deleted, %d left", count)
+	}
+}
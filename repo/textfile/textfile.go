@@ -4,96 +4,143 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/eymyong/todo/model"
 	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/internal/fsutil"
 )
 
 /*
 	data
 
-1: one
-2: two
-3: three
+# todo textfile v2
+1: one: TODO
+2: two: DONE: <created_at>: <updated_at>: <completed_at>: <due_at>: <priority>: <tags>: <notes>: <version>: <deleted_at>
+
+Backslashes, line breaks and ": " inside a field are escaped with '\\',
+and so are commas inside a tag.
 */
 type RepoTextFile struct {
 	fileName string
+	workflow model.Workflow
+}
+
+// lock serializes read-modify-write cycles on the file
+// across goroutines and processes
+func (j *RepoTextFile) lock(ctx context.Context) (func(), error) {
+	unlock, err := fsutil.Lock(ctx, j.fileName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to lock textfile: %w", repo.ErrStorage, err)
+	}
+
+	return unlock, nil
 }
 
-func (j *RepoTextFile) Add(_ context.Context, todo model.Todo) error {
+func (j *RepoTextFile) Add(ctx context.Context, todo model.Todo) error {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if todo.Status == "" {
+		todo.Status = j.workflow.InitialStatus()
+	}
+
+	err = repo.Validate(j.workflow, todo)
+	if err != nil {
+		return err
+	}
+
 	todosList, err := readDecode(j.fileName)
 	if err != nil {
 		return err
 	}
 
+	for _, v := range todosList {
+		if v.Id == todo.Id {
+			return fmt.Errorf("%w: duplicate id '%s'", repo.ErrConflict, todo.Id)
+		}
+	}
+
+	todo.Created(j.workflow, model.Now())
 	todosList = append(todosList, todo)
 	todosStr := modelToLines(todosList)
 
-	err = os.WriteFile(j.fileName, []byte(todosStr), 0664)
+	err = fsutil.WriteFile(j.fileName, []byte(todosStr), 0664)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: error to writefile: %w", repo.ErrStorage, err)
 	}
 
 	return nil
 }
 
-func (j *RepoTextFile) GetAll(_ context.Context) ([]model.Todo, error) {
-	todosList, err := readDecode(j.fileName)
+func (j *RepoTextFile) GetAll(ctx context.Context) ([]model.Todo, error) {
+	err := ctx.Err()
 	if err != nil {
 		return []model.Todo{}, err
 	}
 
-	if len(todosList) == 0 {
-		return []model.Todo{}, fmt.Errorf("not found data to file")
+	todosList, err := readDecode(j.fileName)
+	if err != nil {
+		return []model.Todo{}, err
 	}
 
-	return todosList, nil
+	return repo.Live(todosList), nil
 }
 
-func (j *RepoTextFile) Get(_ context.Context, id string) (model.Todo, error) {
+func (j *RepoTextFile) Get(ctx context.Context, id string) (model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return model.Todo{}, err
+	}
+
 	todosList, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, err
 	}
 
 	if len(todosList) == 0 {
-		return model.Todo{}, fmt.Errorf("not found data to file")
+		return model.Todo{}, fmt.Errorf("%w: not found data to file", repo.ErrNotFound)
 	}
 
 	var expectedId bool
 	for _, v := range todosList {
-		if id == v.Id {
+		if id == v.Id && !v.IsDeleted() {
 			expectedId = true
 			return v, nil
 		}
 	}
 
 	if expectedId == false {
-		return model.Todo{}, fmt.Errorf("not found id")
+		return model.Todo{}, fmt.Errorf("%w: not found id '%s'", repo.ErrNotFound, id)
 	}
 
 	return model.Todo{}, nil
 }
 
-func (j *RepoTextFile) GetByStatus(_ context.Context, status model.Status) ([]model.Todo, error) {
-	todosList, err := readDecode(j.fileName)
+func (j *RepoTextFile) GetByStatus(ctx context.Context, status model.Status) ([]model.Todo, error) {
+	err := ctx.Err()
 	if err != nil {
 		return []model.Todo{}, err
 	}
 
-	if len(todosList) == 0 {
-		return []model.Todo{}, fmt.Errorf("not found data to file")
+	todosList, err := readDecode(j.fileName)
+	if err != nil {
+		return []model.Todo{}, err
 	}
 
-	statusCorrect := status.IsValid()
+	statusCorrect := j.workflow.IsValid(status)
 	if statusCorrect == false {
-		return []model.Todo{}, fmt.Errorf("status is not correct")
+		return []model.Todo{}, fmt.Errorf("%w: status is not correct: '%s'", repo.ErrInvalidStatus, status)
 	}
 
 	newTodoList := []model.Todo{}
 	for _, v := range todosList {
-		if status == v.Status {
+		if status == v.Status && !v.IsDeleted() {
 			newTodoList = append(newTodoList, v)
 		}
 	}
@@ -101,24 +148,54 @@ func (j *RepoTextFile) GetByStatus(_ context.Context, status model.Status) ([]mo
 	return newTodoList, nil
 }
 
-func (j *RepoTextFile) UpdateData(_ context.Context, id string, newData string) (model.Todo, error) {
+func (j *RepoTextFile) Query(ctx context.Context, filter repo.Filter) (repo.Page, error) {
+	err := ctx.Err()
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	filter, err = repo.ValidateFilter(j.workflow, filter)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	todosList, err := readDecode(j.fileName)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	return repo.ApplyFilter(todosList, filter)
+}
+
+func (j *RepoTextFile) UpdateData(ctx context.Context, id string, newData string, version int64) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
 	todos, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, err
 	}
 
 	if len(todos) == 0 {
-		return model.Todo{}, fmt.Errorf("not found data to file")
+		return model.Todo{}, fmt.Errorf("%w: not found data to file", repo.ErrNotFound)
 	}
 
 	newTodos := []model.Todo{}
 	old := model.Todo{}
 	var expectedId bool
 	for _, v := range todos {
-		if id == v.Id {
+		if id == v.Id && !v.IsDeleted() {
+			err = repo.CheckVersion(v, version)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
 			expectedId = true
 			old = v
-			v.Data = newData
+			v.SetData(newData, model.Now())
 			newTodos = append(newTodos, v)
 			continue
 		}
@@ -126,42 +203,58 @@ func (j *RepoTextFile) UpdateData(_ context.Context, id string, newData string)
 	}
 
 	if expectedId == false {
-		return model.Todo{}, fmt.Errorf("not found id")
+		return model.Todo{}, fmt.Errorf("%w: not found id '%s'", repo.ErrNotFound, id)
 	}
 
 	byteTodosStr := []byte(modelToLines(newTodos))
 
-	err = os.WriteFile(j.fileName, byteTodosStr, 0664)
+	err = fsutil.WriteFile(j.fileName, byteTodosStr, 0664)
 	if err != nil {
-		return model.Todo{}, fmt.Errorf("error to writefile")
+		return model.Todo{}, fmt.Errorf("%w: error to writefile: %w", repo.ErrStorage, err)
 	}
 
 	return old, nil
 }
 
-func (j *RepoTextFile) UpdateStatus(_ context.Context, id string, status model.Status) (model.Todo, error) {
+func (j *RepoTextFile) UpdateStatus(ctx context.Context, id string, status model.Status, version int64) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
 	todos, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, err
 	}
 
 	if len(todos) == 0 {
-		return model.Todo{}, fmt.Errorf("not found data to file")
+		return model.Todo{}, fmt.Errorf("%w: not found data to file", repo.ErrNotFound)
 	}
 
-	statusCorrect := status.IsValid()
+	statusCorrect := j.workflow.IsValid(status)
 	if statusCorrect == false {
-		return model.Todo{}, fmt.Errorf("status is not correct")
+		return model.Todo{}, fmt.Errorf("%w: status is not correct: '%s'", repo.ErrInvalidStatus, status)
 	}
 
 	newTodos := []model.Todo{}
 	old := model.Todo{}
 	var expectedId bool
 	for _, v := range todos {
-		if id == v.Id {
+		if id == v.Id && !v.IsDeleted() {
+			err = repo.CheckVersion(v, version)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
+			err = repo.CheckTransition(j.workflow, v.Status, status)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
 			expectedId = true
 			old = v
-			v.Status = status
+			v.SetStatus(j.workflow, status, model.Now())
 			newTodos = append(newTodos, v)
 			continue
 		}
@@ -169,102 +262,589 @@ func (j *RepoTextFile) UpdateStatus(_ context.Context, id string, status model.S
 	}
 
 	if expectedId == false {
-		return model.Todo{}, fmt.Errorf("not found id")
+		return model.Todo{}, fmt.Errorf("%w: not found id '%s'", repo.ErrNotFound, id)
 	}
 
 	toodosStr := modelToLines(newTodos)
 
-	err = os.WriteFile(j.fileName, []byte(toodosStr), 0664)
+	err = fsutil.WriteFile(j.fileName, []byte(toodosStr), 0664)
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: error to writefile: %w", repo.ErrStorage, err)
+	}
+
+	return old, nil
+}
+
+func (j *RepoTextFile) Update(ctx context.Context, todo model.Todo) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
+	err = repo.Validate(j.workflow, todo)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todos, err := readDecode(j.fileName)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	newTodos := []model.Todo{}
+	old := model.Todo{}
+	var expectedId bool
+	for _, v := range todos {
+		if todo.Id == v.Id && !v.IsDeleted() {
+			err = repo.CheckVersion(v, todo.Version)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
+			err = repo.CheckTransition(j.workflow, v.Status, todo.Status)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
+			expectedId = true
+			old = v
+			v.Apply(j.workflow, todo, model.Now())
+			newTodos = append(newTodos, v)
+			continue
+		}
+		newTodos = append(newTodos, v)
+	}
+
+	if expectedId == false {
+		return model.Todo{}, fmt.Errorf("%w: not found id '%s'", repo.ErrNotFound, todo.Id)
+	}
+
+	err = fsutil.WriteFile(j.fileName, []byte(modelToLines(newTodos)), 0664)
 	if err != nil {
-		return model.Todo{}, fmt.Errorf("error to writefile")
+		return model.Todo{}, fmt.Errorf("%w: error to writefile: %w", repo.ErrStorage, err)
 	}
 
 	return old, nil
 }
 
-func (j *RepoTextFile) Remove(_ context.Context, id string) (model.Todo, error) {
+func (j *RepoTextFile) Remove(ctx context.Context, id string, version int64) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
 	todos, err := readDecode(j.fileName)
 	if err != nil {
 		return model.Todo{}, err
 	}
 
 	if len(todos) == 0 {
-		return model.Todo{}, fmt.Errorf("not found data to file")
+		return model.Todo{}, fmt.Errorf("%w: not found data to file", repo.ErrNotFound)
 	}
 
 	newTodos := []model.Todo{}
 	var expectedId bool
 	old := model.Todo{}
 	for _, v := range todos {
-		if id == v.Id {
+		if id == v.Id && !v.IsDeleted() {
+			err = repo.CheckVersion(v, version)
+			if err != nil {
+				return model.Todo{}, err
+			}
+
 			expectedId = true
 			old = v
+			v.Delete(model.Now())
+			newTodos = append(newTodos, v)
 			continue
 		}
 		newTodos = append(newTodos, v)
 	}
 
 	if expectedId == false {
-		return model.Todo{}, fmt.Errorf("not found id")
+		return model.Todo{}, fmt.Errorf("%w: not found id '%s'", repo.ErrNotFound, id)
 	}
 
 	todosStr := modelToLines(newTodos)
 
-	err = os.WriteFile(j.fileName, []byte(todosStr), 0664)
+	err = fsutil.WriteFile(j.fileName, []byte(todosStr), 0664)
 	if err != nil {
-		return model.Todo{}, fmt.Errorf("error to writefile")
+		return model.Todo{}, fmt.Errorf("%w: error to writefile: %w", repo.ErrStorage, err)
 	}
 
 	return old, nil
 }
 
-func New(fileName string) repo.Repository {
+// writeTodos writes todos to the file, replacing its content
+func (j *RepoTextFile) writeTodos(todos []model.Todo) error {
+	err := fsutil.WriteFile(j.fileName, []byte(modelToLines(todos)), 0664)
+	if err != nil {
+		return fmt.Errorf("%w: error to writefile: %w", repo.ErrStorage, err)
+	}
+
+	return nil
+}
+
+func (j *RepoTextFile) AddMany(ctx context.Context, todos []model.Todo) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	todosList, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	todosList, err = repo.AddTodos(j.workflow, todosList, todos, model.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = j.writeTodos(todosList)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.PickTodos(todosList, repo.TodoIds(todos)), nil
+}
+
+func (j *RepoTextFile) UpdateMany(ctx context.Context, todos []model.Todo) ([]model.Todo, []model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	todosList, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	todosList, old, err := repo.UpdateTodos(j.workflow, todosList, todos, model.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = j.writeTodos(todosList)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return repo.PickTodos(todosList, repo.TodoIds(todos)), old, nil
+}
+
+func (j *RepoTextFile) RemoveMany(ctx context.Context, ids []string) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	todosList, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	todosList, removed, err := repo.RemoveTodos(todosList, ids, model.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = j.writeTodos(todosList)
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+func (j *RepoTextFile) Trash(ctx context.Context) ([]model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	todosList, err := readDecode(j.fileName)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return repo.TrashTodos(todosList), nil
+}
+
+func (j *RepoTextFile) Restore(ctx context.Context, id string) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
+	todosList, err := readDecode(j.fileName)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	restored, err := repo.RestoreTodo(todosList, id, model.Now())
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = j.writeTodos(todosList)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return restored, nil
+}
+
+func (j *RepoTextFile) Purge(ctx context.Context, id string) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
+	todosList, err := readDecode(j.fileName)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todosList, purged, err := repo.PurgeTodo(todosList, id)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = j.writeTodos(todosList)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return purged, nil
+}
+
+func (j *RepoTextFile) PurgeBefore(ctx context.Context, t time.Time) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	todosList, err := readDecode(j.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	todosList, purged := repo.PurgeTodosBefore(todosList, t)
+	if len(purged) == 0 {
+		return purged, nil
+	}
+
+	err = j.writeTodos(todosList)
+	if err != nil {
+		return nil, err
+	}
+
+	return purged, nil
+}
+
+func New(fileName string, opts ...repo.Option) repo.Repository {
 	b, err := os.ReadFile(fileName)
 	if err != nil || len(b) == 0 {
-		err := os.WriteFile(fileName, []byte(""), os.ModePerm)
+		err := os.WriteFile(fileName, []byte(modelToLines(nil)), os.ModePerm)
 		if err != nil {
-			panic("failed to write empty array to init file: " + err.Error())
+			panic("failed to write header to init file: " + err.Error())
 		}
 	}
 	return &RepoTextFile{
 		fileName: fileName,
+		workflow: repo.NewOptions(opts...).Workflow,
 	}
 }
 
+// header is the first line of files in the current format.
+// Files without it are read as version 1 and rewritten as version 2
+// on the next write.
+const (
+	headerPrefix   = "# todo textfile v"
+	currentVersion = 2
+)
+
+// fields after "id: data: status", all optional so older files still load
+const (
+	fieldCreatedAt = iota + 3
+	fieldUpdatedAt
+	fieldCompletedAt
+	fieldDueAt
+	fieldPriority
+	fieldTags
+	fieldNotes
+	fieldVersion
+	fieldDeletedAt
+)
+
+// escape makes s safe inside a field: backslashes, line breaks and the
+// ": " separator are escaped, as well as any of the runes in special
+func escape(s string, special string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == ':' && strings.HasPrefix(s[i+1:], " "):
+			b.WriteString(`\:`)
+		case strings.ContainsRune(special, r):
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// unescape reverses escape
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if !escaped && r == '\\' {
+			escaped = true
+			continue
+		}
+
+		if escaped {
+			switch r {
+			case 'n':
+				r = '\n'
+			case 'r':
+				r = '\r'
+			}
+
+			escaped = false
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// split cuts s at every unescaped sep, leaving the escapes in place
+func split(s string, sep string) []string {
+	parts := []string{}
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+
+		if strings.HasPrefix(s[i:], sep) {
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// lineToModel reads one line of the current format
 func lineToModel(line string) (model.Todo, error) {
+	parts := split(line, ": ")
+	todo, err := partsToModel(parts, unescape)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	if len(parts) > fieldTags && parts[fieldTags] != "" {
+		todo.Tags = split(parts[fieldTags], ",")
+		for i := range todo.Tags {
+			todo.Tags[i] = unescape(todo.Tags[i])
+		}
+	}
+
+	return todo, nil
+}
+
+// legacyLineToModel reads one line of version 1, written before escaping.
+// Its notes are the only field that can hold ": ".
+func legacyLineToModel(line string) (model.Todo, error) {
 	parts := strings.Split(line, ": ")
+	if len(parts) > fieldNotes {
+		parts = append(parts[:fieldNotes], strings.Join(parts[fieldNotes:], ": "))
+	}
+
+	todo, err := partsToModel(parts, func(s string) string { return s })
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	if len(parts) > fieldTags && parts[fieldTags] != "" {
+		todo.Tags = strings.Split(parts[fieldTags], ",")
+	}
+
+	return todo, nil
+}
 
+func partsToModel(parts []string, unescape func(string) string) (model.Todo, error) {
 	if len(parts) < 2 {
 		return model.Todo{}, fmt.Errorf("not data")
 	}
 
-	status := model.StatusTodo
+	field := func(i int) string {
+		if i >= len(parts) {
+			return ""
+		}
 
-	if len(parts) >= 3 {
-		status = model.Status(parts[2])
+		return unescape(parts[i])
 	}
 
 	todo := model.Todo{
-		Id:     parts[0],
-		Data:   parts[1],
-		Status: status,
+		Id:     field(0),
+		Data:   field(1),
+		Status: model.Status(field(2)),
+		Notes:  field(fieldNotes),
 	}
 
 	if todo.Status == "" {
 		todo.Status = model.StatusTodo
 	}
 
+	var err error
+	todo.CreatedAt, err = parseTime(field(fieldCreatedAt))
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todo.UpdatedAt, err = parseTime(field(fieldUpdatedAt))
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todo.CompletedAt, err = parseTimePtr(field(fieldCompletedAt))
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todo.DueAt, err = parseTimePtr(field(fieldDueAt))
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	if p := field(fieldPriority); p != "" {
+		priority, err := strconv.Atoi(p)
+		if err != nil {
+			return model.Todo{}, fmt.Errorf("bad priority: %w", err)
+		}
+
+		todo.Priority = model.Priority(priority)
+	}
+
+	if v := field(fieldVersion); v != "" {
+		todo.Version, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return model.Todo{}, fmt.Errorf("bad version: %w", err)
+		}
+	}
+
+	todo.DeletedAt, err = parseTimePtr(field(fieldDeletedAt))
+	if err != nil {
+		return model.Todo{}, err
+	}
+
 	return todo, nil
 }
 
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad time: %w", err)
+	}
+
+	return t, nil
+}
+
+func parseTimePtr(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	t, err := parseTime(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
+
+func formatTimePtr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return formatTime(*t)
+}
+
+// linesToModel reads a whole file. Blank lines are skipped, and so are
+// lines starting with '#' in the current format.
 func linesToModel(data string) ([]model.Todo, error) {
 	lines := strings.Split(data, "\n")
 
+	version := 1
+	parse := legacyLineToModel
 	todos := []model.Todo{}
-	for _, v := range lines {
-		todo, err := lineToModel(v)
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, headerPrefix) && len(todos) == 0 && version == 1 {
+			v, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, headerPrefix)))
+			if err != nil || v < 1 || v > currentVersion {
+				return nil, fmt.Errorf("unsupported format '%s'", line)
+			}
+
+			version = v
+			if version == currentVersion {
+				parse = lineToModel
+			}
+
+			continue
+		}
+
+		if version > 1 && strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		todo, err := parse(line)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
 		}
 
 		todos = append(todos, todo)
@@ -276,7 +856,7 @@ func linesToModel(data string) ([]model.Todo, error) {
 func readDecode(fname string) ([]model.Todo, error) {
 	b, err := os.ReadFile(fname)
 	if err != nil {
-		return nil, fmt.Errorf("failed to readfile: %w", err)
+		return nil, fmt.Errorf("%w: failed to readfile: %w", repo.ErrStorage, err)
 	}
 
 	if len(b) == 0 {
@@ -286,36 +866,69 @@ func readDecode(fname string) ([]model.Todo, error) {
 	s := string(b)
 	s = strings.ReplaceAll(s, "\r\n", "\n")
 
-	return linesToModel(s)
+	todos, err := linesToModel(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode textfile: %w", repo.ErrStorage, err)
+	}
+
+	return todos, nil
 }
 
+// modelToLine writes one line of the current format
 func modelToLine(t model.Todo) string {
-	return fmt.Sprintf("%s: %s: %s", t.Id, t.Data, t.Status)
-}
+	priority := ""
+	if t.Priority != model.PriorityNone {
+		priority = strconv.Itoa(int(t.Priority))
+	}
 
-func modelToLines(todos []model.Todo) string {
-	s := ""
-	last := len(todos) - 1
-	for i := range todos {
-		s += modelToLine(todos[i])
+	version := ""
+	if t.Version != 0 {
+		version = strconv.FormatInt(t.Version, 10)
+	}
 
-		if i == last {
-			continue
-		}
+	tags := make([]string, len(t.Tags))
+	for i := range t.Tags {
+		tags[i] = escape(t.Tags[i], ",")
+	}
 
-		s += "\n"
+	parts := []string{
+		escape(t.Id, ""),
+		escape(t.Data, ""),
+		escape(string(t.Status), ""),
+		formatTime(t.CreatedAt),
+		formatTime(t.UpdatedAt),
+		formatTimePtr(t.CompletedAt),
+		formatTimePtr(t.DueAt),
+		priority,
+		strings.Join(tags, ","),
+		escape(t.Notes, ""),
+		version,
+		formatTimePtr(t.DeletedAt),
 	}
 
-	return s
+	// a line starting with '#' would read as a comment
+	if strings.HasPrefix(parts[0], "#") {
+		parts[0] = `\` + parts[0]
+	}
+
+	// drop empty trailing fields, a plain todo stays "id: data: status"
+	last := len(parts)
+	for last > fieldCreatedAt && parts[last-1] == "" {
+		last--
+	}
+
+	return strings.Join(parts[:last], ": ")
 }
 
-func modelToLinesJoin(todos []model.Todo) string {
-	lines := make([]string, len(todos))
+// modelToLines writes a whole file: the header, then one line per todo
+func modelToLines(todos []model.Todo) string {
+	lines := make([]string, len(todos)+1)
+	lines[0] = headerPrefix + strconv.Itoa(currentVersion)
 	for i := range todos {
-		lines[i] = modelToLine(todos[i])
+		lines[i+1] = modelToLine(todos[i])
 	}
 
-	return strings.Join(lines, "\n")
+	return strings.Join(lines, "\n") + "\n"
 }
 
 func makeTodos() []model.Todo {
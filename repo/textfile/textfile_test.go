@@ -1,11 +1,17 @@
 package textfile
 
 import (
+	"context"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/repotest"
 )
 
 // expectedTodo := model.Todo{
@@ -43,7 +49,7 @@ func TestReadDecode_Happy(t *testing.T) {
 		actual := todosActual[i]
 		expected := todosExpected[i]
 
-		if actual != expected {
+		if !reflect.DeepEqual(actual, expected) {
 			t.Errorf("unexpected value, expecting='%+v', got='%+v'", expected, actual)
 		}
 	}
@@ -70,16 +76,11 @@ func TestReadDecode_fileErr(t *testing.T) {
 	}
 }
 
-// case readDecode return []model.Todo{}
+// case a line without data
 func TestReadDecode_linesToModelErr(t *testing.T) {
-	expectedErr := "not data"
-
-	b, err := os.ReadFile(fileName)
-	if err != nil {
-		t.Error("unexpected error", err)
-	}
+	expectedErr := "line 2: not data"
 
-	_, err = linesToModel(string(b))
+	_, err := linesToModel("# todo textfile v2\nfoo\n")
 	if err == nil {
 		t.Errorf("expected err but got nil")
 		return
@@ -133,10 +134,10 @@ func TestModelToLines_Happy(t *testing.T) {
 		},
 	}
 
-	expected := "1: one: TODO\n2: two: DONE"
+	expected := "# todo textfile v2\n1: one: TODO\n2: two: DONE\n"
 	actual := modelToLines(todos)
 
-	if actual != expected {
+	if !reflect.DeepEqual(actual, expected) {
 		t.Errorf("unexpected value: expecting `%s` but got `%s`", expected, actual)
 	}
 }
@@ -155,7 +156,35 @@ func TestLineToModel_Happy(t *testing.T) {
 		return
 	}
 
-	if actual != expected {
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("unexpected value, expecting='%+v', got='%+v'", expected, actual)
+	}
+}
+
+func TestLineToModel_AllFields(t *testing.T) {
+	created := time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)
+	due := created.Add(48 * time.Hour)
+	expected := model.Todo{
+		Id:          "24",
+		Data:        "foo",
+		Status:      model.StatusDone,
+		CreatedAt:   created,
+		UpdatedAt:   created,
+		CompletedAt: &created,
+		DueAt:       &due,
+		Priority:    model.PriorityMedium,
+		Tags:        []string{"work", "home"},
+		Notes:       "see: the wiki",
+	}
+
+	line := modelToLine(expected)
+	actual, err := lineToModel(line)
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
+		return
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
 		t.Errorf("unexpected value, expecting='%+v', got='%+v'", expected, actual)
 	}
 }
@@ -194,7 +223,7 @@ func TestLinesToModel_Happy(t *testing.T) {
 		actual := actuals[i]
 		expected := expecteds[i]
 
-		if actual != expected {
+		if !reflect.DeepEqual(actual, expected) {
 			t.Errorf("unexpected value, expecting='%+v', got='%+v'", expected, actual)
 		}
 	}
@@ -207,13 +236,14 @@ func TestAdd_Happy(t *testing.T) {
 		Status: model.StatusTodo,
 	}
 
-	expectedTodo := `10: yong: TODO`
+	// followed by created_at and updated_at
+	expectedTodo := `10: yong: TODO: `
 
 	repo := RepoTextFile{
 		fileName: fileName,
 	}
 
-	err := repo.Add(nil, data)
+	err := repo.Add(context.Background(), data)
 	if err != nil {
 		t.Errorf("unexpected err: `%s`", err)
 	}
@@ -223,8 +253,19 @@ func TestAdd_Happy(t *testing.T) {
 		t.Errorf("unexpected err: `%s`", err)
 	}
 
-	if expectedTodo != string(b) {
+	lines := strings.Split(string(b), "\n")
+	if len(lines) < 2 || lines[0] != "# todo textfile v2" || !strings.HasPrefix(lines[1], expectedTodo) {
 		t.Errorf("expected todo: `%s` but got `%s`", expectedTodo, string(b))
+		return
+	}
+
+	actual, err := lineToModel(lines[1])
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
+	}
+
+	if actual.CreatedAt.IsZero() || !actual.UpdatedAt.Equal(actual.CreatedAt) {
+		t.Errorf("expected created_at and updated_at to be set, got='%+v'", actual)
 	}
 
 	err = os.WriteFile(repo.fileName, []byte{}, 0664)
@@ -282,7 +323,7 @@ func TestGetAll_Happy(t *testing.T) {
 		return
 	}
 
-	actualTodos, err := repo.GetAll(nil)
+	actualTodos, err := repo.GetAll(context.Background())
 	if err != nil {
 		t.Errorf("unexpected err: `%s`", err)
 		return
@@ -296,7 +337,7 @@ func TestGetAll_Happy(t *testing.T) {
 		expected := expectedTodos[i]
 		actual := actualTodos[i]
 
-		if actual != expected {
+		if !reflect.DeepEqual(actual, expected) {
 			t.Errorf("unexpected value, expecting='%+v', got='%+v'", expected, actual)
 		}
 	}
@@ -327,22 +368,20 @@ func TestGetAll_lineToModelErr(t *testing.T) {
 
 }
 
-func TestGetAll_Err(t *testing.T) {
+// empty file is an empty store, not an error
+func TestGetAll_Empty(t *testing.T) {
 	repo := RepoTextFile{
 		fileName: fileName,
 	}
-	expectedErr := "not found data to file"
 
-	_, err := repo.GetAll(nil)
-	if err == nil {
-		t.Errorf("expected err but got nil")
+	todos, err := repo.GetAll(context.Background())
+	if err != nil {
+		t.Errorf("unexpected err: `%s`", err)
 		return
 	}
 
-	// error contain expected error ?
-	if !strings.Contains(err.Error(), expectedErr) {
-		t.Errorf("expected err: `%s` but got `%s`", expectedErr, err.Error())
-		return
+	if len(todos) != 0 {
+		t.Errorf("unexpected length '%d', expecting '0'", len(todos))
 	}
 
 }
@@ -369,13 +408,13 @@ func TestGet_Happy(t *testing.T) {
 		return
 	}
 
-	actual, err := repo.Get(nil, expectedTodo.Id)
+	actual, err := repo.Get(context.Background(), expectedTodo.Id)
 	if err != nil {
 		t.Errorf("unexpected err: `%s`", err)
 		return
 	}
 
-	if expectedTodo != actual {
+	if !reflect.DeepEqual(expectedTodo, actual) {
 		t.Errorf("unexpected value, expecting='%+v', got='%+v'", expectedTodo, actual)
 		return
 	}
@@ -407,7 +446,7 @@ func TestGet_IdErr(t *testing.T) {
 	expectedErr := "not found id"
 	expectedId := "kuy"
 
-	_, err = repo.Get(nil, expectedId)
+	_, err = repo.Get(context.Background(), expectedId)
 	if err == nil {
 		t.Errorf("expected err but got nil")
 		return
@@ -451,7 +490,7 @@ func TestGetStatus_Happy(t *testing.T) {
 	}
 
 	repo := RepoTextFile{fileName: fileName}
-	actuals, err := repo.GetByStatus(nil, model.StatusTodo)
+	actuals, err := repo.GetByStatus(context.Background(), model.StatusTodo)
 	if err != nil {
 		t.Error("unexpected error", err)
 	}
@@ -501,7 +540,7 @@ func TestGet_statusErr(t *testing.T) {
 	expectedErr := "status is not correct"
 	expectedStatus := model.Status("kuyy")
 
-	_, err = repo.GetByStatus(nil, expectedStatus)
+	_, err = repo.GetByStatus(context.Background(), expectedStatus)
 	if err == nil {
 		t.Errorf("expected err but got nil")
 		return
@@ -556,14 +595,14 @@ func TestUpdateData_Happy(t *testing.T) {
 	newData := "two"
 
 	repo := RepoTextFile{fileName: fileName}
-	actuals, err := repo.UpdateData(nil, expectedTodo.Id, newData)
+	actuals, err := repo.UpdateData(context.Background(), expectedTodo.Id, newData, 0)
 	if err != nil {
 		t.Error("unexpected error", err)
 		return
 	}
 
 	if expectedTodo.Data != actuals.Data {
-		t.Errorf("expected data: `%s` but got `%s`", newData, actuals)
+		t.Errorf("expected data: `%s` but got `%s`", expectedTodo.Data, actuals.Data)
 		return
 	}
 
@@ -606,7 +645,7 @@ func TestUpdateData_IdErr(t *testing.T) {
 
 	repo := RepoTextFile{fileName: fileName}
 
-	_, err = repo.UpdateData(nil, "10", newData)
+	_, err = repo.UpdateData(context.Background(), "10", newData, 0)
 	if err == nil {
 		t.Errorf("expected err but got nil")
 		return
@@ -653,7 +692,7 @@ func TestUpdateStatus_Happy(t *testing.T) {
 
 	newStatus := model.StatusDone
 	repo := RepoTextFile{fileName: fileName}
-	_, err = repo.UpdateStatus(nil, data[0].Id, newStatus)
+	_, err = repo.UpdateStatus(context.Background(), data[0].Id, newStatus, 0)
 	if err != nil {
 		t.Error("unexpected error", err)
 		return
@@ -705,7 +744,7 @@ func TestRemove_Happy(t *testing.T) {
 
 	idToRemove := data[0].Id
 	repo := RepoTextFile{fileName: fileName}
-	_, err = repo.Remove(nil, idToRemove)
+	_, err = repo.Remove(context.Background(), idToRemove, 0)
 	if err != nil {
 		t.Errorf("unexpectErr")
 	}
@@ -715,6 +754,23 @@ func TestRemove_Happy(t *testing.T) {
 		t.Errorf("unexpected err `%s`", err)
 	}
 
+	// the removed todo stays in the file, in the trash
+	for _, v := range todos {
+		if v.Id == idToRemove && v.DeletedAt == nil {
+			t.Errorf("expected deleted_at on id: `%s`", idToRemove)
+		}
+	}
+
+	_, err = repo.Purge(context.Background(), idToRemove)
+	if err != nil {
+		t.Errorf("unexpected err `%s`", err)
+	}
+
+	todos, err = readDecode(fileName)
+	if err != nil {
+		t.Errorf("unexpected err `%s`", err)
+	}
+
 	for _, v := range todos {
 		if v.Id == idToRemove {
 			t.Errorf("unexpected id: `%s`", idToRemove)
@@ -727,3 +783,117 @@ func TestRemove_Happy(t *testing.T) {
 	}
 
 }
+
+func TestLineRoundTrip(t *testing.T) {
+	due := time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC)
+	todos := []model.Todo{
+		{Id: "1", Data: "a: b", Status: model.StatusTodo},
+		{Id: "#2", Data: "line\nbreak\r\n", Status: model.StatusDone, Notes: `back\slash \: \n`},
+		{Id: "3", Data: "ไทย 日本 🎉", Status: model.StatusTodo, DueAt: &due, Tags: []string{"a,b", "c: d", `e\`}, Notes: "x: y: z"},
+		{Id: "4", Data: "trailing:", Status: model.StatusTodo, Notes: ":"},
+		{Id: "5", Data: "", Status: model.StatusTodo, Tags: []string{""}},
+		{Id: "6", Data: "removed", Status: model.StatusTodo, DeletedAt: &due},
+	}
+
+	fname := filepath.Join(t.TempDir(), "todo.text")
+	err := os.WriteFile(fname, []byte(modelToLines(todos)), 0664)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	actual, err := readDecode(fname)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(actual) != len(todos) {
+		t.Fatalf("expected %d todos but got %d", len(todos), len(actual))
+	}
+
+	for i := range todos {
+		expected := todos[i]
+		if len(expected.Tags) == 1 && expected.Tags[0] == "" {
+			// a single empty tag reads back as no tags
+			expected.Tags = nil
+		}
+
+		if !reflect.DeepEqual(actual[i], expected) {
+			t.Errorf("expected %+v but got %+v", expected, actual[i])
+		}
+	}
+}
+
+func TestLinesToModel_BlankAndComments(t *testing.T) {
+	data := "\n# todo textfile v2\n\n# a comment\n1: one: TODO\n   \n#\n2: two: DONE\n\n"
+
+	todos, err := linesToModel(data)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(todos) != 2 || todos[0].Id != "1" || todos[1].Id != "2" {
+		t.Errorf("unexpected todos: %+v", todos)
+	}
+}
+
+func TestLinesToModel_UnsupportedVersion(t *testing.T) {
+	_, err := linesToModel("# todo textfile v3\n1: one: TODO")
+	if err == nil || !strings.Contains(err.Error(), "unsupported format") {
+		t.Errorf("expected unsupported format but got '%v'", err)
+	}
+}
+
+// files written before the header are read as version 1 and upgraded on write
+func TestUpgrade(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "todo.text")
+	v1 := "1: one: TODO\n2: two: DONE: 2026-01-02T03:04:05Z: 2026-01-02T03:04:05Z: : : : a,b: note: with: colons\n"
+	err := os.WriteFile(fname, []byte(v1), 0664)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	r := New(fname)
+	old, err := r.Get(context.Background(), "2")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if old.Notes != "note: with: colons" || !reflect.DeepEqual(old.Tags, []string{"a", "b"}) {
+		t.Errorf("unexpected v1 todo: %+v", old)
+	}
+
+	err = r.Add(context.Background(), model.Todo{Id: "3", Data: "three: 3"})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if !strings.HasPrefix(string(b), "# todo textfile v2\n") {
+		t.Errorf("expected file to be upgraded but got `%s`", b)
+	}
+
+	upgraded, err := r.Get(context.Background(), "2")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if !reflect.DeepEqual(upgraded, old) {
+		t.Errorf("expected %+v after upgrade but got %+v", old, upgraded)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(opts ...repo.Option) repo.Repository {
+		return New(filepath.Join(t.TempDir(), "todo.text"), opts...)
+	})
+}
+
+// separate instances on one file behave like the api and cli sharing it
+func TestConcurrentAddInstances(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "todo.text")
+	repotest.ConcurrentAdd(t, New(fname), New(fname), New(fname))
+}
@@ -2,7 +2,15 @@ package todoredis
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/eymyong/todo/model"
 	"github.com/eymyong/todo/repo"
@@ -14,161 +22,884 @@ todo: {udkfdhfl}
 id "udkfdhfl",
 data: "yong",
 status: "TODO"
+created_at: "2024-07-01T10:00:00Z"
+updated_at: "2024-07-01T10:00:00Z"
+completed_at: ""
+due_at: ""
+priority: "0"
+tags: "[\"work\"]"
+notes: ""
+version: "1"
+deleted_at: ""
 */
 func redisKeyTodo(id string) string {
 	return "todo: " + id
 }
 
+// Indexes used by GetAll, GetByStatus, Query and Trash, so listings are
+// O(result) and never touch unrelated keys. They are kept in step with the
+// hashes in the same MULTI/EXEC as every write:
+//
+//	todos:ids            set of every todo id
+//	todos:status:{TODO}  set of ids per status
+//	todos:tag:{work}     set of ids per tag
+//	todos:due            sorted set of ids scored by due date in unix milliseconds
+//	todos:trash          sorted set of the ids in the trash scored by deleted_at,
+//	                     which are in no other index
+const redisKeyIds = "todos:ids"
+const redisKeyDue = "todos:due"
+const redisKeyTrash = "todos:trash"
+
+// redisKeyIndexed is set once the todos stored before the indexes existed
+// have been added to them, see Reindex
+const redisKeyIndexed = "todos:indexed"
+
+func redisKeyStatus(status model.Status) string {
+	return "todos:status:" + string(status)
+}
+
+func redisKeyTag(tag string) string {
+	return "todos:tag:" + tag
+}
+
 type RepoRedis struct {
-	rd *redis.Client
+	rd       *redis.Client
+	workflow model.Workflow
+
+	mut     sync.Mutex
+	indexed bool
 }
 
-func New(addr string) repo.Repository {
+func New(addr string, opts ...repo.Option) repo.Repository {
 	rd := redis.NewClient(&redis.Options{
 		Addr: addr,
 		// DB:   db,
 	})
 
-	return &RepoRedis{rd: rd}
+	return &RepoRedis{rd: rd, workflow: repo.NewOptions(opts...).Workflow}
 }
 
-func (j *RepoRedis) Add(ctx context.Context, data model.Todo) error {
-	err := j.rd.HSet(ctx, redisKeyTodo(data.Id), "id", data.Id, "data", data.Data, "status", data.Status).Err()
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
 
+func parseTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
 	if err != nil {
-		return fmt.Errorf("hset redis err: %w", err)
+		return nil, err
 	}
-	return nil
+
+	return &t, nil
 }
 
-func (j *RepoRedis) GetAll(ctx context.Context) ([]model.Todo, error) {
-	todos := []model.Todo{}
+// modelToHash returns the hash fields stored for todo
+func modelToHash(todo model.Todo) (map[string]interface{}, error) {
+	tags := ""
+	if len(todo.Tags) != 0 {
+		b, err := json.Marshal(todo.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal tags: %w", err)
+		}
+
+		tags = string(b)
+	}
+
+	return map[string]interface{}{
+		"id":           todo.Id,
+		"data":         todo.Data,
+		"status":       string(todo.Status),
+		"created_at":   formatTime(&todo.CreatedAt),
+		"updated_at":   formatTime(&todo.UpdatedAt),
+		"completed_at": formatTime(todo.CompletedAt),
+		"due_at":       formatTime(todo.DueAt),
+		"priority":     int(todo.Priority),
+		"tags":         tags,
+		"notes":        todo.Notes,
+		"version":      todo.Version,
+		"deleted_at":   formatTime(todo.DeletedAt),
+	}, nil
+}
+
+// hashToModel reads a todo back from its hash fields.
+// Fields missing from hashes written by older versions are left zero.
+func hashToModel(hash map[string]string) (model.Todo, error) {
+	todo := model.Todo{}
+	for k, v := range hash {
+		var err error
+		switch k {
+		case "id":
+			todo.Id = v
+		case "data":
+			todo.Data = v
+		case "status":
+			todo.Status = model.Status(v)
+		case "created_at":
+			var t *time.Time
+			t, err = parseTime(v)
+			if t != nil {
+				todo.CreatedAt = *t
+			}
+		case "updated_at":
+			var t *time.Time
+			t, err = parseTime(v)
+			if t != nil {
+				todo.UpdatedAt = *t
+			}
+		case "completed_at":
+			todo.CompletedAt, err = parseTime(v)
+		case "due_at":
+			todo.DueAt, err = parseTime(v)
+		case "priority":
+			if v != "" {
+				var p int
+				p, err = strconv.Atoi(v)
+				todo.Priority = model.Priority(p)
+			}
+		case "tags":
+			if v != "" {
+				err = json.Unmarshal([]byte(v), &todo.Tags)
+			}
+		case "notes":
+			todo.Notes = v
+		case "version":
+			todo.Version, err = strconv.ParseInt(v, 10, 64)
+		case "deleted_at":
+			todo.DeletedAt, err = parseTime(v)
+		default:
+		}
+
+		if err != nil {
+			return model.Todo{}, fmt.Errorf("%w: bad field '%s' of todo '%s': %w", repo.ErrStorage, k, hash["id"], err)
+		}
+	}
+
+	return todo, nil
+}
+
+// maxRetries bounds how often a write is retried when another client
+// changes the same todo between WATCH and EXEC
+const maxRetries = 20
+
+// errNoChange is returned by a mutate callback to leave the todo as it is
+var errNoChange = errors.New("no change")
+
+// mutate reads the todo with id, passes it to fn (nil if there is none)
+// and stores what fn returns, deleting the todo if fn returns nil.
+// The read, the write and the index updates happen in one WATCH/MULTI/EXEC,
+// retried if another client writes the todo in between.
+// It returns the todo as it was before.
+func (j *RepoRedis) mutate(ctx context.Context, id string, fn func(old *model.Todo) (*model.Todo, error)) (model.Todo, error) {
+	old, err := j.mutateMany(ctx, []string{id}, func(_ int, old *model.Todo) (*model.Todo, error) {
+		return fn(old)
+	})
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return old[0], nil
+}
+
+// mutateMany is mutate for the todos of ids, fn being called with the
+// index of each. Every todo is read in one round trip and written in the
+// same MULTI/EXEC, so either all of them change or none does.
+func (j *RepoRedis) mutateMany(ctx context.Context, ids []string, fn func(i int, old *model.Todo) (*model.Todo, error)) ([]model.Todo, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = redisKeyTodo(id)
+	}
+
+	var olds []*model.Todo
+	var abort error
+
+	txf := func(tx *redis.Tx) error {
+		olds, abort = make([]*model.Todo, len(ids)), nil
+
+		cmds, err := tx.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.HGetAll(ctx, key)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for i, cmd := range cmds {
+			hash := cmd.(*redis.MapStringStringCmd).Val()
+			if len(hash) == 0 {
+				continue
+			}
+
+			todo, err := hashToModel(hash)
+			if err != nil {
+				abort = err
+				return err
+			}
+
+			olds[i] = &todo
+		}
+
+		nexts := make([]*model.Todo, len(ids))
+		nextHashes := make([]map[string]interface{}, len(ids))
+		for i := range ids {
+			nexts[i], err = fn(i, olds[i])
+			if err != nil {
+				abort = err
+				return err
+			}
+
+			if nexts[i] != nil {
+				nextHashes[i], err = modelToHash(*nexts[i])
+				if err != nil {
+					abort = fmt.Errorf("%w: %w", repo.ErrStorage, err)
+					return abort
+				}
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				if olds[i] != nil {
+					unindex(ctx, pipe, *olds[i])
+				}
+
+				if nexts[i] == nil {
+					pipe.Del(ctx, key)
+					continue
+				}
+
+				pipe.HSet(ctx, key, nextHashes[i])
+				index(ctx, pipe, *nexts[i])
+			}
+
+			return nil
+		})
+
+		return err
+	}
+
+	for i := 0; i < maxRetries; i++ {
+		err := j.rd.Watch(ctx, txf, keys...)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+
+		if errors.Is(abort, errNoChange) {
+			abort, err = nil, nil
+		}
+
+		if abort != nil {
+			return nil, abort
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: watch redis err: %w", repo.ErrStorage, err)
+		}
+
+		result := make([]model.Todo, len(ids))
+		for i, old := range olds {
+			if old != nil {
+				result[i] = *old
+			}
+		}
+
+		return result, nil
+	}
+
+	if len(ids) == 1 {
+		return nil, fmt.Errorf("%w: todo '%s' was changed by others %d times in a row", repo.ErrConflict, ids[0], maxRetries)
+	}
 
-	keyMain, err := j.rd.Keys(ctx, "*").Result()
+	return nil, fmt.Errorf("%w: todos were changed by others %d times in a row", repo.ErrConflict, maxRetries)
+}
+
+func index(ctx context.Context, pipe redis.Pipeliner, todo model.Todo) {
+	if todo.IsDeleted() {
+		pipe.ZAdd(ctx, redisKeyTrash, redis.Z{Score: float64(todo.DeletedAt.UnixMilli()), Member: todo.Id})
+		return
+	}
+
+	pipe.SAdd(ctx, redisKeyIds, todo.Id)
+	pipe.SAdd(ctx, redisKeyStatus(todo.Status), todo.Id)
+
+	for _, tag := range todo.Tags {
+		pipe.SAdd(ctx, redisKeyTag(tag), todo.Id)
+	}
+
+	if todo.DueAt != nil {
+		pipe.ZAdd(ctx, redisKeyDue, redis.Z{Score: float64(todo.DueAt.UnixMilli()), Member: todo.Id})
+	}
+}
+
+func unindex(ctx context.Context, pipe redis.Pipeliner, todo model.Todo) {
+	pipe.SRem(ctx, redisKeyIds, todo.Id)
+	pipe.SRem(ctx, redisKeyStatus(todo.Status), todo.Id)
+
+	for _, tag := range todo.Tags {
+		pipe.SRem(ctx, redisKeyTag(tag), todo.Id)
+	}
+
+	pipe.ZRem(ctx, redisKeyDue, todo.Id)
+	pipe.ZRem(ctx, redisKeyTrash, todo.Id)
+}
+
+// ensureIndexed runs Reindex the first time this database is used
+// by a version that keeps indexes
+func (j *RepoRedis) ensureIndexed(ctx context.Context) error {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	if j.indexed {
+		return nil
+	}
+
+	done, err := j.rd.Exists(ctx, redisKeyIndexed).Result()
 	if err != nil {
-		return []model.Todo{}, fmt.Errorf("keys redis err: %w", err)
+		return fmt.Errorf("%w: exists redis err: %w", repo.ErrStorage, err)
 	}
 
-	for _, v := range keyMain {
-		keyMainMap, err := j.rd.HGetAll(ctx, v).Result()
+	if done == 0 {
+		err = j.Reindex(ctx)
 		if err != nil {
-			return []model.Todo{}, fmt.Errorf("hgetall redis err: %w", err)
+			return err
+		}
+	}
+
+	j.indexed = true
+
+	return nil
+}
+
+// Reindex adds every todo hash to the indexes. It SCANs the todo keys,
+// so it does not block redis, and it is safe to run while others write.
+func (j *RepoRedis) Reindex(ctx context.Context) error {
+	iter := j.rd.Scan(ctx, 0, redisKeyTodo("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		keyType, err := j.rd.Type(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("%w: type redis err: %w", repo.ErrStorage, err)
+		}
+
+		if keyType != "hash" {
+			continue
 		}
-		todo := model.Todo{}
-		for k, v := range keyMainMap {
-			switch k {
-			case "id":
-				todo.Id = v
-			case "data":
-				todo.Data = v
-			case "status":
-				todo.Status = model.Status(v)
-			default:
+
+		// rewriting the todo through mutate indexes it atomically,
+		// even if another client changes it meanwhile
+		id := strings.TrimPrefix(key, redisKeyTodo(""))
+		_, err = j.mutate(ctx, id, func(old *model.Todo) (*model.Todo, error) {
+			if old == nil || old.Id != id {
+				return nil, errNoChange
 			}
+
+			return old, nil
+		})
+		if err != nil {
+			return err
 		}
+	}
+
+	err := iter.Err()
+	if err != nil {
+		return fmt.Errorf("%w: scan redis err: %w", repo.ErrStorage, err)
+	}
+
+	err = j.rd.Set(ctx, redisKeyIndexed, "1", 0).Err()
+	if err != nil {
+		return fmt.Errorf("%w: set redis err: %w", repo.ErrStorage, err)
+	}
+
+	return nil
+}
+
+func (j *RepoRedis) Add(ctx context.Context, data model.Todo) error {
+	if data.Status == "" {
+		data.Status = j.workflow.InitialStatus()
+	}
+
+	err := repo.Validate(j.workflow, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = j.mutate(ctx, data.Id, func(old *model.Todo) (*model.Todo, error) {
+		if old != nil {
+			return nil, fmt.Errorf("%w: duplicate id '%s'", repo.ErrConflict, data.Id)
+		}
+
+		todo := data
+		todo.Created(j.workflow, model.Now())
+
+		return &todo, nil
+	})
+
+	return err
+}
+
+// load reads the todos with ids in one round trip, sorted by id.
+// Ids left in an index without their hash are skipped, and the todos
+// may have moved in or out of the trash since the index was read.
+func (j *RepoRedis) load(ctx context.Context, ids []string) ([]model.Todo, error) {
+	cmds, err := j.rd.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			pipe.HGetAll(ctx, redisKeyTodo(id))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: hgetall redis err: %w", repo.ErrStorage, err)
+	}
+
+	todos := []model.Todo{}
+	for _, cmd := range cmds {
+		hash := cmd.(*redis.MapStringStringCmd).Val()
+		if len(hash) == 0 {
+			continue
+		}
+
+		todo, err := hashToModel(hash)
+		if err != nil {
+			return nil, err
+		}
+
 		todos = append(todos, todo)
 	}
 
+	sort.Slice(todos, func(i, j int) bool {
+		return todos[i].Id < todos[j].Id
+	})
+
 	return todos, nil
 }
 
+func (j *RepoRedis) GetAll(ctx context.Context) ([]model.Todo, error) {
+	err := j.ensureIndexed(ctx)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	ids, err := j.rd.SMembers(ctx, redisKeyIds).Result()
+	if err != nil {
+		return []model.Todo{}, fmt.Errorf("%w: smembers redis err: %w", repo.ErrStorage, err)
+	}
+
+	todos, err := j.load(ctx, ids)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return repo.Live(todos), nil
+}
+
 func (j *RepoRedis) Get(ctx context.Context, id string) (model.Todo, error) {
 	mapStr, err := j.rd.HGetAll(ctx, redisKeyTodo(id)).Result()
+	if err != nil {
+		return model.Todo{}, fmt.Errorf("%w: hgetall redis err: %w", repo.ErrStorage, err)
+	}
+
+	// HGETALL on a missing key returns an empty hash, not redis.Nil
+	if len(mapStr) == 0 {
+		return model.Todo{}, fmt.Errorf("%w: not found id: %s", repo.ErrNotFound, id)
+	}
+
+	todo, err := hashToModel(mapStr)
 	if err != nil {
 		return model.Todo{}, err
 	}
 
-	todo := model.Todo{}
-	for k, v := range mapStr {
-		switch k {
-		case "id":
-			todo.Id = v
-		case "data":
-			todo.Data = v
-		case "status":
-			todo.Status = model.Status(v)
-		default:
-		}
+	if todo.IsDeleted() {
+		return model.Todo{}, fmt.Errorf("%w: not found id: %s", repo.ErrNotFound, id)
 	}
 
 	return todo, nil
 }
 
 func (j *RepoRedis) GetByStatus(ctx context.Context, status model.Status) ([]model.Todo, error) {
-	all, err := j.GetAll(ctx)
+	err := repo.ValidateStatus(j.workflow, status)
 	if err != nil {
 		return []model.Todo{}, err
 	}
 
-	targets := []model.Todo{}
-	for _, v := range all {
-		if v.Status == status {
-			targets = append(targets, v)
+	err = j.ensureIndexed(ctx)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	ids, err := j.rd.SMembers(ctx, redisKeyStatus(status)).Result()
+	if err != nil {
+		return []model.Todo{}, fmt.Errorf("%w: smembers redis err: %w", repo.ErrStorage, err)
+	}
+
+	todos, err := j.load(ctx, ids)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return repo.Live(todos), nil
+}
+
+// Query narrows the candidate ids with the status, tag and due date indexes,
+// then loads only those todos to check the text filter and sort them
+func (j *RepoRedis) Query(ctx context.Context, filter repo.Filter) (repo.Page, error) {
+	filter, err := repo.ValidateFilter(j.workflow, filter)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	err = j.ensureIndexed(ctx)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	ids, err := j.queryIds(ctx, filter)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	todos, err := j.load(ctx, ids)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	matched := []model.Todo{}
+	for _, todo := range todos {
+		if filter.Match(todo) {
+			matched = append(matched, todo)
 		}
 	}
 
-	return targets, nil
+	return repo.SortPage(matched, filter)
 }
 
-func (j *RepoRedis) UpdateData(ctx context.Context, id string, newdata string) (model.Todo, error) {
-	todos, err := j.GetAll(ctx)
+// queryIds returns the ids the indexes allow for filter
+func (j *RepoRedis) queryIds(ctx context.Context, filter repo.Filter) ([]string, error) {
+	var ids []string
+	var err error
+	if len(filter.Statuses) != 0 {
+		keys := make([]string, len(filter.Statuses))
+		for i, s := range filter.Statuses {
+			keys[i] = redisKeyStatus(s)
+		}
+
+		ids, err = j.rd.SUnion(ctx, keys...).Result()
+	} else {
+		ids, err = j.rd.SMembers(ctx, redisKeyIds).Result()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: smembers redis err: %w", repo.ErrStorage, err)
+	}
+
+	for _, tag := range filter.Tags {
+		tagged, err := j.rd.SMembers(ctx, redisKeyTag(tag)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("%w: smembers redis err: %w", repo.ErrStorage, err)
+		}
+
+		ids = intersect(ids, tagged)
+	}
+
+	if filter.DueFrom != nil || filter.DueTo != nil {
+		// scores are whole milliseconds, filter.Match checks the exact bounds
+		by := &redis.ZRangeBy{Min: "-inf", Max: "+inf"}
+		if filter.DueFrom != nil {
+			by.Min = strconv.FormatInt(filter.DueFrom.UnixMilli(), 10)
+		}
+
+		if filter.DueTo != nil {
+			by.Max = strconv.FormatInt(filter.DueTo.UnixMilli(), 10)
+		}
+
+		due, err := j.rd.ZRangeByScore(ctx, redisKeyDue, by).Result()
+		if err != nil {
+			return nil, fmt.Errorf("%w: zrangebyscore redis err: %w", repo.ErrStorage, err)
+		}
+
+		ids = intersect(ids, due)
+	}
+
+	return ids, nil
+}
+
+func intersect(a []string, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, v := range b {
+		inB[v] = true
+	}
+
+	result := []string{}
+	for _, v := range a {
+		if inB[v] {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+func (j *RepoRedis) UpdateData(ctx context.Context, id string, newdata string, version int64) (model.Todo, error) {
+	return j.mutate(ctx, id, func(old *model.Todo) (*model.Todo, error) {
+		if old == nil || old.IsDeleted() {
+			return nil, fmt.Errorf("%w: not found id: %s", repo.ErrNotFound, id)
+		}
+
+		err := repo.CheckVersion(*old, version)
+		if err != nil {
+			return nil, err
+		}
+
+		todo := *old
+		todo.SetData(newdata, model.Now())
+
+		return &todo, nil
+	})
+}
+
+func (j *RepoRedis) UpdateStatus(ctx context.Context, id string, status model.Status, version int64) (model.Todo, error) {
+	err := repo.ValidateStatus(j.workflow, status)
 	if err != nil {
 		return model.Todo{}, err
 	}
 
-	old := model.Todo{}
-	for _, v := range todos {
-		if v.Id == id {
-			old = v
-			v.Data = newdata
+	return j.mutate(ctx, id, func(old *model.Todo) (*model.Todo, error) {
+		if old == nil || old.IsDeleted() {
+			return nil, fmt.Errorf("%w: not found id: %s", repo.ErrNotFound, id)
+		}
 
-			err := j.rd.HSet(ctx, redisKeyTodo(id), "data", v.Data).Err()
-			if err != nil {
-				return model.Todo{}, fmt.Errorf("hset redis err: %w", err)
-			}
+		err := repo.CheckVersion(*old, version)
+		if err != nil {
+			return nil, err
+		}
 
-			return old, nil
+		err = repo.CheckTransition(j.workflow, old.Status, status)
+		if err != nil {
+			return nil, err
+		}
+
+		todo := *old
+		todo.SetStatus(j.workflow, status, model.Now())
+
+		return &todo, nil
+	})
+}
+
+func (j *RepoRedis) Update(ctx context.Context, todo model.Todo) (model.Todo, error) {
+	err := repo.Validate(j.workflow, todo)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return j.mutate(ctx, todo.Id, func(old *model.Todo) (*model.Todo, error) {
+		if old == nil || old.IsDeleted() {
+			return nil, fmt.Errorf("%w: not found id: %s", repo.ErrNotFound, todo.Id)
+		}
+
+		err := repo.CheckVersion(*old, todo.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		err = repo.CheckTransition(j.workflow, old.Status, todo.Status)
+		if err != nil {
+			return nil, err
+		}
+
+		updated := *old
+		updated.Apply(j.workflow, todo, model.Now())
+
+		return &updated, nil
+	})
+}
+
+func (j *RepoRedis) Remove(ctx context.Context, id string, version int64) (model.Todo, error) {
+	return j.mutate(ctx, id, func(old *model.Todo) (*model.Todo, error) {
+		if old == nil || old.IsDeleted() {
+			return nil, fmt.Errorf("%w: not found id: %s", repo.ErrNotFound, id)
+		}
+
+		err := repo.CheckVersion(*old, version)
+		if err != nil {
+			return nil, err
 		}
+
+		todo := *old
+		todo.Delete(model.Now())
+
+		return &todo, nil
+	})
+}
+
+func (j *RepoRedis) AddMany(ctx context.Context, todos []model.Todo) ([]model.Todo, error) {
+	todos = slices.Clone(todos)
+
+	err := repo.PrepareAdd(j.workflow, todos, model.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = j.mutateMany(ctx, repo.TodoIds(todos), func(i int, old *model.Todo) (*model.Todo, error) {
+		if old != nil {
+			return nil, &repo.BatchError{Index: i, Err: fmt.Errorf("%w: duplicate id '%s'", repo.ErrConflict, old.Id)}
+		}
+
+		return &todos[i], nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return model.Todo{}, fmt.Errorf("not found id: %s", id)
+	return todos, nil
 }
 
-func (j *RepoRedis) UpdateStatus(ctx context.Context, id string, status model.Status) (model.Todo, error) {
-	statusOk := status.IsValid()
-	if statusOk != true {
-		return model.Todo{}, fmt.Errorf("bad status: %s", status)
+func (j *RepoRedis) UpdateMany(ctx context.Context, todos []model.Todo) ([]model.Todo, []model.Todo, error) {
+	ids := repo.TodoIds(todos)
+
+	err := repo.CheckBatchIds(ids)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	statusStr, err := j.rd.HGet(ctx, redisKeyTodo(id), "status").Result()
+	now := model.Now()
+	updated := make([]model.Todo, len(todos))
+	old, err := j.mutateMany(ctx, ids, func(i int, old *model.Todo) (*model.Todo, error) {
+		if old == nil || old.IsDeleted() {
+			return nil, &repo.BatchError{Index: i, Err: fmt.Errorf("%w: not found id: %s", repo.ErrNotFound, ids[i])}
+		}
+
+		updated[i] = *old
+		err := repo.UpdateTodo(j.workflow, &updated[i], todos[i], now)
+		if err != nil {
+			return nil, &repo.BatchError{Index: i, Err: err}
+		}
+
+		return &updated[i], nil
+	})
 	if err != nil {
-		return model.Todo{}, fmt.Errorf("hget redis err: %w", err)
+		return nil, nil, err
 	}
 
-	err = j.rd.HSet(ctx, redisKeyTodo(id), "status", string(status)).Err()
+	return updated, old, nil
+}
+
+func (j *RepoRedis) RemoveMany(ctx context.Context, ids []string) ([]model.Todo, error) {
+	err := repo.CheckBatchIds(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	now := model.Now()
+	return j.mutateMany(ctx, ids, func(i int, old *model.Todo) (*model.Todo, error) {
+		if old == nil || old.IsDeleted() {
+			return nil, &repo.BatchError{Index: i, Err: fmt.Errorf("%w: not found id: %s", repo.ErrNotFound, ids[i])}
+		}
+
+		todo := *old
+		todo.Delete(now)
+
+		return &todo, nil
+	})
+}
+
+func (j *RepoRedis) Trash(ctx context.Context) ([]model.Todo, error) {
+	err := j.ensureIndexed(ctx)
 	if err != nil {
-		return model.Todo{}, fmt.Errorf("hset redis err: %w", err)
+		return []model.Todo{}, err
+	}
+
+	ids, err := j.rd.ZRange(ctx, redisKeyTrash, 0, -1).Result()
+	if err != nil {
+		return []model.Todo{}, fmt.Errorf("%w: zrange redis err: %w", repo.ErrStorage, err)
 	}
 
-	old := model.Todo{
-		Id:     id,
-		Status: model.Status(statusStr),
+	todos, err := j.load(ctx, ids)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return repo.TrashTodos(todos), nil
+}
+
+func (j *RepoRedis) Restore(ctx context.Context, id string) (model.Todo, error) {
+	var restored model.Todo
+	_, err := j.mutate(ctx, id, func(old *model.Todo) (*model.Todo, error) {
+		if old == nil || !old.IsDeleted() {
+			return nil, repo.NotInTrash(id)
+		}
+
+		restored = *old
+		restored.Restore(model.Now())
+
+		return &restored, nil
+	})
+	if err != nil {
+		return model.Todo{}, err
 	}
 
-	return old, nil
+	return restored, nil
 }
 
-func (j *RepoRedis) Remove(ctx context.Context, id string) (model.Todo, error) {
+func (j *RepoRedis) Purge(ctx context.Context, id string) (model.Todo, error) {
+	return j.mutate(ctx, id, func(old *model.Todo) (*model.Todo, error) {
+		if old == nil || !old.IsDeleted() {
+			return nil, repo.NotInTrash(id)
+		}
+
+		return nil, nil
+	})
+}
+
+// PurgeBefore finds the ids with the trash index, then deletes those still
+// in the trash and removed before t once their todos are watched
+func (j *RepoRedis) PurgeBefore(ctx context.Context, t time.Time) ([]model.Todo, error) {
+	err := j.ensureIndexed(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	dataStr, err := j.rd.HGet(ctx, redisKeyTodo(id), "data").Result()
+	// scores are whole milliseconds, repo.DeletedBefore checks the exact bound
+	by := &redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(t.UnixMilli(), 10)}
+	ids, err := j.rd.ZRangeByScore(ctx, redisKeyTrash, by).Result()
 	if err != nil {
-		return model.Todo{}, fmt.Errorf("hget redis err: %w", err)
+		return nil, fmt.Errorf("%w: zrangebyscore redis err: %w", repo.ErrStorage, err)
+	}
+
+	if len(ids) == 0 {
+		return []model.Todo{}, nil
 	}
 
-	err = j.rd.Del(ctx, redisKeyTodo(id)).Err()
+	var purged []model.Todo
+	_, err = j.mutateMany(ctx, ids, func(i int, old *model.Todo) (*model.Todo, error) {
+		if i == 0 {
+			// mutateMany calls again on a retry
+			purged = []model.Todo{}
+		}
+
+		if old == nil {
+			return nil, nil
+		}
+
+		if !repo.DeletedBefore(*old, t) {
+			return old, nil
+		}
+
+		purged = append(purged, *old)
+		return nil, nil
+	})
 	if err != nil {
-		return model.Todo{}, fmt.Errorf("del redis err: %w", err)
+		return nil, err
 	}
 
-	return model.Todo{Id: id, Data: dataStr}, nil
+	repo.SortTrash(purged)
+	return purged, nil
 }
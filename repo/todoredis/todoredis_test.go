@@ -0,0 +1,222 @@
+package todoredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(opts ...repo.Option) repo.Repository {
+		return New(miniredis.RunT(t).Addr(), opts...)
+	})
+}
+
+func TestUnrelatedKeys(t *testing.T) {
+	s := miniredis.RunT(t)
+	s.Set("foo", "bar")
+	s.HSet("user: 1", "id", "1", "name", "yong")
+	s.Lpush("todo: list", "not a todo")
+
+	r := New(s.Addr())
+	ctx := context.Background()
+
+	err := r.Add(ctx, model.Todo{Id: "1", Data: "one", Status: model.StatusTodo})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	todos, err := r.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(todos) != 1 || todos[0].Id != "1" {
+		t.Errorf("unexpected todos: %+v", todos)
+	}
+
+	if s.Exists("foo") != true || s.Exists("user: 1") != true {
+		t.Errorf("unrelated keys were changed")
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	s := miniredis.RunT(t)
+	r := New(s.Addr())
+	ctx := context.Background()
+
+	err := r.Add(ctx, model.Todo{Id: "1", Data: "one", Status: model.StatusTodo})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	_, err = r.UpdateStatus(ctx, "1", model.StatusDone, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	members, _ := s.SMembers(redisKeyStatus(model.StatusDone))
+	if len(members) != 1 || members[0] != "1" {
+		t.Errorf("expected '1' in the DONE set but got %v", members)
+	}
+
+	if s.Exists(redisKeyStatus(model.StatusTodo)) {
+		members, _ := s.SMembers(redisKeyStatus(model.StatusTodo))
+		t.Errorf("expected '1' removed from the TODO set but got %v", members)
+	}
+
+	_, err = r.Remove(ctx, "1", repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if s.Exists(redisKeyIds) || s.Exists(redisKeyStatus(model.StatusDone)) {
+		t.Errorf("expected removed todo to leave the indexes")
+	}
+
+	trash, _ := s.ZMembers(redisKeyTrash)
+	if len(trash) != 1 || trash[0] != "1" {
+		t.Errorf("expected '1' in the trash but got %v", trash)
+	}
+
+	_, err = r.Purge(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if s.Exists(redisKeyTrash) || s.Exists(redisKeyTodo("1")) {
+		t.Errorf("expected purged todo to leave redis")
+	}
+}
+
+// TestReindex checks that todos stored before the indexes existed are found
+func TestReindex(t *testing.T) {
+	s := miniredis.RunT(t)
+	s.HSet(redisKeyTodo("old"), "id", "old", "data", "legacy", "status", "TODO")
+	s.HSet(redisKeyTodo("done"), "id", "done", "data", "legacy done", "status", "DONE")
+
+	r := New(s.Addr())
+	ctx := context.Background()
+
+	todos, err := r.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(todos) != 2 || todos[0].Id != "done" || todos[1].Id != "old" {
+		t.Errorf("unexpected todos: %+v", todos)
+	}
+
+	done, err := r.GetByStatus(ctx, model.StatusDone)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(done) != 1 || done[0].Id != "done" {
+		t.Errorf("unexpected done todos: %+v", done)
+	}
+
+	if !s.Exists(redisKeyIndexed) {
+		t.Errorf("expected reindex to be recorded")
+	}
+
+	// a new instance does not scan again
+	s.HSet(redisKeyTodo("late"), "id", "late", "data", "unindexed", "status", "TODO")
+
+	todos, err = New(s.Addr()).GetAll(ctx)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	if len(todos) != 2 {
+		t.Errorf("expected 2 todos but got %d", len(todos))
+	}
+}
+
+// TestMissingNoWrite checks that writes to a missing id do not leave
+// a half-populated hash behind
+func TestMissingNoWrite(t *testing.T) {
+	s := miniredis.RunT(t)
+	r := New(s.Addr())
+	ctx := context.Background()
+
+	_, err := r.UpdateData(ctx, "nope", "data", repo.AnyVersion)
+	if !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("expected not found but got '%v'", err)
+	}
+
+	_, err = r.UpdateStatus(ctx, "nope", model.StatusDone, repo.AnyVersion)
+	if !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("expected not found but got '%v'", err)
+	}
+
+	_, err = r.Remove(ctx, "nope", repo.AnyVersion)
+	if !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("expected not found but got '%v'", err)
+	}
+
+	if keys := s.Keys(); len(keys) != 0 {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+// TestConcurrentUpdates races status and data updates on one todo
+// from several clients; the indexes must end up matching the hash
+func TestConcurrentUpdates(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx := context.Background()
+
+	err := New(s.Addr()).Add(ctx, model.Todo{Id: "1", Data: "one", Status: model.StatusTodo})
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			r := New(s.Addr())
+			for n := 0; n < 20; n++ {
+				status := model.StatusTodo
+				if (i+n)%2 == 0 {
+					status = model.StatusDone
+				}
+
+				_, err := r.UpdateStatus(ctx, "1", status, repo.AnyVersion)
+				if err != nil && !errors.Is(err, repo.ErrConflict) {
+					t.Errorf("unexpected err: %s", err)
+				}
+
+				_, err = r.UpdateData(ctx, "1", fmt.Sprintf("%d-%d", i, n), repo.AnyVersion)
+				if err != nil && !errors.Is(err, repo.ErrConflict) {
+					t.Errorf("unexpected err: %s", err)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	todo, err := New(s.Addr()).Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	for _, status := range []model.Status{model.StatusTodo, model.StatusDone} {
+		members, _ := s.SMembers(redisKeyStatus(status))
+		indexed := len(members) == 1 && members[0] == "1"
+		if indexed != (status == todo.Status) {
+			t.Errorf("status set of '%s' is %v but todo is '%s'", status, members, todo.Status)
+		}
+	}
+}
@@ -0,0 +1,955 @@
+package todotxt
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/internal/fsutil"
+)
+
+/*
+	data, one todo per line in the todo.txt format
+
+(A) 2026-10-01 call mom +family @phone due:2026-10-03 id:1
+x 2026-10-02 2026-10-01 write report +work pri:B id:2
+2026-10-01 fix bike status:IN_PROGRESS note:needs%20a%20new%20chain id:3
+
+Priority is "(A)" to "(Z)" on open todos and "pri:A" on completed ones.
+Projects map to tags ("+work" is the tag "work"), contexts keep their '@'
+("@phone" is the tag "@phone"). Creation and completion dates are kept to
+the day, and updated_at is not stored.
+
+Fields todo.txt has no place for use key:value tokens at the end of the
+line: id, due, status (only when not the workflow's default), note,
+rev, the version, and deleted, the time the todo went to the trash.
+Tags ending the data are stored as tags, and a last data word that
+would read as a field has its ':' written "%3A" ("due%3Atoday").
+Lines written by other tools without an id get their line number as id,
+written back on the next change.
+*/
+type RepoTodoTxt struct {
+	fileName string
+	workflow model.Workflow
+}
+
+const dateLayout = "2006-01-02"
+
+const (
+	keyId       = "id"
+	keyDue      = "due"
+	keyStatus   = "status"
+	keyPriority = "pri"
+	keyNote     = "note"
+	keyVersion  = "rev"
+	keyDeleted  = "deleted"
+)
+
+func isDate(s string) bool {
+	_, err := time.Parse(dateLayout, s)
+	return err == nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	t, err := time.Parse(dateLayout, s)
+	if err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// formatDate keeps dates at midnight UTC in the usual todo.txt form,
+// and anything else exact
+func formatDate(t time.Time) string {
+	t = t.UTC()
+	if t.Equal(t.Truncate(24 * time.Hour)) {
+		return t.Format(dateLayout)
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
+
+func parsePriority(s string) (model.Priority, bool) {
+	if len(s) == 3 && s[0] == '(' && s[2] == ')' && s[1] >= 'A' && s[1] <= 'Z' {
+		return model.Priority(s[1]-'A') + 1, true
+	}
+
+	return model.PriorityNone, false
+}
+
+func formatPriority(p model.Priority) string {
+	return string(rune('A' + p - 1))
+}
+
+func isTag(word string) bool {
+	return len(word) > 1 && (word[0] == '+' || word[0] == '@')
+}
+
+// tagFromWord maps "+project" to "project" and "@context" to "@context"
+func tagFromWord(word string) string {
+	if word[0] == '+' {
+		return word[1:]
+	}
+
+	return word
+}
+
+func wordFromTag(tag string) string {
+	if strings.HasPrefix(tag, "@") {
+		return tag
+	}
+
+	return "+" + tag
+}
+
+func isKey(word string) bool {
+	key, _, ok := strings.Cut(word, ":")
+	if !ok {
+		return false
+	}
+
+	switch key {
+	case keyId, keyDue, keyStatus, keyPriority, keyNote, keyVersion, keyDeleted:
+		return true
+	}
+
+	return false
+}
+
+// isField reports whether word, at the end of a line, is read as a field
+func isField(word string) bool {
+	return isTag(word) || parseKey(word, &model.Todo{}, new(model.Status)) == nil
+}
+
+// isEscapedKey reports whether word has the form escapeKey gives to data
+// words looking like a key:value field
+func isEscapedKey(word string) bool {
+	switch key, _, _ := strings.Cut(word, "%"); key {
+	case keyId, keyDue, keyStatus, keyPriority, keyNote, keyVersion, keyDeleted:
+		return len(key) < len(word)
+	}
+
+	return false
+}
+
+// escapeKey %-encodes the ':' of a data word that todo.txt would read as a
+// key:value field, like note values are, and the '%' so that words already
+// in that form read back as they are
+func escapeKey(word string) string {
+	if isTag(word) || !(isField(word) || isEscapedKey(word)) {
+		return word
+	}
+
+	return strings.NewReplacer("%", "%25", ":", "%3A").Replace(word)
+}
+
+// parseKey sets the field of the key:value token word on todo, or status
+// for the status key, failing on words that are no such token
+func parseKey(word string, todo *model.Todo, status *model.Status) error {
+	if !isKey(word) {
+		return fmt.Errorf("not a field: '%s'", word)
+	}
+
+	key, value, _ := strings.Cut(word, ":")
+	var err error
+	switch key {
+	case keyId:
+		todo.Id, err = url.PathUnescape(value)
+	case keyDue:
+		var due time.Time
+		due, err = parseDate(value)
+		todo.DueAt = &due
+	case keyStatus:
+		if value == "" {
+			err = fmt.Errorf("empty status")
+		}
+		*status = model.Status(value)
+	case keyPriority:
+		p, ok := parsePriority("(" + value + ")")
+		if !ok {
+			err = fmt.Errorf("bad priority '%s'", value)
+		}
+		todo.Priority = p
+	case keyNote:
+		todo.Notes, err = url.PathUnescape(value)
+	case keyVersion:
+		todo.Version, err = strconv.ParseInt(value, 10, 64)
+	case keyDeleted:
+		var deleted time.Time
+		deleted, err = parseDate(value)
+		todo.DeletedAt = &deleted
+	}
+
+	return err
+}
+
+// lineToModel reads one todo.txt line. The id is left empty when the line
+// has none.
+func lineToModel(wf model.Workflow, line string) (model.Todo, error) {
+	words := strings.Split(line, " ")
+	todo := model.Todo{}
+
+	completed := false
+	if words[0] == "x" {
+		completed = true
+		words = words[1:]
+	}
+
+	if len(words) > 0 {
+		p, ok := parsePriority(words[0])
+		if ok {
+			todo.Priority = p
+			words = words[1:]
+		}
+	}
+
+	dates := []time.Time{}
+	for len(dates) < 2 && len(words) > 0 && isDate(words[0]) {
+		d, _ := parseDate(words[0])
+		dates = append(dates, d)
+		words = words[1:]
+
+		if !completed {
+			break
+		}
+	}
+
+	switch {
+	case completed && len(dates) == 2:
+		todo.CompletedAt = &dates[0]
+		todo.CreatedAt = dates[1]
+	case completed && len(dates) == 1:
+		todo.CompletedAt = &dates[0]
+	case len(dates) == 1:
+		todo.CreatedAt = dates[0]
+	}
+
+	// tags and key:value tokens at the end of the line are fields, the
+	// ones inside the description stay part of the data. So does a
+	// token with a value we cannot read, e.g. "due:friday" of another tool.
+	end := len(words)
+	for end > 0 && isField(words[end-1]) {
+		end--
+	}
+
+	// the last word of the data is escaped when it looks like a field
+	if end > 0 && isEscapedKey(words[end-1]) {
+		word, err := url.PathUnescape(words[end-1])
+		if err == nil {
+			words[end-1] = word
+		}
+	}
+
+	status := model.Status("")
+	tags := []string{}
+	for _, word := range words[end:] {
+		if isTag(word) {
+			tags = append(tags, tagFromWord(word))
+			continue
+		}
+
+		parseKey(word, &todo, &status)
+	}
+
+	todo.Data = strings.Join(words[:end], " ")
+	for _, word := range words[:end] {
+		if isTag(word) {
+			tags = append(tags, tagFromWord(word))
+		}
+	}
+
+	for _, tag := range tags {
+		if !containsTag(todo.Tags, tag) {
+			todo.Tags = append(todo.Tags, tag)
+		}
+	}
+
+	switch {
+	case status != "":
+		todo.Status = status
+	case completed:
+		todo.Status = wf.DoneStatus()
+	default:
+		todo.Status = wf.InitialStatus()
+	}
+
+	if !wf.IsCompleted(todo.Status) {
+		todo.CompletedAt = nil
+	}
+
+	todo.UpdatedAt = todo.CreatedAt
+	if todo.CompletedAt != nil && todo.CompletedAt.After(todo.UpdatedAt) {
+		todo.UpdatedAt = *todo.CompletedAt
+	}
+
+	return todo, nil
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// modelToLine writes one todo.txt line
+func modelToLine(wf model.Workflow, t model.Todo) string {
+	words := []string{}
+
+	completed := wf.IsCompleted(t.Status)
+	if completed {
+		completedAt := t.UpdatedAt
+		if t.CompletedAt != nil {
+			completedAt = *t.CompletedAt
+		}
+
+		words = append(words, "x", completedAt.UTC().Format(dateLayout))
+	} else if t.Priority != model.PriorityNone {
+		words = append(words, "("+formatPriority(t.Priority)+")")
+	}
+
+	if !t.CreatedAt.IsZero() {
+		words = append(words, t.CreatedAt.UTC().Format(dateLayout))
+	}
+
+	if t.Data != "" {
+		data := strings.Split(t.Data, " ")
+		data[len(data)-1] = escapeKey(data[len(data)-1])
+		words = append(words, strings.Join(data, " "))
+	}
+
+	inData := strings.Split(t.Data, " ")
+	for _, tag := range t.Tags {
+		word := wordFromTag(tag)
+		if !containsTag(inData, word) {
+			words = append(words, word)
+		}
+	}
+
+	if t.DueAt != nil {
+		words = append(words, keyDue+":"+formatDate(*t.DueAt))
+	}
+
+	if completed && t.Priority != model.PriorityNone {
+		words = append(words, keyPriority+":"+formatPriority(t.Priority))
+	}
+
+	if (completed && t.Status != wf.DoneStatus()) || (!completed && t.Status != wf.InitialStatus()) {
+		words = append(words, keyStatus+":"+string(t.Status))
+	}
+
+	if t.Notes != "" {
+		words = append(words, keyNote+":"+url.PathEscape(t.Notes))
+	}
+
+	if t.Version != 0 {
+		words = append(words, keyVersion+":"+strconv.FormatInt(t.Version, 10))
+	}
+
+	if t.DeletedAt != nil {
+		words = append(words, keyDeleted+":"+formatDate(*t.DeletedAt))
+	}
+
+	words = append(words, keyId+":"+url.PathEscape(t.Id))
+
+	return strings.Join(words, " ")
+}
+
+// linesToModel reads a whole file, skipping blank lines
+func linesToModel(wf model.Workflow, data string) ([]model.Todo, error) {
+	lines := strings.Split(data, "\n")
+
+	todos := []model.Todo{}
+	missing := []int{}
+	ids := make(map[string]bool)
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		todo, err := lineToModel(wf, line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		if todo.Id == "" {
+			// keep the line number around until every explicit id is known
+			todo.Id = strconv.Itoa(i + 1)
+			missing = append(missing, len(todos))
+		} else {
+			ids[todo.Id] = true
+		}
+
+		todos = append(todos, todo)
+	}
+
+	for _, i := range missing {
+		n, _ := strconv.Atoi(todos[i].Id)
+		for ids[strconv.Itoa(n)] {
+			n++
+		}
+
+		todos[i].Id = strconv.Itoa(n)
+		ids[todos[i].Id] = true
+	}
+
+	return todos, nil
+}
+
+func modelToLines(wf model.Workflow, todos []model.Todo) string {
+	b := strings.Builder{}
+	for i := range todos {
+		b.WriteString(modelToLine(wf, todos[i]))
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+func (j *RepoTodoTxt) readDecode() ([]model.Todo, error) {
+	b, err := os.ReadFile(j.fileName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read todo.txt: %w", repo.ErrStorage, err)
+	}
+
+	todos, err := linesToModel(j.workflow, string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode todo.txt: %w", repo.ErrStorage, err)
+	}
+
+	return todos, nil
+}
+
+func (j *RepoTodoTxt) writeEncode(todos []model.Todo) error {
+	err := fsutil.WriteFile(j.fileName, []byte(modelToLines(j.workflow, todos)), 0664)
+	if err != nil {
+		return fmt.Errorf("%w: failed to write todo.txt: %w", repo.ErrStorage, err)
+	}
+
+	return nil
+}
+
+// lock serializes read-modify-write cycles on the file
+// across goroutines and processes
+func (j *RepoTodoTxt) lock(ctx context.Context) (func(), error) {
+	unlock, err := fsutil.Lock(ctx, j.fileName)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to lock todo.txt: %w", repo.ErrStorage, err)
+	}
+
+	return unlock, nil
+}
+
+// validate rejects what a todo.txt line cannot hold,
+// on top of repo.Validate
+func (j *RepoTodoTxt) validate(todo model.Todo) error {
+	err := repo.Validate(j.workflow, todo)
+	if err != nil {
+		return err
+	}
+
+	if strings.ContainsAny(todo.Data, "\r\n") {
+		return fmt.Errorf("%w: data cannot contain line breaks", repo.ErrInvalidTodo)
+	}
+
+	if strings.ContainsAny(string(todo.Status), " \t\r\n") {
+		return fmt.Errorf("%w: status cannot contain spaces", repo.ErrInvalidTodo)
+	}
+
+	for _, tag := range todo.Tags {
+		if tag == "" || tag == "@" || strings.ContainsAny(tag, " \t\r\n") {
+			return fmt.Errorf("%w: bad tag '%s'", repo.ErrInvalidTodo, tag)
+		}
+	}
+
+	return nil
+}
+
+// splitTags moves the +project and @context words ending the data of
+// todo to its tags, where todo.txt reads them back from
+func splitTags(todo *model.Todo) {
+	words := strings.Split(todo.Data, " ")
+	end := len(words)
+	for end > 0 && isTag(words[end-1]) {
+		end--
+	}
+
+	if end == len(words) {
+		return
+	}
+
+	todo.Tags = slices.Clone(todo.Tags)
+	for _, word := range words[end:] {
+		tag := tagFromWord(word)
+		if !containsTag(todo.Tags, tag) {
+			todo.Tags = append(todo.Tags, tag)
+		}
+	}
+
+	todo.Data = strings.Join(words[:end], " ")
+}
+
+// SplitTags returns todo as this backend stores it, with the +project and
+// @context words ending its data moved to its tags
+func SplitTags(todo model.Todo) model.Todo {
+	splitTags(&todo)
+	return todo
+}
+
+// splitTagsMany is splitTags on a copy of the todos of a batch
+func splitTagsMany(todos []model.Todo) []model.Todo {
+	todos = slices.Clone(todos)
+	for i := range todos {
+		splitTags(&todos[i])
+	}
+
+	return todos
+}
+
+// update runs fn on the todo with id, once checked to be at version,
+// and writes the file back
+func (j *RepoTodoTxt) update(ctx context.Context, id string, version int64, fn func(todo *model.Todo) error) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
+	todos, err := j.readDecode()
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	for i := range todos {
+		if todos[i].Id != id || todos[i].IsDeleted() {
+			continue
+		}
+
+		old := todos[i]
+		err = repo.CheckVersion(old, version)
+		if err != nil {
+			return model.Todo{}, err
+		}
+
+		err = fn(&todos[i])
+		if err != nil {
+			return model.Todo{}, err
+		}
+
+		err = j.writeEncode(todos)
+		if err != nil {
+			return model.Todo{}, err
+		}
+
+		return old, nil
+	}
+
+	return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
+}
+
+func (j *RepoTodoTxt) Add(ctx context.Context, todo model.Todo) error {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if todo.Status == "" {
+		todo.Status = j.workflow.InitialStatus()
+	}
+
+	splitTags(&todo)
+	err = j.validate(todo)
+	if err != nil {
+		return err
+	}
+
+	todos, err := j.readDecode()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range todos {
+		if v.Id == todo.Id {
+			return fmt.Errorf("%w: duplicate id '%s'", repo.ErrConflict, todo.Id)
+		}
+	}
+
+	todo.Created(j.workflow, model.Now())
+	todos = append(todos, todo)
+
+	return j.writeEncode(todos)
+}
+
+func (j *RepoTodoTxt) GetAll(ctx context.Context) ([]model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	todos, err := j.readDecode()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return repo.Live(todos), nil
+}
+
+func (j *RepoTodoTxt) Get(ctx context.Context, id string) (model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todos, err := j.readDecode()
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	for _, todo := range todos {
+		if todo.Id == id && !todo.IsDeleted() {
+			return todo, nil
+		}
+	}
+
+	return model.Todo{}, fmt.Errorf("%w: no id: %s", repo.ErrNotFound, id)
+}
+
+func (j *RepoTodoTxt) GetByStatus(ctx context.Context, status model.Status) ([]model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	err = repo.ValidateStatus(j.workflow, status)
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	todos, err := j.readDecode()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	result := []model.Todo{}
+	for _, todo := range todos {
+		if todo.Status == status && !todo.IsDeleted() {
+			result = append(result, todo)
+		}
+	}
+
+	return result, nil
+}
+
+func (j *RepoTodoTxt) Query(ctx context.Context, filter repo.Filter) (repo.Page, error) {
+	err := ctx.Err()
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	filter, err = repo.ValidateFilter(j.workflow, filter)
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	todos, err := j.readDecode()
+	if err != nil {
+		return repo.Page{}, err
+	}
+
+	return repo.ApplyFilter(todos, filter)
+}
+
+func (j *RepoTodoTxt) UpdateData(ctx context.Context, id string, newData string, version int64) (model.Todo, error) {
+	if strings.ContainsAny(newData, "\r\n") {
+		return model.Todo{}, fmt.Errorf("%w: data cannot contain line breaks", repo.ErrInvalidTodo)
+	}
+
+	return j.update(ctx, id, version, func(todo *model.Todo) error {
+		todo.SetData(newData, model.Now())
+		splitTags(todo)
+		return nil
+	})
+}
+
+func (j *RepoTodoTxt) UpdateStatus(ctx context.Context, id string, status model.Status, version int64) (model.Todo, error) {
+	err := repo.ValidateStatus(j.workflow, status)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return j.update(ctx, id, version, func(todo *model.Todo) error {
+		err := repo.CheckTransition(j.workflow, todo.Status, status)
+		if err != nil {
+			return err
+		}
+
+		todo.SetStatus(j.workflow, status, model.Now())
+		return nil
+	})
+}
+
+func (j *RepoTodoTxt) Update(ctx context.Context, todo model.Todo) (model.Todo, error) {
+	splitTags(&todo)
+	err := j.validate(todo)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return j.update(ctx, todo.Id, todo.Version, func(old *model.Todo) error {
+		err := repo.CheckTransition(j.workflow, old.Status, todo.Status)
+		if err != nil {
+			return err
+		}
+
+		old.Apply(j.workflow, todo, model.Now())
+		return nil
+	})
+}
+
+func (j *RepoTodoTxt) Remove(ctx context.Context, id string, version int64) (model.Todo, error) {
+	return j.update(ctx, id, version, func(todo *model.Todo) error {
+		todo.Delete(model.Now())
+		return nil
+	})
+}
+
+// validateMany is validate for the todos of a batch
+func (j *RepoTodoTxt) validateMany(todos []model.Todo) error {
+	for i, todo := range todos {
+		if todo.Status == "" {
+			todo.Status = j.workflow.InitialStatus()
+		}
+
+		err := j.validate(todo)
+		if err != nil {
+			return &repo.BatchError{Index: i, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// asStored returns todos as they read back from the file, which keeps
+// some fields to the day
+func (j *RepoTodoTxt) asStored(todos []model.Todo) ([]model.Todo, error) {
+	stored := make([]model.Todo, len(todos))
+	for i := range todos {
+		todo, err := lineToModel(j.workflow, modelToLine(j.workflow, todos[i]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to decode todo.txt: %w", repo.ErrStorage, err)
+		}
+
+		stored[i] = todo
+	}
+
+	return stored, nil
+}
+
+func (j *RepoTodoTxt) AddMany(ctx context.Context, todos []model.Todo) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	todos = splitTagsMany(todos)
+	err = j.validateMany(todos)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := j.readDecode()
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err = repo.AddTodos(j.workflow, stored, todos, model.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = j.writeEncode(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return j.asStored(repo.PickTodos(stored, repo.TodoIds(todos)))
+}
+
+func (j *RepoTodoTxt) UpdateMany(ctx context.Context, todos []model.Todo) ([]model.Todo, []model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	todos = splitTagsMany(todos)
+	err = j.validateMany(todos)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stored, err := j.readDecode()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stored, old, err := repo.UpdateTodos(j.workflow, stored, todos, model.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = j.writeEncode(stored)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	updated, err := j.asStored(repo.PickTodos(stored, repo.TodoIds(todos)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return updated, old, nil
+}
+
+func (j *RepoTodoTxt) RemoveMany(ctx context.Context, ids []string) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	stored, err := j.readDecode()
+	if err != nil {
+		return nil, err
+	}
+
+	stored, removed, err := repo.RemoveTodos(stored, ids, model.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = j.writeEncode(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+func (j *RepoTodoTxt) Trash(ctx context.Context) ([]model.Todo, error) {
+	err := ctx.Err()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	todos, err := j.readDecode()
+	if err != nil {
+		return []model.Todo{}, err
+	}
+
+	return repo.TrashTodos(todos), nil
+}
+
+func (j *RepoTodoTxt) Restore(ctx context.Context, id string) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
+	todos, err := j.readDecode()
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	restored, err := repo.RestoreTodo(todos, id, model.Now())
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = j.writeEncode(todos)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return restored, nil
+}
+
+func (j *RepoTodoTxt) Purge(ctx context.Context, id string) (model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return model.Todo{}, err
+	}
+	defer unlock()
+
+	todos, err := j.readDecode()
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	todos, purged, err := repo.PurgeTodo(todos, id)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	err = j.writeEncode(todos)
+	if err != nil {
+		return model.Todo{}, err
+	}
+
+	return purged, nil
+}
+
+func (j *RepoTodoTxt) PurgeBefore(ctx context.Context, t time.Time) ([]model.Todo, error) {
+	unlock, err := j.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	todos, err := j.readDecode()
+	if err != nil {
+		return nil, err
+	}
+
+	todos, purged := repo.PurgeTodosBefore(todos, t)
+	if len(purged) == 0 {
+		return purged, nil
+	}
+
+	err = j.writeEncode(todos)
+	if err != nil {
+		return nil, err
+	}
+
+	return purged, nil
+}
+
+func New(fileName string, opts ...repo.Option) repo.Repository {
+	_, err := os.Stat(fileName)
+	if err != nil {
+		err := os.WriteFile(fileName, []byte{}, 0664)
+		if err != nil {
+			panic("failed to create todo.txt file: " + err.Error())
+		}
+	}
+
+	return &RepoTodoTxt{
+		fileName: fileName,
+		workflow: repo.NewOptions(opts...).Workflow,
+	}
+}
@@ -0,0 +1,263 @@
+package todotxt
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eymyong/todo/model"
+	"github.com/eymyong/todo/repo"
+	"github.com/eymyong/todo/repo/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	// todo.txt keeps creation and completion dates only, not the time
+	repotest.Run(t, func(opts ...repo.Option) repo.Repository {
+		return New(filepath.Join(t.TempDir(), "todo.txt"), opts...)
+	}, "Timestamps", "KeepTimestamps")
+}
+
+func TestConcurrentAddInstances(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "todo.txt")
+	repotest.ConcurrentAdd(t, New(fname), New(fname), New(fname))
+}
+
+func date(s string) *time.Time {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+
+	return &t
+}
+
+func TestLineToModel(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected model.Todo
+	}{
+		{
+			line:     "call mom",
+			expected: model.Todo{Data: "call mom", Status: model.StatusTodo},
+		},
+		{
+			line: "(A) 2026-10-01 call mom +family @phone due:2026-10-03 id:1",
+			expected: model.Todo{
+				Id:        "1",
+				Data:      "call mom",
+				Status:    model.StatusTodo,
+				Priority:  model.PriorityHigh,
+				CreatedAt: *date("2026-10-01"),
+				UpdatedAt: *date("2026-10-01"),
+				DueAt:     date("2026-10-03"),
+				Tags:      []string{"family", "@phone"},
+			},
+		},
+		{
+			line: "x 2026-10-02 2026-10-01 write +work report pri:B id:2",
+			expected: model.Todo{
+				Id:          "2",
+				Data:        "write +work report",
+				Status:      model.StatusDone,
+				Priority:    model.PriorityMedium,
+				CreatedAt:   *date("2026-10-01"),
+				UpdatedAt:   *date("2026-10-02"),
+				CompletedAt: date("2026-10-02"),
+				Tags:        []string{"work"},
+			},
+		},
+		{
+			line: "2026-10-01 fix bike t:2026-10-05 note:needs%20a%20chain id:3",
+			expected: model.Todo{
+				Id:        "3",
+				Data:      "fix bike t:2026-10-05",
+				Status:    model.StatusTodo,
+				CreatedAt: *date("2026-10-01"),
+				UpdatedAt: *date("2026-10-01"),
+				Notes:     "needs a chain",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.line, func(t *testing.T) {
+			actual, err := lineToModel(model.DefaultWorkflow, tc.line)
+			if err != nil {
+				t.Fatalf("unexpected err: %s", err)
+			}
+
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected %+v but got %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+// lines already in our form are written back unchanged
+func TestLineRoundTrip(t *testing.T) {
+	wf := model.Workflow{
+		Initial:   model.StatusTodo,
+		Statuses:  []model.Status{model.StatusTodo, model.StatusInProgress, model.StatusDone, model.StatusCancelled},
+		Completed: []model.Status{model.StatusDone, model.StatusCancelled},
+	}
+
+	lines := []string{
+		"(A) 2026-10-01 call mom +family @phone due:2026-10-03 id:1",
+		"x 2026-10-02 2026-10-01 write +work report pri:B id:2",
+		"2026-10-01 fix  bike status:IN_PROGRESS note:a:b%20c id:3",
+		"x 2026-10-02 2026-10-01 skip it status:CANCELLED id:4",
+		"2026-10-01 ไทย 日本 due:2026-10-01T09:30:00Z id:5",
+		"2026-10-01 thrown away rev:2 deleted:2026-10-05T08:00:00Z id:6",
+		"2026-10-01 pay rent due:friday id:7",
+		"2026-10-01 ask about status: +home id:8",
+		"2026-10-01 pay rent due%3A2026-10-03 due:2026-10-05 id:9",
+		"2026-10-01 keep due%253Atoday +home id:10",
+	}
+
+	for _, line := range lines {
+		todo, err := lineToModel(wf, line)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		actual := modelToLine(wf, todo)
+		if actual != line {
+			t.Errorf("expected `%s` but got `%s`", line, actual)
+		}
+	}
+}
+
+func TestLinesWithoutId(t *testing.T) {
+	data := "(B) buy milk\n\nfeed cat id:4\nwalk dog\n"
+
+	todos, err := linesToModel(model.DefaultWorkflow, data)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	ids := []string{}
+	for _, todo := range todos {
+		ids = append(ids, todo.Id)
+	}
+
+	// line 4 is taken by an explicit id
+	expected := []string{"1", "4", "5"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected ids %v but got %v", expected, ids)
+	}
+}
+
+// a file kept by other tools is usable as is, and keeps its lines on write
+func TestExistingFile(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "todo.txt")
+	err := os.WriteFile(fname, []byte("(A) 2026-10-01 call mom @phone\nx 2026-10-02 2026-10-01 pay rent\n"), 0664)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	r := New(fname)
+	_, err = r.UpdateStatus(ctx, "1", model.StatusDone, repo.AnyVersion)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatalf("unexpected err: %s", err)
+	}
+
+	today := model.Now().Format(dateLayout)
+	expected := "x " + today + " 2026-10-01 call mom @phone pri:A rev:1 id:1\nx 2026-10-02 2026-10-01 pay rent id:2\n"
+	if string(b) != expected {
+		t.Errorf("expected `%s` but got `%s`", expected, b)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	r := New(filepath.Join(t.TempDir(), "todo.txt"))
+
+	todos := []model.Todo{
+		{Id: "1", Data: "two\nlines"},
+		{Id: "2", Data: "spaced tag", Tags: []string{"a b"}},
+	}
+
+	for _, todo := range todos {
+		err := r.Add(context.Background(), todo)
+		if !errors.Is(err, repo.ErrInvalidTodo) {
+			t.Errorf("expected invalid todo but got '%v'", err)
+		}
+	}
+}
+
+func TestDataLikeFields(t *testing.T) {
+	ctx := context.Background()
+	r := New(filepath.Join(t.TempDir(), "todo.txt"))
+
+	tests := []struct {
+		data         string
+		expectedData string
+		expectedTags []string
+	}{
+		{data: "call mom +family", expectedData: "call mom", expectedTags: []string{"family"}},
+		{data: "buy +shop milk @town", expectedData: "buy +shop milk", expectedTags: []string{"@town", "shop"}},
+		{data: "pay rent due:2026-10-03", expectedData: "pay rent due:2026-10-03"},
+		{data: "pay rent due:friday", expectedData: "pay rent due:friday"},
+		{data: "ask about status:DONE", expectedData: "ask about status:DONE"},
+		{data: "old rev:3", expectedData: "old rev:3"},
+		{data: "gone deleted:2026-10-05", expectedData: "gone deleted:2026-10-05"},
+		{data: "odd id%3A7", expectedData: "odd id%3A7"},
+	}
+
+	for i, tt := range tests {
+		id := strconv.Itoa(i + 1)
+		err := r.Add(ctx, model.Todo{Id: id, Data: tt.data})
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		check := func(todo model.Todo) {
+			t.Helper()
+			if todo.Data != tt.expectedData {
+				t.Errorf("expected data '%s' but got '%s'", tt.expectedData, todo.Data)
+			}
+
+			sort.Strings(todo.Tags)
+			if len(todo.Tags) != 0 || len(tt.expectedTags) != 0 {
+				if !reflect.DeepEqual(todo.Tags, tt.expectedTags) {
+					t.Errorf("expected tags %q but got %q", tt.expectedTags, todo.Tags)
+				}
+			}
+		}
+
+		todo, err := r.Get(ctx, id)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		check(todo)
+
+		_, err = r.UpdateData(ctx, id, "changed", repo.AnyVersion)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		_, err = r.UpdateData(ctx, id, tt.data, repo.AnyVersion)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		todo, err = r.Get(ctx, id)
+		if err != nil {
+			t.Fatalf("unexpected err: %s", err)
+		}
+
+		check(todo)
+	}
+}
@@ -0,0 +1,111 @@
+package repo
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/eymyong/todo/model"
+)
+
+// Removing a todo does not delete it: Remove and RemoveMany set its
+// DeletedAt, which moves it to the trash. From there Restore puts it back
+// as it was, and Purge or PurgeBefore delete it for good. While in the
+// trash, the todo is left out of every listing, Get and the updates fail
+// with ErrNotFound, and Add fails with ErrConflict as its id is taken.
+// The helpers below implement the trash for the backends holding every
+// todo in memory.
+
+// NotInTrash is the error of the trash methods for an id not in the trash
+func NotInTrash(id string) error {
+	return fmt.Errorf("%w: no id '%s' in the trash", ErrNotFound, id)
+}
+
+// DeletedBefore reports whether todo was moved to the trash before t
+func DeletedBefore(todo model.Todo, t time.Time) bool {
+	return todo.DeletedAt != nil && todo.DeletedAt.Before(t)
+}
+
+// Live returns the todos of todos not in the trash
+func Live(todos []model.Todo) []model.Todo {
+	live := []model.Todo{}
+	for _, todo := range todos {
+		if !todo.IsDeleted() {
+			live = append(live, todo)
+		}
+	}
+
+	return live
+}
+
+// SortTrash orders todos the last removed first, ties broken by id
+func SortTrash(todos []model.Todo) {
+	sort.SliceStable(todos, func(i, j int) bool {
+		a, b := todos[i].DeletedAt, todos[j].DeletedAt
+		if a == nil || b == nil || a.Equal(*b) {
+			return todos[i].Id < todos[j].Id
+		}
+
+		return a.After(*b)
+	})
+}
+
+// TrashTodos returns the todos of todos in the trash, see Trash
+func TrashTodos(todos []model.Todo) []model.Todo {
+	trash := []model.Todo{}
+	for _, todo := range todos {
+		if todo.IsDeleted() {
+			trash = append(trash, todo)
+		}
+	}
+
+	SortTrash(trash)
+	return trash
+}
+
+// RestoreTodo takes the todo with id out of the trash, changing todos in
+// place, and returns it, see Restore
+func RestoreTodo(todos []model.Todo, id string, now time.Time) (model.Todo, error) {
+	for i := range todos {
+		if todos[i].Id == id && todos[i].IsDeleted() {
+			todos[i].Restore(now)
+			return todos[i], nil
+		}
+	}
+
+	return model.Todo{}, NotInTrash(id)
+}
+
+// PurgeTodo returns todos without the todo with id, which must be in the
+// trash, and that todo, see Purge
+func PurgeTodo(todos []model.Todo, id string) ([]model.Todo, model.Todo, error) {
+	i := slices.IndexFunc(todos, func(todo model.Todo) bool {
+		return todo.Id == id && todo.IsDeleted()
+	})
+
+	if i < 0 {
+		return nil, model.Todo{}, NotInTrash(id)
+	}
+
+	purged := todos[i]
+	return slices.Delete(slices.Clone(todos), i, i+1), purged, nil
+}
+
+// PurgeTodosBefore returns todos without the todos removed before t,
+// and those todos, see PurgeBefore
+func PurgeTodosBefore(todos []model.Todo, t time.Time) ([]model.Todo, []model.Todo) {
+	kept := []model.Todo{}
+	purged := []model.Todo{}
+	for _, todo := range todos {
+		if DeletedBefore(todo, t) {
+			purged = append(purged, todo)
+			continue
+		}
+
+		kept = append(kept, todo)
+	}
+
+	SortTrash(purged)
+	return kept, purged
+}